@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ================ 访问控制（ACL） ================
+//
+// 服务端对客户端发来的每个IP包（无论目标是TUN设备还是其他客户端）按顺序匹配ACL规则，
+// 第一条匹配的规则决定放行或丢弃，都不匹配时按默认动作（acl_default）处理。
+// 规则按来源身份（证书CN、分组）、目标地址（CIDR）、协议和目标端口匹配，未设置的条件匹配任意值。
+// 非首个分片不携带端口，只匹配未指定端口的规则。
+//
+// 规则从配置文件加载，可以通过控制接口增删或从配置文件重新加载，修改后立即作用于在线会话。
+// 每条规则记录命中次数，重新加载时内容不变的规则保留计数。
+
+// ACL 动作
+const (
+	ACLActionAllow = "allow"
+	ACLActionDeny  = "deny"
+)
+
+// ACL 协议
+const (
+	ACLProtocolAny  = "any"
+	ACLProtocolTCP  = "tcp"
+	ACLProtocolUDP  = "udp"
+	ACLProtocolICMP = "icmp" // 同时匹配 ICMP 和 ICMPv6
+)
+
+// ACLRule 一条访问控制规则（除 Action 外的字段为空表示匹配任意值）
+type ACLRule struct {
+	Action   string `json:"action"`             // "allow" 或 "deny"
+	CN       string `json:"cn,omitempty"`       // 来源客户端的证书CN
+	Group    string `json:"group,omitempty"`    // 来源客户端的分组（证书OU）
+	Dst      string `json:"dst,omitempty"`      // 目标地址，CIDR 或单个地址
+	Protocol string `json:"protocol,omitempty"` // "tcp"、"udp"、"icmp" 或 "any"
+	Ports    string `json:"ports,omitempty"`    // 目标端口，如 "443" 或 "8000-8080"（仅 tcp/udp）
+}
+
+// validate 检查规则格式
+func (r ACLRule) validate() error {
+	_, err := compileACLRule(r)
+	return err
+}
+
+// String 返回规则的简短描述（用于提示信息和日志）
+func (r ACLRule) String() string {
+	var b strings.Builder
+	b.WriteString(r.Action)
+	if r.CN != "" {
+		b.WriteString(" cn=" + r.CN)
+	}
+	if r.Group != "" {
+		b.WriteString(" group=" + r.Group)
+	}
+	dst := r.Dst
+	if dst == "" {
+		dst = "any"
+	}
+	b.WriteString(" → " + dst)
+	if r.Protocol != "" && r.Protocol != ACLProtocolAny {
+		b.WriteString(" " + r.Protocol)
+		if r.Ports != "" {
+			b.WriteString("/" + r.Ports)
+		}
+	}
+	return b.String()
+}
+
+// validateACLDefault 检查默认动作（空表示放行）
+func validateACLDefault(action string) error {
+	switch action {
+	case "", ACLActionAllow, ACLActionDeny:
+		return nil
+	}
+	return fmt.Errorf("ACL默认动作必须是 %s 或 %s", ACLActionAllow, ACLActionDeny)
+}
+
+// aclEntry 编译后的规则及其命中次数
+type aclEntry struct {
+	rule   ACLRule
+	allow  bool
+	dst    netip.Prefix // 无效值表示任意目标
+	proto  uint8        // 0表示任意协议，ICMP 以1表示（同时匹配 ICMPv6）
+	portLo uint16
+	portHi uint16 // 0表示任意端口
+	hits   atomic.Uint64
+}
+
+// compileACLRule 解析规则中的地址、协议和端口
+func compileACLRule(r ACLRule) (*aclEntry, error) {
+	e := &aclEntry{rule: r}
+	switch r.Action {
+	case ACLActionAllow:
+		e.allow = true
+	case ACLActionDeny:
+	default:
+		return nil, fmt.Errorf("动作必须是 %s 或 %s", ACLActionAllow, ACLActionDeny)
+	}
+
+	if r.Dst != "" {
+		if strings.Contains(r.Dst, "/") {
+			prefix, err := netip.ParsePrefix(r.Dst)
+			if err != nil {
+				return nil, fmt.Errorf("无效的目标地址: %s", r.Dst)
+			}
+			e.dst = prefix.Masked()
+		} else {
+			addr, err := netip.ParseAddr(r.Dst)
+			if err != nil {
+				return nil, fmt.Errorf("无效的目标地址: %s", r.Dst)
+			}
+			e.dst = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if e.dst.Addr().Is4In6() {
+			return nil, fmt.Errorf("无效的目标地址: %s", r.Dst)
+		}
+	}
+
+	switch r.Protocol {
+	case "", ACLProtocolAny:
+	case ACLProtocolTCP:
+		e.proto = 6
+	case ACLProtocolUDP:
+		e.proto = 17
+	case ACLProtocolICMP:
+		e.proto = 1
+	default:
+		return nil, fmt.Errorf("协议必须是 %s、%s、%s 或 %s", ACLProtocolTCP, ACLProtocolUDP, ACLProtocolICMP, ACLProtocolAny)
+	}
+
+	if r.Ports != "" {
+		if e.proto != 6 && e.proto != 17 {
+			return nil, fmt.Errorf("只有 %s 和 %s 规则可以指定端口", ACLProtocolTCP, ACLProtocolUDP)
+		}
+		lo, hi, isRange := strings.Cut(r.Ports, "-")
+		if !isRange {
+			hi = lo
+		}
+		portLo, err1 := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+		portHi, err2 := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+		if err1 != nil || err2 != nil || portLo == 0 || portLo > portHi {
+			return nil, fmt.Errorf("无效的端口: %s", r.Ports)
+		}
+		e.portLo, e.portHi = uint16(portLo), uint16(portHi)
+	}
+	return e, nil
+}
+
+// aclPacket ACL匹配需要的IP包字段
+type aclPacket struct {
+	dst     netip.Addr
+	proto   uint8 // ICMP 和 ICMPv6 都记为1
+	port    uint16
+	hasPort bool // 非首个分片及非 tcp/udp 包没有端口
+	opaque  bool // 上层头部无法解析（IPv6扩展头链或 tcp/udp 头部被截断），不匹配任何放行规则
+}
+
+// parseACLPacket 解析IP包的目标地址、协议和目标端口，无法识别的包返回 false。
+// IPv6包沿扩展头链取上层协议和端口，避免插入逐跳选项等扩展头绕过按协议和端口的规则
+func parseACLPacket(packet []byte) (aclPacket, bool) {
+	var p aclPacket
+	var l4 []byte
+	switch packetIPVersion(packet) {
+	case 4:
+		ihl := int(packet[0]&0x0F) * 4
+		if ihl < 20 || len(packet) < ihl {
+			return p, false
+		}
+		p.dst = netip.AddrFrom4([4]byte(packet[16:20]))
+		p.proto = packet[9]
+		// 只有首个分片（且未分片）才带有端口
+		if binary.BigEndian.Uint16(packet[6:8])&0x1FFF == 0 {
+			l4 = packet[ihl:]
+		}
+	case 6:
+		p.dst = netip.AddrFrom16([16]byte(packet[24:40]))
+		var ok bool
+		p.proto, l4, ok = ipv6UpperLayer(packet)
+		p.opaque = !ok
+		if p.proto == 58 {
+			p.proto = 1
+		}
+	default:
+		return p, false
+	}
+	if (p.proto == 6 || p.proto == 17) && l4 != nil {
+		if len(l4) < 4 {
+			p.opaque = true
+		} else {
+			p.port = binary.BigEndian.Uint16(l4[2:4])
+			p.hasPort = true
+		}
+	}
+	return p, true
+}
+
+// match 判断规则是否匹配来自 cn/group 的数据包
+func (e *aclEntry) match(cn, group string, p *aclPacket) bool {
+	if e.allow && p.opaque {
+		return false
+	}
+	if e.rule.CN != "" && e.rule.CN != cn {
+		return false
+	}
+	if e.rule.Group != "" && e.rule.Group != group {
+		return false
+	}
+	if e.dst.IsValid() && !e.dst.Contains(p.dst) {
+		return false
+	}
+	if e.proto != 0 && e.proto != p.proto {
+		return false
+	}
+	if e.portHi != 0 && (!p.hasPort || p.port < e.portLo || p.port > e.portHi) {
+		return false
+	}
+	return true
+}
+
+// aclPolicy 服务端的ACL配置，修改时整体替换
+type aclPolicy struct {
+	defaultAllow bool
+	entries      []*aclEntry
+	defaultHits  atomic.Uint64 // 没有规则匹配、按默认动作处理的包数
+}
+
+// newACLPolicy 根据配置编译ACL（配置已验证，无效规则跳过）。
+// prev 不为nil时，内容相同的规则沿用其命中次数
+func newACLPolicy(config *VPNConfig, prev *aclPolicy) *aclPolicy {
+	p := &aclPolicy{defaultAllow: config.GetACLDefault() == ACLActionAllow}
+	var reused map[*aclEntry]bool
+	if prev != nil {
+		reused = make(map[*aclEntry]bool)
+		if prev.defaultAllow == p.defaultAllow {
+			p.defaultHits.Store(prev.defaultHits.Load())
+		}
+	}
+	for _, rule := range config.ACLRules {
+		e, err := compileACLRule(rule)
+		if err != nil {
+			continue
+		}
+		if prev != nil {
+			for _, old := range prev.entries {
+				if old.rule == rule && !reused[old] {
+					reused[old] = true
+					e.hits.Store(old.hits.Load())
+					break
+				}
+			}
+		}
+		p.entries = append(p.entries, e)
+	}
+	return p
+}
+
+// allow 按规则判断是否放行来自 cn/group 的数据包，并记录命中次数
+func (p *aclPolicy) allow(cn, group string, packet []byte) bool {
+	if len(p.entries) == 0 && p.defaultAllow {
+		return true // 未配置ACL
+	}
+	if pkt, ok := parseACLPacket(packet); ok {
+		for _, e := range p.entries {
+			if e.match(cn, group, &pkt) {
+				e.hits.Add(1)
+				return e.allow
+			}
+		}
+	}
+	p.defaultHits.Add(1)
+	return p.defaultAllow
+}
+
+// ================ 服务端 ================
+
+// aclAllowed 按当前ACL判断是否转发会话发来的数据包（丢弃时计入会话的ACL丢弃统计）
+func (s *VPNServer) aclAllowed(session *VPNSession, packet []byte) bool {
+	if s.acl.Load().allow(session.CertSubject, session.Group, packet) {
+		return true
+	}
+	atomic.AddUint64(&session.aclDenied, 1)
+	return false
+}
+
+// SetACL 替换ACL配置并立即作用于所有在线会话（内容不变的规则保留命中次数）
+func (s *VPNServer) SetACL(config *VPNConfig) {
+	s.acl.Store(newACLPolicy(config, s.acl.Load()))
+}
+
+// ACLHits 返回每条规则的命中次数（与当前规则顺序一致）和按默认动作处理的包数
+func (s *VPNServer) ACLHits() (hits []uint64, defaultHits uint64) {
+	p := s.acl.Load()
+	hits = make([]uint64, len(p.entries))
+	for i, e := range p.entries {
+		hits[i] = e.hits.Load()
+	}
+	return hits, p.defaultHits.Load()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+// v6ext 测试用的IPv6扩展头（首字节的下一个头部类型由 buildIPv6 填写）
+type v6ext struct {
+	typ  byte
+	body []byte
+}
+
+// optionsExt 长度为 8*(units+1) 字节的逐跳选项（0）、路由（43）或目的选项（60）扩展头
+func optionsExt(typ byte, units byte) v6ext {
+	body := make([]byte, 8*(int(units)+1))
+	body[1] = units
+	return v6ext{typ, body}
+}
+
+// fragmentExt 分片扩展头，offset 以8字节为单位
+func fragmentExt(offset uint16, more bool) v6ext {
+	body := make([]byte, 8)
+	field := offset << 3
+	if more {
+		field |= 1
+	}
+	binary.BigEndian.PutUint16(body[2:4], field)
+	return v6ext{44, body}
+}
+
+// authExt 12字节的认证头
+func authExt() v6ext {
+	body := make([]byte, 12)
+	body[1] = 1
+	return v6ext{51, body}
+}
+
+// transportHeader 目标端口为 port 的TCP（20字节）或UDP（8字节）头部
+func transportHeader(proto byte, port uint16) []byte {
+	size := 20
+	if proto == 17 {
+		size = 8
+	}
+	l4 := make([]byte, size)
+	binary.BigEndian.PutUint16(l4[0:2], 40000)
+	binary.BigEndian.PutUint16(l4[2:4], port)
+	return l4
+}
+
+// buildIPv6 构造发往 fd00::1、依次带有 exts 扩展头的IPv6包
+func buildIPv6(exts []v6ext, proto byte, l4 []byte) []byte {
+	packet := make([]byte, 40)
+	packet[0] = 0x60
+	packet[7] = 64
+	packet[8] = 0xfd
+	packet[23] = 2
+	packet[24] = 0xfd
+	packet[39] = 1
+	next := &packet[6]
+	for _, ext := range exts {
+		*next = ext.typ
+		n := len(packet)
+		packet = append(packet, ext.body...)
+		next = &packet[n]
+	}
+	*next = proto
+	return append(packet, l4...)
+}
+
+// buildIPv4 构造发往 10.0.0.1 的IPv4包，fragment 为标志和片偏移字段
+func buildIPv4(proto byte, fragment uint16, l4 []byte) []byte {
+	packet := make([]byte, 20)
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[6:8], fragment)
+	packet[8] = 64
+	packet[9] = proto
+	copy(packet[12:16], []byte{10, 8, 0, 2})
+	copy(packet[16:20], []byte{10, 0, 0, 1})
+	return append(packet, l4...)
+}
+
+func TestIPv6UpperLayer(t *testing.T) {
+	tcp22 := transportHeader(6, 22)
+	truncated := buildIPv6([]v6ext{optionsExt(0, 1)}, 6, tcp22)[:48] // 逐跳选项声明16字节，只有8字节
+
+	tests := []struct {
+		name    string
+		packet  []byte
+		proto   byte
+		l4Len   int // -1 表示没有上层头部
+		ok      bool
+		l4Port  uint16
+		hasPort bool
+	}{
+		{"无扩展头", buildIPv6(nil, 6, tcp22), 6, 20, true, 22, true},
+		{"逐跳选项", buildIPv6([]v6ext{optionsExt(0, 1)}, 6, tcp22), 6, 20, true, 22, true},
+		{"目的选项", buildIPv6([]v6ext{optionsExt(60, 0)}, 17, transportHeader(17, 53)), 17, 8, true, 53, true},
+		{"逐跳选项+路由+目的选项", buildIPv6([]v6ext{optionsExt(0, 0), optionsExt(43, 2), optionsExt(60, 0)}, 6, tcp22), 6, 20, true, 22, true},
+		{"认证头", buildIPv6([]v6ext{authExt()}, 6, tcp22), 6, 20, true, 22, true},
+		{"首个分片", buildIPv6([]v6ext{fragmentExt(0, true)}, 6, tcp22), 6, 20, true, 22, true},
+		{"非首个分片", buildIPv6([]v6ext{fragmentExt(185, false)}, 6, tcp22), 6, -1, true, 0, false},
+		{"ESP", buildIPv6(nil, 50, make([]byte, 16)), 50, 16, true, 0, false},
+		{"扩展头被截断", truncated, 0, -1, false, 0, false},
+		{"缺少扩展头长度", buildIPv6(nil, 60, nil), 60, -1, false, 0, false},
+		{"分片头被截断", buildIPv6([]v6ext{optionsExt(0, 0), fragmentExt(0, false)}, 6, nil)[:52], 44, -1, false, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proto, l4, ok := ipv6UpperLayer(tt.packet)
+			if proto != tt.proto || ok != tt.ok {
+				t.Fatalf("ipv6UpperLayer() = (%d, ok=%v)，期望 (%d, ok=%v)", proto, ok, tt.proto, tt.ok)
+			}
+			if tt.l4Len < 0 {
+				if l4 != nil {
+					t.Fatalf("上层头部应为nil，实际 %d 字节", len(l4))
+				}
+				return
+			}
+			if len(l4) != tt.l4Len {
+				t.Fatalf("上层头部 %d 字节，期望 %d 字节", len(l4), tt.l4Len)
+			}
+			if tt.hasPort && binary.BigEndian.Uint16(l4[2:4]) != tt.l4Port {
+				t.Fatalf("目标端口 %d，期望 %d", binary.BigEndian.Uint16(l4[2:4]), tt.l4Port)
+			}
+		})
+	}
+}
+
+func TestParseACLPacket(t *testing.T) {
+	tcp443 := transportHeader(6, 443)
+
+	tests := []struct {
+		name    string
+		packet  []byte
+		want    aclPacket
+		wantDst string
+	}{
+		{"IPv4 TCP", buildIPv4(6, 0, tcp443), aclPacket{proto: 6, port: 443, hasPort: true}, "10.0.0.1"},
+		{"IPv4 首个分片", buildIPv4(6, 0x2000, tcp443), aclPacket{proto: 6, port: 443, hasPort: true}, "10.0.0.1"},
+		{"IPv4 非首个分片", buildIPv4(6, 0x2000|185, tcp443), aclPacket{proto: 6}, "10.0.0.1"},
+		{"IPv4 TCP头部被截断", buildIPv4(6, 0, tcp443[:2]), aclPacket{proto: 6, opaque: true}, "10.0.0.1"},
+		{"IPv4 ICMP", buildIPv4(1, 0, make([]byte, 8)), aclPacket{proto: 1}, "10.0.0.1"},
+		{"IPv6 逐跳选项后的TCP", buildIPv6([]v6ext{optionsExt(0, 0)}, 6, tcp443), aclPacket{proto: 6, port: 443, hasPort: true}, "fd00::1"},
+		{"IPv6 首个分片", buildIPv6([]v6ext{fragmentExt(0, true)}, 17, transportHeader(17, 53)), aclPacket{proto: 17, port: 53, hasPort: true}, "fd00::1"},
+		{"IPv6 非首个分片", buildIPv6([]v6ext{fragmentExt(185, false)}, 6, tcp443), aclPacket{proto: 6}, "fd00::1"},
+		{"IPv6 ICMPv6", buildIPv6([]v6ext{optionsExt(60, 0)}, 58, make([]byte, 8)), aclPacket{proto: 1}, "fd00::1"},
+		{"IPv6 扩展头被截断", buildIPv6([]v6ext{optionsExt(0, 1)}, 6, tcp443)[:48], aclPacket{proto: 0, opaque: true}, "fd00::1"},
+		{"IPv6 TCP头部被截断", buildIPv6([]v6ext{authExt()}, 6, tcp443[:3]), aclPacket{proto: 6, opaque: true}, "fd00::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseACLPacket(tt.packet)
+			if !ok {
+				t.Fatal("parseACLPacket() 返回 false")
+			}
+			tt.want.dst = netip.MustParseAddr(tt.wantDst)
+			if got != tt.want {
+				t.Fatalf("parseACLPacket() = %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := parseACLPacket([]byte{0x45, 0}); ok {
+		t.Error("不完整的IP包应返回 false")
+	}
+}
+
+func TestACLPolicyAllow(t *testing.T) {
+	tcp22 := transportHeader(6, 22)
+	tcp80 := transportHeader(6, 80)
+	denySSH := []ACLRule{{Action: ACLActionDeny, Protocol: ACLProtocolTCP, Ports: "22"}}
+	allowAll := []ACLRule{{Action: ACLActionAllow}}
+	truncatedChain := buildIPv6([]v6ext{optionsExt(0, 1)}, 6, tcp22)[:48]
+
+	tests := []struct {
+		name     string
+		rules    []ACLRule
+		defaults string
+		packet   []byte
+		want     bool
+	}{
+		// 扩展头不能绕过按端口的禁止规则
+		{"禁止端口: 无扩展头", denySSH, ACLActionAllow, buildIPv6(nil, 6, tcp22), false},
+		{"禁止端口: 逐跳选项", denySSH, ACLActionAllow, buildIPv6([]v6ext{optionsExt(0, 0)}, 6, tcp22), false},
+		{"禁止端口: 目的选项", denySSH, ACLActionAllow, buildIPv6([]v6ext{optionsExt(60, 1)}, 6, tcp22), false},
+		{"禁止端口: 认证头", denySSH, ACLActionAllow, buildIPv6([]v6ext{authExt()}, 6, tcp22), false},
+		{"禁止端口: 首个分片", denySSH, ACLActionAllow, buildIPv6([]v6ext{fragmentExt(0, true)}, 6, tcp22), false},
+		{"禁止端口: 其他端口放行", denySSH, ACLActionAllow, buildIPv6([]v6ext{optionsExt(0, 0)}, 6, tcp80), true},
+		// 没有端口的包不匹配指定了端口的禁止规则，按默认动作处理
+		{"禁止端口: IPv6 非首个分片", denySSH, ACLActionAllow, buildIPv6([]v6ext{fragmentExt(185, false)}, 6, tcp22), true},
+		{"禁止端口: IPv4 非首个分片", denySSH, ACLActionAllow, buildIPv4(6, 185, tcp22), true},
+		{"禁止端口: UDP", denySSH, ACLActionAllow, buildIPv6(nil, 17, transportHeader(17, 22)), true},
+		{"禁止端口: 默认丢弃", denySSH, ACLActionDeny, buildIPv4(6, 185, tcp22), false},
+		{"禁止TCP: 非首个分片", []ACLRule{{Action: ACLActionDeny, Protocol: ACLProtocolTCP}}, ACLActionAllow,
+			buildIPv6([]v6ext{fragmentExt(185, false)}, 6, tcp22), false},
+		// 无法解析上层头部的包不匹配放行规则
+		{"放行规则: 正常包", allowAll, ACLActionDeny, buildIPv6([]v6ext{optionsExt(0, 0)}, 6, tcp22), true},
+		{"放行规则: 扩展头被截断", allowAll, ACLActionDeny, truncatedChain, false},
+		{"放行规则: TCP头部被截断", allowAll, ACLActionDeny, buildIPv4(6, 0, tcp22[:2]), false},
+		{"放行端口: 非首个分片", []ACLRule{{Action: ACLActionAllow, Protocol: ACLProtocolTCP, Ports: "22"}}, ACLActionDeny,
+			buildIPv6([]v6ext{fragmentExt(185, false)}, 6, tcp22), false},
+		// 禁止规则仍然匹配无法解析的包
+		{"禁止目标: 扩展头被截断", []ACLRule{{Action: ACLActionDeny, Dst: "fd00::/64"}, {Action: ACLActionAllow}}, ACLActionAllow,
+			truncatedChain, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig
+			config.ACLRules = tt.rules
+			config.ACLDefault = tt.defaults
+			if err := config.ValidateConfig(); err != nil {
+				t.Fatalf("配置无效: %v", err)
+			}
+			if got := newACLPolicy(&config, nil).allow("client-1", "", tt.packet); got != tt.want {
+				t.Fatalf("allow() = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
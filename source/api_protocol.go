@@ -36,13 +36,40 @@ type ServerStatusResponse struct {
 	ClientCount int    `json:"client_count"`
 	TotalSent   uint64 `json:"total_sent"`
 	TotalRecv   uint64 `json:"total_recv"`
+	Draining    bool   `json:"draining,omitempty"` // 正在排空会话，不再接受新连接
 }
 
 // ClientInfo 客户端信息
 type ClientInfo struct {
-	IP            string    `json:"ip"`
-	BytesSent     uint64    `json:"bytes_sent"`
-	BytesReceived uint64    `json:"bytes_received"`
+	IP            string `json:"ip"`
+	IP6           string `json:"ip6,omitempty"`
+	CN            string `json:"cn,omitempty"`    // 证书CN
+	Group         string `json:"group,omitempty"` // 客户端分组（证书OU）
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+	WireSent      uint64 `json:"wire_sent"`     // 压缩后实际发送字节数
+	WireReceived  uint64 `json:"wire_received"` // 压缩后实际接收字节数
+	Dropped       uint64 `json:"dropped"`       // 发送队列溢出丢弃的消息数
+	DroppedBytes  uint64 `json:"dropped_bytes"` // 发送队列溢出丢弃的字节数
+	Links         int    `json:"links"`         // TLS连接数（多连接聚合时大于1）
+	PathMTU       int    `json:"path_mtu"`      // 客户端报告的UDP路径MTU（0=未报告）
+	// 带宽限速（上行为客户端到服务端方向）
+	LimitUpKbps        int     `json:"limit_up_kbps"`        // 上行限速（kbit/s，0=不限速）
+	LimitDownKbps      int     `json:"limit_down_kbps"`      // 下行限速（kbit/s，0=不限速）
+	RateUp             uint64  `json:"rate_up"`              // 当前上行速率（字节/秒）
+	RateDown           uint64  `json:"rate_down"`            // 当前下行速率（字节/秒）
+	ThrottledUp        uint64  `json:"throttled_up"`         // 超出上行限速被推迟（TLS）或丢弃（UDP）的包数
+	ThrottledDown      uint64  `json:"throttled_down"`       // 超出下行限速丢弃的包数
+	ThrottledUpBytes   uint64  `json:"throttled_up_bytes"`   // 超出上行限速的字节数
+	ThrottledDownBytes uint64  `json:"throttled_down_bytes"` // 超出下行限速的字节数
+	ThrottleDelayUpMs  float64 `json:"throttle_delay_up_ms"` // 上行累计推迟的时间（毫秒）
+	ACLDenied          uint64  `json:"acl_denied"`           // 被ACL丢弃的包数
+	Spoofed            uint64  `json:"spoofed"`              // 源地址校验失败丢弃的包数
+	// 链路质量（服务端心跳探测的结果，客户端不支持时为0）
+	RTTMs         float64   `json:"rtt_ms"`         // 平滑往返时延（毫秒）
+	JitterMs      float64   `json:"jitter_ms"`      // 往返时延抖动（毫秒）
+	HeartbeatLoss float64   `json:"heartbeat_loss"` // 最近的心跳探测丢失率（百分比）
+	Measured      bool      `json:"measured"`       // 客户端支持链路质量测量且已发出探测
 	ConnectedAt   time.Time `json:"connected_at"`
 	Duration      string    `json:"duration"`
 }
@@ -57,6 +84,30 @@ type KickClientRequest struct {
 	IP string `json:"ip"`
 }
 
+// DrainRequest 排空会话请求
+type DrainRequest struct {
+	TimeoutSec int `json:"timeout_sec,omitempty"` // 最长等待时间（0=使用配置的 drain_timeout_sec）
+}
+
+// QuotaInfo 客户端流量配额状态
+type QuotaInfo struct {
+	CN          string    `json:"cn"`
+	Period      string    `json:"period"` // 配额周期: "daily"、"weekly" 或 "monthly"
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	LimitBytes  uint64    `json:"limit_bytes"` // 本周期配额（0=不限）
+	BytesUp     uint64    `json:"bytes_up"`    // 本周期上行流量（客户端→服务端）
+	BytesDown   uint64    `json:"bytes_down"`  // 本周期下行流量（服务端→客户端）
+	Exceeded    bool      `json:"exceeded"`    // 已超出配额
+	Online      bool      `json:"online"`
+}
+
+// QuotaListResponse 流量配额状态响应
+type QuotaListResponse struct {
+	Action string      `json:"action"` // 超出配额后的处理: "warn"、"throttle" 或 "disconnect"
+	Quotas []QuotaInfo `json:"quotas"`
+}
+
 // --- 客户端相关 ---
 
 // VPNClientStatusResponse VPN客户端状态响应
@@ -65,7 +116,23 @@ type VPNClientStatusResponse struct {
 	ServerAddress string `json:"server_address,omitempty"`
 	ServerPort    int    `json:"server_port,omitempty"`
 	AssignedIP    string `json:"assigned_ip,omitempty"`
+	AssignedIP6   string `json:"assigned_ip6,omitempty"`
 	TUNDevice     string `json:"tun_device,omitempty"`
+	DataChannel   string `json:"data_channel,omitempty"` // 数据通道: "udp" 或 "tcp"
+	Links         int    `json:"links,omitempty"`        // TLS连接数（多连接聚合时大于1）
+	MTU           int    `json:"mtu,omitempty"`          // 隧道MTU（路径MTU探测后可能小于服务端推送的值）
+	Notice        string `json:"notice,omitempty"`       // 服务端最近一次发来的通知（如流量配额已用尽）
+	// 服务端最近一次通知断开的原因（如 "kick"、"pool-full"）和说明，断开后仍保留
+	DisconnectReason  string `json:"disconnect_reason,omitempty"`
+	DisconnectMessage string `json:"disconnect_message,omitempty"`
+	// 链路质量（客户端心跳探测的结果，服务端不支持时省略）
+	RTTMs         float64 `json:"rtt_ms,omitempty"`
+	JitterMs      float64 `json:"jitter_ms,omitempty"`
+	HeartbeatLoss float64 `json:"heartbeat_loss,omitempty"`
+	Measured      bool    `json:"measured,omitempty"`
+	// 协议协商结果
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	Features        []string `json:"features,omitempty"`
 }
 
 // --- 证书相关 ---
@@ -130,6 +197,72 @@ type DeleteTokenRequest struct {
 	Index int `json:"index"` // Token 索引（从1开始）
 }
 
+// --- IP租约相关 ---
+
+// LeaseInfo IP租约信息
+type LeaseInfo struct {
+	Key       string    `json:"key"` // 证书CN或序列号
+	IP        string    `json:"ip"`
+	IP6       string    `json:"ip6,omitempty"`
+	Static    bool      `json:"static"`     // 静态租约，永不过期
+	ExpiresAt time.Time `json:"expires_at"` // 静态租约为零值
+	Online    bool      `json:"online"`     // 持有该租约的客户端是否在线
+}
+
+// LeaseListResponse IP租约列表响应
+type LeaseListResponse struct {
+	Leases []LeaseInfo `json:"leases"`
+}
+
+// SetLeaseRequest 设置IP租约请求
+type SetLeaseRequest struct {
+	Key    string `json:"key"`
+	IP     string `json:"ip"`
+	IP6    string `json:"ip6,omitempty"`
+	Static bool   `json:"static"`
+}
+
+// DeleteLeaseRequest 删除IP租约请求
+type DeleteLeaseRequest struct {
+	Key string `json:"key"`
+}
+
+// --- 带宽限速相关 ---
+
+// RateLimitListResponse 带宽限速配置响应
+type RateLimitListResponse struct {
+	UpKbps   int             `json:"up_kbps"`   // 默认上行限速（kbit/s，0=不限速）
+	DownKbps int             `json:"down_kbps"` // 默认下行限速（kbit/s，0=不限速）
+	Rules    []RateLimitRule `json:"rules"`     // 按证书CN或分组的限速规则
+}
+
+// --- 访问控制相关 ---
+
+// ACLRuleInfo ACL规则及其命中次数
+type ACLRuleInfo struct {
+	Index int `json:"index"` // 规则序号（从1开始，按匹配顺序）
+	ACLRule
+	Hits uint64 `json:"hits"`
+}
+
+// ACLListResponse ACL配置响应
+type ACLListResponse struct {
+	Default     string        `json:"default"`      // 没有规则匹配时的动作: "allow" 或 "deny"
+	DefaultHits uint64        `json:"default_hits"` // 按默认动作处理的包数
+	Rules       []ACLRuleInfo `json:"rules"`
+}
+
+// ACLAddRequest 添加ACL规则请求
+type ACLAddRequest struct {
+	ACLRule
+	Position int `json:"position,omitempty"` // 插入位置（从1开始，0=追加到末尾）
+}
+
+// ACLDeleteRequest 删除ACL规则请求
+type ACLDeleteRequest struct {
+	Index int `json:"index"` // 规则序号（从1开始）
+}
+
 // --- 配置相关 ---
 
 // ConfigResponse 配置响应
@@ -148,6 +281,7 @@ type UpdateConfigRequest struct {
 // GenerateCSRRequest 生成 CSR 请求
 type GenerateCSRRequest struct {
 	ClientName string `json:"client_name"`
+	Group      string `json:"group,omitempty"` // 客户端分组（写入证书OU）
 }
 
 // GenerateCSRResponse 生成 CSR 响应
@@ -199,6 +333,9 @@ const (
 	ActionServerClients = "server/clients"
 	ActionServerKick    = "server/kick"
 	ActionServerStats   = "server/stats"
+	ActionServerDrain   = "server/drain"
+	ActionServerUpgrade = "server/upgrade"
+	ActionServerQuotas  = "server/quotas"
 
 	// 客户端
 	ActionClientConnect    = "client/connect"
@@ -219,6 +356,22 @@ const (
 	ActionTokenDelete   = "token/delete"
 	ActionTokenCleanup  = "token/cleanup"
 
+	// IP租约
+	ActionLeaseList   = "lease/list"
+	ActionLeaseSet    = "lease/set"
+	ActionLeaseDelete = "lease/delete"
+
+	// 带宽限速
+	ActionRateLimitList   = "ratelimit/list"
+	ActionRateLimitSet    = "ratelimit/set"
+	ActionRateLimitDelete = "ratelimit/delete"
+
+	// 访问控制
+	ActionACLList   = "acl/list"
+	ActionACLAdd    = "acl/add"
+	ActionACLDelete = "acl/delete"
+	ActionACLReload = "acl/reload"
+
 	// 配置
 	ActionConfigGet    = "config/get"
 	ActionConfigUpdate = "config/update"
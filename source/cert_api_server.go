@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"log"
 	"math/big"
+	"net"
 	"net/http"
 	"os"
 	"time"
@@ -56,7 +57,7 @@ func (api *CertAPIServer) Start() error {
 	mux.HandleFunc("/api/health", api.handleHealth)
 
 	api.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", api.port),
+		Addr:    net.JoinHostPort("", fmt.Sprintf("%d", api.port)),
 		Handler: mux,
 	}
 
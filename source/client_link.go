@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// clientLink 客户端的一条TLS连接。每条连接有独立的写入锁、序列号和批量发送器，
+// 启用多连接聚合时数据包按流分配到各条连接（协议见 session_link.go）
+type clientLink struct {
+	slot       int // 连接槽位，决定绑定的本地地址（第一条连接为0）
+	conn       tunnelConn
+	writeMutex sync.Mutex     // 写入锁（保证序列号顺序与写入顺序一致）
+	sendSeq    uint32         // 发送序列号（持有 writeMutex 时访问）
+	recvSeq    uint32         // 接收序列号（仅由该连接的读取协程访问）
+	batcher    *packetBatcher // 服务端支持批量传输时创建，连接加入客户端后不再修改
+	closeOnce  sync.Once
+}
+
+// newClientLink 创建连接，batching 为 true 时创建批量发送器
+func newClientLink(slot int, conn tunnelConn, batching bool, config *VPNConfig) *clientLink {
+	link := &clientLink{slot: slot, conn: conn}
+	if batching {
+		link.batcher = newPacketBatcher(config.GetBatchDelay(), config.GetBatchMaxBytes(),
+			func(msgType MessageType, payload []byte, packetBytes uint64, buf *packetBuffer) error {
+				defer buf.release()
+				return link.writeMessage(msgType, payload)
+			})
+	}
+	return link
+}
+
+// writeMessage 发送带序列号和校验和的消息（消息头和负载编码进同一个池化缓冲区后一次写入）
+func (l *clientLink) writeMessage(msgType MessageType, data []byte) error {
+	buf := getPacketBuffer()
+	defer buf.release()
+
+	// 序列号分配和写入在同一把锁内完成，保证线路上的序列号递增
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+
+	seq := l.sendSeq
+	l.sendSeq++
+	buf.b = appendMessage(buf.b, msgType, seq, data, true)
+	_, err := l.conn.Write(buf.b)
+	return err
+}
+
+// writeHeartbeat 发送心跳（不使用序列号和校验和，旧版服务端只发送空心跳）
+func (l *clientLink) writeHeartbeat(payload []byte) error {
+	buf := getPacketBuffer()
+	defer buf.release()
+	buf.b = appendMessage(buf.b, MessageTypeHeartbeat, 0, payload, false)
+
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+	_, err := l.conn.Write(buf.b)
+	return err
+}
+
+// receive 通过 reader 接收一条消息，返回消息类型和数据（数据在下一次读取前有效）
+func (l *clientLink) receive(reader *frameReader) (MessageType, []byte, error) {
+	msgType, length, sequence, checksum, err := reader.ReadHeader()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// 读取消息体
+	payload, err := reader.ReadPayload(length)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// 验证序列号（心跳和IP分配消息除外）
+	if msgType != MessageTypeHeartbeat && msgType != MessageTypeIPAssignment {
+		// 检测重放攻击（序列号回退）
+		if sequence < l.recvSeq {
+			return 0, nil, fmt.Errorf("检测到重放攻击：期望序列号 >= %d，收到 %d", l.recvSeq, sequence)
+		}
+		// 检测消息丢失（序列号跳跃）
+		if sequence > l.recvSeq+1 && l.recvSeq > 0 {
+			log.Printf("警告：检测到消息丢失，期望序列号 %d，收到 %d", l.recvSeq+1, sequence)
+		}
+		l.recvSeq = sequence
+	}
+
+	// 验证校验和（如果提供）
+	if checksum != 0 && len(payload) > 0 {
+		actualChecksum := crc32.ChecksumIEEE(payload)
+		if actualChecksum != checksum {
+			return 0, nil, fmt.Errorf("消息校验和不匹配: 期望 %d, 收到 %d", actualChecksum, checksum)
+		}
+	}
+
+	return msgType, payload, nil
+}
+
+// close 关闭连接并停止批量发送器
+func (l *clientLink) close() {
+	l.closeOnce.Do(func() {
+		_ = l.conn.Close()
+		if l.batcher != nil {
+			l.batcher.Stop()
+		}
+	})
+}
+
+// ================ 多连接聚合（客户端） ================
+
+// bondHandshakeTimeout 成员连接加入会话的超时
+const bondHandshakeTimeout = 30 * time.Second
+
+// dialServer 建立到服务器的TLS 1.3连接（WebSocket传输时在其上完成升级）。
+// slot 决定绑定的本地地址（bond_local_addrs），host 为空或经过代理时使用配置的服务器地址
+func (c *VPNClient) dialServer(ctx context.Context, slot int, host string) (tunnelConn, error) {
+	proxy, err := c.transportProxy()
+	if err != nil {
+		return nil, fmt.Errorf("无效的代理配置: %v", err)
+	}
+	if host == "" || proxy != nil {
+		host = c.config.ServerAddress
+	}
+	address := net.JoinHostPort(host, fmt.Sprintf("%d", c.config.ServerPort))
+
+	// 使用带超时的 dialer
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if addrs := c.config.BondLocalAddrs; len(addrs) > 0 {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(addrs[slot%len(addrs)])}
+	}
+	var netConn net.Conn
+	if proxy != nil {
+		netConn, err = dialProxy(ctx, dialer, proxy, address)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("连接失败: %v", err)
+	}
+
+	// 升级为 TLS 连接
+	conn := tls.Client(netConn, c.tlsConfig)
+
+	err = conn.Handshake()
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("TLS握手失败: %v", err)
+	}
+
+	// 验证TLS版本
+	if conn.ConnectionState().Version != tls.VersionTLS13 {
+		_ = conn.Close()
+		return nil, fmt.Errorf("未使用TLS 1.3协议")
+	}
+
+	if c.config.GetTransport() == TransportWebSocket {
+		ws, err := c.upgradeWebSocket(conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return ws, nil
+	}
+	return conn, nil
+}
+
+// joinBond 建立一条成员连接并加入当前会话
+func (c *VPNClient) joinBond(ctx context.Context, slot int, token string) (*clientLink, error) {
+	// 成员连接直接连接主连接解析到的服务器地址，避免域名解析到其他服务器
+	c.connMutex.Lock()
+	host := ""
+	if c.serverAddrIP != nil {
+		host = c.serverAddrIP.String()
+	}
+	c.connMutex.Unlock()
+
+	conn, err := c.dialServer(ctx, slot, host)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := c.joinBondHandshake(conn, slot, token)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return link, nil
+}
+
+// joinBondHandshake 在新连接上携带凭据发送 Hello，服务端以本会话的 IPAssignment 确认加入
+func (c *VPNClient) joinBondHandshake(conn tunnelConn, slot int, token string) (*clientLink, error) {
+	_ = conn.SetDeadline(time.Now().Add(bondHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	err := writeHello(conn, &Hello{
+		Version:     ProtocolVersion,
+		MinVersion:  MinProtocolVersion,
+		Features:    c.clientFeatures(),
+		AuthMethods: []string{AuthMethodCert},
+		BondToken:   token,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("发送Hello消息失败: %v", err)
+	}
+
+	msgType, payload, err := readHandshakeMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("读取消息失败: %v", err)
+	}
+	if msgType != MessageTypeHello {
+		return nil, fmt.Errorf("期望Hello消息，收到消息类型 %d", msgType)
+	}
+	var reply Hello
+	if err := json.Unmarshal(payload, &reply); err != nil {
+		return nil, fmt.Errorf("解析Hello消息失败: %v", err)
+	}
+	if reply.Error != "" {
+		return nil, fmt.Errorf("服务端拒绝连接: %s", reply.Error)
+	}
+	if !reply.HasFeature(FeatureBonding) {
+		return nil, fmt.Errorf("服务端未启用多连接聚合")
+	}
+
+	msgType, payload, err = readHandshakeMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("加入会话失败: %v", err)
+	}
+	if msgType != MessageTypeIPAssignment || !net.IP(payload).Equal(c.assignedIP) {
+		return nil, fmt.Errorf("加入会话失败: 服务端返回的IP与当前会话不一致")
+	}
+
+	return newClientLink(slot, conn, reply.HasFeature(FeatureBatching), &c.config), nil
+}
+
+// maintainBond 建立其余成员连接，成员连接断开后定期补齐
+func (c *VPNClient) maintainBond(ctx context.Context) {
+	ticker := time.NewTicker(c.config.ReconnectDelay)
+	defer ticker.Stop()
+
+	for {
+		c.fillBondLinks(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fillBondLinks 为空缺的连接槽位建立成员连接
+func (c *VPNClient) fillBondLinks(ctx context.Context) {
+	c.connMutex.Lock()
+	token, want := c.bondToken, c.bondLinks
+	used := make(map[int]bool, len(c.links))
+	for _, link := range c.links {
+		used[link.slot] = true
+	}
+	active := len(c.links) > 0
+	c.connMutex.Unlock()
+
+	if !active || token == "" {
+		return
+	}
+	for slot := 0; slot < want && ctx.Err() == nil; slot++ {
+		if used[slot] {
+			continue
+		}
+		link, err := c.joinBond(ctx, slot, token)
+		if err != nil {
+			log.Printf("建立聚合连接 #%d 失败: %v", slot, err)
+			continue
+		}
+		if !c.addLink(link) {
+			link.close()
+			return
+		}
+		log.Printf("已建立聚合连接 #%d (本地地址 %s)", slot, link.conn.LocalAddr())
+		go c.linkLoop(ctx, link)
+	}
+}
+
+// addLink 加入一条已完成握手的连接，会话已结束时返回 false
+func (c *VPNClient) addLink(link *clientLink) bool {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	if len(c.links) == 0 {
+		return false
+	}
+	// 替换切片而不是原地修改，getLinks 返回的切片不受影响
+	c.links = append(c.links[:len(c.links):len(c.links)], link)
+	return true
+}
+
+// removeLink 移除并关闭一条连接，返回剩余的连接数。第一条连接断开时由下一条连接接替承载控制消息，
+// 所有连接都断开时通知 runLinks 返回
+func (c *VPNClient) removeLink(link *clientLink) int {
+	c.connMutex.Lock()
+	for i, l := range c.links {
+		if l == link {
+			c.links = append(c.links[:i:i], c.links[i+1:]...)
+			break
+		}
+	}
+	remaining := len(c.links)
+	if remaining > 0 {
+		c.conn = c.links[0].conn
+	} else {
+		c.closeLinksDone()
+	}
+	c.connMutex.Unlock()
+
+	link.close()
+	return remaining
+}
+
+// closeLinksDone 通知所有连接都已断开（调用方持有 connMutex）
+func (c *VPNClient) closeLinksDone() {
+	if c.linksDone == nil {
+		return
+	}
+	select {
+	case <-c.linksDone:
+	default:
+		close(c.linksDone)
+	}
+}
+
+// getLinks 返回当前所有连接（返回值只读）
+func (c *VPNClient) getLinks() []*clientLink {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	return c.links
+}
+
+// hasLink 判断连接是否仍属于当前会话
+func (c *VPNClient) hasLink(link *clientLink) bool {
+	for _, l := range c.getLinks() {
+		if l == link {
+			return true
+		}
+	}
+	return false
+}
+
+// linkForPacket 按流哈希为IP包选择连接，没有连接时返回nil
+func (c *VPNClient) linkForPacket(packet []byte) *clientLink {
+	links := c.getLinks()
+	switch len(links) {
+	case 0:
+		return nil
+	case 1:
+		return links[0]
+	}
+	return links[flowHash(packet)%uint32(len(links))]
+}
+
+// LinkCount 返回当前的TLS连接数
+func (c *VPNClient) LinkCount() int {
+	return len(c.getLinks())
+}
+
+// runLinks 为已建立的连接启动接收协程，所有连接都断开（或 ctx 取消）后返回
+func (c *VPNClient) runLinks(ctx context.Context) {
+	c.connMutex.Lock()
+	links := c.links
+	done := c.linksDone
+	c.connMutex.Unlock()
+
+	for _, link := range links {
+		go c.linkLoop(ctx, link)
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// linkLoop 运行一条连接的接收循环，结束后将其移除
+func (c *VPNClient) linkLoop(ctx context.Context, link *clientLink) {
+	c.dataLoop(ctx, link)
+	if remaining := c.removeLink(link); remaining > 0 {
+		log.Printf("聚合连接 #%d 已断开，剩余 %d 条连接", link.slot, remaining)
+	}
+}
+
+// linkFailed 关闭发送失败的连接（由其接收循环移除）。仍有其他连接时该包按丢包处理并返回nil，
+// 否则返回原错误
+func (c *VPNClient) linkFailed(link *clientLink, err error) error {
+	link.close()
+	for _, l := range c.getLinks() {
+		if l != link {
+			return nil
+		}
+	}
+	return err
+}
+
+// flushBatchers 立即发送所有连接上的待发批次
+func (c *VPNClient) flushBatchers() error {
+	var firstErr error
+	for _, link := range c.getLinks() {
+		if link.batcher == nil {
+			continue
+		}
+		if err := link.batcher.Flush(); err != nil {
+			if err = c.linkFailed(link, err); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ================ 数据包压缩 ================
+//
+// 每个IP包独立压缩（不共享字典），因此压缩包可以在UDP通道上乱序或丢失，
+// 也可以与未压缩的包混合发送。压缩后的包使用 MessageTypeCompressedData，
+// 在批量消息中则通过条目长度的最高位标记。
+
+const (
+	compressionDeflate = "deflate"
+
+	// compressionMinSize 小于该长度的包不压缩（压缩收益不足以抵消开销）
+	compressionMinSize = 128
+	// compressionMaxSize 解压后允许的最大长度，防止解压炸弹
+	compressionMaxSize = 65535
+)
+
+// incompressiblePorts 已加密流量的常用端口（TLS/QUIC/SSH/IPsec/WireGuard等），直接跳过压缩
+var incompressiblePorts = map[uint16]bool{
+	22:    true,
+	443:   true,
+	465:   true,
+	853:   true,
+	993:   true,
+	995:   true,
+	4500:  true,
+	51820: true,
+}
+
+// sliceWriter 将写入的数据追加到切片（配合池化缓冲区避免分配）
+type sliceWriter struct {
+	b []byte
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+// deflater 池化的压缩器及其输出缓冲
+type deflater struct {
+	w   *flate.Writer
+	out sliceWriter
+}
+
+// inflater 池化的解压器及其输入
+type inflater struct {
+	r  io.ReadCloser
+	in bytes.Reader
+}
+
+var deflaterPool = sync.Pool{
+	New: func() interface{} {
+		d := &deflater{}
+		d.w, _ = flate.NewWriter(&d.out, flate.BestSpeed)
+		return d
+	},
+}
+
+var inflaterPool = sync.Pool{
+	New: func() interface{} {
+		f := &inflater{}
+		f.r = flate.NewReader(&f.in)
+		return f
+	},
+}
+
+// compressPacket 将IP包压缩后追加到 dst，压缩无收益时返回 (packet, false)，调用方应发送原始数据。
+// dst 通常是池化缓冲区，容量足够时不分配内存
+func compressPacket(dst, packet []byte) ([]byte, bool) {
+	if len(packet) < compressionMinSize || isEncryptedFlow(packet) {
+		return packet, false
+	}
+
+	d := deflaterPool.Get().(*deflater)
+	d.out.b = dst[:0]
+	d.w.Reset(&d.out)
+	_, err := d.w.Write(packet)
+	if err == nil {
+		err = d.w.Close()
+	}
+	out := d.out.b
+	d.out.b = nil
+	deflaterPool.Put(d)
+	if err != nil {
+		return packet, false
+	}
+
+	// 至少节省 1/16 才使用压缩结果
+	if len(out) > len(packet)-len(packet)/16 || len(out) > batchLengthMask {
+		return packet, false
+	}
+	return out, true
+}
+
+// decompressPacket 解压IP包到 dst 的底层数组（dst 容量不足 compressionMaxSize+1 时重新分配）
+func decompressPacket(dst, data []byte) ([]byte, error) {
+	if cap(dst) <= compressionMaxSize {
+		dst = make([]byte, 0, compressionMaxSize+1)
+	}
+	buf := dst[:cap(dst)]
+
+	f := inflaterPool.Get().(*inflater)
+	defer inflaterPool.Put(f)
+	f.in.Reset(data)
+	if err := f.r.(flate.Resetter).Reset(&f.in, nil); err != nil {
+		return nil, fmt.Errorf("初始化解压失败: %v", err)
+	}
+
+	n := 0
+	for {
+		k, err := f.r.Read(buf[n:])
+		n += k
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解压数据包失败: %v", err)
+		}
+		if n > compressionMaxSize {
+			return nil, fmt.Errorf("解压后数据包过大")
+		}
+	}
+	return buf[:n], nil
+}
+
+// decodePacket 还原收到的数据包（未压缩时原样返回，压缩时解压到 dst）
+func decodePacket(dst, payload []byte, compressed bool) ([]byte, error) {
+	if !compressed {
+		return payload, nil
+	}
+	return decompressPacket(dst, payload)
+}
+
+// isEncryptedFlow 判断IP包是否属于已加密的流量（ESP或加密协议常用端口）
+func isEncryptedFlow(packet []byte) bool {
+	var proto byte
+	var l4 []byte
+	switch packet[0] >> 4 {
+	case 4:
+		ihl := int(packet[0]&0x0F) * 4
+		if ihl < 20 || len(packet) < ihl {
+			return false
+		}
+		proto = packet[9]
+		l4 = packet[ihl:]
+	case 6:
+		if len(packet) < 40 {
+			return false
+		}
+		proto = packet[6]
+		l4 = packet[40:]
+	default:
+		return false
+	}
+
+	switch proto {
+	case 50: // ESP
+		return true
+	case 6, 17: // TCP, UDP
+		if len(l4) < 4 {
+			return false
+		}
+		src := binary.BigEndian.Uint16(l4[0:2])
+		dst := binary.BigEndian.Uint16(l4[2:4])
+		return incompressiblePorts[src] || incompressiblePorts[dst]
+	}
+	return false
+}
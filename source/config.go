@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -22,106 +24,290 @@ const DefaultPIDFile = "/var/run/tlsvpn.pid"
 // DefaultTokenDir 默认Token目录
 const DefaultTokenDir = "./tokens"
 
+// DefaultLeaseFile 默认IP租约文件
+const DefaultLeaseFile = "./leases.json"
+
+// DefaultQuotaFile 默认流量配额用量文件
+const DefaultQuotaFile = "./quotas.json"
+
+// 客户端互访策略
+const (
+	ClientToClientAllow     = "allow"           // 允许客户端之间互访
+	ClientToClientDeny      = "deny"            // 禁止客户端之间互访
+	ClientToClientSameGroup = "same-group-only" // 仅允许同一分组（证书OU）的客户端互访
+)
+
+// 会话发送队列溢出策略
+const (
+	SendQueueDropNewest = "drop-newest" // 队列满时丢弃新到的数据包
+	SendQueueDropOldest = "drop-oldest" // 队列满时丢弃最早排队的数据包
+	SendQueueDisconnect = "disconnect"  // 队列满时断开该客户端
+)
+
+// IP租约绑定的证书字段
+const (
+	LeaseKeyCN     = "cn"     // 按证书CN绑定（同一客户端重新签发证书后地址不变）
+	LeaseKeySerial = "serial" // 按证书序列号绑定（每张证书独立的地址）
+)
+
+// 流量配额周期
+const (
+	QuotaPeriodDaily   = "daily"   // 按自然日
+	QuotaPeriodWeekly  = "weekly"  // 按自然周（周一开始）
+	QuotaPeriodMonthly = "monthly" // 按自然月
+)
+
+// 超出流量配额后的处理
+const (
+	QuotaActionWarn       = "warn"       // 通知客户端
+	QuotaActionThrottle   = "throttle"   // 通知客户端并限速
+	QuotaActionDisconnect = "disconnect" // 断开客户端，本周期内拒绝连接
+)
+
 // ConfigFile JSON配置文件结构（用于序列化和反序列化）
 type ConfigFile struct {
-	ServerAddress             string   `json:"server_address"`
-	ServerPort                int      `json:"server_port"`
-	ClientAddress             string   `json:"client_address"`
-	Network                   string   `json:"network"`
-	MTU                       int      `json:"mtu"`
-	KeepAliveTimeoutSec       int      `json:"keep_alive_timeout_sec"`
-	ReconnectDelaySec         int      `json:"reconnect_delay_sec"`
-	MaxConnections            int      `json:"max_connections"`
-	SessionTimeoutSec         int      `json:"session_timeout_sec"`
-	SessionCleanupIntervalSec int      `json:"session_cleanup_interval_sec"`
-	ServerIP                  string   `json:"server_ip"`
-	ClientIPStart             int      `json:"client_ip_start"`
-	ClientIPEnd               int      `json:"client_ip_end"`
-	DNSServers                []string `json:"dns_servers"`
-	PushRoutes                []string `json:"push_routes"`
-	RouteMode                 string   `json:"route_mode"`
-	ExcludeRoutes             []string `json:"exclude_routes"`
-	RedirectGateway           bool     `json:"redirect_gateway"`
-	RedirectDNS               bool     `json:"redirect_dns"`
-	EnableNAT                 bool     `json:"enable_nat"`
-	NATInterface              string   `json:"nat_interface"`
+	ServerAddress             string             `json:"server_address"`
+	ServerPort                int                `json:"server_port"`
+	ClientAddress             string             `json:"client_address"`
+	Network                   string             `json:"network"`
+	MTU                       int                `json:"mtu"`
+	KeepAliveTimeoutSec       int                `json:"keep_alive_timeout_sec"`
+	ReconnectDelaySec         int                `json:"reconnect_delay_sec"`
+	MaxConnections            int                `json:"max_connections"`
+	SessionTimeoutSec         int                `json:"session_timeout_sec"`
+	SessionCleanupIntervalSec int                `json:"session_cleanup_interval_sec"`
+	ServerIP                  string             `json:"server_ip"`
+	ClientIPStart             int                `json:"client_ip_start"`
+	ClientIPEnd               int                `json:"client_ip_end"`
+	DNSServers                []string           `json:"dns_servers"`
+	PushRoutes                []string           `json:"push_routes"`
+	RouteMode                 string             `json:"route_mode"`
+	ExcludeRoutes             []string           `json:"exclude_routes"`
+	RedirectGateway           bool               `json:"redirect_gateway"`
+	RedirectDNS               bool               `json:"redirect_dns"`
+	EnableNAT                 bool               `json:"enable_nat"`
+	NATInterface              string             `json:"nat_interface"`
+	EnableUDP                 bool               `json:"enable_udp"`
+	UDPPort                   int                `json:"udp_port"`
+	EnableBatching            bool               `json:"enable_batching"`
+	BatchDelayUs              int                `json:"batch_delay_us"`
+	BatchMaxBytes             int                `json:"batch_max_bytes"`
+	EnableCompression         bool               `json:"enable_compression"`
+	ClientToClient            string             `json:"client_to_client"`
+	Network6                  string             `json:"network6"`
+	SendQueueSize             int                `json:"send_queue_size"`
+	SendQueuePolicy           string             `json:"send_queue_policy"`
+	TUNQueues                 int                `json:"tun_queues"`
+	TUNOffload                bool               `json:"tun_offload"`
+	BondLinks                 int                `json:"bond_links"`
+	BondLocalAddrs            []string           `json:"bond_local_addrs"`
+	LeaseFile                 string             `json:"lease_file"`
+	LeaseDurationHours        int                `json:"lease_duration_hours"`
+	LeaseKey                  string             `json:"lease_key"`
+	DrainTimeoutSec           int                `json:"drain_timeout_sec"`
+	DisableMSSClamp           bool               `json:"disable_mss_clamp"`
+	RateLimitUpKbps           int                `json:"rate_limit_up_kbps"`
+	RateLimitDownKbps         int                `json:"rate_limit_down_kbps"`
+	RateLimits                []RateLimitRule    `json:"rate_limits"`
+	QuotaFile                 string             `json:"quota_file"`
+	QuotaPeriod               string             `json:"quota_period"`
+	QuotaDefaultMB            int                `json:"quota_default_mb"`
+	QuotaAction               string             `json:"quota_action"`
+	QuotaThrottleKbps         int                `json:"quota_throttle_kbps"`
+	Quotas                    []QuotaRule        `json:"quotas"`
+	ACLDefault                string             `json:"acl_default"`
+	ACLRules                  []ACLRule          `json:"acl_rules"`
+	ClientSubnets             []ClientSubnetRule `json:"client_subnets"`
+	SpoofDisconnectThreshold  int                `json:"spoof_disconnect_threshold"`
+	Transport                 string             `json:"transport"`
+	ProxyURL                  string             `json:"proxy_url"`
+	WebSocketPort             int                `json:"websocket_port"`
+	WebSocketPath             string             `json:"websocket_path"`
 }
 
 // ToVPNConfig 将ConfigFile转换为VPNConfig
 func (cf *ConfigFile) ToVPNConfig() VPNConfig {
 	return VPNConfig{
-		ServerAddress:          cf.ServerAddress,
-		ServerPort:             cf.ServerPort,
-		ClientAddress:          cf.ClientAddress,
-		Network:                cf.Network,
-		MTU:                    cf.MTU,
-		KeepAliveTimeout:       time.Duration(cf.KeepAliveTimeoutSec) * time.Second,
-		ReconnectDelay:         time.Duration(cf.ReconnectDelaySec) * time.Second,
-		MaxConnections:         cf.MaxConnections,
-		SessionTimeout:         time.Duration(cf.SessionTimeoutSec) * time.Second,
-		SessionCleanupInterval: time.Duration(cf.SessionCleanupIntervalSec) * time.Second,
-		ServerIP:               cf.ServerIP,
-		ClientIPStart:          cf.ClientIPStart,
-		ClientIPEnd:            cf.ClientIPEnd,
-		DNSServers:             cf.DNSServers,
-		PushRoutes:             cf.PushRoutes,
-		RouteMode:              cf.RouteMode,
-		ExcludeRoutes:          cf.ExcludeRoutes,
-		RedirectGateway:        cf.RedirectGateway,
-		RedirectDNS:            cf.RedirectDNS,
-		EnableNAT:              cf.EnableNAT,
-		NATInterface:           cf.NATInterface,
+		ServerAddress:            cf.ServerAddress,
+		ServerPort:               cf.ServerPort,
+		ClientAddress:            cf.ClientAddress,
+		Network:                  cf.Network,
+		MTU:                      cf.MTU,
+		KeepAliveTimeout:         time.Duration(cf.KeepAliveTimeoutSec) * time.Second,
+		ReconnectDelay:           time.Duration(cf.ReconnectDelaySec) * time.Second,
+		MaxConnections:           cf.MaxConnections,
+		SessionTimeout:           time.Duration(cf.SessionTimeoutSec) * time.Second,
+		SessionCleanupInterval:   time.Duration(cf.SessionCleanupIntervalSec) * time.Second,
+		ServerIP:                 cf.ServerIP,
+		ClientIPStart:            cf.ClientIPStart,
+		ClientIPEnd:              cf.ClientIPEnd,
+		DNSServers:               cf.DNSServers,
+		PushRoutes:               cf.PushRoutes,
+		RouteMode:                cf.RouteMode,
+		ExcludeRoutes:            cf.ExcludeRoutes,
+		RedirectGateway:          cf.RedirectGateway,
+		RedirectDNS:              cf.RedirectDNS,
+		EnableNAT:                cf.EnableNAT,
+		NATInterface:             cf.NATInterface,
+		EnableUDP:                cf.EnableUDP,
+		UDPPort:                  cf.UDPPort,
+		EnableBatching:           cf.EnableBatching,
+		BatchDelay:               time.Duration(cf.BatchDelayUs) * time.Microsecond,
+		BatchMaxBytes:            cf.BatchMaxBytes,
+		EnableCompression:        cf.EnableCompression,
+		ClientToClient:           cf.ClientToClient,
+		Network6:                 cf.Network6,
+		SendQueueSize:            cf.SendQueueSize,
+		SendQueuePolicy:          cf.SendQueuePolicy,
+		TUNQueues:                cf.TUNQueues,
+		TUNOffload:               cf.TUNOffload,
+		BondLinks:                cf.BondLinks,
+		BondLocalAddrs:           cf.BondLocalAddrs,
+		LeaseFile:                cf.LeaseFile,
+		LeaseDuration:            time.Duration(cf.LeaseDurationHours) * time.Hour,
+		LeaseKey:                 cf.LeaseKey,
+		DrainTimeout:             time.Duration(cf.DrainTimeoutSec) * time.Second,
+		DisableMSSClamp:          cf.DisableMSSClamp,
+		RateLimitUpKbps:          cf.RateLimitUpKbps,
+		RateLimitDownKbps:        cf.RateLimitDownKbps,
+		RateLimits:               cf.RateLimits,
+		QuotaFile:                cf.QuotaFile,
+		QuotaPeriod:              cf.QuotaPeriod,
+		QuotaDefaultMB:           cf.QuotaDefaultMB,
+		QuotaAction:              cf.QuotaAction,
+		QuotaThrottleKbps:        cf.QuotaThrottleKbps,
+		Quotas:                   cf.Quotas,
+		ACLDefault:               cf.ACLDefault,
+		ACLRules:                 cf.ACLRules,
+		ClientSubnets:            cf.ClientSubnets,
+		SpoofDisconnectThreshold: cf.SpoofDisconnectThreshold,
+		Transport:                cf.Transport,
+		ProxyURL:                 cf.ProxyURL,
+		WebSocketPort:            cf.WebSocketPort,
+		WebSocketPath:            cf.WebSocketPath,
 	}
 }
 
 // VPNConfig VPN配置结构
 type VPNConfig struct {
-	ServerAddress          string
-	ServerPort             int
-	ClientAddress          string
-	Network                string
-	MTU                    int
-	KeepAliveTimeout       time.Duration
-	ReconnectDelay         time.Duration
-	MaxConnections         int
-	SessionTimeout         time.Duration
-	SessionCleanupInterval time.Duration // 新增：会话清理间隔
-	ServerIP               string        // 新增：服务器VPN IP (例如 "10.8.0.1/24")
-	ClientIPStart          int           // 新增：客户端IP起始 (默认 2)
-	ClientIPEnd            int           // 新增：客户端IP结束 (默认 254)
-	DNSServers             []string      // 新增：推送给客户端的DNS
-	PushRoutes             []string      // 新增：推送给客户端的路由 (CIDR格式)
-	RouteMode              string        // 新增：路由模式 "full" 或 "split"
-	ExcludeRoutes          []string      // 新增：排除的路由（full模式使用）
-	RedirectGateway        bool          // 新增：是否重定向默认网关
-	RedirectDNS            bool          // 新增：是否劫持DNS
-	EnableNAT              bool          // 新增：服务器端是否启用NAT
-	NATInterface           string        // 新增：NAT出口网卡（空字符串=自动检测）
+	ServerAddress            string
+	ServerPort               int
+	ClientAddress            string
+	Network                  string
+	MTU                      int
+	KeepAliveTimeout         time.Duration
+	ReconnectDelay           time.Duration
+	MaxConnections           int
+	SessionTimeout           time.Duration
+	SessionCleanupInterval   time.Duration      // 新增：会话清理间隔
+	ServerIP                 string             // 新增：服务器VPN IP (例如 "10.8.0.1/24")
+	ClientIPStart            int                // 新增：客户端IP起始 (默认 2)
+	ClientIPEnd              int                // 新增：客户端IP结束 (默认 254)
+	DNSServers               []string           // 新增：推送给客户端的DNS
+	PushRoutes               []string           // 新增：推送给客户端的路由 (CIDR格式)
+	RouteMode                string             // 新增：路由模式 "full" 或 "split"
+	ExcludeRoutes            []string           // 新增：排除的路由（full模式使用）
+	RedirectGateway          bool               // 新增：是否重定向默认网关
+	RedirectDNS              bool               // 新增：是否劫持DNS
+	EnableNAT                bool               // 新增：服务器端是否启用NAT
+	NATInterface             string             // 新增：NAT出口网卡（空字符串=自动检测）
+	EnableUDP                bool               // 是否启用UDP数据通道（TLS连接保留为控制通道）
+	UDPPort                  int                // UDP数据通道端口（0=与ServerPort相同）
+	EnableBatching           bool               // 是否启用数据包批量传输
+	BatchDelay               time.Duration      // 批量合并窗口
+	BatchMaxBytes            int                // 单个批量消息的最大字节数
+	EnableCompression        bool               // 是否启用数据包压缩（连接时与客户端协商）
+	ClientToClient           string             // 客户端互访策略 "allow"、"deny" 或 "same-group-only"
+	Network6                 string             // IPv6隧道网段（建议使用ULA，如 "fd00:8::/64"；空=不启用IPv6）
+	SendQueueSize            int                // 每个会话的数据发送队列长度（消息数）
+	SendQueuePolicy          string             // 发送队列溢出策略 "drop-newest"、"drop-oldest" 或 "disconnect"
+	TUNQueues                int                // 服务端TUN队列数（Linux多队列，每个队列一个读取协程）
+	TUNOffload               bool               // 是否启用TUN设备GSO/GRO offload（仅Linux，批量读写分段）
+	BondLinks                int                // 每个会话的TLS连接数（客户端: 建立的连接数；服务端: 允许的上限；1=不聚合）
+	BondLocalAddrs           []string           // 客户端各条连接依次绑定的本地地址（用于聚合多条上行链路，空=由系统选择）
+	LeaseFile                string             // IP租约文件（客户端证书到隧道地址的绑定，重启后保留）
+	LeaseDuration            time.Duration      // IP租约有效期（从客户端最近一次断开起计算）
+	LeaseKey                 string             // IP租约绑定的证书字段 "cn" 或 "serial"
+	DrainTimeout             time.Duration      // 排空会话的最长等待时间（超时后强制关闭剩余会话）
+	DisableMSSClamp          bool               // 关闭TCP MSS钳制（默认按隧道MTU改写SYN包中的MSS）
+	RateLimitUpKbps          int                // 每个客户端的默认上行限速（客户端→服务端，kbit/s，0=不限速）
+	RateLimitDownKbps        int                // 每个客户端的默认下行限速（服务端→客户端，kbit/s，0=不限速）
+	RateLimits               []RateLimitRule    // 按证书CN或分组覆盖默认值的限速规则（CN规则优先）
+	QuotaFile                string             // 流量配额用量文件（各客户端当前周期的流量，重启后保留）
+	QuotaPeriod              string             // 默认配额周期 "daily"、"weekly" 或 "monthly"
+	QuotaDefaultMB           int                // 每个客户端的默认流量配额（MB，上下行合计，0=不限）
+	QuotaAction              string             // 超出配额后的处理 "warn"、"throttle" 或 "disconnect"
+	QuotaThrottleKbps        int                // 超出配额后的限速（kbit/s，quota_action 为 throttle 时使用）
+	Quotas                   []QuotaRule        // 按证书CN覆盖默认值的流量配额
+	ACLDefault               string             // 没有ACL规则匹配时的动作 "allow" 或 "deny"
+	ACLRules                 []ACLRule          // 按顺序匹配客户端发出的数据包的访问控制规则
+	ClientSubnets            []ClientSubnetRule // 按证书CN授权客户端作为源地址使用的网段（隧道地址之外）
+	SpoofDisconnectThreshold int                // 会话伪造源地址的包数达到该值时断开（0=默认）
+	Transport                string             // 客户端传输方式 "tls" 或 "websocket"
+	ProxyURL                 string             // 客户端WebSocket传输使用的HTTP代理（空=读取 HTTPS_PROXY 环境变量）
+	WebSocketPort            int                // 服务端WebSocket传输的HTTPS端口（0=不启用）
+	WebSocketPath            string             // WebSocket升级请求的路径（服务端和客户端需一致）
 }
 
 // DefaultConfig 默认配置
 var DefaultConfig = VPNConfig{
-	ServerAddress:          "localhost",
-	ServerPort:             8080,
-	ClientAddress:          "10.8.0.2/24",
-	Network:                "10.8.0.0/24",
-	MTU:                    1500,
-	KeepAliveTimeout:       90 * time.Second,
-	ReconnectDelay:         5 * time.Second,
-	MaxConnections:         100,
-	SessionTimeout:         5 * time.Minute,
-	SessionCleanupInterval: 30 * time.Second,
-	ServerIP:               "10.8.0.1/24",
-	ClientIPStart:          2,
-	ClientIPEnd:            254,
-	DNSServers:             []string{"8.8.8.8", "8.8.4.4"},
-	PushRoutes:             []string{},
-	RouteMode:              "split",
-	ExcludeRoutes:          []string{},
-	RedirectGateway:        false,
-	RedirectDNS:            false,
-	EnableNAT:              true,
-	NATInterface:           "",
+	ServerAddress:            "localhost",
+	ServerPort:               8080,
+	ClientAddress:            "10.8.0.2/24",
+	Network:                  "10.8.0.0/24",
+	MTU:                      1500,
+	KeepAliveTimeout:         90 * time.Second,
+	ReconnectDelay:           5 * time.Second,
+	MaxConnections:           100,
+	SessionTimeout:           5 * time.Minute,
+	SessionCleanupInterval:   30 * time.Second,
+	ServerIP:                 "10.8.0.1/24",
+	ClientIPStart:            2,
+	ClientIPEnd:              254,
+	DNSServers:               []string{"8.8.8.8", "8.8.4.4"},
+	PushRoutes:               []string{},
+	RouteMode:                "split",
+	ExcludeRoutes:            []string{},
+	RedirectGateway:          false,
+	RedirectDNS:              false,
+	EnableNAT:                true,
+	NATInterface:             "",
+	EnableUDP:                false,
+	UDPPort:                  0,
+	EnableBatching:           false,
+	BatchDelay:               200 * time.Microsecond,
+	BatchMaxBytes:            16384,
+	EnableCompression:        false,
+	ClientToClient:           ClientToClientAllow,
+	Network6:                 "",
+	SendQueueSize:            256,
+	SendQueuePolicy:          SendQueueDropNewest,
+	TUNQueues:                1,
+	TUNOffload:               false,
+	BondLinks:                1,
+	BondLocalAddrs:           []string{},
+	LeaseFile:                DefaultLeaseFile,
+	LeaseDuration:            7 * 24 * time.Hour,
+	LeaseKey:                 LeaseKeyCN,
+	DrainTimeout:             30 * time.Second,
+	DisableMSSClamp:          false,
+	RateLimitUpKbps:          0,
+	RateLimitDownKbps:        0,
+	RateLimits:               []RateLimitRule{},
+	QuotaFile:                DefaultQuotaFile,
+	QuotaPeriod:              QuotaPeriodMonthly,
+	QuotaDefaultMB:           0,
+	QuotaAction:              QuotaActionWarn,
+	QuotaThrottleKbps:        1000,
+	Quotas:                   []QuotaRule{},
+	ACLDefault:               ACLActionAllow,
+	ACLRules:                 []ACLRule{},
+	ClientSubnets:            []ClientSubnetRule{},
+	SpoofDisconnectThreshold: 100,
+	Transport:                TransportTLS,
+	ProxyURL:                 "",
+	WebSocketPort:            0,
+	WebSocketPath:            DefaultWebSocketPath,
 }
 
 // ValidateConfig 验证配置
@@ -135,9 +321,13 @@ func (c *VPNConfig) ValidateConfig() error {
 	if c.Network == "" {
 		return fmt.Errorf("VPN网络不能为空")
 	}
-	if _, _, err := net.ParseCIDR(c.Network); err != nil {
+	_, network, err := net.ParseCIDR(c.Network)
+	if err != nil {
 		return fmt.Errorf("VPN网络格式无效: %v", err)
 	}
+	if network.IP.To4() == nil {
+		return fmt.Errorf("VPN网络必须是IPv4网段（IPv6请使用 network6）")
+	}
 	if c.MTU < 576 || c.MTU > 9000 {
 		return fmt.Errorf("MTU必须在576-9000之间")
 	}
@@ -162,6 +352,149 @@ func (c *VPNConfig) ValidateConfig() error {
 	if c.ClientIPEnd < c.ClientIPStart || c.ClientIPEnd > 254 {
 		return fmt.Errorf("客户端IP结束必须在起始之后且不超过254")
 	}
+	if !network.Contains(ipAdd(network.IP, c.ClientIPEnd)) {
+		return fmt.Errorf("客户端IP范围超出VPN网络 %s", c.Network)
+	}
+	if c.Network6 != "" {
+		_, network6, err := net.ParseCIDR(c.Network6)
+		if err != nil {
+			return fmt.Errorf("IPv6网络格式无效: %v", err)
+		}
+		if network6.IP.To4() != nil {
+			return fmt.Errorf("network6 必须是IPv6网段")
+		}
+		if ones, _ := network6.Mask.Size(); ones > 120 {
+			return fmt.Errorf("IPv6网络前缀长度不能超过120")
+		}
+	}
+	if c.UDPPort < 0 || c.UDPPort > 65535 {
+		return fmt.Errorf("UDP端口必须在0-65535之间（0表示与服务器端口相同）")
+	}
+	if c.BatchDelay < 0 || c.BatchDelay > 10*time.Millisecond {
+		return fmt.Errorf("批量合并窗口必须在0-10000微秒之间")
+	}
+	if c.BatchMaxBytes != 0 && (c.BatchMaxBytes < c.MTU+batchEntryHeaderSize || c.BatchMaxBytes > 65535) {
+		return fmt.Errorf("批量消息大小必须在MTU+%d到65535之间（0表示使用默认值）", batchEntryHeaderSize)
+	}
+	switch c.ClientToClient {
+	case "", ClientToClientAllow, ClientToClientDeny, ClientToClientSameGroup:
+	default:
+		return fmt.Errorf("客户端互访策略必须是 %s、%s 或 %s",
+			ClientToClientAllow, ClientToClientDeny, ClientToClientSameGroup)
+	}
+	if c.SendQueueSize < 0 || c.SendQueueSize > 65536 {
+		return fmt.Errorf("发送队列长度必须在0-65536之间（0表示使用默认值）")
+	}
+	switch c.SendQueuePolicy {
+	case "", SendQueueDropNewest, SendQueueDropOldest, SendQueueDisconnect:
+	default:
+		return fmt.Errorf("发送队列溢出策略必须是 %s、%s 或 %s",
+			SendQueueDropNewest, SendQueueDropOldest, SendQueueDisconnect)
+	}
+	if c.TUNQueues < 0 || c.TUNQueues > maxTUNQueues {
+		return fmt.Errorf("TUN队列数必须在0-%d之间（0表示使用默认值）", maxTUNQueues)
+	}
+	if c.BondLinks < 0 || c.BondLinks > maxBondLinks {
+		return fmt.Errorf("聚合连接数必须在0-%d之间（0表示使用默认值）", maxBondLinks)
+	}
+	for _, addr := range c.BondLocalAddrs {
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("无效的聚合连接本地地址: %s", addr)
+		}
+	}
+	if c.LeaseDuration < 0 {
+		return fmt.Errorf("IP租约有效期不能为负数（0表示使用默认值）")
+	}
+	switch c.LeaseKey {
+	case "", LeaseKeyCN, LeaseKeySerial:
+	default:
+		return fmt.Errorf("IP租约绑定字段必须是 %s 或 %s", LeaseKeyCN, LeaseKeySerial)
+	}
+	if c.DrainTimeout < 0 {
+		return fmt.Errorf("排空超时不能为负数（0表示使用默认值）")
+	}
+	if c.RateLimitUpKbps < 0 || c.RateLimitDownKbps < 0 {
+		return fmt.Errorf("默认限速不能为负数（0表示不限速）")
+	}
+	for i, rule := range c.RateLimits {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("第%d条限速规则无效: %v", i+1, err)
+		}
+		for _, other := range c.RateLimits[:i] {
+			if rule.sameTarget(other) {
+				return fmt.Errorf("第%d条限速规则与之前的规则重复", i+1)
+			}
+		}
+	}
+	if err := validateQuotaPeriod(c.QuotaPeriod); err != nil {
+		return err
+	}
+	switch c.QuotaAction {
+	case "", QuotaActionWarn, QuotaActionThrottle, QuotaActionDisconnect:
+	default:
+		return fmt.Errorf("超出配额的处理方式必须是 %s、%s 或 %s",
+			QuotaActionWarn, QuotaActionThrottle, QuotaActionDisconnect)
+	}
+	if c.QuotaDefaultMB < 0 || c.QuotaThrottleKbps < 0 {
+		return fmt.Errorf("流量配额和配额限速不能为负数")
+	}
+	for i, rule := range c.Quotas {
+		if rule.CN == "" {
+			return fmt.Errorf("第%d条流量配额未指定证书CN", i+1)
+		}
+		if rule.LimitMB < 0 {
+			return fmt.Errorf("第%d条流量配额不能为负数", i+1)
+		}
+		if err := validateQuotaPeriod(rule.Period); err != nil {
+			return fmt.Errorf("第%d条流量配额无效: %v", i+1, err)
+		}
+		for _, other := range c.Quotas[:i] {
+			if other.CN == rule.CN {
+				return fmt.Errorf("第%d条流量配额与之前的配额重复: %s", i+1, rule.CN)
+			}
+		}
+	}
+	if err := validateACLDefault(c.ACLDefault); err != nil {
+		return err
+	}
+	for i, rule := range c.ACLRules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("第%d条ACL规则无效: %v", i+1, err)
+		}
+	}
+	for i, rule := range c.ClientSubnets {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("第%d条客户端网段规则无效: %v", i+1, err)
+		}
+		for _, other := range c.ClientSubnets[:i] {
+			if other.CN == rule.CN {
+				return fmt.Errorf("第%d条客户端网段规则与之前的规则重复: %s", i+1, rule.CN)
+			}
+		}
+	}
+	if c.SpoofDisconnectThreshold < 0 {
+		return fmt.Errorf("伪造源地址断开阈值不能为负数（0表示使用默认值）")
+	}
+	switch c.Transport {
+	case "", TransportTLS, TransportWebSocket:
+	default:
+		return fmt.Errorf("传输方式必须是 %s 或 %s", TransportTLS, TransportWebSocket)
+	}
+	if c.ProxyURL != "" {
+		proxy, err := url.Parse(c.ProxyURL)
+		if err != nil || proxy.Scheme != "http" || proxy.Host == "" {
+			return fmt.Errorf("代理地址格式无效（应为 http://[用户名:密码@]主机:端口）")
+		}
+	}
+	if c.WebSocketPort < 0 || c.WebSocketPort > 65535 {
+		return fmt.Errorf("WebSocket端口必须在0-65535之间（0表示不启用）")
+	}
+	if c.WebSocketPort != 0 && c.WebSocketPort == c.ServerPort {
+		return fmt.Errorf("WebSocket端口不能与服务器端口相同")
+	}
+	if c.WebSocketPath != "" && !strings.HasPrefix(c.WebSocketPath, "/") {
+		return fmt.Errorf("WebSocket路径必须以 / 开头")
+	}
 	// 验证ServerIP（如果提供）
 	if c.ServerIP != "" {
 		if _, _, err := net.ParseCIDR(c.ServerIP); err != nil {
@@ -171,6 +504,181 @@ func (c *VPNConfig) ValidateConfig() error {
 	return nil
 }
 
+// GetUDPPort 获取UDP数据通道端口（未指定时与服务器端口相同）
+func (c *VPNConfig) GetUDPPort() int {
+	if c.UDPPort > 0 {
+		return c.UDPPort
+	}
+	return c.ServerPort
+}
+
+// GetClientToClient 获取客户端互访策略（未指定时允许互访）
+func (c *VPNConfig) GetClientToClient() string {
+	if c.ClientToClient == "" {
+		return ClientToClientAllow
+	}
+	return c.ClientToClient
+}
+
+// GetBatchDelay 获取批量合并窗口（未指定时使用默认值）
+func (c *VPNConfig) GetBatchDelay() time.Duration {
+	if c.BatchDelay > 0 {
+		return c.BatchDelay
+	}
+	return DefaultConfig.BatchDelay
+}
+
+// GetBatchMaxBytes 获取单个批量消息的最大字节数（未指定时使用默认值）
+func (c *VPNConfig) GetBatchMaxBytes() int {
+	if c.BatchMaxBytes > 0 {
+		return c.BatchMaxBytes
+	}
+	return DefaultConfig.BatchMaxBytes
+}
+
+// GetSendQueueSize 获取会话数据发送队列长度（未指定时使用默认值）
+func (c *VPNConfig) GetSendQueueSize() int {
+	if c.SendQueueSize > 0 {
+		return c.SendQueueSize
+	}
+	return DefaultConfig.SendQueueSize
+}
+
+// GetSendQueuePolicy 获取发送队列溢出策略（未指定时丢弃新到的数据包）
+func (c *VPNConfig) GetSendQueuePolicy() string {
+	if c.SendQueuePolicy == "" {
+		return SendQueueDropNewest
+	}
+	return c.SendQueuePolicy
+}
+
+// maxTUNQueues TUN队列数上限
+const maxTUNQueues = 64
+
+// GetTUNQueues 获取服务端TUN队列数（未指定时使用单队列）
+func (c *VPNConfig) GetTUNQueues() int {
+	if c.TUNQueues > 0 {
+		return c.TUNQueues
+	}
+	return 1
+}
+
+// maxBondLinks 每个会话的TLS连接数上限
+const maxBondLinks = 8
+
+// GetBondLinks 获取每个会话的TLS连接数（未指定时为1，即不聚合）
+func (c *VPNConfig) GetBondLinks() int {
+	if c.BondLinks > 0 {
+		return c.BondLinks
+	}
+	return 1
+}
+
+// GetLeaseFile 获取IP租约文件路径（未指定时使用默认路径）
+func (c *VPNConfig) GetLeaseFile() string {
+	if c.LeaseFile != "" {
+		return c.LeaseFile
+	}
+	return DefaultLeaseFile
+}
+
+// GetLeaseDuration 获取IP租约有效期（未指定时使用默认值）
+func (c *VPNConfig) GetLeaseDuration() time.Duration {
+	if c.LeaseDuration > 0 {
+		return c.LeaseDuration
+	}
+	return DefaultConfig.LeaseDuration
+}
+
+// GetDrainTimeout 获取排空会话的超时时间（未指定时使用默认值）
+func (c *VPNConfig) GetDrainTimeout() time.Duration {
+	if c.DrainTimeout > 0 {
+		return c.DrainTimeout
+	}
+	return DefaultConfig.DrainTimeout
+}
+
+// GetLeaseKey 获取IP租约绑定的证书字段（未指定时使用CN）
+func (c *VPNConfig) GetLeaseKey() string {
+	if c.LeaseKey == "" {
+		return LeaseKeyCN
+	}
+	return c.LeaseKey
+}
+
+// validateQuotaPeriod 检查配额周期（空表示使用默认值）
+func validateQuotaPeriod(period string) error {
+	switch period {
+	case "", QuotaPeriodDaily, QuotaPeriodWeekly, QuotaPeriodMonthly:
+		return nil
+	}
+	return fmt.Errorf("配额周期必须是 %s、%s 或 %s", QuotaPeriodDaily, QuotaPeriodWeekly, QuotaPeriodMonthly)
+}
+
+// GetQuotaFile 获取流量配额用量文件路径（未指定时使用默认路径）
+func (c *VPNConfig) GetQuotaFile() string {
+	if c.QuotaFile != "" {
+		return c.QuotaFile
+	}
+	return DefaultQuotaFile
+}
+
+// GetQuotaPeriod 获取默认配额周期（未指定时按月）
+func (c *VPNConfig) GetQuotaPeriod() string {
+	if c.QuotaPeriod == "" {
+		return QuotaPeriodMonthly
+	}
+	return c.QuotaPeriod
+}
+
+// GetQuotaAction 获取超出配额后的处理方式（未指定时只通知客户端）
+func (c *VPNConfig) GetQuotaAction() string {
+	if c.QuotaAction == "" {
+		return QuotaActionWarn
+	}
+	return c.QuotaAction
+}
+
+// GetACLDefault 获取没有ACL规则匹配时的动作（未指定时放行）
+func (c *VPNConfig) GetACLDefault() string {
+	if c.ACLDefault == "" {
+		return ACLActionAllow
+	}
+	return c.ACLDefault
+}
+
+// GetSpoofDisconnectThreshold 获取断开会话的伪造源地址包数（未指定时使用默认值）
+func (c *VPNConfig) GetSpoofDisconnectThreshold() int {
+	if c.SpoofDisconnectThreshold == 0 {
+		return DefaultConfig.SpoofDisconnectThreshold
+	}
+	return c.SpoofDisconnectThreshold
+}
+
+// GetTransport 获取客户端传输方式（未指定时直接使用TLS）
+func (c *VPNConfig) GetTransport() string {
+	if c.Transport == "" {
+		return TransportTLS
+	}
+	return c.Transport
+}
+
+// GetWebSocketPath 获取WebSocket升级请求的路径（未指定时使用默认值）
+func (c *VPNConfig) GetWebSocketPath() string {
+	if c.WebSocketPath == "" {
+		return DefaultWebSocketPath
+	}
+	return c.WebSocketPath
+}
+
+// GetQuotaThrottleKbps 获取超出配额后的限速（未指定时使用默认值）
+func (c *VPNConfig) GetQuotaThrottleKbps() int {
+	if c.QuotaThrottleKbps > 0 {
+		return c.QuotaThrottleKbps
+	}
+	return DefaultConfig.QuotaThrottleKbps
+}
+
 // ParseServerIP 解析服务器IP配置
 func (c *VPNConfig) ParseServerIP() (net.IP, *net.IPNet, error) {
 	if c.ServerIP == "" {
@@ -179,12 +687,7 @@ func (c *VPNConfig) ParseServerIP() (net.IP, *net.IPNet, error) {
 		if err != nil {
 			return nil, nil, fmt.Errorf("无效的网络配置: %v", err)
 		}
-		ip := network.IP.To4()
-		if ip == nil {
-			return nil, nil, fmt.Errorf("仅支持IPv4")
-		}
-		serverIP := net.IPv4(ip[0], ip[1], ip[2], 1)
-		return serverIP, network, nil
+		return ipAdd(network.IP, 1), network, nil
 	}
 	ip, ipNet, err := net.ParseCIDR(c.ServerIP)
 	if err != nil {
@@ -193,6 +696,18 @@ func (c *VPNConfig) ParseServerIP() (net.IP, *net.IPNet, error) {
 	return ip, ipNet, nil
 }
 
+// ParseServerIP6 解析IPv6隧道网段，服务器使用网段中的第一个地址（未启用IPv6时返回nil）
+func (c *VPNConfig) ParseServerIP6() (net.IP, *net.IPNet, error) {
+	if c.Network6 == "" {
+		return nil, nil, nil
+	}
+	_, network, err := net.ParseCIDR(c.Network6)
+	if err != nil {
+		return nil, nil, fmt.Errorf("无效的IPv6网络配置: %v", err)
+	}
+	return ipAdd(network.IP, 1), network, nil
+}
+
 // LoadConfigFromFile 从文件加载配置
 func LoadConfigFromFile(filename string) (VPNConfig, error) {
 	// 检查文件是否存在
@@ -239,6 +754,42 @@ func SaveConfigToFile(filename string, config VPNConfig) error {
 		RedirectDNS:               config.RedirectDNS,
 		EnableNAT:                 config.EnableNAT,
 		NATInterface:              config.NATInterface,
+		EnableUDP:                 config.EnableUDP,
+		UDPPort:                   config.UDPPort,
+		EnableBatching:            config.EnableBatching,
+		BatchDelayUs:              int(config.BatchDelay / time.Microsecond),
+		BatchMaxBytes:             config.BatchMaxBytes,
+		EnableCompression:         config.EnableCompression,
+		ClientToClient:            config.ClientToClient,
+		Network6:                  config.Network6,
+		SendQueueSize:             config.SendQueueSize,
+		SendQueuePolicy:           config.SendQueuePolicy,
+		TUNQueues:                 config.TUNQueues,
+		TUNOffload:                config.TUNOffload,
+		BondLinks:                 config.BondLinks,
+		BondLocalAddrs:            config.BondLocalAddrs,
+		LeaseFile:                 config.LeaseFile,
+		LeaseDurationHours:        int(config.LeaseDuration / time.Hour),
+		LeaseKey:                  config.LeaseKey,
+		DrainTimeoutSec:           int(config.DrainTimeout / time.Second),
+		DisableMSSClamp:           config.DisableMSSClamp,
+		RateLimitUpKbps:           config.RateLimitUpKbps,
+		RateLimitDownKbps:         config.RateLimitDownKbps,
+		RateLimits:                config.RateLimits,
+		QuotaFile:                 config.QuotaFile,
+		QuotaPeriod:               config.QuotaPeriod,
+		QuotaDefaultMB:            config.QuotaDefaultMB,
+		QuotaAction:               config.QuotaAction,
+		QuotaThrottleKbps:         config.QuotaThrottleKbps,
+		Quotas:                    config.Quotas,
+		ACLDefault:                config.ACLDefault,
+		ACLRules:                  config.ACLRules,
+		ClientSubnets:             config.ClientSubnets,
+		SpoofDisconnectThreshold:  config.SpoofDisconnectThreshold,
+		Transport:                 config.Transport,
+		ProxyURL:                  config.ProxyURL,
+		WebSocketPort:             config.WebSocketPort,
+		WebSocketPath:             config.WebSocketPath,
 	}
 
 	data, err := json.MarshalIndent(configFile, "", "  ")
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"log"
+	"net"
+	"slices"
+	"strings"
+)
+
+// ================ 在线推送配置 ================
+//
+// 路由模式、推送路由、排除路由和DNS等客户端配置在连接时随 MessageTypeControl 推送。
+// 服务端运行期间通过 config/update 修改这些字段时，服务端与当前推送的配置比较，有变化时
+// 向所有在线会话重新推送完整的 ClientConfig；客户端按新旧配置的差异增删路由、更新DNS，
+// 不需要重连。路由模式变化时客户端清理全部路由后按新模式重新配置。
+
+// pushedConfig 推送给客户端的路由和DNS配置（修改时整体替换）
+type pushedConfig struct {
+	RouteMode       string
+	PushRoutes      []string
+	ExcludeRoutes   []string
+	DNSServers      []string
+	RedirectGateway bool
+	RedirectDNS     bool
+}
+
+// newPushedConfig 从配置中复制推送给客户端的字段
+func newPushedConfig(config *VPNConfig) *pushedConfig {
+	return &pushedConfig{
+		RouteMode:       config.RouteMode,
+		PushRoutes:      slices.Clone(config.PushRoutes),
+		ExcludeRoutes:   slices.Clone(config.ExcludeRoutes),
+		DNSServers:      slices.Clone(config.DNSServers),
+		RedirectGateway: config.RedirectGateway,
+		RedirectDNS:     config.RedirectDNS,
+	}
+}
+
+// diff 返回与 other 不同的配置字段名
+func (p *pushedConfig) diff(other *pushedConfig) []string {
+	var changed []string
+	if p.RouteMode != other.RouteMode {
+		changed = append(changed, "route_mode")
+	}
+	if !slices.Equal(p.PushRoutes, other.PushRoutes) {
+		changed = append(changed, "push_routes")
+	}
+	if !slices.Equal(p.ExcludeRoutes, other.ExcludeRoutes) {
+		changed = append(changed, "exclude_routes")
+	}
+	if !slices.Equal(p.DNSServers, other.DNSServers) {
+		changed = append(changed, "dns_servers")
+	}
+	if p.RedirectGateway != other.RedirectGateway {
+		changed = append(changed, "redirect_gateway")
+	}
+	if p.RedirectDNS != other.RedirectDNS {
+		changed = append(changed, "redirect_dns")
+	}
+	return changed
+}
+
+// ================ 服务端 ================
+
+// PushClientConfig 推送配置有变化时更新并重新推送给所有在线会话，返回推送的会话数
+func (s *VPNServer) PushClientConfig(config *VPNConfig) int {
+	next := newPushedConfig(config)
+	changed := s.pushed.Swap(next).diff(next)
+	if len(changed) == 0 {
+		return 0
+	}
+
+	log.Printf("客户端配置已修改 (%s)，推送给在线客户端", strings.Join(changed, ", "))
+	pushed := 0
+	for _, session := range s.sessions.all() {
+		if session.IsClosed() {
+			continue
+		}
+		if err := s.pushConfigToClient(session); err != nil {
+			log.Printf("推送配置给会话 %s 失败: %v", session.ID, err)
+			continue
+		}
+		pushed++
+	}
+	return pushed
+}
+
+// ================ 客户端 ================
+
+// withServerConfig 返回在 p 的基础上应用服务端推送的配置后的新配置：
+// 路由模式为空时沿用 p，路由、排除路由和DNS以服务端为准（空列表表示没有）
+func (p *pushedConfig) withServerConfig(config *ClientConfig) *pushedConfig {
+	next := *p
+	if config.RouteMode != "" {
+		next.RouteMode = config.RouteMode
+	}
+	next.PushRoutes = config.Routes
+	next.ExcludeRoutes = config.ExcludeRoutes
+	next.DNSServers = config.DNS
+	next.RedirectGateway = config.RedirectGateway
+	next.RedirectDNS = config.RedirectDNS
+	return &next
+}
+
+// resetRoutes 连接建立时按服务端推送的配置重置路由配置。以本地配置为基础，
+// 不沿用上一次连接推送的配置，断线期间服务端删除的路由和DNS不会在重连后恢复
+func (c *VPNClient) resetRoutes(config *ClientConfig) *pushedConfig {
+	routes := newPushedConfig(&c.config).withServerConfig(config)
+	if config.RouteMode != "" {
+		log.Printf("服务器配置路由模式: %s", config.RouteMode)
+	}
+	c.routes.Store(routes)
+	return routes
+}
+
+// applyServerConfig 应用服务端在连接建立后推送的配置：按差异增删路由并更新DNS。
+// 在线推送的配置以服务端为准，空列表表示删除全部推送路由或DNS。
+// 新配置整体替换 c.routes，不修改数据路径并发读取的 c.config
+func (c *VPNClient) applyServerConfig(config *ClientConfig) error {
+	c.routeMutex.Lock()
+	defer c.routeMutex.Unlock()
+
+	old := c.routes.Load()
+	next := old.withServerConfig(config)
+	c.routes.Store(next)
+	log.Printf("收到服务器推送的配置: RouteMode=%s, Routes=%v, ExcludeRoutes=%v, DNS=%v",
+		next.RouteMode, next.PushRoutes, next.ExcludeRoutes, next.DNSServers)
+
+	rm := c.routeManager
+	if rm == nil || c.tunDevice == nil {
+		return nil // 尚未配置路由，连接建立时按新配置设置
+	}
+
+	// 路由模式变化：清理全部路由和DNS后按新模式重新配置
+	if next.RouteMode != old.RouteMode {
+		log.Printf("路由模式由 %s 改为 %s，重新配置路由", old.RouteMode, next.RouteMode)
+		rm.CleanupRoutes()
+		if old.dnsRedirected() {
+			if err := rm.RestoreDNS(); err != nil {
+				log.Printf("警告：恢复DNS配置失败: %v", err)
+			}
+		}
+		return c.setupRoutes()
+	}
+
+	if next.RouteMode == "full" {
+		c.reconcileRoutes(rm, old.ExcludeRoutes, next.ExcludeRoutes, func(route string) (string, string, bool) {
+			gateway, iface := rm.bypassRouteGateway(route)
+			return gateway, iface, true
+		})
+	} else {
+		c.reconcileRoutes(rm, old.PushRoutes, next.PushRoutes, c.tunnelRouteGateway)
+	}
+	c.reconcileDNS(rm, old, next)
+	return nil
+}
+
+// reconcileRoutes 删除 old 中不再需要的路由，添加 new 中新增的路由。
+// gateway 返回路由的网关和接口，ok 为 false 时跳过该路由
+func (c *VPNClient) reconcileRoutes(rm *RouteManager, old, new []string, gateway func(route string) (string, string, bool)) {
+	for _, route := range old {
+		if slices.Contains(new, route) {
+			continue
+		}
+		if err := rm.DeleteRoute(route); err != nil {
+			log.Printf("警告：删除路由 %s 失败: %v", route, err)
+		}
+	}
+	for _, route := range new {
+		if slices.Contains(old, route) {
+			continue
+		}
+		gw, iface, ok := gateway(route)
+		if !ok {
+			continue
+		}
+		if err := rm.AddRoute(route, gw, iface); err != nil {
+			log.Printf("警告：添加路由 %s 失败: %v", route, err)
+		}
+	}
+}
+
+// tunnelRouteGateway 返回经隧道的路由使用的网关（服务器的隧道地址）和TUN设备
+func (c *VPNClient) tunnelRouteGateway(route string) (string, string, bool) {
+	if isIPv6CIDR(route) {
+		ip, _, err := net.ParseCIDR(c.serverIP6)
+		if err != nil {
+			log.Printf("跳过IPv6路由 %s：未分配IPv6地址", route)
+			return "", "", false
+		}
+		return ip.String(), c.tunDevice.Name(), true
+	}
+	gateway, _, _ := strings.Cut(c.config.ServerIP, "/")
+	if gateway == "" {
+		gateway = "10.8.0.1"
+	}
+	return gateway, c.tunDevice.Name(), true
+}
+
+// dnsRedirected 判断配置是否要求客户端使用推送的DNS服务器（仅全流量模式）
+func (p *pushedConfig) dnsRedirected() bool {
+	return p.RouteMode == "full" && p.RedirectDNS && len(p.DNSServers) > 0
+}
+
+// reconcileDNS 按新旧配置设置、更新或恢复DNS
+func (c *VPNClient) reconcileDNS(rm *RouteManager, old, next *pushedConfig) {
+	was, now := old.dnsRedirected(), next.dnsRedirected()
+	switch {
+	case now && !was:
+		if err := rm.SaveDNS(); err != nil {
+			log.Printf("警告：保存DNS配置失败: %v", err)
+			return
+		}
+		fallthrough
+	case now && !slices.Equal(old.DNSServers, next.DNSServers):
+		if err := rm.SetDNSForInterface(next.DNSServers, c.tunDevice.Name()); err != nil {
+			log.Printf("警告：设置DNS失败: %v", err)
+		}
+	case !now && was:
+		if err := rm.RestoreDNS(); err != nil {
+			log.Printf("警告：恢复DNS配置失败: %v", err)
+		}
+	}
+}
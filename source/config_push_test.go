@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"log"
+	"slices"
+	"testing"
+)
+
+// TestResetRoutesAfterReconnect 断线期间服务端删除了路由、排除路由和DNS，重连后不应沿用上一次推送的值，
+// 且与在线推送同样的配置得到相同的结果
+func TestResetRoutesAfterReconnect(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	local := DefaultConfig
+	local.RouteMode = "split"
+	full := &ClientConfig{
+		RouteMode:       "full",
+		Routes:          []string{"192.168.10.0/24"},
+		ExcludeRoutes:   []string{"203.0.113.0/24"},
+		DNS:             []string{"10.8.0.1"},
+		RedirectGateway: true,
+		RedirectDNS:     true,
+	}
+
+	tests := []struct {
+		name   string
+		config *ClientConfig
+		want   pushedConfig
+	}{
+		{
+			name:   "清空列表",
+			config: &ClientConfig{RouteMode: "full"},
+			want:   pushedConfig{RouteMode: "full"},
+		},
+		{
+			name:   "未指定路由模式时使用本地配置",
+			config: &ClientConfig{Routes: []string{"10.20.0.0/16"}},
+			want:   pushedConfig{RouteMode: "split", PushRoutes: []string{"10.20.0.0/16"}},
+		},
+		{
+			name:   "与首次连接相同",
+			config: full,
+			want: pushedConfig{RouteMode: "full", PushRoutes: full.Routes, ExcludeRoutes: full.ExcludeRoutes,
+				DNSServers: full.DNS, RedirectGateway: true, RedirectDNS: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &VPNClient{config: local}
+			c.routes.Store(newPushedConfig(&c.config))
+			c.resetRoutes(full) // 第一次连接
+
+			got := c.resetRoutes(tt.config) // 重连
+			assertPushedConfig(t, "重连", got, &tt.want)
+			if c.config.RouteMode != "split" || len(c.config.PushRoutes) != 0 {
+				t.Errorf("本地配置被修改: RouteMode=%s, PushRoutes=%v", c.config.RouteMode, c.config.PushRoutes)
+			}
+
+			// 在线推送同样的配置（从第一次连接的状态开始）结果一致。
+			// 未指定路由模式时在线推送沿用当前模式，不与重连比较
+			if tt.config.RouteMode == "" {
+				return
+			}
+			c.resetRoutes(full)
+			if err := c.applyServerConfig(tt.config); err != nil {
+				t.Fatal(err)
+			}
+			assertPushedConfig(t, "在线推送", c.routes.Load(), &tt.want)
+		})
+	}
+}
+
+func assertPushedConfig(t *testing.T, path string, got, want *pushedConfig) {
+	t.Helper()
+	if got.RouteMode != want.RouteMode || got.RedirectGateway != want.RedirectGateway || got.RedirectDNS != want.RedirectDNS ||
+		!slices.Equal(got.PushRoutes, want.PushRoutes) || !slices.Equal(got.ExcludeRoutes, want.ExcludeRoutes) ||
+		!slices.Equal(got.DNSServers, want.DNSServers) {
+		t.Errorf("%s后的路由配置 = %+v，期望 %+v", path, *got, *want)
+	}
+}
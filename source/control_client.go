@@ -77,6 +77,32 @@ func (c *ControlClient) ServerStop() (*APIResponse, error) {
 	return c.Call(ActionServerStop, nil)
 }
 
+// ServerDrain 排空会话后停止服务端（timeoutSec 为0时使用配置值）
+func (c *ControlClient) ServerDrain(timeoutSec int) (*APIResponse, error) {
+	return c.Call(ActionServerDrain, DrainRequest{TimeoutSec: timeoutSec})
+}
+
+// ServerUpgrade 无中断重启：新进程接管监听套接字和TUN设备，旧进程排空会话后退出
+func (c *ControlClient) ServerUpgrade() (*APIResponse, error) {
+	return c.Call(ActionServerUpgrade, nil)
+}
+
+// ServerQuotas 获取各客户端的流量配额状态
+func (c *ControlClient) ServerQuotas() (*QuotaListResponse, error) {
+	resp, err := c.Call(ActionServerQuotas, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	var result QuotaListResponse
+	if err := json.Unmarshal(resp.Data, &result); err != nil {
+		return nil, fmt.Errorf("解析配额状态失败: %v", err)
+	}
+	return &result, nil
+}
+
 // ServerStatus 获取服务端状态
 func (c *ControlClient) ServerStatus() (*ServerStatusResponse, error) {
 	resp, err := c.Call(ActionServerStatus, nil)
@@ -162,8 +188,8 @@ func (c *ControlClient) CertList() (*CertListResponse, error) {
 }
 
 // CertGenCSR 生成 CSR
-func (c *ControlClient) CertGenCSR(clientName string) (*GenerateCSRResponse, error) {
-	resp, err := c.Call(ActionCertGenCSR, GenerateCSRRequest{ClientName: clientName})
+func (c *ControlClient) CertGenCSR(clientName, group string) (*GenerateCSRResponse, error) {
+	resp, err := c.Call(ActionCertGenCSR, GenerateCSRRequest{ClientName: clientName, Group: group})
 	if err != nil {
 		return nil, err
 	}
@@ -227,6 +253,89 @@ func (c *ControlClient) TokenCleanup() (*APIResponse, error) {
 	return c.Call(ActionTokenCleanup, nil)
 }
 
+// LeaseList 获取IP租约列表
+func (c *ControlClient) LeaseList() ([]LeaseInfo, error) {
+	resp, err := c.Call(ActionLeaseList, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	var result LeaseListResponse
+	if err := json.Unmarshal(resp.Data, &result); err != nil {
+		return nil, fmt.Errorf("解析租约列表失败: %v", err)
+	}
+	return result.Leases, nil
+}
+
+// LeaseSet 新增或修改IP租约
+func (c *ControlClient) LeaseSet(req SetLeaseRequest) (*APIResponse, error) {
+	return c.Call(ActionLeaseSet, req)
+}
+
+// LeaseDelete 删除IP租约
+func (c *ControlClient) LeaseDelete(key string) (*APIResponse, error) {
+	return c.Call(ActionLeaseDelete, DeleteLeaseRequest{Key: key})
+}
+
+// RateLimitList 获取带宽限速配置
+func (c *ControlClient) RateLimitList() (*RateLimitListResponse, error) {
+	resp, err := c.Call(ActionRateLimitList, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	var result RateLimitListResponse
+	if err := json.Unmarshal(resp.Data, &result); err != nil {
+		return nil, fmt.Errorf("解析限速配置失败: %v", err)
+	}
+	return &result, nil
+}
+
+// RateLimitSet 新增或修改限速规则（CN和分组都为空时修改默认值）
+func (c *ControlClient) RateLimitSet(rule RateLimitRule) (*APIResponse, error) {
+	return c.Call(ActionRateLimitSet, rule)
+}
+
+// RateLimitDelete 删除针对某个CN或分组的限速规则
+func (c *ControlClient) RateLimitDelete(rule RateLimitRule) (*APIResponse, error) {
+	return c.Call(ActionRateLimitDelete, rule)
+}
+
+// ACLList 获取ACL规则及命中次数
+func (c *ControlClient) ACLList() (*ACLListResponse, error) {
+	resp, err := c.Call(ActionACLList, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	var result ACLListResponse
+	if err := json.Unmarshal(resp.Data, &result); err != nil {
+		return nil, fmt.Errorf("解析ACL配置失败: %v", err)
+	}
+	return &result, nil
+}
+
+// ACLAdd 在指定位置（从1开始，0=末尾）插入ACL规则
+func (c *ControlClient) ACLAdd(rule ACLRule, position int) (*APIResponse, error) {
+	return c.Call(ActionACLAdd, ACLAddRequest{ACLRule: rule, Position: position})
+}
+
+// ACLDelete 删除指定序号（从1开始）的ACL规则
+func (c *ControlClient) ACLDelete(index int) (*APIResponse, error) {
+	return c.Call(ActionACLDelete, ACLDeleteRequest{Index: index})
+}
+
+// ACLReload 从配置文件重新加载ACL规则
+func (c *ControlClient) ACLReload() (*APIResponse, error) {
+	return c.Call(ActionACLReload, nil)
+}
+
 // ConfigGet 获取配置
 func (c *ControlClient) ConfigGet() (*VPNConfig, error) {
 	resp, err := c.Call(ActionConfigGet, nil)
@@ -8,6 +8,7 @@ import (
 	"net"
 	"os"
 	"sync"
+	"time"
 )
 
 // ControlServer 控制 API 服务端（Unix Socket）
@@ -42,6 +43,12 @@ func (s *ControlServer) Start() error {
 	// 设置权限（允许同组用户访问）
 	os.Chmod(s.socketPath, 0660)
 
+	s.serve(listener)
+	return nil
+}
+
+// serve 在监听套接字上处理请求（无中断重启时套接字继承自旧进程）
+func (s *ControlServer) serve(listener net.Listener) {
 	s.mu.Lock()
 	s.listener = listener
 	s.running = true
@@ -50,25 +57,43 @@ func (s *ControlServer) Start() error {
 	log.Printf("控制API服务已启动: %s", s.socketPath)
 
 	go s.acceptLoop()
-	return nil
 }
 
 // Stop 停止控制服务端
 func (s *ControlServer) Stop() {
+	if s.closeListener(true) {
+		log.Println("控制API服务已停止")
+	}
+}
+
+// detach 停止接受请求但保留套接字文件（套接字已交接给新进程）
+func (s *ControlServer) detach() {
+	if s.closeListener(false) {
+		log.Println("控制API服务已交接给新进程")
+	}
+}
+
+// closeListener 关闭监听套接字，removeSocket 为 false 时不删除套接字文件。未运行时返回 false
+func (s *ControlServer) closeListener(removeSocket bool) bool {
 	s.mu.Lock()
 	if !s.running {
 		s.mu.Unlock()
-		return
+		return false
 	}
 	s.running = false
 	s.mu.Unlock()
 
 	close(s.done)
+	if ul, ok := s.listener.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(removeSocket)
+	}
 	if s.listener != nil {
 		s.listener.Close()
 	}
-	os.Remove(s.socketPath)
-	log.Println("控制API服务已停止")
+	if removeSocket {
+		os.Remove(s.socketPath)
+	}
+	return true
 }
 
 func (s *ControlServer) acceptLoop() {
@@ -121,6 +146,12 @@ func (s *ControlServer) handleRequest(req APIRequest) APIResponse {
 		return s.handleServerKick(req.Data)
 	case ActionServerStats:
 		return s.handleServerStats()
+	case ActionServerDrain:
+		return s.handleServerDrain(req.Data)
+	case ActionServerUpgrade:
+		return s.handleServerUpgrade()
+	case ActionServerQuotas:
+		return s.handleServerQuotas()
 
 	// 客户端
 	case ActionClientConnect:
@@ -154,6 +185,32 @@ func (s *ControlServer) handleRequest(req APIRequest) APIResponse {
 	case ActionTokenCleanup:
 		return s.handleTokenCleanup()
 
+	// IP租约
+	case ActionLeaseList:
+		return s.handleLeaseList()
+	case ActionLeaseSet:
+		return s.handleLeaseSet(req.Data)
+	case ActionLeaseDelete:
+		return s.handleLeaseDelete(req.Data)
+
+	// 带宽限速
+	case ActionRateLimitList:
+		return s.handleRateLimitList()
+	case ActionRateLimitSet:
+		return s.handleRateLimitSet(req.Data)
+	case ActionRateLimitDelete:
+		return s.handleRateLimitDelete(req.Data)
+
+	// 访问控制
+	case ActionACLList:
+		return s.handleACLList()
+	case ActionACLAdd:
+		return s.handleACLAdd(req.Data)
+	case ActionACLDelete:
+		return s.handleACLDelete(req.Data)
+	case ActionACLReload:
+		return s.handleACLReload()
+
 	// 配置
 	case ActionConfigGet:
 		return s.handleConfigGet()
@@ -244,6 +301,40 @@ func (s *ControlServer) handleServerStats() APIResponse {
 	return APIResponse{Success: true, Data: data}
 }
 
+func (s *ControlServer) handleServerDrain(reqData json.RawMessage) APIResponse {
+	var req DrainRequest
+	if len(reqData) > 0 {
+		if err := json.Unmarshal(reqData, &req); err != nil {
+			return APIResponse{Success: false, Error: "无效的请求数据"}
+		}
+	}
+	if req.TimeoutSec < 0 {
+		return APIResponse{Success: false, Error: "无效的排空超时"}
+	}
+	timeout, err := s.service.DrainServer(time.Duration(req.TimeoutSec) * time.Second)
+	if err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	return APIResponse{Success: true, Message: fmt.Sprintf("正在排空会话，最长等待 %v 后停止服务端", timeout)}
+}
+
+func (s *ControlServer) handleServerUpgrade() APIResponse {
+	pid, err := s.upgrade()
+	if err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	return APIResponse{Success: true, Message: fmt.Sprintf("已交接给新进程 (PID: %d)，旧进程排空会话后退出", pid)}
+}
+
+func (s *ControlServer) handleServerQuotas() APIResponse {
+	quotas, err := s.service.GetQuotas()
+	if err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	data, _ := json.Marshal(quotas)
+	return APIResponse{Success: true, Data: data}
+}
+
 // ================ 客户端处理 ================
 
 func (s *ControlServer) handleClientConnect() APIResponse {
@@ -292,7 +383,7 @@ func (s *ControlServer) handleCertGenCSR(reqData json.RawMessage) APIResponse {
 	if err := json.Unmarshal(reqData, &req); err != nil {
 		return APIResponse{Success: false, Error: "无效的请求数据"}
 	}
-	resp, err := s.service.GenerateCSR(req.ClientName)
+	resp, err := s.service.GenerateCSR(req.ClientName, req.Group)
 	if err != nil {
 		return APIResponse{Success: false, Error: err.Error()}
 	}
@@ -357,6 +448,118 @@ func (s *ControlServer) handleTokenCleanup() APIResponse {
 	return APIResponse{Success: true, Message: fmt.Sprintf("已清理 %d 个过期Token", count)}
 }
 
+// ================ IP租约处理 ================
+
+func (s *ControlServer) handleLeaseList() APIResponse {
+	leases, err := s.service.GetLeases()
+	if err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	data, _ := json.Marshal(LeaseListResponse{Leases: leases})
+	return APIResponse{Success: true, Data: data}
+}
+
+func (s *ControlServer) handleLeaseSet(reqData json.RawMessage) APIResponse {
+	var req SetLeaseRequest
+	if err := json.Unmarshal(reqData, &req); err != nil {
+		return APIResponse{Success: false, Error: "无效的请求数据"}
+	}
+	if err := s.service.SetLease(req); err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	return APIResponse{Success: true, Message: fmt.Sprintf("租约已设置: %s -> %s", req.Key, req.IP)}
+}
+
+func (s *ControlServer) handleLeaseDelete(reqData json.RawMessage) APIResponse {
+	var req DeleteLeaseRequest
+	if err := json.Unmarshal(reqData, &req); err != nil {
+		return APIResponse{Success: false, Error: "无效的请求数据"}
+	}
+	if err := s.service.DeleteLease(req.Key); err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	return APIResponse{Success: true, Message: "租约已删除: " + req.Key}
+}
+
+// ================ 带宽限速处理 ================
+
+// rateLimitTarget 返回限速规则针对的对象（用于提示信息）
+func rateLimitTarget(rule RateLimitRule) string {
+	switch {
+	case rule.CN != "":
+		return "CN " + rule.CN
+	case rule.Group != "":
+		return "分组 " + rule.Group
+	default:
+		return "默认"
+	}
+}
+
+func (s *ControlServer) handleRateLimitList() APIResponse {
+	data, _ := json.Marshal(s.service.GetRateLimits())
+	return APIResponse{Success: true, Data: data}
+}
+
+func (s *ControlServer) handleRateLimitSet(reqData json.RawMessage) APIResponse {
+	var req RateLimitRule
+	if err := json.Unmarshal(reqData, &req); err != nil {
+		return APIResponse{Success: false, Error: "无效的请求数据"}
+	}
+	if err := s.service.SetRateLimit(req); err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	return APIResponse{Success: true, Message: fmt.Sprintf("%s限速已设置: 上行 %d kbps, 下行 %d kbps（0=不限速）",
+		rateLimitTarget(req), req.UpKbps, req.DownKbps)}
+}
+
+func (s *ControlServer) handleRateLimitDelete(reqData json.RawMessage) APIResponse {
+	var req RateLimitRule
+	if err := json.Unmarshal(reqData, &req); err != nil {
+		return APIResponse{Success: false, Error: "无效的请求数据"}
+	}
+	if err := s.service.DeleteRateLimit(req); err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	return APIResponse{Success: true, Message: rateLimitTarget(req) + " 的限速规则已删除"}
+}
+
+// ================ 访问控制处理 ================
+
+func (s *ControlServer) handleACLList() APIResponse {
+	data, _ := json.Marshal(s.service.GetACL())
+	return APIResponse{Success: true, Data: data}
+}
+
+func (s *ControlServer) handleACLAdd(reqData json.RawMessage) APIResponse {
+	var req ACLAddRequest
+	if err := json.Unmarshal(reqData, &req); err != nil {
+		return APIResponse{Success: false, Error: "无效的请求数据"}
+	}
+	if err := s.service.AddACLRule(req.ACLRule, req.Position); err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	return APIResponse{Success: true, Message: "ACL规则已添加: " + req.ACLRule.String()}
+}
+
+func (s *ControlServer) handleACLDelete(reqData json.RawMessage) APIResponse {
+	var req ACLDeleteRequest
+	if err := json.Unmarshal(reqData, &req); err != nil {
+		return APIResponse{Success: false, Error: "无效的请求数据"}
+	}
+	if err := s.service.DeleteACLRule(req.Index); err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	return APIResponse{Success: true, Message: fmt.Sprintf("第%d条ACL规则已删除", req.Index)}
+}
+
+func (s *ControlServer) handleACLReload() APIResponse {
+	count, err := s.service.ReloadACL()
+	if err != nil {
+		return APIResponse{Success: false, Error: err.Error()}
+	}
+	return APIResponse{Success: true, Message: fmt.Sprintf("已从配置文件重新加载 %d 条ACL规则", count)}
+}
+
 // ================ 配置处理 ================
 
 func (s *ControlServer) handleConfigGet() APIResponse {
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// ================ 消息帧编解码（数据路径） ================
+//
+// 数据路径上的消息编解码尽量不分配内存：
+//   - 消息头直接写入目标缓冲区，负载紧随其后，一次 Write 发出（tls.Conn 不支持 writev，
+//     分开写头和负载会产生两个TLS记录）
+//   - 读取时复用每个连接自己的头部数组和负载缓冲区
+//   - 临时缓冲区来自 packetBufferPool，用完归还
+
+const (
+	messageHeaderSize = 13    // Type(1) + Length(4) + Sequence(4) + Checksum(4)
+	maxMessagePayload = 65535 // 单条消息负载上限
+)
+
+// putMessageHeader 将消息头写入 b[:messageHeaderSize]
+func putMessageHeader(b []byte, msgType MessageType, length, seq, checksum uint32) {
+	b[0] = byte(msgType)
+	binary.BigEndian.PutUint32(b[1:5], length)
+	binary.BigEndian.PutUint32(b[5:9], seq)
+	binary.BigEndian.PutUint32(b[9:13], checksum)
+}
+
+// appendMessage 将一条完整消息追加到 dst，withChecksum 为 true 时计算负载的CRC32
+func appendMessage(dst []byte, msgType MessageType, seq uint32, payload []byte, withChecksum bool) []byte {
+	checksum := uint32(0)
+	if withChecksum && len(payload) > 0 {
+		checksum = crc32.ChecksumIEEE(payload)
+	}
+	n := len(dst)
+	dst = append(dst, make([]byte, messageHeaderSize)...)
+	putMessageHeader(dst[n:], msgType, uint32(len(payload)), seq, checksum)
+	return append(dst, payload...)
+}
+
+// packetBuffer 池化的缓冲区，容量足够容纳一条最大的消息
+type packetBuffer struct {
+	b []byte
+}
+
+var packetBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &packetBuffer{b: make([]byte, 0, messageHeaderSize+maxMessagePayload)}
+	},
+}
+
+// getPacketBuffer 从池中获取一个空缓冲区
+func getPacketBuffer() *packetBuffer {
+	pb := packetBufferPool.Get().(*packetBuffer)
+	pb.b = pb.b[:0]
+	return pb
+}
+
+// release 归还缓冲区，调用后不得再使用其中的数据
+func (pb *packetBuffer) release() {
+	if pb != nil {
+		packetBufferPool.Put(pb)
+	}
+}
+
+// frameReader 复用头部和负载缓冲区逐条读取消息，返回的负载只在下一次读取前有效
+type frameReader struct {
+	r      io.Reader
+	header [messageHeaderSize]byte
+	buf    *packetBuffer
+}
+
+// newFrameReader 创建消息读取器，不再使用时需调用 Release
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{r: r, buf: getPacketBuffer()}
+}
+
+// ReadHeader 读取消息头
+func (fr *frameReader) ReadHeader() (msgType MessageType, length, seq, checksum uint32, err error) {
+	if _, err = io.ReadFull(fr.r, fr.header[:]); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	msgType = MessageType(fr.header[0])
+	length = binary.BigEndian.Uint32(fr.header[1:5])
+	seq = binary.BigEndian.Uint32(fr.header[5:9])
+	checksum = binary.BigEndian.Uint32(fr.header[9:13])
+	return msgType, length, seq, checksum, nil
+}
+
+// ReadPayload 读取 length 字节的消息体到复用的缓冲区
+func (fr *frameReader) ReadPayload(length uint32) ([]byte, error) {
+	if length > maxMessagePayload {
+		return nil, fmt.Errorf("消息过大: %d 字节", length)
+	}
+	payload := fr.buf.b[:length]
+	if length > 0 {
+		if _, err := io.ReadFull(fr.r, payload); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// Release 归还负载缓冲区
+func (fr *frameReader) Release() {
+	fr.buf.release()
+	fr.buf = nil
+}
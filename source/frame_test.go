@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// 数据路径编解码的基准测试：Legacy 子测试按池化改造之前的实现分配内存，
+// 与当前实现对比每个包的分配次数（go test -bench . -benchmem -run ^$）
+
+// benchPacket 构造一个 size 字节、负载可压缩的 IPv4/UDP 包（目标端口5000，不在跳过压缩的端口中）
+func benchPacket(size int) []byte {
+	packet := make([]byte, size)
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(size))
+	packet[8] = 64
+	packet[9] = 17
+	copy(packet[12:16], []byte{10, 8, 0, 2})
+	copy(packet[16:20], []byte{10, 8, 0, 1})
+	binary.BigEndian.PutUint16(packet[20:22], 40000)
+	binary.BigEndian.PutUint16(packet[22:24], 5000)
+	for i := 28; i < size; i++ {
+		packet[i] = byte('a' + i%16)
+	}
+	return packet
+}
+
+// legacySerialize 池化改造之前的 Message.Serialize：单独分配消息头，再追加负载
+func legacySerialize(m *Message) []byte {
+	header := make([]byte, messageHeaderSize)
+	putMessageHeader(header, m.Type, m.Length, m.Sequence, m.Checksum)
+	return append(header, m.Payload...)
+}
+
+func BenchmarkAppendMessage(b *testing.B) {
+	packet := benchPacket(1400)
+
+	b.Run("Legacy", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(packet)))
+		for i := 0; i < b.N; i++ {
+			m := &Message{Type: MessageTypeData, Length: uint32(len(packet)), Sequence: uint32(i), Payload: packet}
+			if data := legacySerialize(m); len(data) != messageHeaderSize+len(packet) {
+				b.Fatal("消息长度错误")
+			}
+		}
+	})
+
+	b.Run("Pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(packet)))
+		for i := 0; i < b.N; i++ {
+			buf := getPacketBuffer()
+			buf.b = appendMessage(buf.b, MessageTypeData, uint32(i), packet, true)
+			if len(buf.b) != messageHeaderSize+len(packet) {
+				b.Fatal("消息长度错误")
+			}
+			buf.release()
+		}
+	})
+}
+
+// benchStream 构造包含 count 条数据消息的字节流
+func benchStream(packet []byte, count int) []byte {
+	var stream []byte
+	for i := 0; i < count; i++ {
+		stream = appendMessage(stream, MessageTypeData, uint32(i), packet, false)
+	}
+	return stream
+}
+
+func BenchmarkFrameReader(b *testing.B) {
+	packet := benchPacket(1400)
+	stream := benchStream(packet, 64)
+
+	b.Run("Legacy", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(packet)))
+		r := bytes.NewReader(stream)
+		for i := 0; i < b.N; i++ {
+			if r.Len() == 0 {
+				r.Reset(stream)
+			}
+			// 改造之前每条消息分配消息头和负载
+			header := make([]byte, messageHeaderSize)
+			if _, err := io.ReadFull(r, header); err != nil {
+				b.Fatal(err)
+			}
+			payload := make([]byte, binary.BigEndian.Uint32(header[1:5]))
+			if _, err := io.ReadFull(r, payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(packet)))
+		r := bytes.NewReader(stream)
+		fr := newFrameReader(r)
+		defer fr.Release()
+		for i := 0; i < b.N; i++ {
+			if r.Len() == 0 {
+				r.Reset(stream)
+			}
+			_, length, _, _, err := fr.ReadHeader()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := fr.ReadPayload(length); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkCompressRoundTrip(b *testing.B) {
+	packet := benchPacket(1400)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	compressed := getPacketBuffer()
+	defer compressed.release()
+	decompressed := getPacketBuffer()
+	defer decompressed.release()
+	for i := 0; i < b.N; i++ {
+		out, ok := compressPacket(compressed.b[:0], packet)
+		if !ok {
+			b.Fatal("数据包未被压缩")
+		}
+		restored, err := decompressPacket(decompressed.b[:0], out)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(restored) != len(packet) {
+			b.Fatal("解压后长度错误")
+		}
+	}
+}
+
+func BenchmarkPacketBatcher(b *testing.B) {
+	packet := benchPacket(1400)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	batches := 0
+	batcher := newPacketBatcher(time.Hour, DefaultConfig.BatchMaxBytes,
+		func(msgType MessageType, payload []byte, packetBytes uint64, buf *packetBuffer) error {
+			batches++
+			buf.release()
+			return nil
+		})
+	defer batcher.Stop()
+	for i := 0; i < b.N; i++ {
+		if err := batcher.Add(packet, false, len(packet)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := batcher.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	if b.N > 1 && batches == 0 {
+		b.Fatal("没有发送批次")
+	}
+}
@@ -0,0 +1,438 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ================ 无中断重启（进程交接） ================
+//
+// 升级程序或修改需要重启的配置时，旧进程把监听套接字和TUN设备交给新进程，客户端只需重连：
+//   1. 旧进程停止证书API服务器（释放端口），以 --service 启动新进程，控制套接字、TCP/UDP监听
+//      套接字（包括WebSocket监听套接字）、TUN设备各队列和就绪通知管道作为继承的描述符传递，
+//      描述符编号和服务端状态通过环境变量 TLSVPN_HANDOFF（JSON）传递
+//   2. 新进程在继承的描述符上恢复控制服务和VPN服务器（跳过TUN配置和NAT设置，接管NAT规则），
+//      就绪后通过管道通知旧进程；未能就绪时旧进程终止新进程并继续服务
+//   3. 旧进程停止接受连接，向客户端发送 Goodbye（restart），客户端在随机延迟后重连到新进程，
+//      会话全部迁移或超时后旧进程退出，不删除TUN设备、NAT规则和控制套接字文件
+//
+// 交接期间新旧进程同时读取TUN设备和UDP套接字，发往尚未迁移客户端的包可能被另一个进程读走而丢弃，
+// 因此交接后使用较短的重连延迟。IP租约保证客户端重连后分配到相同的地址。
+// 监听端口、隧道网段、UDP端口和WebSocket端口必须与旧进程一致，修改这些配置需要完全重启。
+
+const (
+	handoffEnv          = "TLSVPN_HANDOFF"
+	handoffReadyTimeout = 20 * time.Second // 等待新进程就绪的时间（小于控制客户端的请求超时）
+)
+
+// handoffState 旧进程传给新进程的交接信息（描述符为新进程中的编号）
+type handoffState struct {
+	Control    int       `json:"control"`            // 控制套接字
+	Ready      int       `json:"ready"`              // 就绪通知管道（写端）
+	Listener   int       `json:"listener"`           // TCP监听套接字
+	UDP        int       `json:"udp,omitempty"`      // UDP数据通道套接字（0=未启用）
+	WebSocket  int       `json:"ws,omitempty"`       // WebSocket传输的监听套接字（0=未启用）
+	TUN        []int     `json:"tun"`                // TUN设备各队列
+	TUNName    string    `json:"tun_name"`           // TUN设备名称
+	TUNOffload bool      `json:"tun_offload"`        // TUN设备启用了 virtio-net 头
+	Port       int       `json:"port"`               // 监听端口
+	UDPPort    int       `json:"udp_port,omitempty"` // UDP端口（0=未启用）
+	WSPort     int       `json:"ws_port,omitempty"`  // WebSocket端口（0=未启用）
+	Network    string    `json:"network"`            // IPv4隧道网段
+	Network6   string    `json:"network6,omitempty"` // IPv6隧道网段
+	NATRules   []NATRule `json:"nat_rules,omitempty"`
+}
+
+// handoffFiles 按传递顺序收集的描述符，第 i 个在新进程中的编号为 3+i
+type handoffFiles struct {
+	files []*os.File
+}
+
+// add 添加一个文件，返回其在新进程中的编号
+func (h *handoffFiles) add(f *os.File) int {
+	h.files = append(h.files, f)
+	return 2 + len(h.files)
+}
+
+// addDup 复制 c 的描述符并添加。传递的是副本，启动新进程时对副本的操作不影响本进程的文件对象
+func (h *handoffFiles) addDup(c syscall.Conn, name string) (int, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return 0, fmt.Errorf("获取%s描述符失败: %v", name, err)
+	}
+	var fd int
+	var dupErr error
+	if err := raw.Control(func(s uintptr) {
+		fd, dupErr = syscall.Dup(int(s))
+	}); err != nil {
+		return 0, fmt.Errorf("获取%s描述符失败: %v", name, err)
+	}
+	if dupErr != nil {
+		return 0, fmt.Errorf("复制%s描述符失败: %v", name, dupErr)
+	}
+	syscall.CloseOnExec(fd)
+	return h.add(os.NewFile(uintptr(fd), name)), nil
+}
+
+// restoreNonblock 恢复非阻塞模式。启动子进程时会把传递的描述符设为阻塞模式，
+// 而文件状态由副本和原描述符共享，不恢复会使本进程的读取和 Accept 阻塞在系统调用中
+func (h *handoffFiles) restoreNonblock() {
+	for _, f := range h.files {
+		_ = syscall.SetNonblock(int(f.Fd()), true)
+	}
+}
+
+// close 关闭本进程持有的副本
+func (h *handoffFiles) close() {
+	for _, f := range h.files {
+		_ = f.Close()
+	}
+}
+
+// upgrade 启动新进程并交接控制套接字和VPN服务器，成功后旧进程排空会话并退出。返回新进程的PID
+func (s *ControlServer) upgrade() (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("获取可执行文件路径失败: %v", err)
+	}
+
+	state, files, err := s.service.beginHandoff()
+	if err != nil {
+		return 0, err
+	}
+	defer files.close()
+
+	pid, err := s.startSuccessor(executable, state, files)
+	if err != nil {
+		s.service.abortHandoff()
+		return 0, err
+	}
+
+	s.detach()
+	s.service.completeHandoff()
+	return pid, nil
+}
+
+// startSuccessor 以 --service 启动新进程并等待其就绪
+func (s *ControlServer) startSuccessor(executable string, state *handoffState, files *handoffFiles) (int, error) {
+	s.mu.Lock()
+	listener, ok := s.listener.(syscall.Conn)
+	s.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("控制套接字不支持交接")
+	}
+	var err error
+	if state.Control, err = files.addDup(listener, "控制套接字"); err != nil {
+		return 0, err
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("创建就绪通知管道失败: %v", err)
+	}
+	defer readyR.Close()
+	state.Ready = files.add(readyW)
+
+	env, err := json.Marshal(state)
+	if err != nil {
+		return 0, fmt.Errorf("序列化交接状态失败: %v", err)
+	}
+
+	cmd := exec.Command(executable, "--service")
+	cmd.Env = append(os.Environ(), handoffEnv+"="+string(env))
+	cmd.ExtraFiles = files.files
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	if err := cmd.Start(); err != nil {
+		files.restoreNonblock()
+		return 0, fmt.Errorf("启动新进程失败: %v", err)
+	}
+	files.restoreNonblock()
+	// 关闭本进程的写端，新进程退出时读取会立即结束
+	_ = readyW.Close()
+	go cmd.Wait()
+
+	log.Printf("已启动新进程 (PID: %d)，等待其接管服务...", cmd.Process.Pid)
+	_ = readyR.SetReadDeadline(time.Now().Add(handoffReadyTimeout))
+	line, err := bufio.NewReader(readyR).ReadString('\n')
+	line = strings.TrimSpace(line)
+	if err != nil || line != "ok" {
+		_ = cmd.Process.Kill()
+		switch {
+		case line != "":
+			return 0, fmt.Errorf("新进程接管失败: %s", line)
+		case os.IsTimeout(err):
+			return 0, fmt.Errorf("等待新进程就绪超时")
+		default:
+			return 0, fmt.Errorf("新进程未能就绪（详见日志）")
+		}
+	}
+	log.Printf("新进程 (PID: %d) 已接管服务", cmd.Process.Pid)
+	return cmd.Process.Pid, nil
+}
+
+// beginHandoff 准备交接：停止证书API服务器，收集服务端的监听套接字和TUN设备
+func (s *VPNService) beginHandoff() (*handoffState, *handoffFiles, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.server == nil || !s.server.IsRunning() {
+		return nil, nil, fmt.Errorf("服务端未运行")
+	}
+	if s.server.isDraining() {
+		return nil, nil, fmt.Errorf("服务端正在排空会话")
+	}
+	if s.client != nil && s.client.IsRunning() {
+		return nil, nil, fmt.Errorf("客户端正在运行，客户端连接无法交接给新进程")
+	}
+	server := s.server
+	// 新进程启动时从配额文件加载用量，先写回在线会话的最新流量
+	server.flushQuotas()
+
+	state := &handoffState{
+		Port:     s.config.ServerPort,
+		Network:  s.config.Network,
+		Network6: s.config.Network6,
+	}
+	files := &handoffFiles{}
+	ok := false
+	defer func() {
+		if !ok {
+			files.close()
+		}
+	}()
+
+	tcpListener, isConn := server.tcpListener.(syscall.Conn)
+	if !isConn {
+		return nil, nil, fmt.Errorf("监听套接字不支持交接")
+	}
+	var err error
+	if state.Listener, err = files.addDup(tcpListener, "监听套接字"); err != nil {
+		return nil, nil, err
+	}
+	if server.udpConn != nil {
+		if state.UDP, err = files.addDup(server.udpConn, "UDP套接字"); err != nil {
+			return nil, nil, err
+		}
+		state.UDPPort = server.udpConn.LocalAddr().(*net.UDPAddr).Port
+	}
+	if server.wsListener != nil {
+		wsListener, isConn := server.wsListener.(syscall.Conn)
+		if !isConn {
+			return nil, nil, fmt.Errorf("WebSocket监听套接字不支持交接")
+		}
+		if state.WebSocket, err = files.addDup(wsListener, "WebSocket监听套接字"); err != nil {
+			return nil, nil, err
+		}
+		state.WSPort = server.wsListener.Addr().(*net.TCPAddr).Port
+	}
+
+	if server.tunDevice == nil {
+		return nil, nil, fmt.Errorf("服务端没有TUN设备")
+	}
+	tunFiles, offload, err := tunHandoffFiles(server.tunDevice)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range tunFiles {
+		fd, err := files.addDup(f, "TUN设备")
+		if err != nil {
+			return nil, nil, err
+		}
+		state.TUN = append(state.TUN, fd)
+	}
+	state.TUNName = server.tunDevice.Name()
+	state.TUNOffload = offload
+
+	server.natMutex.Lock()
+	state.NATRules = append([]NATRule(nil), server.natRules...)
+	server.natMutex.Unlock()
+
+	// 新进程需要监听证书API端口
+	if s.apiServer != nil {
+		s.apiServer.Stop()
+		s.apiServer = nil
+	}
+	ok = true
+	return state, files, nil
+}
+
+// abortHandoff 新进程未能接管时恢复证书API服务器
+func (s *VPNService) abortHandoff() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.certManager != nil && s.apiServer == nil {
+		s.startCertAPIServer(s.certManager)
+	}
+}
+
+// completeHandoff 新进程已接管：停止写回租约文件，排空会话后退出进程
+func (s *VPNService) completeHandoff() {
+	s.mu.Lock()
+	server := s.server
+	timeout := s.config.GetDrainTimeout()
+	s.mu.Unlock()
+
+	atomic.StoreInt32(&server.handedOff, 1)
+	server.leases.freeze()
+	server.quotas.freeze()
+
+	go func() {
+		if err := server.drain(GoodbyeReasonRestart, "服务端已重启", handoffRetryJitter, timeout); err != nil {
+			log.Printf("排空会话失败: %v", err)
+			server.Stop()
+		}
+		log.Println("会话已交接给新进程，旧进程退出")
+		os.Exit(0)
+	}()
+}
+
+// resumeHandoff 以交接方式启动时（环境变量 TLSVPN_HANDOFF），在继承的描述符上恢复控制服务和
+// VPN服务器并通知旧进程。不是交接启动时返回 false
+func resumeHandoff(service *VPNService, controlServer *ControlServer) (bool, error) {
+	env := os.Getenv(handoffEnv)
+	if env == "" {
+		return false, nil
+	}
+	_ = os.Unsetenv(handoffEnv)
+
+	var state handoffState
+	if err := json.Unmarshal([]byte(env), &state); err != nil {
+		return true, fmt.Errorf("解析交接状态失败: %v", err)
+	}
+	ready := os.NewFile(uintptr(state.Ready), "ready")
+	defer ready.Close()
+
+	err := resumeFromState(service, controlServer, &state)
+	if err != nil {
+		fmt.Fprintf(ready, "%v\n", err)
+		return true, err
+	}
+	if _, err := fmt.Fprintln(ready, "ok"); err != nil {
+		return true, fmt.Errorf("通知旧进程失败: %v", err)
+	}
+	log.Printf("已从旧进程接管服务 (TUN: %s)", state.TUNName)
+	return true, nil
+}
+
+// resumeFromState 恢复控制服务和VPN服务器
+func resumeFromState(service *VPNService, controlServer *ControlServer, state *handoffState) error {
+	controlListener, err := inheritedListener(state.Control, "控制套接字")
+	if err != nil {
+		return err
+	}
+	if err := service.resumeServer(state); err != nil {
+		controlListener.Close()
+		return err
+	}
+	controlServer.serve(controlListener)
+	return nil
+}
+
+// resumeServer 在继承的描述符上恢复VPN服务器（TUN设备和NAT规则沿用旧进程的配置）
+func (s *VPNService) resumeServer(state *handoffState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	udpPort := 0
+	if s.config.EnableUDP {
+		udpPort = s.config.GetUDPPort()
+	}
+	if s.config.ServerPort != state.Port || s.config.Network != state.Network ||
+		s.config.Network6 != state.Network6 || udpPort != state.UDPPort || s.config.WebSocketPort != state.WSPort {
+		return fmt.Errorf("监听端口、隧道网段、UDP端口或WebSocket端口已修改，需要完全重启服务")
+	}
+	if err := s.config.ValidateConfig(); err != nil {
+		return fmt.Errorf("配置验证失败: %v", err)
+	}
+
+	certManager, err := s.getOrInitCertManager(true)
+	if err != nil {
+		return fmt.Errorf("初始化证书失败: %v", err)
+	}
+
+	tcpListener, err := inheritedListener(state.Listener, "监听套接字")
+	if err != nil {
+		return err
+	}
+	var udpConn *net.UDPConn
+	if state.UDP != 0 {
+		f := os.NewFile(uintptr(state.UDP), "udp")
+		pc, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			tcpListener.Close()
+			return fmt.Errorf("恢复UDP套接字失败: %v", err)
+		}
+		udpConn = pc.(*net.UDPConn)
+	}
+	var wsListener net.Listener
+	if state.WebSocket != 0 {
+		if wsListener, err = inheritedListener(state.WebSocket, "WebSocket监听套接字"); err != nil {
+			tcpListener.Close()
+			if udpConn != nil {
+				udpConn.Close()
+			}
+			return err
+		}
+	}
+	closeListeners := func() {
+		tcpListener.Close()
+		if udpConn != nil {
+			udpConn.Close()
+		}
+		if wsListener != nil {
+			wsListener.Close()
+		}
+	}
+
+	server, err := newVPNServer(tcpListener, udpConn, wsListener, certManager, s.config)
+	if err != nil {
+		closeListeners()
+		return err
+	}
+
+	tunFiles := make([]*os.File, 0, len(state.TUN))
+	for _, fd := range state.TUN {
+		_ = syscall.SetNonblock(fd, true)
+		tunFiles = append(tunFiles, os.NewFile(uintptr(fd), "tun"))
+	}
+	tun, err := openInheritedTUN(tunFiles, state.TUNName, state.TUNOffload, s.config.MTU)
+	if err != nil {
+		closeListeners()
+		return err
+	}
+	server.tunDevice = tun
+	server.serverIP = ipAdd(server.vpnNetwork.IP, 1)
+	server.natRules = state.NATRules
+
+	s.startCertAPIServer(certManager)
+	s.server = server
+	go server.Start(context.Background())
+	return nil
+}
+
+// inheritedListener 在继承的描述符上恢复监听套接字
+func inheritedListener(fd int, name string) (net.Listener, error) {
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+	listener, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("恢复%s失败: %v", name, err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "fmt"
+
+// upgrade Windows 不支持无中断重启（无法把监听套接字和Wintun设备交给新进程）
+func (s *ControlServer) upgrade() (int, error) {
+	return 0, fmt.Errorf("Windows 不支持无中断重启")
+}
+
+// resumeHandoff Windows 上不存在交接启动
+func resumeHandoff(service *VPNService, controlServer *ControlServer) (bool, error) {
+	return false, nil
+}
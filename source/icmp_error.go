@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ================ ICMP差错报文 ================
+//
+// 隧道无法投递的数据包（目标地址没有在线客户端、超过隧道MTU）不静默丢弃，而是像路由器一样
+// 生成ICMP差错报文写回TUN设备，应用立即得到“不可达”或按新的MTU重传，不必等到超时。
+// 差错报文的源地址使用原数据包的目标地址：该地址经TUN设备路由，能通过反向路径检查，
+// 且不是本机地址（内核丢弃源地址为本机地址的入站包）。
+//
+// 速率限制与 Linux 内核一致：目标不可达按差错报文的目标地址限速（icmp_ratelimit，
+// 每秒1个、突发6个），并受全局速率限制（icmp_msgs_per_sec/icmp_msgs_burst）；
+// “需要分片”和 Packet Too Big 用于路径MTU发现，不限速。
+
+const (
+	icmpRateInterval = time.Second // 每个目标地址的令牌补充间隔
+	icmpRateBurst    = 6           // 每个目标地址的突发量
+	icmpGlobalPerSec = 1000        // 全局每秒差错报文数
+	icmpGlobalBurst  = 50          // 全局突发量
+	icmpMaxPeers     = 4096        // 限速表超过该大小时清理已补满令牌的条目
+
+	icmpv4MaxError = 576  // ICMPv4差错报文的最大长度（RFC 1812）
+	icmpv6MaxError = 1280 // ICMPv6差错报文的最大长度（RFC 4443）
+)
+
+// ICMP类型和代码
+const (
+	icmpv4DestUnreachable = 3
+	icmpv4NetUnreachable  = 0
+	icmpv4HostUnreachable = 1
+	icmpv4FragNeeded      = 4
+
+	icmpv6DestUnreachable    = 1
+	icmpv6PacketTooBig       = 2
+	icmpv6NoRoute            = 0
+	icmpv6AddressUnreachable = 3
+)
+
+// icmpTokenBucket 令牌桶，tokens 单位为纳秒（每条报文消耗 cost）
+type icmpTokenBucket struct {
+	tokens time.Duration
+	last   time.Time
+}
+
+// take 补充令牌并尝试消耗一条报文的令牌
+func (b *icmpTokenBucket) take(now time.Time, cost, burst time.Duration) bool {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last)
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	if b.tokens < cost {
+		return false
+	}
+	b.tokens -= cost
+	return true
+}
+
+// icmpRateLimiter ICMP差错报文的速率限制（每个目标地址一个令牌桶，外加全局令牌桶）
+type icmpRateLimiter struct {
+	mu     sync.Mutex
+	peers  map[netip.Addr]*icmpTokenBucket
+	global icmpTokenBucket
+}
+
+// newICMPRateLimiter 创建ICMP速率限制器
+func newICMPRateLimiter() *icmpRateLimiter {
+	return &icmpRateLimiter{peers: make(map[netip.Addr]*icmpTokenBucket)}
+}
+
+// allow 判断是否可以向 dst 发送一条差错报文
+func (l *icmpRateLimiter) allow(dst netip.Addr) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	peer := l.peers[dst]
+	if peer == nil {
+		if len(l.peers) >= icmpMaxPeers {
+			l.prune(now)
+		}
+		peer = &icmpTokenBucket{}
+		l.peers[dst] = peer
+	}
+	if !peer.take(now, icmpRateInterval, icmpRateBurst*icmpRateInterval) {
+		return false
+	}
+	return l.global.take(now, time.Second/icmpGlobalPerSec, icmpGlobalBurst*time.Second/icmpGlobalPerSec)
+}
+
+// prune 删除令牌已补满的条目（与新建条目等价）
+func (l *icmpRateLimiter) prune(now time.Time) {
+	for addr, peer := range l.peers {
+		if now.Sub(peer.last)+peer.tokens >= icmpRateBurst*icmpRateInterval {
+			delete(l.peers, addr)
+		}
+	}
+}
+
+// icmpErrorTarget 判断能否为数据包生成差错报文，返回差错报文的目标地址（原数据包的源地址）。
+// 不为差错报文、非首个分片以及源或目标为组播/广播/未指定地址的包生成差错报文（RFC 1812、RFC 4443）
+func icmpErrorTarget(packet []byte) (netip.Addr, bool) {
+	var src, dst netip.Addr
+	var proto byte
+	var l4 []byte
+	switch packetIPVersion(packet) {
+	case 4:
+		ihl := int(packet[0]&0x0F) * 4
+		if ihl < 20 || len(packet) < ihl {
+			return netip.Addr{}, false
+		}
+		if binary.BigEndian.Uint16(packet[6:8])&0x1FFF != 0 {
+			return netip.Addr{}, false
+		}
+		src = netip.AddrFrom4([4]byte(packet[12:16]))
+		dst = netip.AddrFrom4([4]byte(packet[16:20]))
+		if src == netip.AddrFrom4([4]byte{255, 255, 255, 255}) || dst == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+			return netip.Addr{}, false
+		}
+		proto, l4 = packet[9], packet[ihl:]
+		if proto == 1 && len(l4) > 0 {
+			switch l4[0] {
+			case 3, 4, 5, 11, 12: // 差错报文
+				return netip.Addr{}, false
+			}
+		}
+	case 6:
+		src = netip.AddrFrom16([16]byte(packet[8:24]))
+		dst = netip.AddrFrom16([16]byte(packet[24:40]))
+		proto, l4 = packet[6], packet[40:]
+		if proto == 44 && len(l4) >= 8 && binary.BigEndian.Uint16(l4[2:4])&0xFFF8 != 0 {
+			return netip.Addr{}, false
+		}
+		if proto == 58 && len(l4) > 0 && l4[0] < 128 {
+			return netip.Addr{}, false
+		}
+	default:
+		return netip.Addr{}, false
+	}
+	if !src.IsValid() || src.IsUnspecified() || src.IsMulticast() || src.IsLoopback() || dst.IsMulticast() {
+		return netip.Addr{}, false
+	}
+	return src, true
+}
+
+// buildICMPError 构造ICMP差错报文：源地址为原数据包的目标地址，目标地址为原数据包的源地址，
+// 报文中携带尽量多的原数据包内容（不超过 RFC 规定的长度）。info 为类型相关的4字节字段
+// （“需要分片”和 Packet Too Big 为MTU）
+func buildICMPError(packet []byte, icmpType, code uint8, info uint32) []byte {
+	if packetIPVersion(packet) == 6 {
+		quote := packet[:min(len(packet), icmpv6MaxError-40-8)]
+		msg := make([]byte, 40+8+len(quote))
+		msg[0] = 0x60
+		binary.BigEndian.PutUint16(msg[4:6], uint16(8+len(quote)))
+		msg[6] = 58 // ICMPv6
+		msg[7] = 64
+		copy(msg[8:24], packet[24:40])
+		copy(msg[24:40], packet[8:24])
+		icmp := msg[40:]
+		icmp[0], icmp[1] = icmpType, code
+		binary.BigEndian.PutUint32(icmp[4:8], info)
+		copy(icmp[8:], quote)
+		binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(msg[8:24], msg[24:40], icmp))
+		return msg
+	}
+
+	quote := packet[:min(len(packet), icmpv4MaxError-20-8)]
+	msg := make([]byte, 20+8+len(quote))
+	msg[0] = 0x45
+	msg[1] = 0xC0 // 网络控制（与内核生成的差错报文相同）
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)))
+	msg[8] = 64
+	msg[9] = 1 // ICMP
+	copy(msg[12:16], packet[16:20])
+	copy(msg[16:20], packet[12:16])
+	binary.BigEndian.PutUint16(msg[10:12], internetChecksum(msg[:20], 0))
+	icmp := msg[20:]
+	icmp[0], icmp[1] = icmpType, code
+	binary.BigEndian.PutUint32(icmp[4:8], info)
+	copy(icmp[8:], quote)
+	binary.BigEndian.PutUint16(icmp[2:4], internetChecksum(icmp, 0))
+	return msg
+}
+
+// internetChecksum 计算互联网校验和（RFC 1071），initial 为伪头部等预先累加的和
+func internetChecksum(b []byte, initial uint32) uint16 {
+	sum := initial
+	for ; len(b) >= 2; b = b[2:] {
+		sum += uint32(binary.BigEndian.Uint16(b))
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// icmpv6Checksum 计算ICMPv6校验和（包含IPv6伪头部）
+func icmpv6Checksum(src, dst, icmp []byte) uint16 {
+	var sum uint32
+	for i := 0; i < 16; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(src[i:]))
+		sum += uint32(binary.BigEndian.Uint16(dst[i:]))
+	}
+	sum += uint32(len(icmp)) + 58
+	return internetChecksum(icmp, sum)
+}
+
+// icmpUnreachable 为无法投递的数据包生成目标不可达报文（受速率限制），不应生成时返回nil。
+// hostUnreachable 表示目标属于隧道网段但没有在线客户端，否则为网络不可达
+func icmpUnreachable(limiter *icmpRateLimiter, packet []byte, hostUnreachable bool) []byte {
+	target, ok := icmpErrorTarget(packet)
+	if !ok || !limiter.allow(target) {
+		return nil
+	}
+	if packetIPVersion(packet) == 6 {
+		code := uint8(icmpv6NoRoute)
+		if hostUnreachable {
+			code = icmpv6AddressUnreachable
+		}
+		return buildICMPError(packet, icmpv6DestUnreachable, code, 0)
+	}
+	code := uint8(icmpv4NetUnreachable)
+	if hostUnreachable {
+		code = icmpv4HostUnreachable
+	}
+	return buildICMPError(packet, icmpv4DestUnreachable, code, 0)
+}
+
+// icmpPacketTooBig 数据包超过隧道MTU且不允许分片时（IPv4设置了DF，IPv6总是如此）
+// 生成“需要分片”/Packet Too Big 报文，否则返回nil
+func icmpPacketTooBig(packet []byte, mtu int) []byte {
+	version := packetIPVersion(packet)
+	if version == 6 {
+		// IPv6链路MTU不小于1280，更小的隧道MTU下这些包仍然走TLS连接
+		mtu = max(mtu, 1280)
+	}
+	if len(packet) <= mtu {
+		return nil
+	}
+	if version == 4 && packet[6]&0x40 == 0 {
+		return nil
+	}
+	if _, ok := icmpErrorTarget(packet); !ok {
+		return nil
+	}
+	if version == 6 {
+		return buildICMPError(packet, icmpv6PacketTooBig, 0, uint32(mtu))
+	}
+	return buildICMPError(packet, icmpv4DestUnreachable, icmpv4FragNeeded, uint32(mtu))
+}
+
+// inBroadcast 判断IPv4地址是否为网段的广播地址
+func inBroadcast(ip net.IP, network *net.IPNet) bool {
+	ip4 := ip.To4()
+	if ip4 == nil || network == nil || len(network.Mask) != net.IPv4len {
+		return false
+	}
+	for i := range ip4 {
+		if ip4[i]|network.Mask[i] != 0xFF {
+			return false
+		}
+	}
+	return true
+}
+
+// ================ 服务端 ================
+
+// replyUnreachable 目标地址没有在线客户端时向TUN设备写回目标不可达
+func (s *VPNServer) replyUnreachable(packet []byte, destIP net.IP) {
+	if s.tunDevice == nil || inBroadcast(destIP, s.vpnNetwork) {
+		return
+	}
+	host := s.vpnNetwork.Contains(destIP) || (s.vpnNetwork6 != nil && s.vpnNetwork6.Contains(destIP))
+	if msg := icmpUnreachable(s.icmpLimiter, packet, host); msg != nil {
+		if _, err := s.tunDevice.Write(msg); err != nil {
+			log.Printf("写入ICMP差错报文失败: %v", err)
+		}
+	}
+}
+
+// replyTooBig 数据包超过目标会话的隧道MTU且不允许分片时丢弃并向发送方返回“需要分片”，
+// 返回 true 表示数据包已丢弃。src 为发送方会话（客户端互访），为nil时写回TUN设备
+func (s *VPNServer) replyTooBig(target *VPNSession, packet []byte, src *VPNSession) bool {
+	msg := icmpPacketTooBig(packet, target.tunnelMTU(s.config.MTU))
+	if msg == nil {
+		return false
+	}
+	var err error
+	if src != nil {
+		err = s.sendPacket(src, msg)
+	} else if s.tunDevice != nil {
+		_, err = s.tunDevice.Write(msg)
+	}
+	if err != nil {
+		log.Printf("发送ICMP差错报文失败: %v", err)
+	}
+	return true
+}
+
+// ================ 客户端 ================
+
+// replyTooBig 从TUN设备读到的数据包超过隧道MTU且不允许分片时丢弃并写回“需要分片”，
+// 返回 true 表示数据包已丢弃（TUN设备MTU刚被调小时，之前排队的包可能超过新的MTU）
+func (c *VPNClient) replyTooBig(packet []byte) bool {
+	msg := icmpPacketTooBig(packet, c.tunnelMTU())
+	if msg == nil {
+		return false
+	}
+	c.deliverPacket(msg, nil)
+	return true
+}
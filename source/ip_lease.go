@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ================ IP租约 ================
+//
+// 客户端证书（CN或序列号）与隧道地址的绑定保存在租约文件中，客户端重连或服务端重启后
+// 仍分配到相同的地址。租约在客户端连接和断开时续期，有效期从最近一次断开起计算；
+// 静态租约由管理员设置，永不过期。租约中的地址不会分配给其他客户端。
+
+// IPLease 一条IP租约
+type IPLease struct {
+	Key       string    `json:"key"`              // 证书CN或序列号（取决于 lease_key）
+	IP        string    `json:"ip"`               // IPv4隧道地址
+	IP6       string    `json:"ip6,omitempty"`    // IPv6隧道地址（未启用IPv6时为空）
+	Static    bool      `json:"static,omitempty"` // 静态租约，永不过期
+	ExpiresAt time.Time `json:"expires_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// leaseFileContent 租约文件结构
+type leaseFileContent struct {
+	Leases []*IPLease `json:"leases"`
+}
+
+// leaseDB 持久化的IP租约表（并发安全，每次修改后写回文件）
+type leaseDB struct {
+	mu       sync.Mutex
+	path     string
+	duration time.Duration
+	leases   map[string]*IPLease
+	readOnly bool // 已交接给新进程，租约文件由新进程维护，本进程不再写回
+}
+
+// loadLeaseDB 从文件加载租约表（文件不存在时返回空表），已过期的租约被丢弃
+func loadLeaseDB(path string, duration time.Duration) (*leaseDB, error) {
+	db := &leaseDB{
+		path:     path,
+		duration: duration,
+		leases:   make(map[string]*IPLease),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取IP租约文件失败: %v", err)
+	}
+
+	var content leaseFileContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("解析IP租约文件失败: %v", err)
+	}
+	now := time.Now()
+	for _, lease := range content.Leases {
+		if lease == nil || lease.Key == "" || net.ParseIP(lease.IP) == nil {
+			continue
+		}
+		if lease.active(now) {
+			db.leases[lease.Key] = lease
+		}
+	}
+	return db, nil
+}
+
+// active 判断租约是否仍然有效
+func (l *IPLease) active(now time.Time) bool {
+	return l.Static || now.Before(l.ExpiresAt)
+}
+
+// saveLocked 写回租约文件（调用方持有锁）。先写临时文件再替换，避免写入中断损坏租约表
+func (db *leaseDB) saveLocked() error {
+	if db.readOnly {
+		return nil
+	}
+	now := time.Now()
+	content := leaseFileContent{Leases: make([]*IPLease, 0, len(db.leases))}
+	for key, lease := range db.leases {
+		if !lease.active(now) {
+			delete(db.leases, key)
+			continue
+		}
+		content.Leases = append(content.Leases, lease)
+	}
+	sort.Slice(content.Leases, func(i, j int) bool {
+		return content.Leases[i].Key < content.Leases[j].Key
+	})
+
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化IP租约失败: %v", err)
+	}
+	if dir := filepath.Dir(db.path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("创建IP租约目录失败: %v", err)
+		}
+	}
+	tmp := db.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("写入IP租约文件失败: %v", err)
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("写入IP租约文件失败: %v", err)
+	}
+	return nil
+}
+
+// freeze 停止写回租约文件（交接给新进程后调用，避免旧进程用过期的租约表覆盖新进程的修改）
+func (db *leaseDB) freeze() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.readOnly = true
+}
+
+// lookup 返回 key 的有效租约副本（没有时返回nil）
+func (db *leaseDB) lookup(key string) *IPLease {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	lease, ok := db.leases[key]
+	if !ok || !lease.active(time.Now()) {
+		return nil
+	}
+	copied := *lease
+	return &copied
+}
+
+// reservedByOthers 返回判断地址是否在其他客户端有效租约中的函数（基于调用时的租约快照）
+func (db *leaseDB) reservedByOthers(key string) func(net.IP) bool {
+	db.mu.Lock()
+	now := time.Now()
+	reserved := make(map[string]bool, 2*len(db.leases))
+	for k, lease := range db.leases {
+		if k == key || !lease.active(now) {
+			continue
+		}
+		reserved[normalizeIP(lease.IP)] = true
+		if lease.IP6 != "" {
+			reserved[normalizeIP(lease.IP6)] = true
+		}
+	}
+	db.mu.Unlock()
+
+	return func(ip net.IP) bool {
+		return reserved[ip.String()]
+	}
+}
+
+// renew 记录或续期 key 的租约（静态租约的地址由管理员设置，只更新时间戳）
+func (db *leaseDB) renew(key string, ip, ip6 net.IP) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	lease, ok := db.leases[key]
+	if ok && lease.Static {
+		lease.UpdatedAt = now
+		return db.saveLocked()
+	}
+	if !ok {
+		lease = &IPLease{Key: key}
+		db.leases[key] = lease
+	}
+	lease.IP = ip.String()
+	if ip6 != nil {
+		lease.IP6 = ip6.String()
+	}
+	lease.ExpiresAt = now.Add(db.duration)
+	lease.UpdatedAt = now
+	return db.saveLocked()
+}
+
+// list 返回所有有效租约（按 key 排序）
+func (db *leaseDB) list() []IPLease {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	leases := make([]IPLease, 0, len(db.leases))
+	for _, lease := range db.leases {
+		if lease.active(now) {
+			leases = append(leases, *lease)
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Key < leases[j].Key })
+	return leases
+}
+
+// set 新增或替换租约，地址已在其他客户端的有效租约中时返回错误
+func (db *leaseDB) set(lease IPLease) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	for key, other := range db.leases {
+		if key == lease.Key || !other.active(now) {
+			continue
+		}
+		if normalizeIP(other.IP) == normalizeIP(lease.IP) ||
+			(lease.IP6 != "" && normalizeIP(other.IP6) == normalizeIP(lease.IP6)) {
+			return fmt.Errorf("地址已分配给 %s", key)
+		}
+	}
+
+	lease.UpdatedAt = now
+	if !lease.Static {
+		lease.ExpiresAt = now.Add(db.duration)
+	}
+	db.leases[lease.Key] = &lease
+	return db.saveLocked()
+}
+
+// delete 删除租约
+func (db *leaseDB) delete(key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.leases[key]; !ok {
+		return fmt.Errorf("未找到租约: %s", key)
+	}
+	delete(db.leases, key)
+	return db.saveLocked()
+}
+
+// normalizeIP 将地址字符串规范化，便于比较（无效地址原样返回）
+func normalizeIP(s string) string {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String()
+	}
+	return s
+}
+
+// leaseKeyFor 返回客户端证书的租约 key
+func leaseKeyFor(cert *x509.Certificate, keyType string) string {
+	if keyType == LeaseKeySerial {
+		return cert.SerialNumber.Text(16)
+	}
+	return cert.Subject.CommonName
+}
+
+// allocateClientIPs 为客户端分配IPv4（以及 wantIP6 时的IPv6）地址，优先使用租约中的地址，
+// 新分配时跳过其他客户端租约中的地址。owner 表示该会话持有租约（断开时续期）
+func (s *VPNServer) allocateClientIPs(key string, wantIP6 bool) (ip, ip6 net.IP, owner bool) {
+	var lease *IPLease
+	skip := func(net.IP) bool { return false }
+	if key != "" {
+		lease = s.leases.lookup(key)
+		skip = s.leases.reservedByOthers(key)
+	}
+
+	// 租约地址正被同一证书的另一个会话使用时，本会话临时使用其他地址且不修改租约
+	owner = key != ""
+	ip = s.allocateLeasedIP(s.clientIPPool, lease, false, skip, &owner)
+	if ip == nil {
+		return nil, nil, false
+	}
+	if wantIP6 && s.clientIPPool6 != nil {
+		ip6 = s.allocateLeasedIP(s.clientIPPool6, lease, true, skip, &owner)
+		if ip6 == nil {
+			log.Printf("警告：IPv6地址池已满，客户端 %s 仅使用IPv4", key)
+		}
+	}
+
+	if owner {
+		if err := s.leases.renew(key, ip, ip6); err != nil {
+			log.Printf("警告：保存IP租约失败: %v", err)
+		}
+	}
+	return ip, ip6, owner
+}
+
+// allocateLeasedIP 从地址池分配一个地址，优先使用租约中的地址
+func (s *VPNServer) allocateLeasedIP(pool *IPPool, lease *IPLease, v6 bool, skip func(net.IP) bool, owner *bool) net.IP {
+	if lease != nil {
+		leased := lease.IP
+		if v6 {
+			leased = lease.IP6
+		}
+		if ip := net.ParseIP(leased); ip != nil {
+			if pool.AllocateSpecificIP(ip) {
+				return ip
+			}
+			if pool.IsAllocated(ip) {
+				log.Printf("租约地址 %s 正被 %s 的另一个会话使用，临时分配其他地址", ip, lease.Key)
+				*owner = false
+			} else if !pool.Contains(ip) {
+				log.Printf("租约地址 %s 不在当前地址池中，为 %s 重新分配", ip, lease.Key)
+			}
+		}
+	}
+	return pool.AllocateIPExcept(skip)
+}
+
+// renewSessionLease 会话断开时续期其租约，有效期从此刻起计算
+func (s *VPNServer) renewSessionLease(session *VPNSession) {
+	if session.leaseKey == "" {
+		return
+	}
+	if err := s.leases.renew(session.leaseKey, session.IP, session.IP6); err != nil {
+		log.Printf("警告：保存IP租约失败: %v", err)
+	}
+}
+
+// onlineLeaseKeys 返回当前在线会话持有的租约 key
+func (s *VPNServer) onlineLeaseKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, session := range s.sessions.all() {
+		if session.leaseKey != "" {
+			keys[session.leaseKey] = true
+		}
+	}
+	return keys
+}
@@ -5,7 +5,7 @@ import (
 	"sync"
 )
 
-// IPPool IP地址池
+// IPPool IP地址池（支持IPv4和IPv6，地址为网络地址加上索引）
 type IPPool struct {
 	network    *net.IPNet
 	allocated  map[string]bool
@@ -39,28 +39,76 @@ func NewIPPool(network *net.IPNet, config *VPNConfig) *IPPool {
 
 // AllocateIP 分配IP地址 - O(1)操作
 func (p *IPPool) AllocateIP() net.IP {
+	return p.AllocateIPExcept(nil)
+}
+
+// AllocateIPExcept 分配第一个未被 skip 排除的空闲地址（用于跳过其他客户端租约中的地址），
+// skip 为nil时从队列头取出，为O(1)操作
+func (p *IPPool) AllocateIPExcept(skip func(net.IP) bool) net.IP {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	if len(p.freeList) == 0 {
-		return nil
+	for i, index := range p.freeList {
+		allocatedIP := ipAdd(p.network.IP, index)
+		if !p.network.Contains(allocatedIP) {
+			// 索引超出网段范围（配置错误）
+			continue
+		}
+		if skip != nil && skip(allocatedIP) {
+			continue
+		}
+		if i == 0 {
+			p.freeList = p.freeList[1:]
+		} else {
+			p.freeList = append(p.freeList[:i], p.freeList[i+1:]...)
+		}
+		p.markAllocated(allocatedIP, index)
+		return allocatedIP
 	}
+	return nil
+}
 
-	// 从队列头取出空闲IP索引
-	index := p.freeList[0]
-	p.freeList = p.freeList[1:]
+// AllocateSpecificIP 分配指定的地址（用于租约），地址不在池中或已被占用时返回 false
+func (p *IPPool) AllocateSpecificIP(ip net.IP) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
-	ip := p.network.IP.To4()
-	if ip == nil {
-		return nil
+	for i, index := range p.freeList {
+		candidate := ipAdd(p.network.IP, index)
+		if candidate.Equal(ip) {
+			p.freeList = append(p.freeList[:i], p.freeList[i+1:]...)
+			p.markAllocated(candidate, index)
+			return true
+		}
 	}
+	return false
+}
 
-	allocatedIP := net.IPv4(ip[0], ip[1], ip[2], byte(index))
-	ipStr := allocatedIP.String()
+// IsAllocated 判断地址是否已被分配
+func (p *IPPool) IsAllocated(ip net.IP) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.allocated[ip.String()]
+}
+
+// Contains 判断地址是否属于地址池的分配范围
+func (p *IPPool) Contains(ip net.IP) bool {
+	if !p.network.Contains(ip) {
+		return false
+	}
+	for i := p.startIndex; i <= p.endIndex; i++ {
+		if ipAdd(p.network.IP, i).Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// markAllocated 标记地址已分配（调用方持有锁）
+func (p *IPPool) markAllocated(ip net.IP, index int) {
+	ipStr := ip.String()
 	p.allocated[ipStr] = true
 	p.ipToIndex[ipStr] = index
-
-	return allocatedIP
 }
 
 // ReleaseIP 释放IP地址 - O(1)操作
@@ -82,3 +130,18 @@ func (p *IPPool) ReleaseIP(ip net.IP) {
 	}
 }
 
+// ipAdd 返回 base 加上偏移量 n 后的地址（IPv4返回16字节形式）
+func ipAdd(base net.IP, n int) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	if v4 := base.To4(); v4 != nil {
+		copy(ip, net.IPv4(v4[0], v4[1], v4[2], v4[3]))
+	} else {
+		copy(ip, base.To16())
+	}
+	for i := len(ip) - 1; i >= 0 && n > 0; i-- {
+		sum := int(ip[i]) + n
+		ip[i] = byte(sum)
+		n = sum >> 8
+	}
+	return ip
+}
@@ -3,32 +3,36 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
 	"os/exec"
 )
 
-// SetupNAT 配置NAT并跟踪规则（VPNServer方法）
+// SetupNAT 配置NAT并跟踪规则（VPNServer方法，IPv6网段使用ip6tables）
 func (s *VPNServer) SetupNAT(vpnNetwork string, outInterface string) error {
+	ipv6 := isIPv6CIDR(vpnNetwork)
+	tool := iptablesCommand(ipv6)
 	args := []string{"-s", vpnNetwork, "-o", outInterface, "-j", "MASQUERADE"}
 
 	// 检查规则是否已存在
 	checkArgs := append([]string{"-t", "nat", "-C", "POSTROUTING"}, args...)
-	if runCmdSilent("iptables", checkArgs...) == nil {
+	if runCmdSilent(tool, checkArgs...) == nil {
 		log.Println("NAT规则已存在，跳过添加")
 		return nil
 	}
 
 	// 添加规则
 	addArgs := append([]string{"-t", "nat", "-A", "POSTROUTING"}, args...)
-	output, err := runCmdCombined("iptables", addArgs...)
+	output, err := runCmdCombined(tool, addArgs...)
 	if err != nil {
 		return fmt.Errorf("添加NAT规则失败: %v, 输出: %s", err, string(output))
 	}
 
 	// 记录规则以便后续清理
-	s.natRules = append(s.natRules, NATRule{
+	s.recordNATRule(NATRule{
 		Table: "nat",
 		Chain: "POSTROUTING",
 		Args:  args,
+		IPv6:  ipv6,
 	})
 
 	log.Printf("已配置NAT: %s -> %s", vpnNetwork, outInterface)
@@ -38,71 +42,128 @@ func (s *VPNServer) SetupNAT(vpnNetwork string, outInterface string) error {
 // setupServerNAT 配置服务器NAT（辅助函数）
 func setupServerNAT(server *VPNServer, config VPNConfig) error {
 	// 确定NAT出口接口
-	natIface := config.NATInterface
-	if natIface == "" {
-		// 自动检测默认出口接口
-		cmd := exec.Command("ip", "route", "show", "default")
-		output, err := cmd.Output()
+	natIface, err := detectNATInterface(config.NATInterface, false)
+	if err != nil {
+		return err
+	}
+
+	// 配置NAT
+	if err := server.SetupNAT(config.Network, natIface); err != nil {
+		return fmt.Errorf("配置NAT失败: %v", err)
+	}
+	server.addForwardRules(natIface, false)
+
+	// IPv6隧道网段（出口没有IPv6时仅告警，不影响IPv4）
+	if config.Network6 != "" {
+		natIface6, err := detectNATInterface(config.NATInterface, true)
 		if err != nil {
-			return fmt.Errorf("检测默认出口接口失败: %v", err)
+			log.Printf("警告：未配置IPv6 NAT: %v", err)
+			return nil
 		}
-
-		// 解析输出
-		lines := string(output)
-		parts := splitBySpace(lines)
-		for i := 0; i < len(parts); i++ {
-			if parts[i] == "dev" && i+1 < len(parts) {
-				natIface = parts[i+1]
-				break
-			}
+		if err := server.SetupNAT(config.Network6, natIface6); err != nil {
+			log.Printf("警告：配置IPv6 NAT失败: %v", err)
+			return nil
 		}
+		server.addForwardRules(natIface6, true)
+	}
+
+	return nil
+}
+
+// detectNATInterface 确定NAT出口接口（未配置时按默认路由自动检测）
+func detectNATInterface(natIface string, ipv6 bool) (string, error) {
+	if natIface != "" {
+		return natIface, nil
+	}
 
-		if natIface == "" {
-			return fmt.Errorf("无法自动检测出口接口")
+	// 自动检测默认出口接口
+	args := []string{"route", "show", "default"}
+	if ipv6 {
+		args = append([]string{"-6"}, args...)
+	}
+	cmd := exec.Command("ip", args...)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("检测默认出口接口失败: %v", err)
+	}
+
+	// 解析输出
+	lines := string(output)
+	parts := splitBySpace(lines)
+	for i := 0; i < len(parts); i++ {
+		if parts[i] == "dev" && i+1 < len(parts) {
+			natIface = parts[i+1]
+			break
 		}
-		log.Printf("自动检测到NAT出口接口: %s", natIface)
 	}
 
-	// 配置NAT
-	if err := server.SetupNAT(config.Network, natIface); err != nil {
-		return fmt.Errorf("配置NAT失败: %v", err)
+	if natIface == "" {
+		return "", fmt.Errorf("无法自动检测出口接口")
 	}
+	log.Printf("自动检测到NAT出口接口: %s", natIface)
+	return natIface, nil
+}
+
+// addForwardRules 添加TUN设备与出口接口之间的FORWARD规则
+func (s *VPNServer) addForwardRules(natIface string, ipv6 bool) {
+	tool := iptablesCommand(ipv6)
 
 	// 获取实际的TUN设备名称
-	tunDeviceName := server.tunDevice.Name()
+	tunDeviceName := s.tunDevice.Name()
 
 	// 添加FORWARD规则
 	// 允许 tun -> natIface 的转发
 	forwardArgs1 := []string{"-A", "FORWARD", "-i", tunDeviceName, "-o", natIface, "-j", "ACCEPT"}
-	cmd := exec.Command("iptables", forwardArgs1...)
+	cmd := exec.Command(tool, forwardArgs1...)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		log.Printf("警告：添加FORWARD规则失败: %v, 输出: %s", err, string(output))
 	} else {
 		log.Printf("已添加FORWARD规则: %s -> %s", tunDeviceName, natIface)
 		// 记录规则以便清理
-		server.natRules = append(server.natRules, NATRule{
+		s.recordNATRule(NATRule{
 			Table: "filter",
 			Chain: "FORWARD",
 			Args:  []string{"-i", tunDeviceName, "-o", natIface, "-j", "ACCEPT"},
+			IPv6:  ipv6,
 		})
 	}
 
 	// 允许 natIface -> tun 的已建立连接
 	forwardArgs2 := []string{"-A", "FORWARD", "-i", natIface, "-o", tunDeviceName, "-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
-	cmd = exec.Command("iptables", forwardArgs2...)
+	cmd = exec.Command(tool, forwardArgs2...)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		log.Printf("警告：添加FORWARD规则失败: %v, 输出: %s", err, string(output))
 	} else {
 		log.Printf("已添加FORWARD规则: %s -> %s (RELATED,ESTABLISHED)", natIface, tunDeviceName)
 		// 记录规则以便清理
-		server.natRules = append(server.natRules, NATRule{
+		s.recordNATRule(NATRule{
 			Table: "filter",
 			Chain: "FORWARD",
 			Args:  []string{"-i", natIface, "-o", tunDeviceName, "-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+			IPv6:  ipv6,
 		})
 	}
+}
 
-	return nil
+// recordNATRule 记录已添加的规则，服务器停止时由 cleanupNATRules 删除
+func (s *VPNServer) recordNATRule(rule NATRule) {
+	s.natMutex.Lock()
+	s.natRules = append(s.natRules, rule)
+	s.natMutex.Unlock()
+}
+
+// iptablesCommand 返回对应地址族的iptables命令
+func iptablesCommand(ipv6 bool) string {
+	if ipv6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// isIPv6CIDR 判断CIDR是否为IPv6网段
+func isIPv6CIDR(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	return err == nil && ip.To4() == nil
 }
 
 // NATRule NAT规则记录
@@ -110,4 +171,5 @@ type NATRule struct {
 	Table string   // "nat"
 	Chain string   // "POSTROUTING"
 	Args  []string // 规则参数
+	IPv6  bool     // 是否为ip6tables规则
 }
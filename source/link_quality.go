@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// ================ 链路质量测量 ================
+//
+// 双方都启用 heartbeat-rtt 特性时，TLS连接上的心跳携带序列号和时间戳，两端各自定期发送探测，
+// 对端原样回显（只修改 Kind），发送方据此计算往返时延、抖动和心跳丢失率：
+//   - 往返时延：平滑值（RFC 6298，权重 1/8）
+//   - 抖动：相邻两次往返时延之差的平滑平均（RFC 3550，权重 1/16）
+//   - 丢失率：最近 linkQualityWindow 次探测中超过 heartbeatLossTimeout 仍未收到回显的比例
+//
+// 心跳负载: Kind(1) + ID(4) + Timestamp(8, 发送方的 UnixNano)。
+// 旧版对端发送的心跳没有负载，按原来的方式回复空心跳；回显与本端记录的时间戳不一致时丢弃
+// （如重连前发出的探测）。
+
+const (
+	heartbeatKindProbe   = 0 // 探测，对端需要回显
+	heartbeatKindEcho    = 1 // 回显
+	heartbeatPayloadSize = 13
+
+	heartbeatInterval    = 10 * time.Second // 发送探测的间隔（同时用作保活心跳）
+	heartbeatLossTimeout = heartbeatInterval
+	linkQualityWindow    = 30 // 计算丢失率的探测窗口（约5分钟）
+)
+
+// encodeHeartbeat 编码心跳负载
+func encodeHeartbeat(kind byte, id uint32, timestamp int64) []byte {
+	payload := make([]byte, heartbeatPayloadSize)
+	payload[0] = kind
+	binary.BigEndian.PutUint32(payload[1:5], id)
+	binary.BigEndian.PutUint64(payload[5:13], uint64(timestamp))
+	return payload
+}
+
+// parseHeartbeat 解析心跳负载，旧版对端的空心跳返回 ok=false
+func parseHeartbeat(payload []byte) (kind byte, id uint32, timestamp int64, ok bool) {
+	if len(payload) < heartbeatPayloadSize {
+		return 0, 0, 0, false
+	}
+	return payload[0], binary.BigEndian.Uint32(payload[1:5]), int64(binary.BigEndian.Uint64(payload[5:13])), true
+}
+
+// heartbeatEcho 返回探测的回显负载（复制，原负载可能引用接收缓冲区）
+func heartbeatEcho(probe []byte) []byte {
+	echo := append([]byte(nil), probe[:heartbeatPayloadSize]...)
+	echo[0] = heartbeatKindEcho
+	return echo
+}
+
+// heartbeatProbe 一次已发送的探测
+type heartbeatProbe struct {
+	id     uint32
+	sentAt time.Time
+	acked  bool
+}
+
+// linkQuality 一端发出的探测及测量结果（并发安全）
+type linkQuality struct {
+	mu      sync.Mutex
+	nextID  uint32
+	probes  [linkQualityWindow]heartbeatProbe
+	lastRTT time.Duration
+	srtt    time.Duration
+	jitter  time.Duration
+	sent    uint64
+}
+
+// linkQualityStats 链路质量测量结果（sent 为0表示对端不支持或尚未测量）
+type linkQualityStats struct {
+	rtt    time.Duration
+	jitter time.Duration
+	loss   float64 // 百分比
+	sent   uint64
+}
+
+// reset 清空测量结果（客户端重连时调用）
+func (q *linkQuality) reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.probes = [linkQualityWindow]heartbeatProbe{}
+	q.lastRTT, q.srtt, q.jitter, q.sent = 0, 0, 0, 0
+}
+
+// probe 记录一次新的探测并返回其负载
+func (q *linkQuality) probe() []byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.nextID++
+	now := time.Now()
+	q.probes[q.nextID%linkQualityWindow] = heartbeatProbe{id: q.nextID, sentAt: now}
+	q.sent++
+	return encodeHeartbeat(heartbeatKindProbe, q.nextID, now.UnixNano())
+}
+
+// ack 处理回显，更新往返时延和抖动
+func (q *linkQuality) ack(id uint32, timestamp int64) {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	p := &q.probes[id%linkQualityWindow]
+	if p.id != id || p.acked || p.sentAt.UnixNano() != timestamp {
+		return
+	}
+	p.acked = true
+
+	rtt := now.Sub(p.sentAt)
+	if q.srtt == 0 {
+		q.srtt = rtt
+	} else {
+		q.srtt += (rtt - q.srtt) / 8
+		diff := rtt - q.lastRTT
+		if diff < 0 {
+			diff = -diff
+		}
+		q.jitter += (diff - q.jitter) / 16
+	}
+	q.lastRTT = rtt
+}
+
+// stats 返回当前的测量结果
+func (q *linkQuality) stats() linkQualityStats {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due, lost int
+	for _, p := range q.probes {
+		if p.id == 0 || now.Sub(p.sentAt) < heartbeatLossTimeout {
+			continue
+		}
+		due++
+		if !p.acked {
+			lost++
+		}
+	}
+	stats := linkQualityStats{rtt: q.srtt, jitter: q.jitter, sent: q.sent}
+	if due > 0 {
+		stats.loss = float64(lost) * 100 / float64(due)
+	}
+	return stats
+}
+
+// ================ 服务端 ================
+
+// handleHeartbeat 处理客户端的心跳：回显探测、记录回显，旧版客户端的空心跳回复空心跳
+func (s *VPNServer) handleHeartbeat(session *VPNSession, link *sessionLink, payload []byte) error {
+	kind, id, timestamp, ok := parseHeartbeat(payload)
+	switch {
+	case !ok:
+		return s.sendHeartbeat(session, link, []byte{})
+	case kind == heartbeatKindProbe:
+		return s.sendHeartbeat(session, link, heartbeatEcho(payload))
+	case kind == heartbeatKindEcho:
+		session.quality.ack(id, timestamp)
+	}
+	return nil
+}
+
+// heartbeatLoop 定期在支持测量的会话的每条成员连接上发送探测
+func (s *VPNServer) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, session := range s.sessions.all() {
+				if !session.measureRTT || session.IsClosed() {
+					continue
+				}
+				for _, link := range session.getLinks() {
+					// 发送失败说明连接正在关闭，由读协程处理
+					_ = s.sendHeartbeat(session, link, session.quality.probe())
+				}
+			}
+		}
+	}
+}
+
+// ================ 客户端 ================
+
+// handleHeartbeat 处理服务端的心跳：回显探测、记录回显（旧版服务端的空心跳忽略）
+func (c *VPNClient) handleHeartbeat(link *clientLink, payload []byte) error {
+	kind, id, timestamp, ok := parseHeartbeat(payload)
+	switch {
+	case !ok:
+	case kind == heartbeatKindProbe:
+		return link.writeHeartbeat(heartbeatEcho(payload))
+	case kind == heartbeatKindEcho:
+		c.quality.ack(id, timestamp)
+	}
+	return nil
+}
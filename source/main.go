@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"time"
 )
@@ -30,6 +31,11 @@ func main() {
 			showStatus()
 			return
 
+		case "--upgrade":
+			// 无中断重启（新进程接管监听套接字和TUN设备）
+			upgradeService()
+			return
+
 		default:
 			fmt.Printf("未知参数: %s\n", os.Args[1])
 			fmt.Println("使用 --help 查看帮助")
@@ -51,6 +57,7 @@ func printHelp() {
 	fmt.Println("  ./tls-vpn --service    仅启动后台服务（无界面）")
 	fmt.Println("  ./tls-vpn --status     查看服务状态")
 	fmt.Println("  ./tls-vpn --stop       停止后台服务")
+	fmt.Println("  ./tls-vpn --upgrade    无中断重启后台服务（替换程序文件后执行）")
 	fmt.Println()
 	fmt.Println("工作方式:")
 	fmt.Println("  1. 运行 ./tls-vpn 会自动在后台启动服务")
@@ -151,10 +158,16 @@ func runServiceDaemon() {
 	// 创建服务
 	service := NewVPNService()
 
-	// 创建控制服务器
+	// 创建控制服务器（无中断重启时从旧进程接管控制套接字和VPN服务器）
 	controlServer := NewControlServer(service)
-	if err := controlServer.Start(); err != nil {
-		log.Fatalf("启动控制服务器失败: %v", err)
+	resumed, err := resumeHandoff(service, controlServer)
+	if err != nil {
+		log.Fatalf("接管旧进程失败: %v", err)
+	}
+	if !resumed {
+		if err := controlServer.Start(); err != nil {
+			log.Fatalf("启动控制服务器失败: %v", err)
+		}
 	}
 
 	// 只在终端模式下打印（daemon 模式下不会有终端）
@@ -205,6 +218,27 @@ func stopService() {
 	}
 }
 
+// upgradeService 无中断重启服务
+func upgradeService() {
+	client := NewControlClient()
+	if !client.IsServiceRunning() {
+		fmt.Println("服务未运行")
+		return
+	}
+
+	fmt.Println("正在启动新进程接管服务...")
+	resp, err := client.ServerUpgrade()
+	if err != nil {
+		fmt.Printf("重启失败: %v\n", err)
+		return
+	}
+	if resp.Success {
+		fmt.Println(resp.Message)
+	} else {
+		fmt.Printf("重启失败: %s\n", resp.Error)
+	}
+}
+
 // showStatus 显示服务状态
 func showStatus() {
 	client := NewControlClient()
@@ -223,6 +257,9 @@ func showStatus() {
 		if serverStatus.Running {
 			fmt.Printf("VPN服务端: 运行中 (端口: %d, 客户端: %d)\n",
 				serverStatus.Port, serverStatus.ClientCount)
+			if serverStatus.Draining {
+				fmt.Println("  正在排空会话，不再接受新连接")
+			}
 			fmt.Printf("  TUN设备: %s\n", serverStatus.TUNDevice)
 			fmt.Printf("  网段: %s\n", serverStatus.Network)
 			fmt.Printf("  流量: ↑%s ↓%s\n",
@@ -236,7 +273,7 @@ func showStatus() {
 	if err == nil {
 		if clientStatus.Connected {
 			fmt.Printf("VPN客户端: 已连接 (IP: %s)\n", clientStatus.AssignedIP)
-			fmt.Printf("  服务器: %s:%d\n", clientStatus.ServerAddress, clientStatus.ServerPort)
+			fmt.Printf("  服务器: %s\n", net.JoinHostPort(clientStatus.ServerAddress, fmt.Sprintf("%d", clientStatus.ServerPort)))
 		} else {
 			fmt.Println("VPN客户端: 未连接")
 		}
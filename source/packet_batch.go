@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ================ 数据包批量传输 ================
+//
+// MessageTypeBatch 的负载由多个条目顺序拼接而成，每个条目格式为:
+//   Length(2, 大端) + IP包
+// Length 的最高位表示该包已压缩（见 compression.go），因此单个条目最大 32767 字节。
+// 接收端按顺序拆分并逐个处理，保证包序与发送端一致。
+
+const (
+	batchEntryHeaderSize = 2
+	batchLengthMask      = 0x7FFF
+	batchCompressedFlag  = 0x8000
+)
+
+// batchSendFunc 批次发送回调：msgType 为 MessageTypeData/MessageTypeCompressedData（仅一个包）
+// 或 MessageTypeBatch，packetBytes 为批次中IP包压缩前的总字节数，用于流量统计。
+// payload 位于池化缓冲区 buf 中，回调获得 buf 的所有权，发送完成（或丢弃）后需调用 buf.release()
+type batchSendFunc func(msgType MessageType, payload []byte, packetBytes uint64, buf *packetBuffer) error
+
+// packetBatcher 将短时间窗口内的多个IP包合并为一个批量消息
+type packetBatcher struct {
+	mu          sync.Mutex
+	buf         *packetBuffer // 当前批次的缓冲区（从池中获取，发送时转交给回调）
+	count       int
+	compressed  bool // 第一个条目是否已压缩（批次只有一个包时使用）
+	packetBytes uint64
+	maxBytes    int
+	delay       time.Duration
+	timer       *time.Timer
+	send        batchSendFunc
+	stopped     bool
+}
+
+// newPacketBatcher 创建批量发送器
+func newPacketBatcher(delay time.Duration, maxBytes int, send batchSendFunc) *packetBatcher {
+	return &packetBatcher{
+		maxBytes: maxBytes,
+		delay:    delay,
+		send:     send,
+	}
+}
+
+// Add 添加一个IP包，达到大小预算时立即发送，否则等待窗口到期。
+// compressed 表示 packet 已压缩，packetLen 为压缩前的长度
+func (b *packetBatcher) Add(packet []byte, compressed bool, packetLen int) error {
+	if len(packet) > batchLengthMask {
+		return fmt.Errorf("数据包过大，无法批量发送: %d 字节", len(packet))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stopped {
+		return fmt.Errorf("批量发送器已停止")
+	}
+
+	// 放不下时先发送已有的批次
+	if b.count > 0 && len(b.buf.b)+batchEntryHeaderSize+len(packet) > b.maxBytes {
+		if err := b.flushLocked(); err != nil {
+			return err
+		}
+	}
+	if b.buf == nil {
+		b.buf = getPacketBuffer()
+	}
+
+	length := uint16(len(packet))
+	if compressed {
+		length |= batchCompressedFlag
+	}
+	var hdr [batchEntryHeaderSize]byte
+	binary.BigEndian.PutUint16(hdr[:], length)
+	b.buf.b = append(b.buf.b, hdr[:]...)
+	b.buf.b = append(b.buf.b, packet...)
+	if b.count == 0 {
+		b.compressed = compressed
+	}
+	b.count++
+	b.packetBytes += uint64(packetLen)
+
+	if b.count == 1 && b.delay > 0 {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.delay, b.timerFlush)
+		} else {
+			b.timer.Reset(b.delay)
+		}
+	}
+
+	// 窗口为0或已达到预算时立即发送
+	if b.delay <= 0 || len(b.buf.b)+batchEntryHeaderSize >= b.maxBytes {
+		return b.flushLocked()
+	}
+	return nil
+}
+
+// Flush 立即发送当前批次
+func (b *packetBatcher) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flushLocked()
+}
+
+// Stop 停止批量发送器并丢弃未发送的包（连接关闭时调用）
+func (b *packetBatcher) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.buf.release()
+	b.buf = nil
+	b.count = 0
+	b.packetBytes = 0
+}
+
+func (b *packetBatcher) timerFlush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return
+	}
+	if err := b.flushLocked(); err != nil {
+		log.Printf("批量发送数据包失败: %v", err)
+	}
+}
+
+// flushLocked 发送当前批次（调用方持有锁，保证批次之间的顺序）
+func (b *packetBatcher) flushLocked() error {
+	if b.count == 0 {
+		return nil
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+
+	msgType := MessageTypeBatch
+	buf := b.buf
+	payload := buf.b
+	if b.count == 1 {
+		// 只有一个包时不需要批量封装
+		msgType = MessageTypeData
+		if b.compressed {
+			msgType = MessageTypeCompressedData
+		}
+		payload = buf.b[batchEntryHeaderSize:]
+	}
+	packetBytes := b.packetBytes
+
+	// 缓冲区转交给回调（可能异步写入），下一个批次重新从池中获取
+	b.buf = nil
+	b.count = 0
+	b.packetBytes = 0
+
+	return b.send(msgType, payload, packetBytes, buf)
+}
+
+// splitBatch 按顺序拆分批量消息，对每个条目调用 fn（compressed 表示条目已压缩）
+func splitBatch(payload []byte, fn func(packet []byte, compressed bool) error) error {
+	for len(payload) > 0 {
+		if len(payload) < batchEntryHeaderSize {
+			return fmt.Errorf("批量消息条目头不完整")
+		}
+		length := binary.BigEndian.Uint16(payload[:batchEntryHeaderSize])
+		compressed := length&batchCompressedFlag != 0
+		end := batchEntryHeaderSize + int(length&batchLengthMask)
+		if end > len(payload) {
+			return fmt.Errorf("批量消息条目长度越界: %d", length&batchLengthMask)
+		}
+		if err := fn(payload[batchEntryHeaderSize:end], compressed); err != nil {
+			return err
+		}
+		payload = payload[end:]
+	}
+	return nil
+}
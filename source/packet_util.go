@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/binary"
+	"net"
+	"net/netip"
+)
+
+// ================ IP包解析 ================
+
+// packetIPVersion 返回IP包的版本（4或6），无法识别时返回0
+func packetIPVersion(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) >= 20 {
+			return 4
+		}
+	case 6:
+		if len(packet) >= 40 {
+			return 6
+		}
+	}
+	return 0
+}
+
+// ipv6UpperLayer 沿IPv6扩展头链找到上层协议，返回协议号和上层头部（非首个分片没有上层头部，返回nil）。
+// 扩展头链被截断时 ok 为 false，此时 proto 为最后一个能识别的下一个头部类型
+func ipv6UpperLayer(packet []byte) (proto byte, l4 []byte, ok bool) {
+	proto = packet[6]
+	off := 40
+	for {
+		var size int
+		switch proto {
+		case 0, 43, 60, 135, 139, 140, 253, 254: // 逐跳选项、路由、目的选项、移动性、HIP、Shim6、实验用
+			if len(packet) < off+2 {
+				return proto, nil, false
+			}
+			size = (int(packet[off+1]) + 1) * 8
+		case 44: // 分片：只有首个分片带有上层头部
+			if len(packet) < off+8 {
+				return proto, nil, false
+			}
+			if binary.BigEndian.Uint16(packet[off+2:off+4])&0xFFF8 != 0 {
+				return packet[off], nil, true
+			}
+			size = 8
+		case 51: // 认证头（长度以4字节为单位）
+			if len(packet) < off+2 {
+				return proto, nil, false
+			}
+			size = (int(packet[off+1]) + 2) * 4
+		default:
+			return proto, packet[off:], true
+		}
+		if len(packet) < off+size {
+			return proto, nil, false
+		}
+		proto = packet[off]
+		off += size
+	}
+}
+
+// packetSrcIP 提取IP包的源地址，无法解析时返回nil
+func packetSrcIP(packet []byte) net.IP {
+	switch packetIPVersion(packet) {
+	case 4:
+		return net.IP(packet[12:16])
+	case 6:
+		return net.IP(packet[8:24])
+	}
+	return nil
+}
+
+// packetDstIP 提取IP包的目标地址，无法解析时返回nil
+func packetDstIP(packet []byte) net.IP {
+	switch packetIPVersion(packet) {
+	case 4:
+		return net.IP(packet[16:20])
+	case 6:
+		return net.IP(packet[24:40])
+	}
+	return nil
+}
+
+// ipKey 将IP转换为可比较的映射键（IPv4统一为4字节形式，不分配内存）
+func ipKey(ip net.IP) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
+
+// ipString 返回IP的字符串形式（nil返回空字符串）
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// certGroup 从客户端证书中提取分组（取第一个OU）
+func certGroup(cert *x509.Certificate) string {
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		return cert.Subject.OrganizationalUnit[0]
+	}
+	return ""
+}
+
+// flowHash 计算IP包所属流的哈希（源/目标地址、协议和TCP/UDP端口），同一个流的包哈希相同。
+// 分片包和无法解析的包只按地址和协议计算；无法识别的包返回0
+func flowHash(packet []byte) uint32 {
+	const (
+		fnvOffset = 2166136261
+		fnvPrime  = 16777619
+	)
+	var addrs []byte
+	var proto byte
+	var l4 []byte
+	switch packetIPVersion(packet) {
+	case 4:
+		ihl := int(packet[0]&0x0F) * 4
+		addrs = packet[12:20]
+		proto = packet[9]
+		// 只有首个分片（且未分片）才带有端口
+		if fragment := packet[6:8]; fragment[0]&0x3F == 0 && fragment[1] == 0 && ihl >= 20 && len(packet) >= ihl+4 {
+			l4 = packet[ihl : ihl+4]
+		}
+	case 6:
+		addrs = packet[8:40]
+		proto = packet[6]
+		if len(packet) >= 44 {
+			l4 = packet[40:44]
+		}
+	default:
+		return 0
+	}
+	if proto != 6 && proto != 17 {
+		l4 = nil
+	}
+
+	h := uint32(fnvOffset)
+	for _, b := range addrs {
+		h = (h ^ uint32(b)) * fnvPrime
+	}
+	h = (h ^ uint32(proto)) * fnvPrime
+	for _, b := range l4 {
+		h = (h ^ uint32(b)) * fnvPrime
+	}
+	return h
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ================ 路径MTU与TCP MSS钳制 ================
+//
+// 数据包走UDP数据通道时外层还要加上 IP/UDP 头和数据报封装，超过路径MTU的数据报会被分片，
+// 或在丢弃分片/ICMP的链路上（如 PPPoE）直接消失。客户端在UDP数据通道上发送带DF标志、
+// 填充到指定大小的探测数据报，服务端回应同样大小的确认（同时验证回程路径），二分查找得到
+// 双向都能通过的最大内层包长度，据此调整客户端TUN设备的MTU并通知服务端。超过该长度的包
+// 两端都改走TLS连接。
+//
+// 此外两端改写经过隧道的TCP SYN包中的MSS选项，使TCP连接（包括隧道两侧局域网主机的连接）
+// 从一开始就使用不超过隧道MTU的分段。
+//
+// 探测消息（MessageTypeMTUProbe）负载: Kind(1) + Size(2) + 填充，探测和确认填充到 Size 字节，
+// 与承载 Size 字节IP包的数据报大小相同。
+
+const (
+	pmtuKindProbe  = 0 // 客户端探测
+	pmtuKindAck    = 1 // 服务端确认
+	pmtuKindReport = 2 // 客户端通知探测结果
+	pmtuHeaderSize = 3
+
+	pmtuMinMTU        = 1280 // 探测下限，也是探测完成前UDP通道允许的最大包长
+	pmtuSearchStep    = 8    // 二分查找的精度（字节）
+	pmtuProbeAttempts = 2    // 每个大小的探测次数
+	pmtuReportRepeat  = 3    // 探测结果的发送次数（UDP数据报可能丢失）
+	pmtuProbeTimeout  = 500 * time.Millisecond
+	pmtuProbeInterval = 10 * time.Minute // 重新探测的间隔（路径可能变化）
+)
+
+// ================ MSS钳制 ================
+
+// tcpMSSForMTU 返回隧道MTU下TCP分段的最大负载长度（减去IP和TCP头部）
+func tcpMSSForMTU(mtu, ipVersion int) int {
+	if ipVersion == 6 {
+		return mtu - 60
+	}
+	return mtu - 40
+}
+
+// clampTCPMSS 将TCP SYN包中超过隧道MTU允许值的MSS选项改小，并增量更新TCP校验和。
+// 只处理未分片的IPv4包和没有扩展头的IPv6包，返回是否改写了数据包
+func clampTCPMSS(packet []byte, mtu int) bool {
+	var tcp []byte
+	version := packetIPVersion(packet)
+	switch version {
+	case 4:
+		ihl := int(packet[0]&0x0F) * 4
+		if packet[9] != 6 || ihl < 20 || packet[6]&0x3F != 0 || packet[7] != 0 || len(packet) < ihl+20 {
+			return false
+		}
+		tcp = packet[ihl:]
+	case 6:
+		if packet[6] != 6 || len(packet) < 60 {
+			return false
+		}
+		tcp = packet[40:]
+	default:
+		return false
+	}
+
+	// 只有 SYN（包括 SYN-ACK）携带MSS选项
+	if tcp[13]&0x02 == 0 {
+		return false
+	}
+	dataOffset := int(tcp[12]>>4) * 4
+	if dataOffset < 20 || len(tcp) < dataOffset {
+		return false
+	}
+
+	mss := tcpMSSForMTU(mtu, version)
+	options := tcp[20:dataOffset]
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case 0: // 选项列表结束
+			return false
+		case 1: // NOP
+			i++
+			continue
+		}
+		if i+1 >= len(options) {
+			return false
+		}
+		length := int(options[i+1])
+		if length < 2 || i+length > len(options) {
+			return false
+		}
+		if options[i] == 2 && length == 4 {
+			old := binary.BigEndian.Uint16(options[i+2:])
+			if int(old) <= mss {
+				return false
+			}
+			binary.BigEndian.PutUint16(options[i+2:], uint16(mss))
+			updateChecksum16(tcp[16:18], old, uint16(mss))
+			return true
+		}
+		i += length
+	}
+	return false
+}
+
+// updateChecksum16 一个16位字段从 old 改为 new 后增量更新校验和（RFC 1624）
+func updateChecksum16(checksum []byte, old, new uint16) {
+	sum := uint32(^binary.BigEndian.Uint16(checksum)) + uint32(^old) + uint32(new)
+	sum = (sum & 0xFFFF) + (sum >> 16)
+	sum = (sum & 0xFFFF) + (sum >> 16)
+	binary.BigEndian.PutUint16(checksum, ^uint16(sum))
+}
+
+// ================ 服务端 ================
+
+// tunnelMTU 返回会话的隧道MTU（客户端报告了路径MTU时取该值）
+func (s *VPNSession) tunnelMTU(base int) int {
+	if mtu := int(atomic.LoadInt32(&s.pathMTU)); mtu > 0 && mtu < base {
+		return mtu
+	}
+	return base
+}
+
+// udpFits 判断长度为 n 的IP包能否走UDP数据通道（客户端未报告路径MTU时不限制，兼容旧版客户端）
+func (s *VPNSession) udpFits(n int) bool {
+	mtu := int(atomic.LoadInt32(&s.pathMTU))
+	return mtu == 0 || n <= mtu
+}
+
+// handleMTUProbe 处理客户端的路径MTU探测和探测结果。payload 引用接收缓冲区，可以原地修改
+func (s *VPNServer) handleMTUProbe(session *VPNSession, addr *net.UDPAddr, payload, scratch []byte) {
+	if len(payload) < pmtuHeaderSize {
+		return
+	}
+	size := int(binary.BigEndian.Uint16(payload[1:3]))
+
+	switch payload[0] {
+	case pmtuKindProbe:
+		// 确认填充到与探测相同的大小，回程路径不通时客户端同样收不到确认
+		payload[0] = pmtuKindAck
+		datagram := session.udp.sealTo(scratch[:0], MessageTypeMTUProbe, payload)
+		if _, err := s.udpConn.WriteToUDP(datagram, addr); err != nil {
+			log.Printf("会话 %s 回应路径MTU探测失败: %v", session.ID, err)
+		}
+	case pmtuKindReport:
+		if size < 576 || size > s.config.MTU {
+			return
+		}
+		if old := atomic.SwapInt32(&session.pathMTU, int32(size)); int(old) != size {
+			log.Printf("会话 %s 路径MTU: %d", session.ID, size)
+		}
+	}
+}
+
+// ================ 客户端 ================
+
+// tunnelMTU 返回客户端当前的隧道MTU（TUN设备实际使用的值）
+func (c *VPNClient) tunnelMTU() int {
+	if mtu := int(atomic.LoadInt32(&c.tunMTU)); mtu > 0 {
+		return mtu
+	}
+	return c.config.MTU
+}
+
+// udpFits 判断长度为 n 的IP包能否走UDP数据通道（路径MTU探测完成前只允许不超过下限的包）
+func (c *VPNClient) udpFits(n int) bool {
+	mtu := int(atomic.LoadInt32(&c.udpMTU))
+	if mtu == 0 {
+		mtu = min(pmtuMinMTU, c.config.MTU)
+	}
+	return n <= mtu
+}
+
+// pmtuLoop UDP数据通道确认可用后探测路径MTU，之后定期重新探测
+func (c *VPNClient) pmtuLoop(ctx context.Context) {
+	udpConn, _ := c.getUDP()
+	if udpConn == nil {
+		return
+	}
+	if err := setUDPDontFragment(udpConn); err != nil {
+		// 无法禁止分片时探测结果没有意义，保持原有行为（由系统分片）
+		log.Printf("无法为UDP数据通道设置DF标志，跳过路径MTU探测: %v", err)
+		atomic.StoreInt32(&c.udpMTU, int32(c.config.MTU))
+		return
+	}
+
+	for {
+		for !c.udpActive() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+
+		mtu := c.discoverPathMTU(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		c.applyPathMTU(mtu)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pmtuProbeInterval):
+		}
+	}
+}
+
+// discoverPathMTU 二分查找UDP数据通道能承载的最大IP包长度（不超过配置的MTU）
+func (c *VPNClient) discoverPathMTU(ctx context.Context) int {
+	hi := c.config.MTU
+	if c.probePathMTU(ctx, hi) {
+		return hi
+	}
+	// lo 按可通过处理（不再向下探测），hi 已确认不通
+	lo := min(pmtuMinMTU, hi)
+	for hi-lo > pmtuSearchStep && ctx.Err() == nil {
+		mid := (lo + hi) / 2
+		if c.probePathMTU(ctx, mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// probePathMTU 发送 size 字节的探测并等待服务端确认
+func (c *VPNClient) probePathMTU(ctx context.Context, size int) bool {
+	// 丢弃之前超时后才到达的确认
+	for len(c.pmtuAcks) > 0 {
+		<-c.pmtuAcks
+	}
+
+	probe := make([]byte, size)
+	probe[0] = pmtuKindProbe
+	binary.BigEndian.PutUint16(probe[1:3], uint16(size))
+
+	for attempt := 0; attempt < pmtuProbeAttempts; attempt++ {
+		// 超过本地网卡MTU时内核直接返回错误
+		if err := c.sendUDP(MessageTypeMTUProbe, probe); err != nil {
+			return false
+		}
+		timer := time.NewTimer(pmtuProbeTimeout)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return false
+			case got := <-c.pmtuAcks:
+				if got == size {
+					timer.Stop()
+					return true
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+	return false
+}
+
+// applyPathMTU 应用路径MTU探测结果：调整TUN设备MTU并通知服务端
+func (c *VPNClient) applyPathMTU(mtu int) {
+	atomic.StoreInt32(&c.udpMTU, int32(mtu))
+	if old := int(atomic.SwapInt32(&c.tunMTU, int32(mtu))); old != mtu {
+		log.Printf("路径MTU探测完成: %d", mtu)
+		if c.tunDevice != nil {
+			if err := setTUNMTU(c.tunDevice.Name(), mtu); err != nil {
+				log.Printf("警告：调整TUN设备MTU失败: %v", err)
+			}
+		}
+	}
+
+	report := make([]byte, pmtuHeaderSize)
+	report[0] = pmtuKindReport
+	binary.BigEndian.PutUint16(report[1:3], uint16(mtu))
+	for i := 0; i < pmtuReportRepeat; i++ {
+		if err := c.sendUDP(MessageTypeMTUProbe, report); err != nil {
+			log.Printf("发送路径MTU探测结果失败: %v", err)
+			return
+		}
+	}
+}
+
+// handleMTUProbe 处理服务端的探测确认
+func (c *VPNClient) handleMTUProbe(payload []byte) {
+	if len(payload) < pmtuHeaderSize || payload[0] != pmtuKindAck {
+		return
+	}
+	select {
+	case c.pmtuAcks <- int(binary.BigEndian.Uint16(payload[1:3])):
+	default:
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// setUDPDontFragment 为UDP套接字设置DF标志（Linux版本）。
+// 使用 PMTUDISC_PROBE：总是设置DF，但不受内核缓存的路径MTU限制，探测可以发现路径MTU变大
+func setUDPDontFragment(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	level, opt := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), level, opt, syscall.IP_PMTUDISC_PROBE)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("设置IP_MTU_DISCOVER失败: %v", sockErr)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// setUDPDontFragment 其他平台暂不支持为UDP套接字设置DF标志
+func setUDPDontFragment(conn *net.UDPConn) error {
+	return fmt.Errorf("当前平台不支持")
+}
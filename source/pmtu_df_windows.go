@@ -0,0 +1,39 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows SDK 中的套接字选项（x/sys/windows 未定义）
+const (
+	ipDontFragment   = 14 // IP_DONTFRAGMENT
+	ipv6DontFragment = 14 // IPV6_DONTFRAG
+)
+
+// setUDPDontFragment 为UDP套接字设置DF标志（Windows版本）
+func setUDPDontFragment(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	level, opt := windows.IPPROTO_IP, ipDontFragment
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		level, opt = windows.IPPROTO_IPV6, ipv6DontFragment
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = windows.SetsockoptInt(windows.Handle(fd), level, opt, 1)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("设置IP_DONTFRAGMENT失败: %v", sockErr)
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
 )
 
 // MessageType 消息类型枚举
@@ -14,6 +16,12 @@ const (
 	MessageTypeIPAssignment
 	MessageTypeAuth
 	MessageTypeControl
+	MessageTypeBatch          // 批量数据包（格式见 packet_batch.go）
+	MessageTypeCompressedData // 压缩的数据包（格式见 compression.go）
+	MessageTypeHello          // 协议版本和能力协商（JSON格式的 Hello）
+	MessageTypeGoodbye        // 服务端通知客户端断开（JSON格式的 Goodbye）
+	MessageTypeMTUProbe       // 路径MTU探测（仅用于UDP数据通道，格式见 path_mtu.go）
+	MessageTypeNotice         // 服务端发给客户端的通知（JSON格式的 Notice）
 )
 
 // Message VPN消息结构
@@ -28,13 +36,11 @@ type Message struct {
 // Serialize 序列化消息
 func (m *Message) Serialize() ([]byte, error) {
 	// 新格式: Type(1) + Length(4) + Sequence(4) + Checksum(4) + Payload
-	header := make([]byte, 13)
-	header[0] = byte(m.Type)
-	binary.BigEndian.PutUint32(header[1:5], m.Length)
-	binary.BigEndian.PutUint32(header[5:9], m.Sequence)
-	binary.BigEndian.PutUint32(header[9:13], m.Checksum)
-
-	return append(header, m.Payload...), nil
+	// 一次分配，消息头直接写入缓冲区头部
+	data := make([]byte, messageHeaderSize+len(m.Payload))
+	putMessageHeader(data, m.Type, m.Length, m.Sequence, m.Checksum)
+	copy(data[messageHeaderSize:], m.Payload)
+	return data, nil
 }
 
 // Deserialize 反序列化消息
@@ -64,13 +70,199 @@ func Deserialize(data []byte) (*Message, error) {
 
 // ClientConfig 客户端配置（服务端推送给客户端）
 type ClientConfig struct {
-	AssignedIP      string   `json:"assigned_ip"`      // 分配的IP地址（例如 "10.8.0.2/24"）
-	ServerIP        string   `json:"server_ip"`        // 服务器IP地址
-	DNS             []string `json:"dns"`              // DNS服务器列表
-	Routes          []string `json:"routes"`           // 路由列表（CIDR格式）
-	MTU             int      `json:"mtu"`              // MTU大小
-	RouteMode       string   `json:"route_mode"`       // 路由模式 "full" 或 "split"
-	ExcludeRoutes   []string `json:"exclude_routes"`   // 排除的路由（full模式使用）
-	RedirectGateway bool     `json:"redirect_gateway"` // 是否重定向默认网关
-	RedirectDNS     bool     `json:"redirect_dns"`     // 是否劫持DNS
+	AssignedIP      string   `json:"assigned_ip"`              // 分配的IP地址（例如 "10.8.0.2/24"）
+	ServerIP        string   `json:"server_ip"`                // 服务器IP地址
+	DNS             []string `json:"dns"`                      // DNS服务器列表
+	Routes          []string `json:"routes"`                   // 路由列表（CIDR格式）
+	MTU             int      `json:"mtu"`                      // MTU大小
+	RouteMode       string   `json:"route_mode"`               // 路由模式 "full" 或 "split"
+	ExcludeRoutes   []string `json:"exclude_routes"`           // 排除的路由（full模式使用）
+	RedirectGateway bool     `json:"redirect_gateway"`         // 是否重定向默认网关
+	RedirectDNS     bool     `json:"redirect_dns"`             // 是否劫持DNS
+	AssignedIP6     string   `json:"assigned_ip6,omitempty"`   // 分配的IPv6地址（例如 "fd00:8::2/64"）
+	ServerIP6       string   `json:"server_ip6,omitempty"`     // 服务器IPv6隧道地址
+	UDPPort         int      `json:"udp_port,omitempty"`       // UDP数据通道端口（0=未启用）
+	UDPSessionID    uint64   `json:"udp_session_id,omitempty"` // UDP数据通道会话ID
+	BondToken       string   `json:"bond_token,omitempty"`     // 加入本会话的凭据（多连接聚合，未启用时为空）
+	BondLinks       int      `json:"bond_links,omitempty"`     // 服务端允许的每会话连接数上限
+}
+
+// ================ 协议版本协商 ================
+//
+// 客户端在TLS握手完成后首先发送 Hello，服务端回复协商结果（版本取双方较小值，
+// 特性取交集），之后才进入 IPAssignment + Control 的连接建立流程。
+// 旧版客户端不发送 Hello，服务端等待 helloTimeout 后按版本1处理；
+// 旧版服务端不回复 Hello，客户端收到 IPAssignment 即按版本1处理。
+
+const (
+	// ProtocolVersion 当前协议版本（版本1为没有 Hello 的旧协议）
+	ProtocolVersion = 2
+	// MinProtocolVersion 支持的最低协议版本
+	MinProtocolVersion = 1
+)
+
+// 协议特性
+const (
+	FeatureCompression  = "compression"   // 数据包压缩（DEFLATE）
+	FeatureBatching     = "batching"      // 批量数据包
+	FeatureUDP          = "udp"           // UDP数据通道
+	FeatureIPv6         = "ipv6"          // IPv6隧道地址
+	FeatureBonding      = "bonding"       // 多连接聚合（一个会话使用多条TLS连接）
+	FeatureHeartbeatRTT = "heartbeat-rtt" // 带序列号和时间戳的心跳，双向测量链路质量（见 link_quality.go）
+	FeatureGoodbye      = "goodbye"       // 服务端以 Goodbye 通知断开原因和重连建议（见 server_drain.go）
+)
+
+// 认证方式
+const (
+	AuthMethodCert = "cert" // 客户端证书（mTLS）
+)
+
+// Hello 协议版本和能力协商消息
+type Hello struct {
+	Version     int      `json:"version"`                // 客户端: 支持的最高版本；服务端: 协商后的版本
+	MinVersion  int      `json:"min_version"`            // 支持的最低版本
+	Features    []string `json:"features,omitempty"`     // 客户端: 支持的特性；服务端: 协商后启用的特性
+	AuthMethods []string `json:"auth_methods,omitempty"` // 支持的认证方式
+	Error       string   `json:"error,omitempty"`        // 服务端拒绝连接的原因
+	BondToken   string   `json:"bond_token,omitempty"`   // 客户端: 作为成员连接加入已有会话（见 session_link.go）
+}
+
+// HasFeature 判断是否启用了指定特性
+func (h *Hello) HasFeature(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// intersectFeatures 计算双方都支持的特性（保持 local 的顺序）
+func intersectFeatures(local, remote []string) []string {
+	result := make([]string, 0, len(local))
+	for _, f := range local {
+		for _, r := range remote {
+			if f == r {
+				result = append(result, f)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// ================ 断开通知 ================
+//
+// 服务端主动结束会话前发送 Goodbye，说明原因以及客户端应等待多久再重连。
+// 地址池已满或连接数达到上限时，服务端在协商后以 Goodbye 代替 IPAssignment 拒绝连接。
+// 客户端被管理员踢出后不再自动重连，地址池已满时在建议的等待时间基础上指数退避。
+// 旧版客户端忽略未知的消息类型，随后按连接断开处理。
+
+// 断开原因
+const (
+	GoodbyeReasonDrain    = "drain"     // 服务端排空会话（停止或重启前）
+	GoodbyeReasonRestart  = "restart"   // 服务端已交接给新进程，重连即可连上新进程
+	GoodbyeReasonQuota    = "quota"     // 流量配额已用尽，新的配额周期开始后重连
+	GoodbyeReasonKick     = "kick"      // 被管理员踢出，客户端不再自动重连
+	GoodbyeReasonTimeout  = "timeout"   // 会话超时（长时间没有收到客户端的消息）
+	GoodbyeReasonShutdown = "shutdown"  // 服务端停止
+	GoodbyeReasonPoolFull = "pool-full" // 地址池已满或连接数达到上限
+	GoodbyeReasonSpoof    = "spoof"     // 发送了过多源地址不属于本客户端的数据包
+)
+
+// goodbyeReasonName 断开原因的中文名称（未知原因原样返回）
+func goodbyeReasonName(reason string) string {
+	switch reason {
+	case GoodbyeReasonDrain:
+		return "服务端排空会话"
+	case GoodbyeReasonRestart:
+		return "服务端重启"
+	case GoodbyeReasonQuota:
+		return "流量配额已用尽"
+	case GoodbyeReasonKick:
+		return "被管理员踢出"
+	case GoodbyeReasonTimeout:
+		return "会话超时"
+	case GoodbyeReasonShutdown:
+		return "服务端停止"
+	case GoodbyeReasonPoolFull:
+		return "地址池已满"
+	case GoodbyeReasonSpoof:
+		return "源地址校验失败"
+	}
+	return reason
+}
+
+// Goodbye 断开通知消息
+type Goodbye struct {
+	Reason       string `json:"reason"`                   // 断开原因
+	Message      string `json:"message,omitempty"`        // 可读的说明
+	RetryAfterMs int    `json:"retry_after_ms,omitempty"` // 建议的重连等待时间（毫秒）
+}
+
+// ================ 服务端通知 ================
+//
+// 服务端向客户端发送的提示信息（不影响连接），客户端记录日志并在状态中显示。
+// 旧版客户端忽略未知的消息类型。
+
+// 通知类型
+const (
+	NoticeQuotaExceeded = "quota-exceeded" // 流量配额已用尽
+)
+
+// Notice 服务端通知消息
+type Notice struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// writeHello 发送 Hello 消息（不占用序列号）
+func writeHello(w io.Writer, hello *Hello) error {
+	data, err := json.Marshal(hello)
+	if err != nil {
+		return fmt.Errorf("序列化Hello消息失败: %v", err)
+	}
+	msg := &Message{
+		Type:     MessageTypeHello,
+		Length:   uint32(len(data)),
+		Sequence: 0,
+		Checksum: 0,
+		Payload:  data,
+	}
+	msgData, err := msg.Serialize()
+	if err != nil {
+		return fmt.Errorf("序列化Hello消息失败: %v", err)
+	}
+	_, err = w.Write(msgData)
+	return err
+}
+
+// writeGoodbye 在连接建立阶段发送断开通知（拒绝连接时代替 IPAssignment）
+func writeGoodbye(w io.Writer, goodbye *Goodbye) error {
+	data, err := json.Marshal(goodbye)
+	if err != nil {
+		return fmt.Errorf("序列化断开通知失败: %v", err)
+	}
+	_, err = w.Write(appendMessage(nil, MessageTypeGoodbye, 0, data, false))
+	return err
+}
+
+// readHandshakeMessage 读取连接建立阶段的一条消息
+func readHandshakeMessage(r io.Reader) (MessageType, []byte, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	msgType := MessageType(header[0])
+	length := binary.BigEndian.Uint32(header[1:5])
+	if length > 65535 {
+		return 0, nil, fmt.Errorf("消息过大: %d 字节", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("读取消息体失败: %v", err)
+	}
+	return msgType, payload, nil
 }
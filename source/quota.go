@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ================ 流量配额 ================
+//
+// 服务端按证书CN累计每个客户端在当前配额周期（按本地时间的自然日、自然周或自然月）内的
+// 隧道流量，保存在配额文件中，客户端重连和服务端重启后继续累计，进入新周期时清零。
+// 会话的流量统计每隔 quotaAccountInterval 计入一次，超出配额时按 quota_action 处理：
+//   - warn: 通过 Notice 消息通知客户端（每个周期一次）
+//   - throttle: 通知客户端并将该客户端限速到 quota_throttle_kbps，直到进入新周期
+//   - disconnect: 发送 Goodbye 断开会话，新周期开始前拒绝该客户端连接
+
+const (
+	quotaAccountInterval = 10 * time.Second // 计入会话流量并检查配额的间隔
+	quotaSaveInterval    = time.Minute      // 配额文件的写回间隔（停止服务器时也会写回）
+	quotaMB              = 1024 * 1024
+)
+
+// QuotaRule 按证书CN设置的流量配额（上下行合计）
+type QuotaRule struct {
+	CN      string `json:"cn"`
+	LimitMB int    `json:"limit_mb"`         // 每个周期的配额（MB，0表示不限）
+	Period  string `json:"period,omitempty"` // 配额周期（空表示使用 quota_period）
+}
+
+// QuotaUsage 一个客户端在当前周期内的流量
+type QuotaUsage struct {
+	CN          string    `json:"cn"`
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"period_start"`
+	BytesUp     uint64    `json:"bytes_up"`           // 客户端→服务端
+	BytesDown   uint64    `json:"bytes_down"`         // 服务端→客户端
+	Notified    bool      `json:"notified,omitempty"` // 本周期已通知客户端超出配额
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// total 上下行合计字节数
+func (u QuotaUsage) total() uint64 {
+	return u.BytesUp + u.BytesDown
+}
+
+// quotaPeriodStart 返回 now 所在周期的起始时间（本地时间，周从周一开始）
+func quotaPeriodStart(period string, now time.Time) time.Time {
+	y, m, d := now.Date()
+	switch period {
+	case QuotaPeriodDaily:
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	case QuotaPeriodWeekly:
+		offset := (int(now.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, now.Location())
+	default:
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	}
+}
+
+// quotaPeriodEnd 返回从 start 开始的周期的结束时间
+func quotaPeriodEnd(period string, start time.Time) time.Time {
+	switch period {
+	case QuotaPeriodDaily:
+		return start.AddDate(0, 0, 1)
+	case QuotaPeriodWeekly:
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// quotaPolicy 服务端的流量配额配置，修改时整体替换
+type quotaPolicy struct {
+	period       string
+	defaultMB    int
+	action       string
+	throttleKbps int
+	rules        []QuotaRule
+}
+
+// newQuotaPolicy 根据配置创建配额策略（复制规则列表）
+func newQuotaPolicy(config *VPNConfig) *quotaPolicy {
+	return &quotaPolicy{
+		period:       config.GetQuotaPeriod(),
+		defaultMB:    config.QuotaDefaultMB,
+		action:       config.GetQuotaAction(),
+		throttleKbps: config.GetQuotaThrottleKbps(),
+		rules:        append([]QuotaRule(nil), config.Quotas...),
+	}
+}
+
+// quotaFor 返回客户端的配额（字节，0表示不限）和配额周期
+func (p *quotaPolicy) quotaFor(cn string) (limit uint64, period string) {
+	for _, rule := range p.rules {
+		if rule.CN == cn {
+			period = rule.Period
+			if period == "" {
+				period = p.period
+			}
+			return uint64(rule.LimitMB) * quotaMB, period
+		}
+	}
+	return uint64(p.defaultMB) * quotaMB, p.period
+}
+
+// quotaFileContent 配额文件结构
+type quotaFileContent struct {
+	Usage []*QuotaUsage `json:"usage"`
+}
+
+// quotaDB 持久化的流量用量表（并发安全，定期写回文件）
+type quotaDB struct {
+	mu       sync.Mutex
+	path     string
+	usage    map[string]*QuotaUsage
+	dirty    bool
+	lastSave time.Time
+	readOnly bool // 已交接给新进程，配额文件由新进程维护，本进程不再写回
+}
+
+// loadQuotaDB 从文件加载用量表（文件不存在时返回空表）
+func loadQuotaDB(path string) (*quotaDB, error) {
+	db := &quotaDB{
+		path:     path,
+		usage:    make(map[string]*QuotaUsage),
+		lastSave: time.Now(),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取配额文件失败: %v", err)
+	}
+
+	var content quotaFileContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("解析配额文件失败: %v", err)
+	}
+	for _, usage := range content.Usage {
+		if usage != nil && usage.CN != "" {
+			db.usage[usage.CN] = usage
+		}
+	}
+	return db, nil
+}
+
+// currentLocked 返回 cn 在当前周期的用量记录，周期变化时清零（调用方持有锁）
+func (db *quotaDB) currentLocked(cn, period string, now time.Time) *QuotaUsage {
+	start := quotaPeriodStart(period, now)
+	usage, ok := db.usage[cn]
+	if !ok {
+		usage = &QuotaUsage{CN: cn}
+		db.usage[cn] = usage
+	}
+	if usage.Period != period || !usage.PeriodStart.Equal(start) {
+		*usage = QuotaUsage{CN: cn, Period: period, PeriodStart: start, UpdatedAt: now}
+		db.dirty = true
+	}
+	return usage
+}
+
+// add 将流量计入 cn 当前周期的用量，返回累计后的用量副本
+func (db *quotaDB) add(cn, period string, up, down uint64) QuotaUsage {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	usage := db.currentLocked(cn, period, now)
+	if up > 0 || down > 0 {
+		usage.BytesUp += up
+		usage.BytesDown += down
+		usage.UpdatedAt = now
+		db.dirty = true
+	}
+	return *usage
+}
+
+// markNotified 记录本周期已通知客户端超出配额，返回之前是否已通知
+func (db *quotaDB) markNotified(cn, period string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	usage := db.currentLocked(cn, period, time.Now())
+	if usage.Notified {
+		return true
+	}
+	usage.Notified = true
+	db.dirty = true
+	return false
+}
+
+// get 返回 cn 当前周期的用量（没有记录时用量为0，不修改用量表）
+func (db *quotaDB) get(cn, period string) QuotaUsage {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	start := quotaPeriodStart(period, now)
+	if usage, ok := db.usage[cn]; ok && usage.Period == period && usage.PeriodStart.Equal(start) {
+		return *usage
+	}
+	return QuotaUsage{CN: cn, Period: period, PeriodStart: start}
+}
+
+// keys 返回有用量记录的所有CN
+func (db *quotaDB) keys() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	keys := make([]string, 0, len(db.usage))
+	for cn := range db.usage {
+		keys = append(keys, cn)
+	}
+	return keys
+}
+
+// save 有修改时写回配额文件。force 为 false 时距上次写回不足 quotaSaveInterval 则跳过
+func (db *quotaDB) save(force bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.readOnly || !db.dirty || (!force && time.Since(db.lastSave) < quotaSaveInterval) {
+		return nil
+	}
+	content := quotaFileContent{Usage: make([]*QuotaUsage, 0, len(db.usage))}
+	for _, usage := range db.usage {
+		content.Usage = append(content.Usage, usage)
+	}
+	sort.Slice(content.Usage, func(i, j int) bool {
+		return content.Usage[i].CN < content.Usage[j].CN
+	})
+
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化配额用量失败: %v", err)
+	}
+	if dir := filepath.Dir(db.path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("创建配额目录失败: %v", err)
+		}
+	}
+	// 先写临时文件再替换，避免写入中断损坏用量表
+	tmp := db.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("写入配额文件失败: %v", err)
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("写入配额文件失败: %v", err)
+	}
+	db.dirty = false
+	db.lastSave = time.Now()
+	return nil
+}
+
+// freeze 停止写回配额文件（交接给新进程后调用）
+func (db *quotaDB) freeze() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.readOnly = true
+}
+
+// quotaStatus 汇总配置了配额或有用量记录的客户端的配额状态（按CN排序）
+func quotaStatus(db *quotaDB, policy *quotaPolicy, online map[string]bool) []QuotaInfo {
+	cns := make(map[string]bool)
+	for _, cn := range db.keys() {
+		cns[cn] = true
+	}
+	for _, rule := range policy.rules {
+		cns[rule.CN] = true
+	}
+	for cn := range online {
+		cns[cn] = true
+	}
+
+	infos := make([]QuotaInfo, 0, len(cns))
+	for cn := range cns {
+		limit, period := policy.quotaFor(cn)
+		usage := db.get(cn, period)
+		infos = append(infos, QuotaInfo{
+			CN:          cn,
+			Period:      period,
+			PeriodStart: usage.PeriodStart,
+			PeriodEnd:   quotaPeriodEnd(period, usage.PeriodStart),
+			LimitBytes:  limit,
+			BytesUp:     usage.BytesUp,
+			BytesDown:   usage.BytesDown,
+			Exceeded:    limit > 0 && usage.total() >= limit,
+			Online:      online[cn],
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CN < infos[j].CN })
+	return infos
+}
+
+// ================ 服务端 ================
+
+// quotaLoop 定期计入在线会话的流量、执行超出配额的处理并写回配额文件
+func (s *VPNServer) quotaLoop(ctx context.Context) {
+	ticker := time.NewTicker(quotaAccountInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, session := range s.sessions.all() {
+				s.accountQuota(session)
+			}
+			if err := s.quotas.save(false); err != nil {
+				log.Printf("警告：保存配额用量失败: %v", err)
+			}
+		}
+	}
+}
+
+// accountQuota 将会话自上次计入以来的流量计入其配额，并按配额状态执行处理
+func (s *VPNServer) accountQuota(session *VPNSession) {
+	sent, received, _ := session.GetStats()
+	session.mutex.Lock()
+	up, down := received-session.quotaReceived, sent-session.quotaSent
+	session.quotaReceived, session.quotaSent = received, sent
+	session.mutex.Unlock()
+
+	policy := s.quotaPolicy.Load()
+	limit, period := policy.quotaFor(session.CertSubject)
+	usage := s.quotas.add(session.CertSubject, period, up, down)
+	if session.IsClosed() {
+		return // 会话断开时最后一次计入流量，不再处理
+	}
+	exceeded := limit > 0 && usage.total() >= limit
+
+	switch policy.action {
+	case QuotaActionThrottle:
+		if exceeded && atomic.CompareAndSwapInt32(&session.quotaExceeded, 0, 1) {
+			log.Printf("客户端 %s 超出流量配额 (%s/%s)，限速到 %s", session.CertSubject,
+				formatBytes(usage.total()), formatBytes(limit), formatKbps(policy.throttleKbps))
+			s.applyRateLimit(session)
+		} else if !exceeded && atomic.CompareAndSwapInt32(&session.quotaExceeded, 1, 0) {
+			log.Printf("客户端 %s 进入新的配额周期，解除限速", session.CertSubject)
+			s.applyRateLimit(session)
+		}
+		if exceeded {
+			s.notifyQuotaExceeded(session, period, fmt.Sprintf("本%s流量配额 %s 已用尽，已限速到 %s",
+				quotaPeriodName(period), formatBytes(limit), formatKbps(policy.throttleKbps)))
+		}
+	case QuotaActionDisconnect:
+		if exceeded {
+			s.disconnectOverQuota(session, period, limit)
+		}
+	default:
+		if exceeded {
+			s.notifyQuotaExceeded(session, period, fmt.Sprintf("本%s流量配额 %s 已用尽",
+				quotaPeriodName(period), formatBytes(limit)))
+		}
+	}
+}
+
+// flushQuotas 计入所有在线会话的流量并立即写回配额文件
+func (s *VPNServer) flushQuotas() {
+	for _, session := range s.sessions.all() {
+		s.accountQuota(session)
+	}
+	if err := s.quotas.save(true); err != nil {
+		log.Printf("警告：保存配额用量失败: %v", err)
+	}
+}
+
+// notifyQuotaExceeded 通知客户端超出配额（每个周期一次）
+func (s *VPNServer) notifyQuotaExceeded(session *VPNSession, period, message string) {
+	if s.quotas.markNotified(session.CertSubject, period) {
+		return
+	}
+	log.Printf("客户端 %s 超出流量配额: %s", session.CertSubject, message)
+	if err := s.sendNotice(session, &Notice{Kind: NoticeQuotaExceeded, Message: message}); err != nil {
+		log.Printf("通知会话 %s 超出配额失败: %v", session.ID, err)
+	}
+}
+
+// disconnectOverQuota 通知客户端在新周期开始后重连，并断开会话
+func (s *VPNServer) disconnectOverQuota(session *VPNSession, period string, limit uint64) {
+	if !atomic.CompareAndSwapInt32(&session.quotaExceeded, 0, 1) {
+		return // 已经在断开
+	}
+	end := quotaPeriodEnd(period, quotaPeriodStart(period, time.Now()))
+	log.Printf("客户端 %s 超出流量配额 %s，断开会话 %s", session.CertSubject, formatBytes(limit), session.ID)
+
+	s.disconnectSession(session, &Goodbye{
+		Reason:       GoodbyeReasonQuota,
+		Message:      fmt.Sprintf("本%s流量配额 %s 已用尽", quotaPeriodName(period), formatBytes(limit)),
+		RetryAfterMs: int(time.Until(end) / time.Millisecond),
+	})
+}
+
+// quotaReject 客户端超出配额且处理方式为断开时，返回拒绝其连接的断开通知（否则为nil），
+// 客户端按 RetryAfterMs 等到新周期开始后再重连
+func (s *VPNServer) quotaReject(cn string) *Goodbye {
+	policy := s.quotaPolicy.Load()
+	if policy.action != QuotaActionDisconnect {
+		return nil
+	}
+	limit, period := policy.quotaFor(cn)
+	if limit == 0 || s.quotas.get(cn, period).total() < limit {
+		return nil
+	}
+	end := quotaPeriodEnd(period, quotaPeriodStart(period, time.Now()))
+	return &Goodbye{
+		Reason: GoodbyeReasonQuota,
+		Message: fmt.Sprintf("本%s流量配额 %s 已用尽，%s 后恢复", quotaPeriodName(period),
+			formatBytes(limit), end.Format("2006-01-02 15:04")),
+		RetryAfterMs: int(time.Until(end) / time.Millisecond),
+	}
+}
+
+// SetQuotas 替换流量配额配置，并按新配置立即重新检查所有在线会话（解除或调整超出配额的限速）
+func (s *VPNServer) SetQuotas(config *VPNConfig) {
+	old := s.quotaPolicy.Swap(newQuotaPolicy(config))
+	policy := s.quotaPolicy.Load()
+	for _, session := range s.sessions.all() {
+		// 不再限速时清除限速标记；处理方式为断开时该标记表示正在断开，保持不变
+		if old.action == QuotaActionThrottle && policy.action != QuotaActionThrottle {
+			atomic.StoreInt32(&session.quotaExceeded, 0)
+		}
+		s.accountQuota(session)
+		s.applyRateLimit(session)
+	}
+}
+
+// QuotaStatus 获取各客户端的配额状态
+func (s *VPNServer) QuotaStatus() []QuotaInfo {
+	online := make(map[string]bool)
+	for _, session := range s.sessions.all() {
+		online[session.CertSubject] = true
+	}
+	return quotaStatus(s.quotas, s.quotaPolicy.Load(), online)
+}
+
+// quotaPeriodName 配额周期的中文名称
+func quotaPeriodName(period string) string {
+	switch period {
+	case QuotaPeriodDaily:
+		return "日"
+	case QuotaPeriodWeekly:
+		return "周"
+	default:
+		return "月"
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ================ 带宽限速 ================
+//
+// 每个会话在两个方向各有一个令牌桶，按隧道内IP包的长度（压缩前）计量：
+//   - 上行（客户端→服务端）：TLS连接上按速率推迟读取后续数据，通过TCP反压让客户端放慢；
+//     UDP数据通道没有反压，超出速率的包直接丢弃
+//   - 下行（服务端→客户端）：从TUN设备或其他客户端发往该会话的包超出速率时直接丢弃，
+//     由隧道内的TCP拥塞控制自行降速
+//
+// 限速值依次按证书CN规则、分组（证书OU）规则、默认值匹配，可以通过控制接口在运行时修改，
+// 修改后立即作用于在线会话。
+
+const (
+	rateLimitBurst    = 100 * time.Millisecond // 令牌桶容量（按限速折算的时长）
+	rateLimitMinBurst = 16 * 1024              // 令牌桶最小容量（字节），保证最大的IP包也能通过
+	rateMeterWindow   = time.Second            // 当前速率的统计窗口
+)
+
+// RateLimitRule 按证书CN或分组设置的限速规则（CN和Group只设置其一，0表示不限速）
+type RateLimitRule struct {
+	CN       string `json:"cn,omitempty"`
+	Group    string `json:"group,omitempty"`
+	UpKbps   int    `json:"up_kbps"`   // 上行限速（客户端→服务端，kbit/s）
+	DownKbps int    `json:"down_kbps"` // 下行限速（服务端→客户端，kbit/s）
+}
+
+// validate 检查规则格式
+func (r RateLimitRule) validate() error {
+	if (r.CN == "") == (r.Group == "") {
+		return fmt.Errorf("限速规则必须指定证书CN或分组之一")
+	}
+	if r.UpKbps < 0 || r.DownKbps < 0 {
+		return fmt.Errorf("限速值不能为负数（0表示不限速）")
+	}
+	return nil
+}
+
+// sameTarget 判断两条规则是否针对同一个CN或分组
+func (r RateLimitRule) sameTarget(other RateLimitRule) bool {
+	return r.CN == other.CN && r.Group == other.Group
+}
+
+// rateLimitPolicy 服务端的限速配置，修改时整体替换
+type rateLimitPolicy struct {
+	upKbps   int
+	downKbps int
+	rules    []RateLimitRule
+}
+
+// newRateLimitPolicy 根据配置创建限速策略（复制规则列表）
+func newRateLimitPolicy(config *VPNConfig) *rateLimitPolicy {
+	return &rateLimitPolicy{
+		upKbps:   config.RateLimitUpKbps,
+		downKbps: config.RateLimitDownKbps,
+		rules:    append([]RateLimitRule(nil), config.RateLimits...),
+	}
+}
+
+// limitsFor 返回客户端的限速值：CN规则优先于分组规则，都没有匹配时使用默认值
+func (p *rateLimitPolicy) limitsFor(cn, group string) (upKbps, downKbps int) {
+	var groupRule *RateLimitRule
+	for i := range p.rules {
+		rule := &p.rules[i]
+		if rule.CN != "" && rule.CN == cn {
+			return rule.UpKbps, rule.DownKbps
+		}
+		if groupRule == nil && rule.Group != "" && rule.Group == group {
+			groupRule = rule
+		}
+	}
+	if groupRule != nil {
+		return groupRule.UpKbps, groupRule.DownKbps
+	}
+	return p.upKbps, p.downKbps
+}
+
+// rateBucket 单方向的令牌桶及速率统计
+type rateBucket struct {
+	mu        sync.Mutex
+	limitKbps int
+	rate      float64 // 字节/秒，0表示不限速
+	burst     float64
+	tokens    float64
+	last      time.Time
+	// 当前速率：最近一个完整统计窗口内通过的字节数
+	windowStart time.Time
+	windowBytes uint64
+	lastRate    uint64
+	// 超出限速被丢弃的包
+	droppedPackets uint64
+	droppedBytes   uint64
+	// 超出限速被推迟读取的包（TLS上行）及累计推迟的时间
+	delayedPackets uint64
+	delayedBytes   uint64
+	delay          time.Duration
+}
+
+// setLimit 修改限速值（kbit/s，0表示不限速），令牌桶重新装满
+func (b *rateBucket) setLimit(kbps int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if kbps == b.limitKbps {
+		return
+	}
+	b.limitKbps = kbps
+	b.rate = float64(kbps) * 1000 / 8
+	b.burst = max(b.rate*rateLimitBurst.Seconds(), rateLimitMinBurst)
+	b.tokens = b.burst
+	b.last = time.Now()
+}
+
+// refill 按经过的时间补充令牌（调用方持有锁）
+func (b *rateBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// record 统计通过的字节数（调用方持有锁）
+func (b *rateBucket) record(now time.Time, n int) {
+	if elapsed := now.Sub(b.windowStart); elapsed >= rateMeterWindow {
+		if elapsed < 2*rateMeterWindow {
+			b.lastRate = b.windowBytes
+		} else {
+			b.lastRate = 0
+		}
+		b.windowStart = now
+		b.windowBytes = 0
+	}
+	b.windowBytes += uint64(n)
+}
+
+// allow 令牌足够时放行长度为 n 的包，否则计入丢弃统计并返回 false
+func (b *rateBucket) allow(n int) bool {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate > 0 {
+		b.refill(now)
+		if b.tokens < float64(n) {
+			b.droppedPackets++
+			b.droppedBytes += uint64(n)
+			return false
+		}
+		b.tokens -= float64(n)
+	}
+	b.record(now, n)
+	return true
+}
+
+// reserve 取走长度为 n 的令牌（允许透支），返回补足透支需要等待的时间
+func (b *rateBucket) reserve(n int) time.Duration {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.record(now, n)
+	if b.rate == 0 {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	d := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.delayedPackets++
+	b.delayedBytes += uint64(n)
+	b.delay += d
+	return d
+}
+
+// wait 按限速等待长度为 n 的包通过，ctx 取消时返回 false
+func (b *rateBucket) wait(ctx context.Context, n int) bool {
+	d := b.reserve(n)
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// rateStats 单方向的限速统计
+type rateStats struct {
+	limitKbps        int
+	rate             uint64        // 当前速率（字节/秒）
+	throttledPackets uint64        // 超出限速被丢弃或推迟的包数
+	throttledBytes   uint64        // 超出限速被丢弃或推迟的字节数
+	delay            time.Duration // 累计推迟的时间（只有TLS上行会推迟）
+}
+
+// stats 返回限速值、当前速率和限速统计
+func (b *rateBucket) stats() rateStats {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := rateStats{
+		limitKbps:        b.limitKbps,
+		throttledPackets: b.droppedPackets + b.delayedPackets,
+		throttledBytes:   b.droppedBytes + b.delayedBytes,
+		delay:            b.delay,
+	}
+	switch elapsed := now.Sub(b.windowStart); {
+	case elapsed >= 2*rateMeterWindow:
+		st.rate = 0
+	case elapsed >= rateMeterWindow:
+		st.rate = b.windowBytes
+	default:
+		st.rate = b.lastRate
+	}
+	return st
+}
+
+// ================ 服务端 ================
+
+// applyRateLimit 按当前限速策略设置会话的限速值（超出流量配额被限速时取两者中较低的值）
+func (s *VPNServer) applyRateLimit(session *VPNSession) {
+	up, down := s.rateLimits.Load().limitsFor(session.CertSubject, session.Group)
+	if quota := s.quotaPolicy.Load(); quota.action == QuotaActionThrottle && atomic.LoadInt32(&session.quotaExceeded) == 1 {
+		up = minRateLimit(up, quota.throttleKbps)
+		down = minRateLimit(down, quota.throttleKbps)
+	}
+	session.rateUp.setLimit(up)
+	session.rateDown.setLimit(down)
+}
+
+// minRateLimit 返回两个限速值中较低的一个（0表示不限速）
+func minRateLimit(a, b int) int {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// SetRateLimits 替换限速配置并立即应用到所有在线会话
+func (s *VPNServer) SetRateLimits(config *VPNConfig) {
+	s.rateLimits.Store(newRateLimitPolicy(config))
+	for _, session := range s.sessions.all() {
+		s.applyRateLimit(session)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
 )
 
@@ -25,6 +26,8 @@ type RouteManager struct {
 	originalDNS     []string
 	defaultGateway  string
 	defaultIface    string
+	defaultGateway6 string // IPv6默认网关（未检测到时为空）
+	defaultIface6   string
 	mutex           sync.Mutex
 }
 
@@ -40,60 +43,67 @@ func NewRouteManager() (*RouteManager, error) {
 		return nil, fmt.Errorf("检测默认网关失败: %v", err)
 	}
 
-	log.Printf("检测到默认网关: %s (接口: %s)", rm.defaultGateway, rm.defaultIface)
+	if rm.defaultGateway != "" {
+		log.Printf("检测到默认网关: %s (接口: %s)", rm.defaultGateway, rm.defaultIface)
+	}
+	if rm.defaultGateway6 != "" {
+		log.Printf("检测到IPv6默认网关: %s (接口: %s)", rm.defaultGateway6, rm.defaultIface6)
+	}
 	return rm, nil
 }
 
-// detectDefaultGateway 检测默认网关
+// detectDefaultGateway 检测默认网关（IPv4和IPv6至少检测到一个）
 func (rm *RouteManager) detectDefaultGateway() error {
+	var err4, err6 error
+	rm.defaultGateway, rm.defaultIface, err4 = parseDefaultRoute(false)
+	rm.defaultGateway6, rm.defaultIface6, err6 = parseDefaultRoute(true)
+
+	if err4 != nil && err6 != nil {
+		return err4
+	}
+	return nil
+}
+
+// parseDefaultRoute 解析指定地址族的默认路由，返回网关和接口
+func parseDefaultRoute(ipv6 bool) (string, string, error) {
 	// 使用 ip route 命令获取默认路由
-	cmd := exec.Command("ip", "route", "show", "default")
+	args := []string{"route", "show", "default"}
+	if ipv6 {
+		args = append([]string{"-6"}, args...)
+	}
+	cmd := exec.Command("ip", args...)
 	output, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("执行ip route命令失败: %v", err)
+		return "", "", fmt.Errorf("执行ip route命令失败: %v", err)
 	}
 
 	// 解析输出，格式如: default via 192.168.1.1 dev eth0
-	lines := string(output)
-	if lines == "" {
-		return fmt.Errorf("未找到默认路由")
-	}
-
-	// 更好的解析方式
-	parts := make([]string, 0)
-	current := ""
-	for _, ch := range lines {
-		if ch == ' ' || ch == '\t' || ch == '\n' {
-			if current != "" {
-				parts = append(parts, current)
-				current = ""
-			}
-		} else {
-			current += string(ch)
-		}
-	}
-	if current != "" {
-		parts = append(parts, current)
+	// 存在多条默认路由时只取第一条
+	lines := strings.SplitN(string(output), "\n", 2)
+	if strings.TrimSpace(lines[0]) == "" {
+		return "", "", fmt.Errorf("未找到默认路由")
 	}
 
 	// 查找 via 和 dev 关键字
+	var gateway, iface string
+	parts := strings.Fields(lines[0])
 	for i := 0; i < len(parts); i++ {
 		if parts[i] == "via" && i+1 < len(parts) {
-			rm.defaultGateway = parts[i+1]
+			gateway = parts[i+1]
 		}
 		if parts[i] == "dev" && i+1 < len(parts) {
-			rm.defaultIface = parts[i+1]
+			iface = parts[i+1]
 		}
 	}
 
-	if rm.defaultGateway == "" {
-		return fmt.Errorf("无法从路由表中解析默认网关")
+	if gateway == "" {
+		return "", "", fmt.Errorf("无法从路由表中解析默认网关")
 	}
-	if rm.defaultIface == "" {
-		return fmt.Errorf("无法从路由表中解析默认接口")
+	if iface == "" {
+		return "", "", fmt.Errorf("无法从路由表中解析默认接口")
 	}
 
-	return nil
+	return gateway, iface, nil
 }
 
 // AddRoute 添加路由
@@ -143,6 +153,7 @@ func (rm *RouteManager) DeleteRoute(destination string) error {
 	if err != nil {
 		return fmt.Errorf("删除路由失败: %v, 输出: %s", err, string(output))
 	}
+	rm.forgetRoute(destination)
 
 	log.Printf("已删除路由: %s", destination)
 	return nil
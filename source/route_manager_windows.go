@@ -6,6 +6,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -27,6 +28,8 @@ type RouteManager struct {
 	originalDNS     []string
 	defaultGateway  string
 	defaultIface    string
+	defaultGateway6 string // IPv6默认网关（未检测到时为空）
+	defaultIface6   string // IPv6默认路由的接口索引
 	mutex           sync.Mutex
 }
 
@@ -43,9 +46,40 @@ func NewRouteManager() (*RouteManager, error) {
 	}
 
 	log.Printf("检测到默认网关: %s (接口: %s)", rm.defaultGateway, rm.defaultIface)
+
+	// IPv6默认网关仅在服务器使用IPv6地址时需要，检测失败不影响IPv4
+	if err := rm.detectDefaultGateway6(); err != nil {
+		log.Printf("未检测到IPv6默认网关: %v", err)
+	} else {
+		log.Printf("检测到IPv6默认网关: %s (接口: %s)", rm.defaultGateway6, rm.defaultIface6)
+	}
 	return rm, nil
 }
 
+// detectDefaultGateway6 使用 netsh 检测IPv6默认网关
+func (rm *RouteManager) detectDefaultGateway6() error {
+	output, err := exec.Command("netsh", "interface", "ipv6", "show", "route").Output()
+	if err != nil {
+		return fmt.Errorf("执行netsh命令失败: %v", err)
+	}
+
+	lines := strings.Split(string(output), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		// 格式：发布 类型 跃点数 前缀 索引 网关/接口名
+		if len(fields) >= 6 && fields[3] == "::/0" {
+			if net.ParseIP(fields[5]) == nil {
+				continue
+			}
+			rm.defaultGateway6 = fields[5]
+			rm.defaultIface6 = fields[4]
+			return nil
+		}
+	}
+
+	return fmt.Errorf("未找到IPv6默认路由")
+}
+
 // detectDefaultGateway 检测默认网关（Windows版本）
 func (rm *RouteManager) detectDefaultGateway() error {
 	// 使用 route print 命令获取默认路由
@@ -169,9 +203,13 @@ func (rm *RouteManager) AddRoute(destination, gateway, iface string) error {
 
 	// 如果提供了接口名称，使用netsh命令（支持接口名称，更可靠）
 	if iface != "" {
-		// 使用netsh interface ipv4 add route命令
+		// 使用netsh interface ipv4/ipv6 add route命令
 		// 格式: netsh interface ipv4 add route <CIDR> <接口名> <网关> metric=1
-		output, err := runCmdCombined("netsh", "interface", "ipv4", "add", "route",
+		family := "ipv4"
+		if isIPv6CIDR(destination) {
+			family = "ipv6"
+		}
+		output, err := runCmdCombined("netsh", "interface", family, "add", "route",
 			destination, iface, gateway, "metric=1")
 		
 		if err != nil {
@@ -235,13 +273,12 @@ func (rm *RouteManager) DeleteRoute(destination string) error {
 	rm.mutex.Lock()
 	defer rm.mutex.Unlock()
 
-	ip, mask := parseCIDR(destination)
-
-	cmd := exec.Command("route", "delete", ip, "mask", mask)
+	cmd := exec.Command("route", routeDeleteArgs(destination)...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("删除路由失败: %v, 输出: %s", err, string(output))
 	}
+	rm.forgetRoute(destination)
 
 	log.Printf("已删除路由: %s", destination)
 	return nil
@@ -253,8 +290,7 @@ func (rm *RouteManager) CleanupRoutes() {
 	defer rm.mutex.Unlock()
 
 	for _, route := range rm.installedRoutes {
-		ip, mask := parseCIDR(route.Destination)
-		output, err := runCmdCombined("route", "delete", ip, "mask", mask)
+		output, err := runCmdCombined("route", routeDeleteArgs(route.Destination)...)
 		if err != nil {
 			log.Printf("警告：删除路由 %s 失败: %v, 输出: %s", route.Destination, err, string(output))
 		} else {
@@ -454,6 +490,15 @@ func parseCIDR(cidr string) (string, string) {
 	return ip, mask
 }
 
+// routeDeleteArgs 构建 route delete 命令参数（IPv6路由直接使用CIDR）
+func routeDeleteArgs(destination string) []string {
+	if isIPv6CIDR(destination) {
+		return []string{"delete", destination}
+	}
+	ip, mask := parseCIDR(destination)
+	return []string{"delete", ip, "mask", mask}
+}
+
 // splitLines 辅助函数：分割字符串为行
 func splitLines(s string) []string {
 	lines := make([]string, 0)
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net"
+)
+
+// hostRoute 返回单个主机的路由（IPv4为/32，IPv6为/128）
+func hostRoute(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
+
+// bypassGateway 返回到达指定地址应使用的原始网关和接口（按地址族选择）
+func (rm *RouteManager) bypassGateway(ip net.IP) (string, string) {
+	if ip.To4() == nil {
+		return rm.defaultGateway6, rm.defaultIface6
+	}
+	return rm.defaultGateway, rm.defaultIface
+}
+
+// forgetRoute 从已安装的路由中移除 destination（调用方持有锁），删除后清理时不再处理
+func (rm *RouteManager) forgetRoute(destination string) {
+	for i, route := range rm.installedRoutes {
+		if route.Destination == destination {
+			rm.installedRoutes = append(rm.installedRoutes[:i], rm.installedRoutes[i+1:]...)
+			return
+		}
+	}
+}
+
+// bypassRouteGateway 返回CIDR路由应使用的原始网关和接口
+func (rm *RouteManager) bypassRouteGateway(cidr string) (string, string) {
+	if isIPv6CIDR(cidr) {
+		return rm.defaultGateway6, rm.defaultIface6
+	}
+	return rm.defaultGateway, rm.defaultIface
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ================ 会话发送队列 ================
+//
+// 每个会话拥有一个发送队列和一个写协程，所有发往客户端的TLS消息（数据、心跳、配置推送）
+// 都经由队列串行写入，避免多个协程并发写同一个 tls.Conn，也避免慢客户端阻塞TUN读取协程。
+// 控制消息使用独立的队列并优先发送；数据消息队列有界，满时按配置的溢出策略处理。
+// 序列号在写协程真正写入时分配，保证线路上的序列号与发送顺序一致。
+// 写协程会把已排队的多条消息编码进同一个缓冲区后一次写入，减少TLS记录和系统调用次数。
+
+// controlQueueSize 控制消息队列长度（控制消息很少，队列满时等待而不丢弃）
+const controlQueueSize = 16
+
+var (
+	errSendQueueClosed = errors.New("发送队列已关闭")
+	errSendQueueFull   = errors.New("发送队列已满")
+)
+
+// outboundMessage 待发送的消息
+type outboundMessage struct {
+	msgType     MessageType
+	payload     []byte
+	packetBytes uint64        // 数据消息中IP包压缩前的总字节数，用于流量统计
+	buf         *packetBuffer // payload 所在的池化缓冲区（可为nil），写入或丢弃后归还
+}
+
+// isDataMessage 是否为承载IP包的数据消息（走有界数据队列）
+func isDataMessage(msgType MessageType) bool {
+	switch msgType {
+	case MessageTypeData, MessageTypeCompressedData, MessageTypeBatch:
+		return true
+	}
+	return false
+}
+
+// sendQueue 会话发送队列
+type sendQueue struct {
+	control   chan outboundMessage
+	data      chan outboundMessage
+	policy    string
+	done      chan struct{}
+	closeOnce sync.Once
+	// 丢弃统计（使用 atomic）
+	droppedMessages uint64
+	droppedBytes    uint64
+}
+
+// newSendQueue 创建发送队列，size 为数据队列长度
+func newSendQueue(size int, policy string) *sendQueue {
+	return &sendQueue{
+		control: make(chan outboundMessage, controlQueueSize),
+		data:    make(chan outboundMessage, size),
+		policy:  policy,
+		done:    make(chan struct{}),
+	}
+}
+
+// pushControl 排队控制消息，队列满时等待写协程腾出空间
+func (q *sendQueue) pushControl(m outboundMessage) error {
+	select {
+	case q.control <- m:
+		return nil
+	case <-q.done:
+		return errSendQueueClosed
+	}
+}
+
+// pushData 排队数据消息，队列满时按溢出策略处理。
+// 只有 disconnect 策略会在丢弃时返回 errSendQueueFull，由调用方断开会话
+func (q *sendQueue) pushData(m outboundMessage) error {
+	for {
+		select {
+		case <-q.done:
+			m.buf.release()
+			return errSendQueueClosed
+		default:
+		}
+
+		select {
+		case q.data <- m:
+			return nil
+		default:
+		}
+
+		switch q.policy {
+		case SendQueueDropOldest:
+			// 丢弃队首后重试
+			select {
+			case old := <-q.data:
+				q.drop(old)
+			default:
+			}
+		case SendQueueDisconnect:
+			q.drop(m)
+			return errSendQueueFull
+		default:
+			q.drop(m)
+			return nil
+		}
+	}
+}
+
+// drop 记录并释放被丢弃的消息
+func (q *sendQueue) drop(m outboundMessage) {
+	atomic.AddUint64(&q.droppedMessages, 1)
+	atomic.AddUint64(&q.droppedBytes, m.packetBytes)
+	m.buf.release()
+}
+
+// next 等待并取出下一条待发送消息（控制消息优先），队列关闭时返回 false
+func (q *sendQueue) next() (outboundMessage, bool) {
+	if m, ok := q.poll(); ok {
+		return m, true
+	}
+
+	select {
+	case m := <-q.control:
+		return m, true
+	case m := <-q.data:
+		return m, true
+	case <-q.done:
+		return outboundMessage{}, false
+	}
+}
+
+// poll 不等待地取出一条已排队的消息（控制消息优先），用于写协程合并写入
+func (q *sendQueue) poll() (outboundMessage, bool) {
+	select {
+	case m := <-q.control:
+		return m, true
+	default:
+	}
+
+	select {
+	case m := <-q.data:
+		return m, true
+	default:
+		return outboundMessage{}, false
+	}
+}
+
+// Close 关闭队列，唤醒写协程和等待中的发送方，未发送的消息被丢弃
+func (q *sendQueue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.done)
+	})
+}
+
+// Len 返回当前排队中的数据消息数
+func (q *sendQueue) Len() int {
+	return len(q.data)
+}
+
+// ControlLen 返回控制消息队列中待发送的消息数
+func (q *sendQueue) ControlLen() int {
+	return len(q.control)
+}
+
+// DropStats 返回丢弃的消息数和IP包字节数
+func (q *sendQueue) DropStats() (messages, bytes uint64) {
+	return atomic.LoadUint64(&q.droppedMessages), atomic.LoadUint64(&q.droppedBytes)
+}
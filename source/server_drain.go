@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ================ 会话排空 ================
+//
+// 排空时服务端关闭监听套接字不再接受新连接，向所有在线会话发送 Goodbye，客户端在随机
+// 延迟后重连（避免所有客户端同时握手），服务端等待会话全部断开或超时后停止。
+// 无中断重启时新进程已接管监听套接字和TUN设备（见 handoff_unix.go 和 tun_handoff_linux.go），客户端重连即连上新进程，
+// 因此交接后使用较短的重连延迟，缩短新旧进程同时读取TUN设备的时间。
+
+const (
+	drainRetryJitter   = 5 * time.Second        // 停止前排空：客户端重连延迟的随机范围
+	handoffRetryJitter = 1 * time.Second        // 交接后排空：客户端重连延迟的随机范围
+	drainPollInterval  = 200 * time.Millisecond // 检查剩余会话数的间隔
+
+	goodbyeGrace          = 2 * time.Second        // 发送 Goodbye 后等待客户端主动断开的时间
+	shutdownNotifyTimeout = 500 * time.Millisecond // 直接停止时等待 Goodbye 发出的最长时间
+	poolFullRetryAfter    = 30 * time.Second       // 地址池已满时建议客户端等待的时间
+	poolFullMaxBackoff    = 5 * time.Minute        // 地址池已满时客户端退避的最长等待时间
+)
+
+// isDraining 是否正在排空会话
+func (s *VPNServer) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// Drain 排空会话后停止服务器：不再接受新连接，通知在线客户端在随机延迟后重连，
+// 等待会话全部断开或 timeout 到期（剩余会话随服务器停止被关闭）
+func (s *VPNServer) Drain(timeout time.Duration) error {
+	return s.drain(GoodbyeReasonDrain, "服务端正在停止", drainRetryJitter, timeout)
+}
+
+// drain 排空会话的实现，reason/message 随 Goodbye 发送给客户端
+func (s *VPNServer) drain(reason, message string, jitter, timeout time.Duration) error {
+	if !atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		return fmt.Errorf("服务端正在排空会话")
+	}
+
+	// 关闭监听套接字，接受循环随后等待服务器停止
+	_ = s.listener.Close()
+	s.closeWebSocket()
+
+	sessions := s.sessions.all()
+	log.Printf("开始排空会话: %d 个在线会话，最长等待 %v", len(sessions), timeout)
+	for _, session := range sessions {
+		if !session.goodbye {
+			s.removeSession(session.ID) // 不支持 Goodbye 的客户端直接断开，自行重连
+			continue
+		}
+		goodbye := &Goodbye{
+			Reason:       reason,
+			Message:      message,
+			RetryAfterMs: mathrand.Intn(int(jitter / time.Millisecond)),
+		}
+		if err := s.sendGoodbye(session, goodbye); err != nil {
+			log.Printf("通知会话 %s 断开失败: %v", session.ID, err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for s.sessions.count() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	if remaining := s.sessions.count(); remaining > 0 {
+		log.Printf("排空超时，关闭剩余的 %d 个会话", remaining)
+	} else {
+		log.Printf("会话已全部排空")
+	}
+
+	s.Stop()
+	return nil
+}
+
+// sendGoodbye 通知客户端断开，客户端收到后关闭连接并在 RetryAfterMs 后重连
+// （客户端不支持 Goodbye 时不发送）
+func (s *VPNServer) sendGoodbye(session *VPNSession, goodbye *Goodbye) error {
+	if !session.goodbye {
+		return nil
+	}
+	data, err := json.Marshal(goodbye)
+	if err != nil {
+		return fmt.Errorf("序列化断开通知失败: %v", err)
+	}
+	return s.sendSessionMessage(session, MessageTypeGoodbye, data, 0)
+}
+
+// disconnectSession 通知客户端断开，客户端未在 goodbyeGrace 内断开时由服务端关闭会话
+// （客户端不支持 Goodbye 时立即关闭）
+func (s *VPNServer) disconnectSession(session *VPNSession, goodbye *Goodbye) {
+	if !session.goodbye {
+		s.removeSession(session.ID)
+		return
+	}
+	if err := s.sendGoodbye(session, goodbye); err != nil {
+		log.Printf("通知会话 %s 断开失败: %v", session.ID, err)
+	}
+	time.AfterFunc(goodbyeGrace, func() {
+		s.removeSession(session.ID)
+	})
+}
+
+// notifyShutdown 服务端直接停止（未排空）前通知所有在线客户端，并短暂等待通知发出
+func (s *VPNServer) notifyShutdown() {
+	sessions := s.sessions.all()
+	if len(sessions) == 0 {
+		return
+	}
+	for _, session := range sessions {
+		goodbye := &Goodbye{
+			Reason:       GoodbyeReasonShutdown,
+			Message:      "服务端已停止",
+			RetryAfterMs: mathrand.Intn(int(drainRetryJitter / time.Millisecond)),
+		}
+		if err := s.sendGoodbye(session, goodbye); err != nil {
+			log.Printf("通知会话 %s 断开失败: %v", session.ID, err)
+		}
+	}
+
+	deadline := time.Now().Add(shutdownNotifyTimeout)
+	for time.Now().Before(deadline) {
+		pending := 0
+		for _, session := range sessions {
+			for _, link := range session.getLinks() {
+				pending += link.sendQueue.ControlLen()
+			}
+		}
+		if pending == 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// rejectConnection 协商后拒绝连接：协商了 goodbye 特性的客户端收到原因和重连建议，其他客户端直接断开
+func (s *VPNServer) rejectConnection(conn tunnelConn, hello *Hello, goodbye *Goodbye) {
+	if hello.HasFeature(FeatureGoodbye) {
+		_ = conn.SetWriteDeadline(time.Now().Add(helloTimeout))
+		if err := writeGoodbye(conn, goodbye); err != nil {
+			log.Printf("通知客户端 %s 拒绝连接失败: %v", conn.RemoteAddr(), err)
+		}
+	}
+	_ = conn.Close()
+}
+
+// sendNotice 向客户端发送通知
+func (s *VPNServer) sendNotice(session *VPNSession, notice *Notice) error {
+	data, err := json.Marshal(notice)
+	if err != nil {
+		return fmt.Errorf("序列化通知失败: %v", err)
+	}
+	return s.sendSessionMessage(session, MessageTypeNotice, data, 0)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ================ 多连接聚合（bonding） ================
+//
+// 一个会话可以由多条TLS连接（成员连接）共同承载，单条TCP流在高延迟链路上的吞吐受拥塞窗口
+// 限制，多条连接并行可以叠加带宽，也可以分别走不同的上行链路（如有线 + LTE）。
+//
+// 建立过程：
+//   1. 客户端和服务端都启用 bonding 特性时，服务端为会话生成随机凭据，随配置推送（bond_token）
+//   2. 客户端另外建立TLS连接，在 Hello 中携带 bond_token，服务端校验凭据和证书CN后
+//      将其加入会话，并在该连接上回复会话的 IPAssignment 作为确认
+//
+// 每条成员连接有自己的发送队列、写协程、批量发送器和序列号状态，互不影响。
+// 数据包按流（源/目标地址、协议、端口）哈希分配到成员连接，同一个流始终走同一条连接，
+// 避免乱序；控制消息走第一条存活的连接。任意成员连接断开时会话继续使用其余连接，
+// 最后一条连接断开时会话才结束。
+
+// sessionLink 会话的一条TLS成员连接
+type sessionLink struct {
+	id        int
+	conn      tunnelConn
+	remote    net.Addr
+	sendQueue *sendQueue
+	batcher   *packetBatcher // 会话启用批量传输时创建，创建后不再修改
+	sendSeq   uint32         // 发送序列号（仅由写协程访问）
+	recvSeq   uint32         // 接收序列号（仅由读协程访问）
+	lastRecv  int64          // 最近一次收到消息的时间（UnixNano，使用 atomic）
+	closeOnce sync.Once
+}
+
+// close 关闭成员连接，停止批量发送器和发送队列
+func (l *sessionLink) close() {
+	l.closeOnce.Do(func() {
+		// 先关闭连接再停止批量发送器和发送队列，避免等待阻塞中的写入
+		_ = l.conn.Close()
+		if l.batcher != nil {
+			l.batcher.Stop()
+		}
+		l.sendQueue.Close()
+	})
+}
+
+// touch 记录收到消息的时间
+func (l *sessionLink) touch() {
+	atomic.StoreInt64(&l.lastRecv, time.Now().UnixNano())
+}
+
+// idle 距离最近一次收到消息的时间
+func (l *sessionLink) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&l.lastRecv)))
+}
+
+// addLink 加入成员连接，会话已关闭或连接数已达到 limit 时返回 false
+func (s *VPNSession) addLink(link *sessionLink, limit int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed || len(s.links) >= limit {
+		return false
+	}
+	s.nextLinkID++
+	link.id = s.nextLinkID
+	s.links = append(s.links, link)
+	return true
+}
+
+// removeLink 移除并关闭成员连接，返回剩余的连接数
+func (s *VPNSession) removeLink(link *sessionLink) int {
+	s.mutex.Lock()
+	for i, l := range s.links {
+		if l == link {
+			s.links = append(s.links[:i:i], s.links[i+1:]...)
+			// 保留已断开连接的丢弃统计
+			messages, bytes := link.sendQueue.DropStats()
+			s.droppedMessages += messages
+			s.droppedBytes += bytes
+			break
+		}
+	}
+	remaining := len(s.links)
+	s.mutex.Unlock()
+
+	link.close()
+	return remaining
+}
+
+// getLinks 返回当前所有成员连接（增删连接时会替换切片，返回值只读且不受后续增删影响）
+func (s *VPNSession) getLinks() []*sessionLink {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.links
+}
+
+// primaryLink 返回承载控制消息的连接（第一条存活的连接），没有连接时返回nil
+func (s *VPNSession) primaryLink() *sessionLink {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if len(s.links) == 0 {
+		return nil
+	}
+	return s.links[0]
+}
+
+// linkForPacket 按流哈希为IP包选择成员连接，没有连接时返回nil
+func (s *VPNSession) linkForPacket(packet []byte) *sessionLink {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	switch len(s.links) {
+	case 0:
+		return nil
+	case 1:
+		return s.links[0]
+	}
+	return s.links[flowHash(packet)%uint32(len(s.links))]
+}
+
+// flushBatchers 立即发送所有成员连接上的待发批次
+func (s *VPNSession) flushBatchers() error {
+	var firstErr error
+	for _, link := range s.getLinks() {
+		if link.batcher != nil {
+			if err := link.batcher.Flush(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// generateBondToken 生成成员连接加入会话的随机凭据
+func generateBondToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成聚合凭据失败: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
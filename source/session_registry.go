@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// ================ 会话注册表 ================
+//
+// 数据路径上每个IP包都要按目标地址查找会话，而会话的增删只在连接建立和断开时发生。
+// 注册表采用写时复制：增删会话时在互斥锁内复制当前快照、修改后原子替换；
+// 查找、遍历和统计直接读取当前快照，不加任何锁，也不会被增删操作阻塞。
+// 快照一经发布就不再修改，读取方可以在持有快照期间安全地遍历。
+
+// sessionSnapshot 会话表的只读快照
+type sessionSnapshot struct {
+	byID        map[string]*VPNSession
+	byIP        map[netip.Addr]*VPNSession // IPv4和IPv6地址都指向所属会话（查找时不分配内存）
+	byUDP       map[uint64]*VPNSession     // UDP会话ID到会话的映射
+	byBondToken map[string]*VPNSession     // 多连接聚合凭据到会话的映射
+}
+
+// sessionRegistry 写时复制的会话注册表
+type sessionRegistry struct {
+	mu      sync.Mutex // 串行化写入
+	current atomic.Pointer[sessionSnapshot]
+}
+
+// newSessionRegistry 创建空的会话注册表
+func newSessionRegistry() *sessionRegistry {
+	r := &sessionRegistry{}
+	r.current.Store(&sessionSnapshot{
+		byID:        make(map[string]*VPNSession),
+		byIP:        make(map[netip.Addr]*VPNSession),
+		byUDP:       make(map[uint64]*VPNSession),
+		byBondToken: make(map[string]*VPNSession),
+	})
+	return r
+}
+
+// snapshot 返回当前快照（只读）
+func (r *sessionRegistry) snapshot() *sessionSnapshot {
+	return r.current.Load()
+}
+
+// clone 复制快照，extra 为新快照预留的容量
+func (snap *sessionSnapshot) clone(extra int) *sessionSnapshot {
+	next := &sessionSnapshot{
+		byID:        make(map[string]*VPNSession, len(snap.byID)+extra),
+		byIP:        make(map[netip.Addr]*VPNSession, len(snap.byIP)+2*extra),
+		byUDP:       make(map[uint64]*VPNSession, len(snap.byUDP)+extra),
+		byBondToken: make(map[string]*VPNSession, len(snap.byBondToken)+extra),
+	}
+	for k, v := range snap.byID {
+		next.byID[k] = v
+	}
+	for k, v := range snap.byIP {
+		next.byIP[k] = v
+	}
+	for k, v := range snap.byUDP {
+		next.byUDP[k] = v
+	}
+	for k, v := range snap.byBondToken {
+		next.byBondToken[k] = v
+	}
+	return next
+}
+
+// add 注册会话，在线会话数已达到 limit 时不注册并返回 false（limit <= 0 表示不限制）
+func (r *sessionRegistry) add(session *VPNSession, limit int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cur := r.current.Load()
+	if limit > 0 && len(cur.byID) >= limit {
+		return false
+	}
+	next := cur.clone(1)
+	next.byID[session.ID] = session
+	next.byIP[ipKey(session.IP)] = session
+	if session.IP6 != nil {
+		next.byIP[ipKey(session.IP6)] = session
+	}
+	if session.udp != nil {
+		next.byUDP[session.udp.sessionID] = session
+	}
+	if session.bondToken != "" {
+		next.byBondToken[session.bondToken] = session
+	}
+	r.current.Store(next)
+	return true
+}
+
+// remove 注销会话，返回被注销的会话（不存在时返回 nil）
+func (r *sessionRegistry) remove(id string) *VPNSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cur := r.current.Load()
+	session, exists := cur.byID[id]
+	if !exists {
+		return nil
+	}
+	next := cur.clone(0)
+	delete(next.byID, id)
+	delete(next.byIP, ipKey(session.IP))
+	if session.IP6 != nil {
+		delete(next.byIP, ipKey(session.IP6))
+	}
+	if session.udp != nil {
+		delete(next.byUDP, session.udp.sessionID)
+	}
+	if session.bondToken != "" {
+		delete(next.byBondToken, session.bondToken)
+	}
+	r.current.Store(next)
+	return session
+}
+
+// get 按会话ID查找
+func (r *sessionRegistry) get(id string) *VPNSession {
+	return r.current.Load().byID[id]
+}
+
+// lookupIP 按隧道地址查找（数据路径使用，无锁）
+func (r *sessionRegistry) lookupIP(addr netip.Addr) *VPNSession {
+	return r.current.Load().byIP[addr]
+}
+
+// lookupUDP 按UDP会话ID查找（数据路径使用，无锁）
+func (r *sessionRegistry) lookupUDP(id uint64) *VPNSession {
+	return r.current.Load().byUDP[id]
+}
+
+// lookupBondToken 按多连接聚合凭据查找
+func (r *sessionRegistry) lookupBondToken(token string) *VPNSession {
+	return r.current.Load().byBondToken[token]
+}
+
+// count 返回在线会话数
+func (r *sessionRegistry) count() int {
+	return len(r.current.Load().byID)
+}
+
+// all 返回当前所有会话
+func (r *sessionRegistry) all() []*VPNSession {
+	snap := r.current.Load()
+	sessions := make([]*VPNSession, 0, len(snap.byID))
+	for _, session := range snap.byID {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+// 会话查找的竞争基准测试：1000 个在线会话，多个协程并行按目标地址查找（数据路径），
+// 对比改造前的读写锁映射与写时复制注册表。Churn 子测试中另有一个协程不断注册和注销会话，
+// 模拟客户端频繁上下线（go test -bench LookupIP -cpu 1,4,8 -run ^$）
+
+// rwMutexSessions 改造前的会话表：全局读写锁保护的IP到会话映射
+type rwMutexSessions struct {
+	mu   sync.RWMutex
+	byIP map[netip.Addr]*VPNSession
+}
+
+func (m *rwMutexSessions) add(session *VPNSession) {
+	m.mu.Lock()
+	m.byIP[ipKey(session.IP)] = session
+	m.mu.Unlock()
+}
+
+func (m *rwMutexSessions) remove(session *VPNSession) {
+	m.mu.Lock()
+	delete(m.byIP, ipKey(session.IP))
+	m.mu.Unlock()
+}
+
+func (m *rwMutexSessions) lookupIP(addr netip.Addr) *VPNSession {
+	m.mu.RLock()
+	session := m.byIP[addr]
+	m.mu.RUnlock()
+	return session
+}
+
+// sessionTable 两种会话表共同的操作
+type sessionTable interface {
+	add(session *VPNSession)
+	remove(session *VPNSession)
+	lookupIP(addr netip.Addr) *VPNSession
+}
+
+// cowSessions 以 sessionTable 接口包装写时复制注册表
+type cowSessions struct {
+	r *sessionRegistry
+}
+
+func (c cowSessions) add(session *VPNSession)              { c.r.add(session, 0) }
+func (c cowSessions) remove(session *VPNSession)           { c.r.remove(session.ID) }
+func (c cowSessions) lookupIP(addr netip.Addr) *VPNSession { return c.r.lookupIP(addr) }
+
+func BenchmarkLookupIP(b *testing.B) {
+	const sessions = 1000
+	addrs := make([]netip.Addr, sessions)
+	all := make([]*VPNSession, sessions)
+	for i := range all {
+		all[i] = &VPNSession{ID: fmt.Sprintf("bench-%d", i), IP: benchSessionIP(i)}
+		addrs[i] = ipKey(all[i].IP)
+	}
+	// 上下线的会话不与查找的会话重叠
+	churner := &VPNSession{ID: "bench-churn", IP: benchSessionIP(sessions)}
+
+	tables := []struct {
+		name string
+		new  func() sessionTable
+	}{
+		{"RWMutex", func() sessionTable { return &rwMutexSessions{byIP: make(map[netip.Addr]*VPNSession)} }},
+		{"CopyOnWrite", func() sessionTable { return cowSessions{newSessionRegistry()} }},
+	}
+
+	for _, churn := range []bool{false, true} {
+		for _, tc := range tables {
+			name := tc.name
+			if churn {
+				name += "/Churn"
+			}
+			b.Run(name, func(b *testing.B) {
+				table := tc.new()
+				for _, session := range all {
+					table.add(session)
+				}
+
+				stop := make(chan struct{})
+				var wg sync.WaitGroup
+				if churn {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for {
+							select {
+							case <-stop:
+								return
+							default:
+							}
+							table.add(churner)
+							table.remove(churner)
+						}
+					}()
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := 0
+					for pb.Next() {
+						if table.lookupIP(addrs[i%sessions]) == nil {
+							b.Error("会话不存在")
+							return
+						}
+						i += 7
+					}
+				})
+				b.StopTimer()
+				close(stop)
+				wg.Wait()
+			})
+		}
+	}
+}
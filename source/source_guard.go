@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"sync/atomic"
+	"time"
+)
+
+// ================ 源地址校验 ================
+//
+// 客户端发来的IP包的源地址必须是服务端分配给该会话的隧道地址（IPv4或IPv6），
+// 或 client_subnets 中为该客户端证书CN授权的网段（如客户端身后的局域网），否则丢弃，
+// 防止客户端冒充其他客户端或向服务端所在网络注入任意源地址。
+//
+// 源地址为链路本地或未指定地址的包（如IPv6邻居发现、DHCP）直接丢弃，不计为违规。
+// 其余违规按会话计数并记录日志（每个会话每 spoofLogInterval 最多一条），
+// 累计达到 spoof_disconnect_threshold 时通知客户端后断开会话。
+
+const (
+	spoofLogInterval = 10 * time.Second // 同一会话记录违规日志的最小间隔
+	spoofRetryAfter  = 30 * time.Second // 因源地址校验断开后建议客户端等待的时间
+)
+
+// ClientSubnetRule 允许某个客户端作为源地址使用的网段（隧道地址之外）
+type ClientSubnetRule struct {
+	CN      string   `json:"cn"`
+	Subnets []string `json:"subnets"` // CIDR，IPv4 或 IPv6
+}
+
+// validate 检查规则格式
+func (r ClientSubnetRule) validate() error {
+	if r.CN == "" {
+		return fmt.Errorf("未指定证书CN")
+	}
+	for _, subnet := range r.Subnets {
+		if _, err := netip.ParsePrefix(subnet); err != nil {
+			return fmt.Errorf("无效的网段: %s", subnet)
+		}
+	}
+	return nil
+}
+
+// sourceGuard 会话的源地址校验状态
+type sourceGuard struct {
+	subnets    []netip.Prefix // 隧道地址之外允许的源网段
+	violations uint64         // 违规包数（使用 atomic）
+	lastLog    int64          // 最近一次记录违规日志的时间（UnixNano，使用 atomic）
+	tripped    int32          // 已因违规断开（使用 atomic，1=true）
+}
+
+// newClientSubnets 按证书CN整理授权网段（配置已验证，无效网段跳过）
+func newClientSubnets(config *VPNConfig) map[string][]netip.Prefix {
+	subnets := make(map[string][]netip.Prefix, len(config.ClientSubnets))
+	for _, rule := range config.ClientSubnets {
+		for _, subnet := range rule.Subnets {
+			if prefix, err := netip.ParsePrefix(subnet); err == nil {
+				subnets[rule.CN] = append(subnets[rule.CN], prefix.Masked())
+			}
+		}
+	}
+	return subnets
+}
+
+// packetSrcAddr 提取IP包的源地址，无法解析时返回无效地址
+func packetSrcAddr(packet []byte) netip.Addr {
+	switch packetIPVersion(packet) {
+	case 4:
+		return netip.AddrFrom4([4]byte(packet[12:16]))
+	case 6:
+		return netip.AddrFrom16([16]byte(packet[8:24]))
+	}
+	return netip.Addr{}
+}
+
+// ================ 服务端 ================
+
+// sourceAllowed 校验会话发来的数据包的源地址，不合法时返回 false（调用方丢弃）
+func (s *VPNServer) sourceAllowed(session *VPNSession, packet []byte) bool {
+	src := packetSrcAddr(packet)
+	if !src.IsValid() {
+		return false
+	}
+	if src == ipKey(session.IP) || (session.IP6 != nil && src == ipKey(session.IP6)) {
+		return true
+	}
+	for _, prefix := range session.source.subnets {
+		if prefix.Contains(src) {
+			return true
+		}
+	}
+	if src.IsLinkLocalUnicast() || src.IsUnspecified() {
+		return false
+	}
+
+	guard := &session.source
+	count := atomic.AddUint64(&guard.violations, 1)
+	now := time.Now().UnixNano()
+	if last := atomic.LoadInt64(&guard.lastLog); now-last >= int64(spoofLogInterval) &&
+		atomic.CompareAndSwapInt64(&guard.lastLog, last, now) {
+		log.Printf("会话 %s (IP: %s, Cert: %s) 发送了源地址为 %s 的数据包，已丢弃（累计 %d 个）",
+			session.ID, session.IP, session.CertSubject, src, count)
+	}
+
+	threshold := atomic.LoadInt64(&s.spoofLimit)
+	if threshold > 0 && count >= uint64(threshold) && atomic.CompareAndSwapInt32(&guard.tripped, 0, 1) {
+		log.Printf("会话 %s (Cert: %s) 伪造源地址的数据包达到 %d 个，断开会话", session.ID, session.CertSubject, count)
+		s.disconnectSession(session, &Goodbye{
+			Reason:       GoodbyeReasonSpoof,
+			Message:      fmt.Sprintf("发送了 %d 个源地址不属于本客户端的数据包", count),
+			RetryAfterMs: int(spoofRetryAfter / time.Millisecond),
+		})
+	}
+	return false
+}
+
+// SetSpoofDisconnectThreshold 修改断开会话的伪造源地址包数，立即作用于所有在线会话
+func (s *VPNServer) SetSpoofDisconnectThreshold(config *VPNConfig) {
+	atomic.StoreInt64(&s.spoofLimit, int64(config.GetSpoofDisconnectThreshold()))
+}
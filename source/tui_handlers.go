@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -44,6 +45,41 @@ func handleServerStop(t *TUIApp) {
 	}()
 }
 
+func handleServerDrain(t *TUIApp) {
+	t.showConfirmDialog("通知所有客户端重连并在会话排空后停止服务端?", func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		resp, err := t.client.ServerDrain(0)
+		if err != nil {
+			t.addLog("[red]排空失败: %v", err)
+		} else if resp.Success {
+			t.addLog("[green]%s", resp.Message)
+		} else {
+			t.addLog("[red]%s", resp.Error)
+		}
+	})
+}
+
+func handleServerUpgrade(t *TUIApp) {
+	t.showConfirmDialog("启动新进程接管服务? (使用当前程序文件和配置文件)", func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		t.addLog("正在启动新进程接管服务...")
+		go func() {
+			resp, err := t.client.ServerUpgrade()
+			if err != nil {
+				t.addLog("[red]重启失败: %v", err)
+			} else if resp.Success {
+				t.addLog("[green]%s", resp.Message)
+			} else {
+				t.addLog("[red]%s", resp.Error)
+			}
+		}()
+	})
+}
+
 func handleShowClients(t *TUIApp) {
 	clients, err := t.client.ServerClients()
 	if err != nil {
@@ -58,15 +94,19 @@ func handleShowClients(t *TUIApp) {
 
 	var content strings.Builder
 	content.WriteString(fmt.Sprintf("在线客户端数量: [green]%d[white]\n\n", len(clients)))
-	content.WriteString("┌────┬──────────────┬──────────────┬──────────────┬──────────┐\n")
-	content.WriteString("│ #  │ IP地址       │ 发送流量     │ 接收流量     │ 连接时间 │\n")
-	content.WriteString("├────┼──────────────┼──────────────┼──────────────┼──────────┤\n")
+	content.WriteString("┌────┬──────────────┬──────────────┬──────────────┬──────────┬──────────┐\n")
+	content.WriteString("│ #  │ IP地址       │ 发送流量     │ 接收流量     │ 延迟     │ 连接时间 │\n")
+	content.WriteString("├────┼──────────────┼──────────────┼──────────────┼──────────┼──────────┤\n")
 
 	for i, c := range clients {
-		content.WriteString(fmt.Sprintf("│ %2d │ %-12s │ %12s │ %12s │ %8s │\n",
-			i+1, c.IP, formatBytes(c.BytesSent), formatBytes(c.BytesReceived), c.Duration))
+		rtt := "-"
+		if c.Measured {
+			rtt = fmt.Sprintf("%.1fms", c.RTTMs)
+		}
+		content.WriteString(fmt.Sprintf("│ %2d │ %-12s │ %12s │ %12s │ %8s │ %8s │\n",
+			i+1, c.IP, formatBytes(c.BytesSent), formatBytes(c.BytesReceived), rtt, c.Duration))
 	}
-	content.WriteString("└────┴──────────────┴──────────────┴──────────────┴──────────┘")
+	content.WriteString("└────┴──────────────┴──────────────┴──────────────┴──────────┴──────────┘")
 
 	t.showInfoDialog("在线客户端", content.String())
 }
@@ -120,14 +160,111 @@ func handleShowStats(t *TUIApp) {
 	if len(clients) > 0 {
 		content.WriteString("客户端流量详情:\n")
 		for _, c := range clients {
-			content.WriteString(fmt.Sprintf("  %s: ↑%s ↓%s\n",
-				c.IP, formatBytes(c.BytesSent), formatBytes(c.BytesReceived)))
+			content.WriteString(fmt.Sprintf("  %s: ↑%s ↓%s (线路 ↑%s ↓%s)\n",
+				c.IP, formatBytes(c.BytesSent), formatBytes(c.BytesReceived),
+				formatBytes(c.WireSent), formatBytes(c.WireReceived)))
+			if c.Links > 1 {
+				content.WriteString(fmt.Sprintf("    聚合连接: %d 条\n", c.Links))
+			}
+			if c.PathMTU > 0 {
+				content.WriteString(fmt.Sprintf("    路径MTU: %d\n", c.PathMTU))
+			}
+			if c.Measured {
+				quality := formatLinkQuality(c.RTTMs, c.JitterMs, c.HeartbeatLoss)
+				if c.HeartbeatLoss > 0 {
+					quality = "[yellow]" + quality + "[white]"
+				}
+				content.WriteString(fmt.Sprintf("    链路质量: %s\n", quality))
+			}
+			if c.RateUp > 0 || c.RateDown > 0 {
+				content.WriteString(fmt.Sprintf("    当前速率: 上行 %s/s, 下行 %s/s\n",
+					formatBytes(c.RateUp), formatBytes(c.RateDown)))
+			}
+			if c.LimitUpKbps > 0 || c.LimitDownKbps > 0 {
+				content.WriteString(fmt.Sprintf("    限速: 上行 %s, 下行 %s\n",
+					formatKbps(c.LimitUpKbps), formatKbps(c.LimitDownKbps)))
+			}
+			if c.ThrottledUp > 0 || c.ThrottledDown > 0 {
+				content.WriteString(fmt.Sprintf("    [yellow]超出限速: 上行 %d 个包/%s (累计推迟 %s), 下行丢弃 %d 个包/%s[white]\n",
+					c.ThrottledUp, formatBytes(c.ThrottledUpBytes),
+					time.Duration(c.ThrottleDelayUpMs*float64(time.Millisecond)).Round(time.Millisecond),
+					c.ThrottledDown, formatBytes(c.ThrottledDownBytes)))
+			}
+			if c.ACLDenied > 0 {
+				content.WriteString(fmt.Sprintf("    [yellow]ACL丢弃: %d 个包[white]\n", c.ACLDenied))
+			}
+			if c.Spoofed > 0 {
+				content.WriteString(fmt.Sprintf("    [red]伪造源地址: %d 个包[white]\n", c.Spoofed))
+			}
+			if c.Dropped > 0 {
+				content.WriteString(fmt.Sprintf("    [yellow]发送队列丢弃: %d 个消息 (%s)[white]\n",
+					c.Dropped, formatBytes(c.DroppedBytes)))
+			}
 		}
 	}
 
 	t.showInfoDialog("流量统计", content.String())
 }
 
+func handleShowQuotas(t *TUIApp) {
+	result, err := t.client.ServerQuotas()
+	if err != nil {
+		t.showInfoDialog("流量配额", "获取失败: "+err.Error())
+		return
+	}
+
+	if len(result.Quotas) == 0 {
+		t.showInfoDialog("流量配额", "暂无配额记录")
+		return
+	}
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("超出配额处理: [green]%s[white]\n\n", result.Action))
+	for i, q := range result.Quotas {
+		state := "[gray]离线"
+		if q.Online {
+			state = "[green]在线"
+		}
+		limit := "不限"
+		if q.LimitBytes > 0 {
+			limit = formatBytes(q.LimitBytes)
+		}
+		content.WriteString(fmt.Sprintf("%2d. %-20s %s[white]  已用: %s / %s\n",
+			i+1, q.CN, state, formatBytes(q.BytesUp+q.BytesDown), limit))
+		content.WriteString(fmt.Sprintf("    ↑%s ↓%s  周期: %s ~ %s\n",
+			formatBytes(q.BytesUp), formatBytes(q.BytesDown),
+			q.PeriodStart.Format("01-02 15:04"), q.PeriodEnd.Format("01-02 15:04")))
+		if q.Exceeded {
+			content.WriteString("    [red]已超出配额[white]\n")
+		}
+	}
+
+	t.showInfoDialog("流量配额", content.String())
+}
+
+func handleShowACL(t *TUIApp) {
+	result, err := t.client.ACLList()
+	if err != nil {
+		t.showInfoDialog("访问控制", "获取失败: "+err.Error())
+		return
+	}
+
+	var content strings.Builder
+	if len(result.Rules) == 0 {
+		content.WriteString("暂无ACL规则\n\n")
+	}
+	for _, r := range result.Rules {
+		color := "[green]"
+		if r.Action == ACLActionDeny {
+			color = "[red]"
+		}
+		content.WriteString(fmt.Sprintf("%2d. %s%s[white]  命中: %d\n", r.Index, color, r.ACLRule, r.Hits))
+	}
+	content.WriteString(fmt.Sprintf("默认动作: [green]%s[white]  命中: %d\n", result.Default, result.DefaultHits))
+
+	t.showInfoDialog("访问控制", content.String())
+}
+
 // ================ 服务端设置处理 ================
 
 func handleSetPort(t *TUIApp) {
@@ -207,6 +344,52 @@ func handleSetMaxConnections(t *TUIApp) {
 	})
 }
 
+func handleSetClientToClient(t *TUIApp) {
+	cfg, _ := t.client.ConfigGet()
+	// 在 allow -> deny -> same-group-only 之间循环切换
+	next := ClientToClientDeny
+	switch cfg.GetClientToClient() {
+	case ClientToClientDeny:
+		next = ClientToClientSameGroup
+	case ClientToClientSameGroup:
+		next = ClientToClientAllow
+	}
+	resp, _ := t.client.ConfigUpdate("client_to_client", next)
+	if resp != nil && resp.Success {
+		t.addLog("[green]客户端互访策略已设置为: %s（重启服务端后生效）", next)
+	}
+	t.showMenu("server_settings")
+}
+
+func handleSetSendQueuePolicy(t *TUIApp) {
+	cfg, _ := t.client.ConfigGet()
+	// 在 drop-newest -> drop-oldest -> disconnect 之间循环切换
+	next := SendQueueDropOldest
+	switch cfg.GetSendQueuePolicy() {
+	case SendQueueDropOldest:
+		next = SendQueueDisconnect
+	case SendQueueDisconnect:
+		next = SendQueueDropNewest
+	}
+	resp, _ := t.client.ConfigUpdate("send_queue_policy", next)
+	if resp != nil && resp.Success {
+		t.addLog("[green]发送队列溢出策略已设置为: %s（重启服务端后生效）", next)
+	}
+	t.showMenu("server_settings")
+}
+
+func handleToggleUDP(t *TUIApp) {
+	cfg, _ := t.client.ConfigGet()
+	newValue := !cfg.EnableUDP
+	t.client.ConfigUpdate("enable_udp", newValue)
+	if newValue {
+		t.addLog("[green]UDP数据通道已启用（重启服务端后生效）")
+	} else {
+		t.addLog("[yellow]UDP数据通道已禁用（重启服务端后生效）")
+	}
+	t.showMenu("server_settings")
+}
+
 func handleSetRouteModeFull(t *TUIApp) {
 	t.client.ConfigUpdate("route_mode", "full")
 	t.client.ConfigUpdate("enable_nat", true)
@@ -576,6 +759,84 @@ func handleCleanupTokens(t *TUIApp) {
 	t.showMenu("token")
 }
 
+// ================ IP租约处理 ================
+
+func handleShowLeases(t *TUIApp) {
+	leases, err := t.client.LeaseList()
+	if err != nil {
+		t.showInfoDialog("IP租约", "获取失败: "+err.Error())
+		return
+	}
+
+	if len(leases) == 0 {
+		t.showInfoDialog("IP租约", "暂无IP租约")
+		return
+	}
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("租约数量: [green]%d[white]\n\n", len(leases)))
+	for i, lease := range leases {
+		state := "[gray]离线"
+		if lease.Online {
+			state = "[green]在线"
+		}
+		expires := "永久"
+		if !lease.Static {
+			expires = lease.ExpiresAt.Format("2006-01-02 15:04")
+		}
+		content.WriteString(fmt.Sprintf("%2d. %-20s %-15s %s[white]  到期: %s\n",
+			i+1, lease.Key, lease.IP, state, expires))
+		if lease.IP6 != "" {
+			content.WriteString(fmt.Sprintf("    IPv6: %s\n", lease.IP6))
+		}
+	}
+
+	t.showInfoDialog("IP租约", content.String())
+}
+
+func handleSetLease(t *TUIApp) {
+	t.showInputDialog("客户端证书CN（或序列号）", "", func(key string) {
+		if key == "" {
+			t.addLog("[red]租约key不能为空")
+			return
+		}
+		t.showInputDialog("固定IPv4地址", "", func(ip string) {
+			resp, err := t.client.LeaseSet(SetLeaseRequest{Key: key, IP: ip, Static: true})
+			if err != nil {
+				t.addLog("[red]设置失败: %v", err)
+			} else if resp.Success {
+				t.addLog("[green]%s（客户端重新连接后生效）", resp.Message)
+			} else {
+				t.addLog("[red]%s", resp.Error)
+			}
+			t.showMenu("lease")
+		})
+	})
+}
+
+func handleDeleteLease(t *TUIApp) {
+	leases, _ := t.client.LeaseList()
+	if len(leases) == 0 {
+		t.addLog("[yellow]暂无IP租约可删除")
+		return
+	}
+
+	t.showInputDialog("输入要删除租约的客户端证书CN（或序列号）", leases[0].Key, func(key string) {
+		if key == "" {
+			return
+		}
+		resp, err := t.client.LeaseDelete(key)
+		if err != nil {
+			t.addLog("[red]删除失败: %v", err)
+		} else if resp.Success {
+			t.addLog("[green]%s", resp.Message)
+		} else {
+			t.addLog("[red]%s", resp.Error)
+		}
+		t.showMenu("lease")
+	})
+}
+
 // ================ 客户端处理 ================
 
 func handleClientConnect(t *TUIApp) {
@@ -625,11 +886,41 @@ func handleShowClientStatus(t *TUIApp) {
 		if status.AssignedIP != "" {
 			content.WriteString(fmt.Sprintf("VPN IP: [green]%s[white]\n", status.AssignedIP))
 		}
+		if status.AssignedIP6 != "" {
+			content.WriteString(fmt.Sprintf("VPN IPv6: [green]%s[white]\n", status.AssignedIP6))
+		}
 		if status.TUNDevice != "" {
 			content.WriteString(fmt.Sprintf("TUN设备: %s\n", status.TUNDevice))
 		}
+		if status.DataChannel != "" {
+			content.WriteString(fmt.Sprintf("数据通道: %s\n", strings.ToUpper(status.DataChannel)))
+		}
+		if status.Links > 1 {
+			content.WriteString(fmt.Sprintf("聚合连接: %d 条\n", status.Links))
+		}
+		if status.MTU > 0 {
+			content.WriteString(fmt.Sprintf("隧道MTU: %d\n", status.MTU))
+		}
+		if status.Measured {
+			quality := formatLinkQuality(status.RTTMs, status.JitterMs, status.HeartbeatLoss)
+			if status.HeartbeatLoss > 0 {
+				quality = "[yellow]" + quality + "[white]"
+			}
+			content.WriteString(fmt.Sprintf("链路质量: %s\n", quality))
+		}
+		if status.ProtocolVersion > 0 {
+			content.WriteString(fmt.Sprintf("协议版本: %d (特性: %s)\n",
+				status.ProtocolVersion, strings.Join(status.Features, ", ")))
+		}
+		if status.Notice != "" {
+			content.WriteString(fmt.Sprintf("[yellow]服务端通知: %s[white]\n", status.Notice))
+		}
 	}
-	content.WriteString(fmt.Sprintf("服务器: %s:%d\n", status.ServerAddress, status.ServerPort))
+	if status.DisconnectReason != "" {
+		content.WriteString(fmt.Sprintf("[yellow]上次断开: %s (%s)[white]\n",
+			goodbyeReasonName(status.DisconnectReason), status.DisconnectMessage))
+	}
+	content.WriteString(fmt.Sprintf("服务器: %s\n", net.JoinHostPort(status.ServerAddress, fmt.Sprintf("%d", status.ServerPort))))
 
 	t.showInfoDialog("客户端状态", content.String())
 }
@@ -664,19 +955,24 @@ func handleGenCSR(t *TUIApp) {
 			t.addLog("[red]客户端名称不能为空")
 			return
 		}
-		t.addLog("正在生成CSR...")
-		go func() {
-			resp, err := t.client.CertGenCSR(clientName)
-			if err != nil {
-				t.addLog("[red]生成CSR失败: %v", err)
-				return
-			}
-			t.addLog("[green]========== CSR生成成功 ==========")
-			t.addLog("私钥文件: %s", resp.KeyFile)
-			t.addLog("CSR文件:  %s", resp.CSRFile)
-			t.addLog("CN:       %s", resp.CN)
-			t.addLog("[green]=================================")
-		}()
+		t.showInputDialogWithID("csr_group", "请输入客户端分组（可留空）", "", func(group string) {
+			t.addLog("正在生成CSR...")
+			go func() {
+				resp, err := t.client.CertGenCSR(clientName, group)
+				if err != nil {
+					t.addLog("[red]生成CSR失败: %v", err)
+					return
+				}
+				t.addLog("[green]========== CSR生成成功 ==========")
+				t.addLog("私钥文件: %s", resp.KeyFile)
+				t.addLog("CSR文件:  %s", resp.CSRFile)
+				t.addLog("CN:       %s", resp.CN)
+				if group != "" {
+					t.addLog("分组:     %s", group)
+				}
+				t.addLog("[green]=================================")
+			}()
+		})
 	})
 }
 
@@ -877,8 +1173,29 @@ func handleShowConfig(t *TUIApp) {
 	content.WriteString(fmt.Sprintf("  服务器地址:     %s\n", cfg.ServerAddress))
 	content.WriteString(fmt.Sprintf("  服务器端口:     %d\n", cfg.ServerPort))
 	content.WriteString(fmt.Sprintf("  VPN网段:        %s\n", cfg.Network))
+	if cfg.Network6 != "" {
+		content.WriteString(fmt.Sprintf("  IPv6网段:       %s\n", cfg.Network6))
+	}
 	content.WriteString(fmt.Sprintf("  服务器IP:       %s\n", cfg.ServerIP))
 	content.WriteString(fmt.Sprintf("  MTU:            %d\n", cfg.MTU))
+	content.WriteString(fmt.Sprintf("  MSS钳制:        %v\n", !cfg.DisableMSSClamp))
+	content.WriteString(fmt.Sprintf("  UDP数据通道:    %v (端口: %d)\n", cfg.EnableUDP, cfg.GetUDPPort()))
+	content.WriteString(fmt.Sprintf("  批量传输:       %v (窗口: %v, 上限: %d字节)\n", cfg.EnableBatching, cfg.GetBatchDelay(), cfg.GetBatchMaxBytes()))
+	content.WriteString(fmt.Sprintf("  数据包压缩:     %v\n", cfg.EnableCompression))
+	content.WriteString(fmt.Sprintf("  客户端互访:     %s\n", cfg.GetClientToClient()))
+	content.WriteString(fmt.Sprintf("  发送队列:       %d (溢出策略: %s)\n", cfg.GetSendQueueSize(), cfg.GetSendQueuePolicy()))
+	content.WriteString(fmt.Sprintf("  TUN队列数:      %d\n", cfg.GetTUNQueues()))
+	content.WriteString(fmt.Sprintf("  TUN offload:    %v\n", cfg.TUNOffload))
+	transport := cfg.GetTransport()
+	if transport == TransportWebSocket && cfg.ProxyURL != "" {
+		if proxy, err := url.Parse(cfg.ProxyURL); err == nil {
+			transport += " (代理: " + proxy.Redacted() + ")"
+		}
+	}
+	content.WriteString(fmt.Sprintf("  传输方式:       %s\n", transport))
+	if cfg.WebSocketPort > 0 {
+		content.WriteString(fmt.Sprintf("  WebSocket服务:  端口 %d, 路径 %s\n", cfg.WebSocketPort, cfg.GetWebSocketPath()))
+	}
 
 	content.WriteString("\n[yellow]路由配置:[white]\n")
 	content.WriteString(fmt.Sprintf("  路由模式:       %s\n", cfg.RouteMode))
@@ -892,6 +1209,19 @@ func handleShowConfig(t *TUIApp) {
 	content.WriteString(fmt.Sprintf("  最大连接数:     %d\n", cfg.MaxConnections))
 	content.WriteString(fmt.Sprintf("  会话超时:       %v\n", cfg.SessionTimeout))
 	content.WriteString(fmt.Sprintf("  心跳超时:       %v\n", cfg.KeepAliveTimeout))
+	content.WriteString(fmt.Sprintf("  IP租约:         %s (有效期: %v, 绑定: %s)\n", cfg.GetLeaseFile(), cfg.GetLeaseDuration(), cfg.GetLeaseKey()))
+	content.WriteString(fmt.Sprintf("  排空超时:       %v\n", cfg.GetDrainTimeout()))
+	content.WriteString(fmt.Sprintf("  默认限速:       上行 %s, 下行 %s (规则: %d 条)\n",
+		formatKbps(cfg.RateLimitUpKbps), formatKbps(cfg.RateLimitDownKbps), len(cfg.RateLimits)))
+	quota := "不限"
+	if cfg.QuotaDefaultMB > 0 {
+		quota = fmt.Sprintf("%d MB", cfg.QuotaDefaultMB)
+	}
+	content.WriteString(fmt.Sprintf("  流量配额:       %s/%s (超出: %s, 规则: %d 条)\n",
+		quota, quotaPeriodName(cfg.GetQuotaPeriod()), cfg.GetQuotaAction(), len(cfg.Quotas)))
+	content.WriteString(fmt.Sprintf("  访问控制:       默认 %s (规则: %d 条)\n", cfg.GetACLDefault(), len(cfg.ACLRules)))
+	content.WriteString(fmt.Sprintf("  源地址校验:     %d 个包后断开 (授权网段: %d 条)\n",
+		cfg.GetSpoofDisconnectThreshold(), len(cfg.ClientSubnets)))
 
 	t.showInfoDialog("当前配置", content.String())
 }
@@ -1250,7 +1580,8 @@ func handleClientWizard(t *TUIApp) {
 			"示例:\n"+
 			"  • 192.168.1.100 (局域网IP)\n"+
 			"  • vpn.example.com (域名)\n"+
-			"  • 1.2.3.4 (公网IP)",
+			"  • 1.2.3.4 (公网IP)\n"+
+			"  • 2001:db8::1 (IPv6地址，无需方括号)",
 		cfg.ServerAddress,
 		func(addr string) {
 			if addr == "" {
@@ -1369,7 +1700,7 @@ func startClientCertApplication(t *TUIApp, serverAddr string, serverPort int) {
 
 			// 生成CSR
 			t.addLog("[cyan]→ 正在生成证书签名请求(CSR)...")
-			csrResp, err := t.client.CertGenCSR(clientName)
+			csrResp, err := t.client.CertGenCSR(clientName, "")
 			if err != nil {
 				t.addLog("[red]✗ CSR生成失败: %v", err)
 				return
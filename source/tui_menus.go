@@ -44,6 +44,21 @@ func GetMenus() map[string]MenuDef {
 				{"⬢ 查看在线客户端", "显示当前连接的客户端", '6', "", handleShowClients},
 				{"⊗ 踢出客户端", "断开指定客户端连接", '7', "", handleKickClient},
 				{"▤ 流量统计", "查看流量统计信息", '8', "", handleShowStats},
+				{"▦ IP租约管理", "查看/固定客户端地址", '9', "lease", nil},
+				{"◔ 流量配额", "查看各客户端本周期用量", 'a', "", handleShowQuotas},
+				{"⛨ 访问控制", "查看ACL规则及命中次数", 'c', "", handleShowACL},
+				{"◌ 排空后停止", "通知客户端重连，会话断开后停止", 'd', "", handleServerDrain},
+				{"⟳ 无中断重启", "新进程接管监听端口和TUN设备", 'u', "", handleServerUpgrade},
+			},
+		},
+
+		"lease": {
+			Title:  "▦ IP租约管理",
+			Parent: "server",
+			Items: []MenuItem{
+				{"▣ 查看IP租约", "显示证书与地址的绑定", '1', "", handleShowLeases},
+				{"✦ 设置固定地址", "为客户端证书指定静态地址", '2', "", handleSetLease},
+				{"⊗ 删除IP租约", "解除证书与地址的绑定", '3', "", handleDeleteLease},
 			},
 		},
 
@@ -57,6 +72,9 @@ func GetMenus() map[string]MenuDef {
 				{"➤ 路由模式设置", "配置流量路由策略", '4', "route_mode", nil},
 				{"◎ 修改NAT出口网卡", "配置NAT出口", '5', "", handleSetNATInterface},
 				{"◎ 修改最大连接数", "限制并发连接", '6', "", handleSetMaxConnections},
+				{"↻ 切换UDP数据通道", "启用/禁用UDP数据传输", '7', "", handleToggleUDP},
+				{"↻ 切换客户端互访策略", "允许/禁止/仅同组互访", '8', "", handleSetClientToClient},
+				{"↻ 切换发送队列溢出策略", "丢弃新包/丢弃旧包/断开客户端", '9', "", handleSetSendQueuePolicy},
 			},
 		},
 
@@ -11,8 +11,26 @@ import (
 	"github.com/songgao/water"
 )
 
-// createTUNDevice 创建TUN设备（Unix/Linux版本）
-func createTUNDevice(baseName string, network string) (TUNDevice, error) {
+// createTUNDevice 创建TUN设备（Unix/Linux版本，queues > 1 时在Linux上启用多队列，
+// offload 为 true 时在Linux上启用GSO/GRO offload，失败则回退到普通设备）
+func createTUNDevice(baseName string, network string, queues int, offload bool) (TUNDevice, error) {
+	if offload {
+		if queues > 1 {
+			log.Printf("警告：TUN offload 不支持多队列，使用单队列")
+		}
+		// MTU在 configureTUNDevice 中重新设置
+		dev, err := openOffloadTUN(baseName, 1500)
+		if err == nil {
+			if bd, ok := asBatchTUN(dev); ok {
+				log.Printf("成功创建TUN设备: %s (GSO/GRO offload，批量 %d)", dev.Name(), bd.BatchSize())
+			} else {
+				log.Printf("成功创建TUN设备: %s (内核不支持offload，逐包读写)", dev.Name())
+			}
+			return dev, nil
+		}
+		log.Printf("警告：启用TUN offload失败，使用普通TUN设备: %v", err)
+	}
+
 	config := water.Config{
 		DeviceType: water.TUN,
 	}
@@ -22,12 +40,16 @@ func createTUNDevice(baseName string, network string) (TUNDevice, error) {
 		config.Name = baseName
 	}
 
-	iface, err := water.New(config)
+	iface, err := openTUNQueues(config, queues)
 	if err != nil {
 		return nil, fmt.Errorf("创建TUN设备失败: %v", err)
 	}
 
-	log.Printf("成功创建TUN设备: %s", iface.Name())
+	if n := len(tunQueues(iface)); n > 1 {
+		log.Printf("成功创建TUN设备: %s (%d 个队列)", iface.Name(), n)
+	} else {
+		log.Printf("成功创建TUN设备: %s", iface.Name())
+	}
 	
 	// water.Interface自动实现TUNDevice接口
 	return iface, nil
@@ -57,6 +79,26 @@ func configureTUNDevice(ifaceName string, ipAddr string, mtu int) error {
 	return nil
 }
 
+// setTUNMTU 调整TUN设备的MTU（Unix/Linux版本，用于路径MTU探测之后）
+func setTUNMTU(ifaceName string, mtu int) error {
+	output, err := exec.Command("ip", "link", "set", "dev", ifaceName, "mtu", fmt.Sprintf("%d", mtu)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("设置MTU失败: %v, 输出: %s", err, string(output))
+	}
+	log.Printf("TUN设备 %s MTU已调整为 %d", ifaceName, mtu)
+	return nil
+}
+
+// addTUNAddress 为TUN设备添加额外的地址（Unix/Linux版本，用于IPv6地址）
+func addTUNAddress(ifaceName string, ipAddr string) error {
+	output, err := exec.Command("ip", "addr", "add", ipAddr, "dev", ifaceName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("添加地址失败: %v, 输出: %s", err, string(output))
+	}
+	log.Printf("TUN设备 %s 已添加地址: %s", ifaceName, ipAddr)
+	return nil
+}
+
 // cleanupTUNDevice 清理TUN设备（Unix/Linux版本）
 func cleanupTUNDevice(ifaceName string) {
 	log.Printf("清理TUN设备: %s", ifaceName)
@@ -90,3 +132,14 @@ func enableIPForwarding() error {
 	log.Println("已启用IP转发")
 	return nil
 }
+
+// enableIPv6Forwarding 启用IPv6转发（Unix/Linux版本）
+func enableIPv6Forwarding(ifaceName string) error {
+	output, err := exec.Command("sysctl", "-w", "net.ipv6.conf.all.forwarding=1").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("启用IPv6转发失败: %v, 输出: %s", err, string(output))
+	}
+
+	log.Println("已启用IPv6转发")
+	return nil
+}
@@ -80,8 +80,14 @@ func checkRootPrivileges() error {
 }
 
 // createTUNDevice 创建TUN设备（Windows版本 - 使用 Wintun）
-func createTUNDevice(baseName string, network string) (TUNDevice, error) {
+func createTUNDevice(baseName string, network string, queues int, offload bool) (TUNDevice, error) {
 	log.Println("使用Wintun驱动创建TUN设备...")
+	if queues > 1 {
+		log.Printf("警告：Wintun不支持多队列，使用单队列")
+	}
+	if offload {
+		log.Printf("警告：Wintun不支持GSO/GRO offload，逐包读写")
+	}
 
 	// 如果没有提供网络配置，使用默认值
 	if network == "" {
@@ -165,6 +171,31 @@ func configureTUNDevice(ifaceName string, ipAddr string, mtu int) error {
 	return nil
 }
 
+// setTUNMTU 调整TUN设备的MTU（Windows版本，用于路径MTU探测之后）
+func setTUNMTU(ifaceName string, mtu int) error {
+	output, err := runCmdCombined("netsh", "interface", "ipv4", "set", "subinterface",
+		ifaceName, fmt.Sprintf("mtu=%d", mtu), "store=active")
+	if err != nil {
+		return fmt.Errorf("设置MTU失败: %v, 输出: %s", err, string(output))
+	}
+	log.Printf("TUN设备 %s MTU已调整为 %d", ifaceName, mtu)
+	return nil
+}
+
+// addTUNAddress 为TUN设备添加额外的地址（Windows版本，用于IPv6地址）
+func addTUNAddress(ifaceName string, ipAddr string) error {
+	family := "ipv4"
+	if strings.Contains(ipAddr, ":") {
+		family = "ipv6"
+	}
+	output, err := runCmdCombined("netsh", "interface", family, "add", "address", ifaceName, ipAddr)
+	if err != nil {
+		return fmt.Errorf("添加地址失败: %v, 输出: %s", err, string(output))
+	}
+	log.Printf("TUN设备 %s 已添加地址: %s", ifaceName, ipAddr)
+	return nil
+}
+
 // cleanupTUNDevice 清理TUN设备（Windows版本）
 func cleanupTUNDevice(ifaceName string) {
 	log.Printf("清理TUN设备: %s", ifaceName)
@@ -193,6 +224,18 @@ func enableIPForwarding() error {
 	return nil
 }
 
+// enableIPv6Forwarding 在TUN设备上启用IPv6转发（Windows版本）
+func enableIPv6Forwarding(ifaceName string) error {
+	output, err := runCmdCombined("netsh", "interface", "ipv6", "set", "interface",
+		ifaceName, "forwarding=enabled")
+	if err != nil {
+		return fmt.Errorf("启用IPv6转发失败: %v, 输出: %s", err, string(output))
+	}
+
+	log.Println("已启用IPv6转发")
+	return nil
+}
+
 // cidrToNetmask 将 CIDR 转换为子网掩码
 func cidrToNetmask(cidr string) string {
 	var bits int
@@ -0,0 +1,72 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/songgao/water"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// tunHandoffFiles 返回TUN设备各队列的文件（交接给新进程），offload 表示设备启用了 virtio-net 头
+func tunHandoffFiles(dev TUNDevice) (files []*os.File, offload bool, err error) {
+	if t, ok := dev.(*offloadTUN); ok {
+		nt, ok := t.device.(interface{ File() *os.File })
+		if !ok {
+			return nil, false, fmt.Errorf("无法获取TUN设备的文件描述符")
+		}
+		return []*os.File{nt.File()}, true, nil
+	}
+
+	for _, queue := range tunQueues(dev) {
+		var f *os.File
+		switch q := queue.(type) {
+		case *water.Interface:
+			f, _ = q.ReadWriteCloser.(*os.File)
+		case *inheritedTUN:
+			f = q.File
+		}
+		if f == nil {
+			return nil, false, fmt.Errorf("无法获取TUN设备的文件描述符")
+		}
+		files = append(files, f)
+	}
+	return files, false, nil
+}
+
+// openInheritedTUN 在继承自旧进程的描述符上恢复TUN设备（设备地址和MTU已由旧进程配置）
+func openInheritedTUN(files []*os.File, name string, offload bool, mtu int) (TUNDevice, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("没有继承到TUN设备")
+	}
+	if offload {
+		device, err := tun.CreateTUNFromFile(files[0], mtu)
+		if err != nil {
+			return nil, fmt.Errorf("恢复offload TUN设备失败: %v", err)
+		}
+		return newOffloadTUN(device)
+	}
+
+	if len(files) == 1 {
+		return &inheritedTUN{File: files[0], name: name}, nil
+	}
+	queues := make([]TUNDevice, 0, len(files))
+	for _, f := range files {
+		queues = append(queues, &inheritedTUN{File: f, name: name})
+	}
+	return &multiQueueTUN{queues: queues}, nil
+}
+
+// inheritedTUN 继承自旧进程的TUN队列（读写方式与 water.Interface 相同）
+type inheritedTUN struct {
+	*os.File
+	name string
+}
+
+// Name 返回设备名称
+func (t *inheritedTUN) Name() string {
+	return t.name
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// tunHandoffFiles 非Linux的Unix平台不支持交接TUN设备
+func tunHandoffFiles(dev TUNDevice) ([]*os.File, bool, error) {
+	return nil, false, fmt.Errorf("当前平台不支持交接TUN设备")
+}
+
+// openInheritedTUN 非Linux的Unix平台不支持交接TUN设备
+func openInheritedTUN(files []*os.File, name string, offload bool, mtu int) (TUNDevice, error) {
+	return nil, fmt.Errorf("当前平台不支持交接TUN设备")
+}
@@ -1,7 +1,12 @@
 package main
 
+import (
+	"fmt"
+	"sync/atomic"
+)
+
 // TUNDevice 统一的TUN设备接口（支持Linux和Windows）
-// Linux使用water.Interface实现（自动满足此接口）
+// Linux使用water.Interface实现（自动满足此接口），启用offload时使用offloadTUN
 // Windows使用WintunAdapter实现
 type TUNDevice interface {
 	// Read 从TUN设备读取一个IP包
@@ -16,3 +21,129 @@ type TUNDevice interface {
 	// Close 关闭设备
 	Close() error
 }
+
+// multiQueueTUN 多队列TUN设备（Linux IFF_MULTI_QUEUE）：同一个网卡的多个队列，
+// 内核按流哈希把发往网卡的包分散到各队列，每个队列由独立的协程读取
+type multiQueueTUN struct {
+	queues []TUNDevice
+	next   uint32 // 写入时轮询使用的队列（使用 atomic）
+}
+
+// Read 从第一个队列读取（需要并行读取时应通过 tunQueues 获取全部队列）
+func (m *multiQueueTUN) Read(p []byte) (int, error) {
+	return m.queues[0].Read(p)
+}
+
+// Write 写入任意队列都会进入内核协议栈，轮询分摊到各队列
+func (m *multiQueueTUN) Write(p []byte) (int, error) {
+	i := atomic.AddUint32(&m.next, 1) % uint32(len(m.queues))
+	return m.queues[i].Write(p)
+}
+
+// Name 返回设备名称
+func (m *multiQueueTUN) Name() string {
+	return m.queues[0].Name()
+}
+
+// Close 关闭所有队列
+func (m *multiQueueTUN) Close() error {
+	var firstErr error
+	for _, q := range m.queues {
+		if err := q.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// tunQueues 返回设备的所有可读队列（单队列设备返回自身）
+func tunQueues(dev TUNDevice) []TUNDevice {
+	if m, ok := dev.(*multiQueueTUN); ok {
+		return m.queues
+	}
+	return []TUNDevice{dev}
+}
+
+// ================ 批量读写（GSO/GRO offload） ================
+
+// tunWriteHeadroom 批量写入时每个缓冲区前预留的字节数（Linux offload设备在此写入virtio-net头）
+const tunWriteHeadroom = 16
+
+// batchTUNDevice 支持批量读写的TUN设备。Linux offload设备一次读取内核交来的GSO超级包
+// 并拆分为多个分段；批量写入时由GRO把同一个流的连续分段重新合并为超级包交给内核
+type batchTUNDevice interface {
+	TUNDevice
+
+	// BatchSize 单次批量读写的最大包数
+	BatchSize() int
+
+	// ReadBatch 读取一批IP包到 bufs，sizes 返回每个包的长度，返回包数
+	ReadBatch(bufs [][]byte, sizes []int) (int, error)
+
+	// WriteBatch 写入一批IP包，每个缓冲区的前 tunWriteHeadroom 字节为预留空间，
+	// 缓冲区内容可能被修改（合并分段）
+	WriteBatch(bufs [][]byte) error
+}
+
+// asBatchTUN 设备支持批量读写时返回其批量接口
+func asBatchTUN(dev TUNDevice) (batchTUNDevice, bool) {
+	bd, ok := dev.(batchTUNDevice)
+	if !ok || bd.BatchSize() <= 1 {
+		return nil, false
+	}
+	return bd, true
+}
+
+// tunWriteBatch 收集待写入TUN设备的IP包，Flush 时一次批量写入。
+// 包被复制到池化缓冲区，调用方的缓冲区在 Add 返回后即可复用
+type tunWriteBatch struct {
+	dev  batchTUNDevice
+	bufs [][]byte
+	held []*packetBuffer
+}
+
+// newTUNWriteBatch 为支持批量写入的设备创建写批次，不支持时返回 nil
+func newTUNWriteBatch(dev TUNDevice) *tunWriteBatch {
+	bd, ok := asBatchTUN(dev)
+	if !ok {
+		return nil
+	}
+	return &tunWriteBatch{
+		dev:  bd,
+		bufs: make([][]byte, 0, bd.BatchSize()),
+		held: make([]*packetBuffer, 0, bd.BatchSize()),
+	}
+}
+
+// Add 添加一个IP包，批次已满时先写入已有的包
+func (w *tunWriteBatch) Add(packet []byte) error {
+	pb := getPacketBuffer()
+	if len(packet) > cap(pb.b)-tunWriteHeadroom {
+		pb.release()
+		return fmt.Errorf("数据包过大: %d 字节", len(packet))
+	}
+	var err error
+	if len(w.bufs) >= w.dev.BatchSize() {
+		err = w.Flush()
+	}
+	pb.b = append(pb.b[:tunWriteHeadroom], packet...)
+	w.bufs = append(w.bufs, pb.b)
+	w.held = append(w.held, pb)
+	return err
+}
+
+// Flush 批量写入已收集的包并归还缓冲区
+func (w *tunWriteBatch) Flush() error {
+	if len(w.bufs) == 0 {
+		return nil
+	}
+	err := w.dev.WriteBatch(w.bufs)
+	for i, pb := range w.held {
+		pb.release()
+		w.held[i] = nil
+		w.bufs[i] = nil
+	}
+	w.bufs = w.bufs[:0]
+	w.held = w.held[:0]
+	return err
+}
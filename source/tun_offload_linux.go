@@ -0,0 +1,122 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// offloadTUN 启用 virtio-net 头的Linux TUN设备（GSO/GRO offload）。
+// 内核交来的TCP/UDP超级包在读取时拆分为MTU大小的分段，写入时同一个流的连续分段
+// 由GRO合并后一次交给内核，减少系统调用次数和内核协议栈的逐包处理开销
+type offloadTUN struct {
+	device tun.Device
+	name   string
+
+	// 单包 Read 的缓存：一次读取可能得到多个分段，剩余的分段留给后续调用
+	readMu      sync.Mutex
+	readBufs    [][]byte
+	readSizes   []int
+	readPending int
+	readNext    int
+
+	writeMu  sync.Mutex
+	writeBuf []byte
+}
+
+// openOffloadTUN 创建offload TUN设备，内核不支持 virtio-net 头时返回错误
+func openOffloadTUN(name string, mtu int) (TUNDevice, error) {
+	device, err := tun.CreateTUN(name, mtu)
+	if err != nil {
+		return nil, err
+	}
+	return newOffloadTUN(device)
+}
+
+// newOffloadTUN 包装已打开的设备（新建或继承自旧进程）
+func newOffloadTUN(device tun.Device) (TUNDevice, error) {
+	realName, err := device.Name()
+	if err != nil {
+		device.Close()
+		return nil, fmt.Errorf("获取设备名称失败: %v", err)
+	}
+
+	// 设备事件（up/down/MTU变化）不使用，持续读取以免事件协程阻塞
+	go func() {
+		for range device.Events() {
+		}
+	}()
+
+	return &offloadTUN{device: device, name: realName}, nil
+}
+
+// BatchSize 单次批量读写的最大包数（内核不支持offload时为1）
+func (t *offloadTUN) BatchSize() int {
+	return t.device.BatchSize()
+}
+
+// ReadBatch 读取一批IP包
+func (t *offloadTUN) ReadBatch(bufs [][]byte, sizes []int) (int, error) {
+	return t.device.Read(bufs, sizes, 0)
+}
+
+// WriteBatch 写入一批IP包（缓冲区前 tunWriteHeadroom 字节为预留空间）
+func (t *offloadTUN) WriteBatch(bufs [][]byte) error {
+	_, err := t.device.Write(bufs, tunWriteHeadroom)
+	return err
+}
+
+// Read 读取一个IP包，一次读取得到的多个分段依次返回
+func (t *offloadTUN) Read(p []byte) (int, error) {
+	t.readMu.Lock()
+	defer t.readMu.Unlock()
+
+	if t.readNext >= t.readPending {
+		if t.readBufs == nil {
+			n := t.device.BatchSize()
+			t.readBufs = make([][]byte, n)
+			t.readSizes = make([]int, n)
+			for i := range t.readBufs {
+				t.readBufs[i] = make([]byte, len(p))
+			}
+		}
+		n, err := t.device.Read(t.readBufs, t.readSizes, 0)
+		if err != nil {
+			return 0, err
+		}
+		t.readPending, t.readNext = n, 0
+	}
+
+	i := t.readNext
+	t.readNext++
+	return copy(p, t.readBufs[i][:t.readSizes[i]]), nil
+}
+
+// Write 写入一个IP包
+func (t *offloadTUN) Write(p []byte) (int, error) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	if t.writeBuf == nil {
+		t.writeBuf = make([]byte, tunWriteHeadroom, tunWriteHeadroom+maxMessagePayload)
+	}
+	t.writeBuf = append(t.writeBuf[:tunWriteHeadroom], p...)
+	if _, err := t.device.Write([][]byte{t.writeBuf}, tunWriteHeadroom); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Name 返回设备名称
+func (t *offloadTUN) Name() string {
+	return t.name
+}
+
+// Close 关闭设备
+func (t *offloadTUN) Close() error {
+	return t.device.Close()
+}
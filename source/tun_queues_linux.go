@@ -0,0 +1,38 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/songgao/water"
+)
+
+// openTUNQueues 打开TUN设备（Linux版本）。queues > 1 时使用 IFF_MULTI_QUEUE
+// 为同一个设备打开多个队列，每个队列是独立的文件描述符
+func openTUNQueues(config water.Config, queues int) (TUNDevice, error) {
+	if queues <= 1 {
+		iface, err := water.New(config)
+		if err != nil {
+			return nil, err
+		}
+		return iface, nil
+	}
+
+	config.MultiQueue = true
+	devices := make([]TUNDevice, 0, queues)
+	for i := 0; i < queues; i++ {
+		iface, err := water.New(config)
+		if err != nil {
+			for _, dev := range devices {
+				_ = dev.Close()
+			}
+			return nil, fmt.Errorf("打开第 %d 个TUN队列失败: %v", i+1, err)
+		}
+		// 后续队列附加到同一个设备
+		config.Name = iface.Name()
+		devices = append(devices, iface)
+	}
+	return &multiQueueTUN{queues: devices}, nil
+}
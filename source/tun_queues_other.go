@@ -0,0 +1,28 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/songgao/water"
+)
+
+// openTUNQueues 打开TUN设备（非Linux的Unix版本，不支持多队列）
+func openTUNQueues(config water.Config, queues int) (TUNDevice, error) {
+	if queues > 1 {
+		log.Printf("警告：当前平台不支持多队列TUN，使用单队列")
+	}
+	iface, err := water.New(config)
+	if err != nil {
+		return nil, err
+	}
+	return iface, nil
+}
+
+// openOffloadTUN 非Linux的Unix平台不支持TUN offload
+func openOffloadTUN(name string, mtu int) (TUNDevice, error) {
+	return nil, fmt.Errorf("当前平台不支持TUN offload")
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 多队列TUN的分发基准测试：N 个队列各由一个 handleTUNRead 协程读取，按目标地址查找会话后
+// 进入会话的发送队列，由写协程编码后写入丢弃数据的连接。队列是内存中的假设备，不需要 root，
+// 衡量的是服务端读取和分发路径随队列数扩展的情况（内核TUN本身的开销见 tun_queues_linux_test.go）
+
+// discardConn 丢弃所有写入数据的连接
+type discardConn struct{}
+
+func (discardConn) Read(p []byte) (int, error)           { return 0, io.EOF }
+func (discardConn) Write(p []byte) (int, error)          { return len(p), nil }
+func (discardConn) Close() error                         { return nil }
+func (discardConn) LocalAddr() net.Addr                  { return &net.TCPAddr{} }
+func (discardConn) RemoteAddr() net.Addr                 { return &net.TCPAddr{} }
+func (discardConn) SetDeadline(time.Time) error          { return nil }
+func (discardConn) SetReadDeadline(time.Time) error      { return nil }
+func (discardConn) SetWriteDeadline(time.Time) error     { return nil }
+func (discardConn) ConnectionState() tls.ConnectionState { return tls.ConnectionState{} }
+
+// benchSessionIP 第 i 个测试会话的隧道地址（10.8.0.0/16 中依次分配）
+func benchSessionIP(i int) net.IP {
+	return net.IPv4(10, 8, byte((i+2)>>8), byte(i+2)).To4()
+}
+
+// newBenchServer 创建带有 sessions 个在线会话的服务器（不需要TUN设备），
+// 每个会话一条写入 discardConn 的连接。返回的函数关闭所有会话
+func newBenchServer(tb testing.TB, sessions int) (*VPNServer, func()) {
+	config := DefaultConfig
+	config.Network = "10.8.0.0/16"
+	config.MaxConnections = sessions
+	config.SendQueueSize = 4096
+	_, network, _ := net.ParseCIDR(config.Network)
+	s := &VPNServer{
+		config:     config,
+		sessions:   newSessionRegistry(),
+		vpnNetwork: network,
+	}
+	for i := 0; i < sessions; i++ {
+		session := &VPNSession{
+			ID:          fmt.Sprintf("bench-%d", i),
+			IP:          benchSessionIP(i),
+			CertSubject: fmt.Sprintf("client-%d", i),
+			ConnectedAt: time.Now(),
+		}
+		link := s.newSessionLink(session, discardConn{})
+		session.addLink(link, 1)
+		go s.runSessionWriter(session, link)
+		if !s.addSession(session) {
+			tb.Fatalf("注册会话 %d 失败", i)
+		}
+	}
+	return s, func() {
+		for _, session := range s.sessions.all() {
+			_ = session.Close()
+		}
+	}
+}
+
+// memTUNQueue 从内存中按顺序返回发往各会话的数据包的假TUN队列。
+// 所有队列共享 budget，分完后取消 ctx 并返回 io.EOF
+type memTUNQueue struct {
+	packets [][]byte
+	next    int
+	budget  *int64
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func (q *memTUNQueue) Read(p []byte) (int, error) {
+	if left := atomic.AddInt64(q.budget, -1); left < 0 {
+		if left == -1 {
+			q.cancel()
+		}
+		<-q.ctx.Done()
+		return 0, io.EOF
+	}
+	packet := q.packets[q.next%len(q.packets)]
+	q.next++
+	return copy(p, packet), nil
+}
+
+func (q *memTUNQueue) Write(p []byte) (int, error) { return len(p), nil }
+func (q *memTUNQueue) Name() string                { return "bench" }
+func (q *memTUNQueue) Close() error                { return nil }
+
+func BenchmarkTUNQueueDispatch(b *testing.B) {
+	const sessions = 64
+	packets := make([][]byte, sessions)
+	for i := range packets {
+		packets[i] = benchPacket(1400)
+		copy(packets[i][16:20], benchSessionIP(i))
+	}
+
+	for _, queues := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("Queues%d", queues), func(b *testing.B) {
+			// 发送队列满时的丢弃日志会干扰计时
+			defer log.SetOutput(log.Writer())
+			log.SetOutput(io.Discard)
+
+			s, closeSessions := newBenchServer(b, sessions)
+			defer closeSessions()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			budget := int64(b.N)
+			devices := make([]TUNDevice, queues)
+			for i := range devices {
+				// 内核按流哈希分散到各队列，同一个流（会话）的包总是进入同一个队列
+				var own [][]byte
+				for j := i; j < sessions; j += queues {
+					own = append(own, packets[j])
+				}
+				devices[i] = &memTUNQueue{packets: own, budget: &budget, ctx: ctx, cancel: cancel}
+			}
+			dev := TUNDevice(&multiQueueTUN{queues: devices})
+			if queues == 1 {
+				dev = devices[0]
+			}
+
+			b.ReportAllocs()
+			b.SetBytes(1400)
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for _, queue := range tunQueues(dev) {
+				wg.Add(1)
+				go func(queue TUNDevice) {
+					defer wg.Done()
+					s.handleTUNRead(ctx, queue)
+				}(queue)
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ================ UDP 数据通道 ================
+//
+// TLS 连接保留为控制通道（IP分配、配置推送、心跳），数据包可以改走 UDP 数据报，
+// 避免有损链路上的 TCP-over-TCP 问题。每个会话的 AEAD 密钥由 TLS 会话通过
+// ExportKeyingMaterial 导出，因此无需额外的密钥交换。
+//
+// 数据报格式: SessionID(8) + Counter(8) + AES-256-GCM(Type(1) + Payload) + Tag(16)
+// SessionID 和 Counter 作为附加认证数据（AAD），Counter 同时作为 nonce 和防重放序号。
+
+const (
+	udpExporterLabel  = "EXPORTER-tls-vpn-udp-data"
+	udpHeaderSize     = 16
+	udpProbeInterval  = 5 * time.Second  // 客户端UDP探测间隔
+	udpActiveTimeout  = 15 * time.Second // 超过该时间未收到UDP数据报则回退到TCP
+	udpReplayWindowSz = 1024             // 防重放窗口大小（位）
+	udpMaxDatagram    = 65535
+)
+
+// udpCipher UDP数据通道的会话加密状态
+type udpCipher struct {
+	sessionID   uint64
+	sendAEAD    cipher.AEAD
+	recvAEAD    cipher.AEAD
+	sendCounter uint64 // 使用 atomic
+	replay      udpReplayWindow
+}
+
+// newUDPSessionID 生成随机的UDP会话ID（0保留表示未启用）
+func newUDPSessionID() (uint64, error) {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, fmt.Errorf("生成UDP会话ID失败: %v", err)
+		}
+		if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+			return id, nil
+		}
+	}
+}
+
+// newUDPCipher 从TLS会话导出UDP数据通道密钥
+// 导出 64 字节：前 32 字节用于客户端->服务端，后 32 字节用于服务端->客户端
+func newUDPCipher(state tls.ConnectionState, sessionID uint64, isServer bool) (*udpCipher, error) {
+	var idBytes [8]byte
+	binary.BigEndian.PutUint64(idBytes[:], sessionID)
+
+	material, err := state.ExportKeyingMaterial(udpExporterLabel, idBytes[:], 64)
+	if err != nil {
+		return nil, fmt.Errorf("导出UDP密钥失败: %v", err)
+	}
+
+	c2s, err := newGCM(material[:32])
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := newGCM(material[32:])
+	if err != nil {
+		return nil, err
+	}
+
+	c := &udpCipher{sessionID: sessionID}
+	if isServer {
+		c.sendAEAD, c.recvAEAD = s2c, c2s
+	} else {
+		c.sendAEAD, c.recvAEAD = c2s, s2c
+	}
+	return c, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("创建AES密钥失败: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("创建GCM失败: %v", err)
+	}
+	return aead, nil
+}
+
+// seal 加密一个消息为UDP数据报
+func (c *udpCipher) seal(msgType MessageType, payload []byte) []byte {
+	return c.sealTo(make([]byte, 0, udpHeaderSize+1+len(payload)+c.sendAEAD.Overhead()), msgType, payload)
+}
+
+// sealTo 加密一个消息为UDP数据报并追加到 dst（在 dst 中原地加密，容量足够时不分配内存）
+func (c *udpCipher) sealTo(dst []byte, msgType MessageType, payload []byte) []byte {
+	counter := atomic.AddUint64(&c.sendCounter, 1)
+
+	n := len(dst)
+	dst = append(dst, make([]byte, udpHeaderSize+1)...)
+	binary.BigEndian.PutUint64(dst[n:n+8], c.sessionID)
+	binary.BigEndian.PutUint64(dst[n+8:n+16], counter)
+	dst[n+udpHeaderSize] = byte(msgType)
+	dst = append(dst, payload...)
+
+	var nonce [12]byte
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	header := dst[n : n+udpHeaderSize]
+	return c.sendAEAD.Seal(dst[:n+udpHeaderSize], nonce[:], dst[n+udpHeaderSize:], header)
+}
+
+// open 解密UDP数据报并执行防重放检查（原地解密，返回的负载引用 datagram 的内存）
+func (c *udpCipher) open(datagram []byte) (MessageType, []byte, error) {
+	if len(datagram) < udpHeaderSize+1+c.recvAEAD.Overhead() {
+		return 0, nil, fmt.Errorf("UDP数据报过短: %d 字节", len(datagram))
+	}
+	counter := binary.BigEndian.Uint64(datagram[8:16])
+	if !c.replay.check(counter) {
+		return 0, nil, fmt.Errorf("UDP数据报重放或过旧: counter=%d", counter)
+	}
+
+	var nonce [12]byte
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	ciphertext := datagram[udpHeaderSize:]
+	plaintext, err := c.recvAEAD.Open(ciphertext[:0], nonce[:], ciphertext, datagram[:udpHeaderSize])
+	if err != nil {
+		return 0, nil, fmt.Errorf("UDP数据报解密失败: %v", err)
+	}
+	// 只有认证通过的数据报才能推进窗口
+	c.replay.accept(counter)
+
+	return MessageType(plaintext[0]), plaintext[1:], nil
+}
+
+// udpDatagramSessionID 读取数据报中的会话ID（未认证，仅用于查找会话）
+func udpDatagramSessionID(datagram []byte) (uint64, bool) {
+	if len(datagram) < udpHeaderSize {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(datagram[0:8]), true
+}
+
+// udpReplayWindow 滑动窗口防重放（参考 RFC 6479）
+type udpReplayWindow struct {
+	mu     sync.Mutex
+	last   uint64
+	bitmap [udpReplayWindowSz / 64]uint64
+}
+
+// check 检查计数器是否可接受（不修改窗口）
+func (w *udpReplayWindow) check(counter uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if counter == 0 {
+		return false
+	}
+	if counter > w.last {
+		return true
+	}
+	if w.last-counter >= udpReplayWindowSz {
+		return false
+	}
+	bit := counter % udpReplayWindowSz
+	return w.bitmap[bit/64]&(1<<(bit%64)) == 0
+}
+
+// accept 将计数器记录到窗口中
+func (w *udpReplayWindow) accept(counter uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if counter > w.last {
+		// 清除窗口中被跳过的位
+		diff := counter - w.last
+		if diff >= udpReplayWindowSz {
+			for i := range w.bitmap {
+				w.bitmap[i] = 0
+			}
+		} else {
+			for i := w.last + 1; i < counter; i++ {
+				bit := i % udpReplayWindowSz
+				w.bitmap[bit/64] &^= 1 << (bit % 64)
+			}
+		}
+		w.last = counter
+	} else if w.last-counter >= udpReplayWindowSz {
+		return
+	}
+	bit := counter % udpReplayWindowSz
+	w.bitmap[bit/64] |= 1 << (bit % 64)
+}
+
+// ================ 服务端 ================
+
+// setupSessionUDP 为会话分配UDP会话ID并派生密钥
+func (s *VPNServer) setupSessionUDP(session *VPNSession, state tls.ConnectionState) error {
+	id, err := newUDPSessionID()
+	if err != nil {
+		return err
+	}
+	udp, err := newUDPCipher(state, id, true)
+	if err != nil {
+		return err
+	}
+	session.udp = udp
+	return nil
+}
+
+// handleUDPRead 处理UDP数据通道收到的数据报
+func (s *VPNServer) handleUDPRead(ctx context.Context) {
+	buf := make([]byte, udpMaxDatagram)
+	scratch := getPacketBuffer() // 解压缓冲区
+	defer scratch.release()
+
+	for {
+		n, addr, err := s.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("从UDP数据通道读取失败: %v", err)
+			}
+			return
+		}
+
+		id, ok := udpDatagramSessionID(buf[:n])
+		if !ok {
+			continue
+		}
+
+		session := s.sessions.lookupUDP(id)
+		if session == nil || session.IsClosed() {
+			continue
+		}
+
+		msgType, payload, err := session.udp.open(buf[:n])
+		if err != nil {
+			// 伪造或重放的数据报直接丢弃，不影响TLS会话
+			continue
+		}
+
+		if session.setUDPPeer(addr) {
+			log.Printf("会话 %s UDP数据通道已建立: %s", session.ID, addr)
+		}
+		session.UpdateActivity()
+
+		switch msgType {
+		case MessageTypeHeartbeat:
+			// 回应UDP探测，客户端据此判断UDP通道是否可用
+			datagram := session.udp.sealTo(scratch.b[:0], MessageTypeHeartbeat, nil)
+			if _, err := s.udpConn.WriteToUDP(datagram, addr); err != nil {
+				log.Printf("会话 %s 回应UDP探测失败: %v", session.ID, err)
+			}
+		case MessageTypeData, MessageTypeCompressedData:
+			session.AddWireBytesReceived(uint64(len(payload)))
+			packet, err := decodePacket(scratch.b, payload, msgType == MessageTypeCompressedData)
+			if err != nil {
+				log.Printf("会话 %s UDP通道%v", session.ID, err)
+				continue
+			}
+			// UDP通道没有反压，超出上行限速的包直接丢弃
+			if !session.rateUp.allow(len(packet)) {
+				continue
+			}
+			s.writeToTUN(session, packet, nil)
+		case MessageTypeMTUProbe:
+			s.handleMTUProbe(session, addr, payload, scratch.b)
+		default:
+			log.Printf("会话 %s UDP通道收到未知消息类型: %d", session.ID, msgType)
+		}
+	}
+}
+
+// ================ 客户端 ================
+
+// setupUDP 根据服务端推送的参数建立UDP数据通道
+func (c *VPNClient) setupUDP(conn tunnelConn, port int, sessionID uint64) error {
+	udp, err := newUDPCipher(conn.ConnectionState(), sessionID, false)
+	if err != nil {
+		return err
+	}
+
+	// 优先使用TLS连接实际解析到的服务器地址，保证与控制通道走同一地址族
+	host := c.config.ServerAddress
+	if c.serverAddrIP != nil {
+		host = c.serverAddrIP.String()
+	}
+	address := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return fmt.Errorf("解析UDP地址失败: %v", err)
+	}
+	udpConn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return fmt.Errorf("创建UDP连接失败: %v", err)
+	}
+
+	c.connMutex.Lock()
+	c.udpConn = udpConn
+	c.udp = udp
+	c.connMutex.Unlock()
+	atomic.StoreInt64(&c.udpLastRecv, 0)
+	atomic.StoreInt32(&c.udpMTU, 0)
+
+	log.Printf("UDP数据通道参数已协商，服务器: %s，等待探测确认...", raddr)
+	return nil
+}
+
+// getUDP 获取当前UDP连接和加密状态
+func (c *VPNClient) getUDP() (*net.UDPConn, *udpCipher) {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	return c.udpConn, c.udp
+}
+
+// udpActive 检查UDP数据通道当前是否可用
+func (c *VPNClient) udpActive() bool {
+	last := atomic.LoadInt64(&c.udpLastRecv)
+	return last != 0 && time.Since(time.Unix(0, last)) < udpActiveTimeout
+}
+
+// sendUDP 通过UDP数据通道发送消息
+func (c *VPNClient) sendUDP(msgType MessageType, payload []byte) error {
+	udpConn, udp := c.getUDP()
+	if udpConn == nil || udp == nil {
+		return fmt.Errorf("UDP数据通道未建立")
+	}
+	pb := getPacketBuffer()
+	_, err := udpConn.Write(udp.sealTo(pb.b, msgType, payload))
+	pb.release()
+	return err
+}
+
+// udpProbeLoop 周期性发送UDP探测，维持NAT映射并检测UDP是否被阻断
+func (c *VPNClient) udpProbeLoop(ctx context.Context) {
+	ticker := time.NewTicker(udpProbeInterval)
+	defer ticker.Stop()
+
+	wasActive := false
+	for {
+		if err := c.sendUDP(MessageTypeHeartbeat, nil); err != nil {
+			log.Printf("发送UDP探测失败: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		active := c.udpActive()
+		if wasActive && !active {
+			log.Println("UDP数据通道无响应，回退到TCP传输")
+		}
+		wasActive = active
+	}
+}
+
+// udpReadLoop 接收UDP数据通道的数据报
+func (c *VPNClient) udpReadLoop(ctx context.Context) {
+	udpConn, udp := c.getUDP()
+	if udpConn == nil || udp == nil {
+		return
+	}
+
+	buf := make([]byte, udpMaxDatagram)
+	scratch := getPacketBuffer() // 解压缓冲区
+	defer scratch.release()
+	for {
+		n, err := udpConn.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("从UDP数据通道读取失败: %v", err)
+			}
+			return
+		}
+
+		if id, ok := udpDatagramSessionID(buf[:n]); !ok || id != udp.sessionID {
+			continue
+		}
+		msgType, payload, err := udp.open(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if !c.udpActive() {
+			log.Println("UDP数据通道已确认可用，数据包将通过UDP传输")
+		}
+		atomic.StoreInt64(&c.udpLastRecv, time.Now().UnixNano())
+
+		switch msgType {
+		case MessageTypeMTUProbe:
+			c.handleMTUProbe(payload)
+		case MessageTypeData, MessageTypeCompressedData:
+			packet, err := decodePacket(scratch.b, payload, msgType == MessageTypeCompressedData)
+			if err != nil {
+				log.Printf("UDP数据通道%v", err)
+				continue
+			}
+			c.deliverPacket(packet, nil)
+		}
+	}
+}
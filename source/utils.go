@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"io"
+	"math"
 	"os/exec"
 	"time"
 )
@@ -62,6 +63,28 @@ func formatBytes(bytes uint64) string {
 	}
 }
 
+// formatKbps 格式化限速值（kbit/s，0表示不限速）
+func formatKbps(kbps int) string {
+	switch {
+	case kbps <= 0:
+		return "不限"
+	case kbps >= 1000 && kbps%1000 == 0:
+		return fmt.Sprintf("%d Mbps", kbps/1000)
+	default:
+		return fmt.Sprintf("%d kbps", kbps)
+	}
+}
+
+// durationMs 将时长换算为毫秒（保留一位小数）
+func durationMs(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*10) / 10
+}
+
+// formatLinkQuality 格式化链路质量（往返时延和抖动单位为毫秒，丢失率为百分比）
+func formatLinkQuality(rttMs, jitterMs, loss float64) string {
+	return fmt.Sprintf("延迟 %.1f ms, 抖动 %.1f ms, 心跳丢失 %.0f%%", rttMs, jitterMs, loss)
+}
+
 // formatDuration 格式化时间
 func formatDuration(d time.Duration) string {
 	if d < time.Minute {
@@ -7,10 +7,10 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"hash/crc32"
 	"io"
 	"log"
 	"net"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,30 +19,56 @@ import (
 // VPNClient VPN客户端结构
 type VPNClient struct {
 	tlsConfig     *tls.Config
-	conn          *tls.Conn
+	conn          tunnelConn
 	connMutex     sync.Mutex
 	assignedIP    net.IP
-	reconnect     int32 // 使用 atomic，1=true, 0=false
+	assignedMask  int    // IPv4地址前缀长度（来自服务端推送的配置，默认24）
+	assignedIP6   string // IPv6隧道地址（CIDR格式，未分配时为空）
+	serverIP6     string // 服务器IPv6隧道地址（CIDR格式）
+	reconnect     int32  // 使用 atomic，1=true, 0=false
 	config        VPNConfig
 	packetHandler func([]byte) error
 	cancel        context.CancelFunc // 主 context 取消函数
 	cancelMutex   sync.Mutex
-	tunDevice     TUNDevice // 统一的TUN设备接口
-	sendSeq       uint32        // 发送序列号
-	recvSeq       uint32        // 接收序列号
-	seqMutex      sync.Mutex    // 序列号锁
+	tunDevice     TUNDevice     // 统一的TUN设备接口
+	links         []*clientLink // 当前的TLS连接（第一条承载控制消息，conn 指向它）
+	linksDone     chan struct{} // 所有连接都断开时关闭
+	bondToken     string        // 成员连接加入会话的凭据（服务端未启用聚合时为空）
+	bondLinks     int           // 聚合的目标连接数
 	routeManager  *RouteManager // 路由管理器
+	routeMutex    sync.Mutex    // 保护路由配置（服务端在线推送配置时与重连、关闭互斥）
 	retryCount    int           // 重连计数器
+	udpConn       *net.UDPConn  // UDP数据通道（未启用时为nil）
+	udp           *udpCipher    // UDP数据通道加密状态
+	udpLastRecv   int64         // 最近一次收到UDP数据报的时间（UnixNano，使用 atomic）
+	compression   int32         // 是否压缩发往服务端的数据包（使用 atomic，1=true）
+	hello         *Hello        // 协商结果（旧版服务端为版本1，无特性）
+	serverAddrIP  net.IP        // 服务器的实际传输层地址（域名解析后的结果）
+	goodbye       *Goodbye      // 服务端的断开通知（决定下一次重连的等待时间，使用后清除）
+	disconnect    *Goodbye      // 服务端最近一次的断开通知（在客户端状态中显示）
+	poolFullCount int           // 连续因地址池已满被拒绝的次数（决定退避时间，连接成功后清零）
+	notice        *Notice       // 服务端最近一次发来的通知（在客户端状态中显示）
+	tunMTU        int32         // TUN设备当前的MTU（路径MTU探测后可能小于配置值，使用 atomic）
+	udpMTU        int32         // UDP数据通道能承载的最大IP包长度（0=尚未探测，使用 atomic）
+	pmtuAcks      chan int      // 服务端确认的路径MTU探测大小
+	quality       linkQuality   // 客户端探测的链路质量（见 link_quality.go，每次连接时清空）
+
+	// 当前生效的路由和DNS配置：初始为本地配置，连接时和服务端在线推送时整体替换（见 config_push.go），
+	// 不修改数据路径并发读取的 config
+	routes atomic.Pointer[pushedConfig]
 }
 
 // NewVPNClient 创建新的VPN客户端
 func NewVPNClient(certManager *CertificateManager, config VPNConfig) *VPNClient {
-	return &VPNClient{
+	client := &VPNClient{
 		tlsConfig:     certManager.ClientTLSConfig(),
 		reconnect:     1, // 1 表示 true
 		config:        config,
 		packetHandler: nil,
+		pmtuAcks:      make(chan int, 4),
 	}
+	client.routes.Store(newPushedConfig(&config))
+	return client
 }
 
 // InitializeTUN 初始化TUN设备
@@ -61,7 +87,7 @@ func (c *VPNClient) InitializeTUN() error {
 	}
 
 	// 创建TUN设备（自动选择可用名称，传入网络配置）
-	tun, err := createTUNDevice("tun", network)
+	tun, err := createTUNDevice("tun", network, 1, c.config.TUNOffload)
 	if err != nil {
 		return err
 	}
@@ -81,10 +107,22 @@ func (c *VPNClient) ConfigureTUN() error {
 	}
 
 	// 配置TUN设备IP地址
-	ipAddr := fmt.Sprintf("%s/24", c.assignedIP.String())
+	mask := c.assignedMask
+	if mask == 0 {
+		mask = 24
+	}
+	ipAddr := fmt.Sprintf("%s/%d", c.assignedIP.String(), mask)
 	if err := configureTUNDevice(c.tunDevice.Name(), ipAddr, c.config.MTU); err != nil {
 		return err
 	}
+	atomic.StoreInt32(&c.tunMTU, int32(c.config.MTU))
+
+	// 配置IPv6隧道地址（失败时仅使用IPv4）
+	if c.assignedIP6 != "" {
+		if err := addTUNAddress(c.tunDevice.Name(), c.assignedIP6); err != nil {
+			log.Printf("警告：配置IPv6地址失败: %v", err)
+		}
+	}
 
 	log.Printf("客户端TUN设备已配置: %s", ipAddr)
 	return nil
@@ -92,74 +130,112 @@ func (c *VPNClient) ConfigureTUN() error {
 
 // Connect 连接到VPN服务器（支持 context 超时/取消）
 func (c *VPNClient) Connect(ctx context.Context) error {
-	address := fmt.Sprintf("%s:%d", c.config.ServerAddress, c.config.ServerPort)
-
-	// 使用带超时的 dialer
-	dialer := &net.Dialer{Timeout: 30 * time.Second}
-	netConn, err := dialer.DialContext(ctx, "tcp", address)
+	conn, err := c.dialServer(ctx, 0, "")
 	if err != nil {
-		return fmt.Errorf("连接失败: %v", err)
+		return err
 	}
 
-	// 升级为 TLS 连接
-	conn := tls.Client(netConn, c.tlsConfig)
+	c.connMutex.Lock()
+	c.conn = conn
+	// 记录实际连接的服务器地址（经过代理时为代理地址），用于绕过路由、UDP数据通道和聚合成员连接
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		c.serverAddrIP = tcpAddr.IP
+	}
+	c.bondToken = ""
+	c.bondLinks = 0
+	c.connMutex.Unlock()
+	if c.config.GetTransport() == TransportWebSocket {
+		log.Println("成功连接到VPN服务器，使用WebSocket传输（TLS 1.3）")
+	} else {
+		log.Println("成功连接到VPN服务器，使用TLS 1.3协议")
+	}
 
-	err = conn.Handshake()
+	// 协议版本和能力协商
+	err = writeHello(conn, &Hello{
+		Version:     ProtocolVersion,
+		MinVersion:  MinProtocolVersion,
+		Features:    c.clientFeatures(),
+		AuthMethods: []string{AuthMethodCert},
+	})
 	if err != nil {
-		_ = conn.Close()
-		return fmt.Errorf("TLS握手失败: %v", err)
+		return fmt.Errorf("发送Hello消息失败: %v", err)
 	}
 
-	// 验证TLS版本
-	if conn.ConnectionState().Version != tls.VersionTLS13 {
-		_ = conn.Close()
-		return fmt.Errorf("未使用TLS 1.3协议")
+	msgType, payload, err := readHandshakeMessage(conn)
+	if err != nil {
+		return fmt.Errorf("读取消息失败: %v", err)
 	}
 
-	c.connMutex.Lock()
-	c.conn = conn
-	c.connMutex.Unlock()
-	log.Println("成功连接到VPN服务器，使用TLS 1.3协议")
+	hello := &Hello{Version: 1, MinVersion: 1}
+	if msgType == MessageTypeHello {
+		if err := json.Unmarshal(payload, hello); err != nil {
+			return fmt.Errorf("解析Hello消息失败: %v", err)
+		}
+		if hello.Error != "" {
+			return fmt.Errorf("服务端拒绝连接: %s", hello.Error)
+		}
+		if hello.Version < MinProtocolVersion || hello.Version > ProtocolVersion {
+			return fmt.Errorf("协议版本不兼容: 服务端协商版本 %d，客户端支持 %d-%d",
+				hello.Version, MinProtocolVersion, ProtocolVersion)
+		}
+		log.Printf("协议协商完成: 版本=%d, 特性=%v", hello.Version, hello.Features)
 
-	// 读取分配的IP - 读取新的消息头格式（13字节）
-	header := make([]byte, 13)
-	_, err = io.ReadFull(c.conn, header)
-	if err != nil {
-		return fmt.Errorf("读取消息头失败: %v", err)
+		msgType, payload, err = readHandshakeMessage(conn)
+		if err != nil {
+			return fmt.Errorf("读取消息失败: %v", err)
+		}
+	} else {
+		// 旧版服务端不回复Hello，直接分配IP
+		log.Println("服务端不支持协议协商，使用兼容模式（版本1）")
 	}
+	c.hello = hello
+	c.quality.reset()
 
-	// 手动解析消息头
-	msgType := MessageType(header[0])
-	length := binary.BigEndian.Uint32(header[1:5])
-
-	// 读取消息体
-	payload := make([]byte, length)
-	_, err = io.ReadFull(c.conn, payload)
-	if err != nil {
-		return fmt.Errorf("读取消息体失败: %v", err)
+	// 服务端以 Goodbye 代替IP分配拒绝连接（如地址池已满）
+	if msgType == MessageTypeGoodbye {
+		if goodbye := c.recordGoodbye(payload); goodbye != nil {
+			return fmt.Errorf("服务端拒绝连接 (%s): %s", goodbyeReasonName(goodbye.Reason), goodbye.Message)
+		}
 	}
 
+	c.assignedMask = 0
+	c.assignedIP6 = ""
+	c.serverIP6 = ""
 	if msgType == MessageTypeIPAssignment && len(payload) >= 4 {
 		c.assignedIP = net.IP(payload)
 		log.Printf("分配的VPN IP: %s", c.assignedIP)
 	} else {
-		return fmt.Errorf("未收到有效的IP分配信息: type=%d, length=%d", msgType, length)
+		return fmt.Errorf("未收到有效的IP分配信息: type=%d, length=%d", msgType, len(payload))
+	}
+
+	// 启用协商成功的数据传输特性
+	if hello.HasFeature(FeatureCompression) {
+		atomic.StoreInt32(&c.compression, 1)
+		log.Printf("已启用数据包压缩: %s", compressionDeflate)
+	}
+	link := newClientLink(0, conn, hello.HasFeature(FeatureBatching), &c.config)
+	if link.batcher != nil {
+		log.Printf("已启用批量数据传输")
 	}
+	c.connMutex.Lock()
+	c.links = []*clientLink{link}
+	c.linksDone = make(chan struct{})
+	c.connMutex.Unlock()
 
 	// 接收服务器推送的配置
-	header = make([]byte, 13)
-	_, err = io.ReadFull(c.conn, header)
+	header := make([]byte, 13)
+	_, err = io.ReadFull(conn, header)
 	if err != nil {
 		log.Printf("警告：未接收到服务器配置: %v", err)
 		return nil // 配置是可选的，不影响连接
 	}
 
 	msgType = MessageType(header[0])
-	length = binary.BigEndian.Uint32(header[1:5])
+	length := binary.BigEndian.Uint32(header[1:5])
 
 	if msgType == MessageTypeControl && length > 0 {
 		payload = make([]byte, length)
-		_, err = io.ReadFull(c.conn, payload)
+		_, err = io.ReadFull(conn, payload)
 		if err != nil {
 			log.Printf("警告：读取服务器配置失败: %v", err)
 			return nil
@@ -169,28 +245,42 @@ func (c *VPNClient) Connect(ctx context.Context) error {
 		if err := json.Unmarshal(payload, &serverConfig); err != nil {
 			log.Printf("警告：解析服务器配置失败: %v", err)
 		} else {
-			// 应用服务器推送的路由配置
-			if serverConfig.RouteMode != "" {
-				c.config.RouteMode = serverConfig.RouteMode
-				log.Printf("服务器配置路由模式: %s", serverConfig.RouteMode)
-			}
-			if len(serverConfig.ExcludeRoutes) > 0 {
-				c.config.ExcludeRoutes = serverConfig.ExcludeRoutes
-			}
-			c.config.RedirectGateway = serverConfig.RedirectGateway
-			c.config.RedirectDNS = serverConfig.RedirectDNS
-			if len(serverConfig.DNS) > 0 {
-				c.config.DNSServers = serverConfig.DNS
-			}
-			if len(serverConfig.Routes) > 0 {
-				c.config.PushRoutes = serverConfig.Routes
-			}
+			// 应用服务器推送的路由配置（替换整个路由配置，不修改 c.config）
+			routes := c.resetRoutes(&serverConfig)
 			// 保存ServerIP供路由设置使用
 			if serverConfig.ServerIP != "" {
 				c.config.ServerIP = serverConfig.ServerIP
 			}
+			if _, ipNet, err := net.ParseCIDR(serverConfig.AssignedIP); err == nil {
+				c.assignedMask, _ = ipNet.Mask.Size()
+			}
+			// 隧道MTU以服务端为准（两端TUN设备一致，路径MTU探测在此基础上向下调整）
+			if serverConfig.MTU > 0 {
+				c.config.MTU = serverConfig.MTU
+			}
+			if serverConfig.AssignedIP6 != "" {
+				c.assignedIP6 = serverConfig.AssignedIP6
+				c.serverIP6 = serverConfig.ServerIP6
+				log.Printf("分配的VPN IPv6: %s", c.assignedIP6)
+			}
 			log.Printf("已应用服务器配置: RouteMode=%s, RedirectGateway=%v, RedirectDNS=%v",
-				c.config.RouteMode, c.config.RedirectGateway, c.config.RedirectDNS)
+				routes.RouteMode, routes.RedirectGateway, routes.RedirectDNS)
+
+			// 服务端提供了UDP数据通道
+			if hello.HasFeature(FeatureUDP) && serverConfig.UDPPort > 0 && serverConfig.UDPSessionID != 0 {
+				if err := c.setupUDP(conn, serverConfig.UDPPort, serverConfig.UDPSessionID); err != nil {
+					log.Printf("警告：建立UDP数据通道失败，仅使用TCP: %v", err)
+				}
+			}
+
+			// 服务端允许其他连接加入本会话
+			if hello.HasFeature(FeatureBonding) && serverConfig.BondToken != "" {
+				c.connMutex.Lock()
+				c.bondToken = serverConfig.BondToken
+				c.bondLinks = min(c.config.GetBondLinks(), serverConfig.BondLinks)
+				c.connMutex.Unlock()
+				log.Printf("已启用多连接聚合: %d 条连接", c.bondLinks)
+			}
 		}
 	}
 
@@ -199,127 +289,88 @@ func (c *VPNClient) Connect(ctx context.Context) error {
 
 // SendData 发送数据
 func (c *VPNClient) SendData(data []byte) error {
-	c.connMutex.Lock()
-	conn := c.conn
-	c.connMutex.Unlock()
-
-	if conn == nil {
+	link := c.linkForPacket(data)
+	if link == nil {
 		return fmt.Errorf("连接未建立")
 	}
 
-	// 获取并递增发送序列号
-	c.seqMutex.Lock()
-	seq := c.sendSeq
-	c.sendSeq++
-	c.seqMutex.Unlock()
-
-	// 计算校验和（可选）
-	checksum := uint32(0)
-	if len(data) > 0 {
-		checksum = crc32.ChecksumIEEE(data)
+	if c.replyTooBig(data) {
+		return nil
 	}
-
-	msg := &Message{
-		Type:     MessageTypeData,
-		Length:   uint32(len(data)),
-		Sequence: seq,
-		Checksum: checksum,
-		Payload:  data,
+	if !c.config.DisableMSSClamp {
+		clampTCPMSS(data, c.tunnelMTU())
 	}
 
-	serialized, err := msg.Serialize()
-	if err != nil {
-		return fmt.Errorf("序列化消息失败: %v", err)
+	payload, compressed := data, false
+	if atomic.LoadInt32(&c.compression) == 1 {
+		buf := getPacketBuffer()
+		defer buf.release()
+		payload, compressed = compressPacket(buf.b, data)
 	}
-
-	_, err = conn.Write(serialized)
-	return err
-}
-
-// SendHeartbeat 发送心跳
-func (c *VPNClient) SendHeartbeat() error {
-	c.connMutex.Lock()
-	conn := c.conn
-	c.connMutex.Unlock()
-
-	if conn == nil {
-		return fmt.Errorf("连接未建立")
+	msgType := MessageTypeData
+	if compressed {
+		msgType = MessageTypeCompressedData
 	}
 
-	msg := &Message{
-		Type:     MessageTypeHeartbeat,
-		Length:   0,
-		Sequence: 0, // 心跳不使用序列号
-		Checksum: 0,
-		Payload:  []byte{},
+	// UDP数据通道可用且数据包不超过路径MTU时优先使用，失败则回退到TLS连接
+	if c.udpActive() && c.udpFits(len(data)) {
+		if err := c.sendUDP(msgType, payload); err == nil {
+			return nil
+		}
 	}
 
-	serialized, err := msg.Serialize()
+	var err error
+	if link.batcher != nil {
+		err = link.batcher.Add(payload, compressed, len(data))
+	} else {
+		err = link.writeMessage(msgType, payload)
+	}
 	if err != nil {
-		return fmt.Errorf("序列化心跳消息失败: %v", err)
+		return c.linkFailed(link, err)
 	}
-
-	_, err = conn.Write(serialized)
-	return err
+	return nil
 }
 
-// ReceiveData 接收数据，返回消息类型和数据
-func (c *VPNClient) ReceiveData() (MessageType, []byte, error) {
-	c.connMutex.Lock()
-	conn := c.conn
-	c.connMutex.Unlock()
-
-	if conn == nil {
-		return 0, nil, fmt.Errorf("连接未建立")
+// clientFeatures 返回客户端支持的协议特性
+func (c *VPNClient) clientFeatures() []string {
+	features := []string{FeatureCompression, FeatureBatching, FeatureIPv6, FeatureHeartbeatRTT, FeatureGoodbye}
+	// 经过代理时不知道服务端的实际地址，无法建立UDP数据通道
+	if proxy, err := c.transportProxy(); err == nil && proxy == nil {
+		features = append(features, FeatureUDP)
 	}
-
-	// 读取消息头（13字节）
-	header := make([]byte, 13)
-	_, err := io.ReadFull(conn, header)
-	if err != nil {
-		return 0, nil, err
+	if c.config.GetBondLinks() > 1 {
+		features = append(features, FeatureBonding)
 	}
+	return features
+}
 
-	// 手动解析消息头
-	msgType := MessageType(header[0])
-	length := binary.BigEndian.Uint32(header[1:5])
-	sequence := binary.BigEndian.Uint32(header[5:9])
-	checksum := binary.BigEndian.Uint32(header[9:13])
-
-	// 读取消息体
-	payload := make([]byte, length)
-	if length > 0 {
-		_, err = io.ReadFull(conn, payload)
-		if err != nil {
-			return 0, nil, err
-		}
+// SendHeartbeat 在所有连接上发送心跳，发送失败的连接被关闭（由其接收循环移除），
+// 所有连接都发送失败时返回错误
+func (c *VPNClient) SendHeartbeat() error {
+	links := c.getLinks()
+	if len(links) == 0 {
+		return fmt.Errorf("连接未建立")
 	}
 
-	// 验证序列号（心跳和IP分配消息除外）
-	if msgType != MessageTypeHeartbeat && msgType != MessageTypeIPAssignment {
-		c.seqMutex.Lock()
-		// 检测重放攻击（序列号回退）
-		if sequence < c.recvSeq {
-			c.seqMutex.Unlock()
-			return 0, nil, fmt.Errorf("检测到重放攻击：期望序列号 >= %d，收到 %d", c.recvSeq, sequence)
+	measure := c.hello != nil && c.hello.HasFeature(FeatureHeartbeatRTT)
+	var lastErr error
+	sent := 0
+	for _, link := range links {
+		var payload []byte
+		if measure {
+			payload = c.quality.probe()
 		}
-		// 检测消息丢失（序列号跳跃）
-		if sequence > c.recvSeq+1 && c.recvSeq > 0 {
-			log.Printf("警告：检测到消息丢失，期望序列号 %d，收到 %d", c.recvSeq+1, sequence)
+		if err := link.writeHeartbeat(payload); err != nil {
+			lastErr = err
+			link.close()
+			continue
 		}
-		c.recvSeq = sequence
-		c.seqMutex.Unlock()
+		sent++
 	}
-
-	// 验证校验和（如果提供）
-	if checksum != 0 && len(payload) > 0 {
-		actualChecksum := crc32.ChecksumIEEE(payload)
-		if actualChecksum != checksum {
-			return 0, nil, fmt.Errorf("消息校验和不匹配: 期望 %d, 收到 %d", actualChecksum, checksum)
-		}
+	if sent == 0 {
+		return lastErr
 	}
-
-	return msgType, payload, nil
+	return nil
 }
 
 // Run 运行客户端（接受 context 控制生命周期）
@@ -352,25 +403,30 @@ func (c *VPNClient) Run(ctx context.Context) {
 			if ctx.Err() != nil {
 				return
 			}
-			c.retryCount++
-			if maxRetries > 0 && c.retryCount >= maxRetries {
-				log.Printf("连接失败: %v，已达最大重试次数(%d)，停止重连", err, maxRetries)
-				atomic.StoreInt32(&c.reconnect, 0)
-				break
+			// 地址池已满或流量配额已用尽时按服务端的建议退避，不计入重连次数
+			if reason := c.pendingGoodbyeReason(); reason != GoodbyeReasonPoolFull && reason != GoodbyeReasonQuota {
+				c.retryCount++
+				if maxRetries > 0 && c.retryCount >= maxRetries {
+					log.Printf("连接失败: %v，已达最大重试次数(%d)，停止重连", err, maxRetries)
+					atomic.StoreInt32(&c.reconnect, 0)
+					break
+				}
 			}
-			log.Printf("连接失败: %v，%v秒后重试 (%d/%d)", err, c.config.ReconnectDelay/time.Second, c.retryCount, maxRetries)
+			delay := c.nextReconnectDelay()
+			log.Printf("连接失败: %v，%v后重试 (%d/%d)", err, delay, c.retryCount, maxRetries)
 
 			// 可中断的等待
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(c.config.ReconnectDelay):
+			case <-time.After(delay):
 			}
 			continue
 		}
 
 		// 连接成功，重置计数器
 		c.retryCount = 0
+		c.poolFullCount = 0
 		log.Println("VPN客户端已连接，开始数据传输...")
 
 		// 如果有TUN设备，配置它
@@ -382,7 +438,10 @@ func (c *VPNClient) Run(ctx context.Context) {
 			}
 
 			// 配置路由
-			if err := c.setupRoutes(); err != nil {
+			c.routeMutex.Lock()
+			err := c.setupRoutes()
+			c.routeMutex.Unlock()
+			if err != nil {
 				log.Printf("配置路由失败: %v", err)
 				c.closeConnection()
 				continue
@@ -400,8 +459,23 @@ func (c *VPNClient) Run(ctx context.Context) {
 			go c.handleTUNRead(sessionCtx)
 		}
 
-		// 数据传输循环
-		c.dataLoop(sessionCtx)
+		// 如果协商了UDP数据通道，启动探测和接收协程
+		if udpConn, _ := c.getUDP(); udpConn != nil {
+			go c.udpReadLoop(sessionCtx)
+			go c.udpProbeLoop(sessionCtx)
+			go c.pmtuLoop(sessionCtx)
+		}
+
+		// 服务端允许多连接聚合时，建立其余成员连接并在断开后补齐
+		c.connMutex.Lock()
+		bonding := c.bondToken != "" && c.bondLinks > 1
+		c.connMutex.Unlock()
+		if bonding {
+			go c.maintainBond(sessionCtx)
+		}
+
+		// 数据传输循环（所有连接都断开后返回）
+		c.runLinks(sessionCtx)
 
 		// 停止本次会话的所有协程
 		sessionCancel()
@@ -414,12 +488,19 @@ func (c *VPNClient) Run(ctx context.Context) {
 			return
 		}
 
+		// 被管理员踢出：不再自动重连，清理路由和TUN设备
+		if c.pendingGoodbyeReason() == GoodbyeReasonKick {
+			log.Println("已被管理员断开连接，不再自动重连")
+			c.Close()
+			break
+		}
+
 		if atomic.LoadInt32(&c.reconnect) == 1 {
 			log.Println("连接断开，准备重连...")
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(c.config.ReconnectDelay):
+			case <-time.After(c.nextReconnectDelay()):
 			}
 		}
 	}
@@ -429,7 +510,7 @@ func (c *VPNClient) Run(ctx context.Context) {
 
 // startHeartbeat 开始心跳
 func (c *VPNClient) startHeartbeat(ctx context.Context) {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(heartbeatInterval)
 	defer ticker.Stop()
 
 	for {
@@ -457,6 +538,11 @@ func (c *VPNClient) startHeartbeat(ctx context.Context) {
 
 // handleTUNRead 处理从TUN设备读取的数据
 func (c *VPNClient) handleTUNRead(ctx context.Context) {
+	if bd, ok := asBatchTUN(c.tunDevice); ok {
+		c.handleTUNReadBatch(ctx, bd)
+		return
+	}
+
 	packet := make([]byte, c.config.MTU)
 
 	for {
@@ -488,8 +574,15 @@ func (c *VPNClient) handleTUNRead(ctx context.Context) {
 	}
 }
 
-// dataLoop 数据传输循环
-func (c *VPNClient) dataLoop(ctx context.Context) {
+// handleTUNReadBatch 批量读取offload TUN设备，一次读取得到的分段全部加入批量发送器后立即发送，
+// 使同一个超级包的分段合并为一条批量消息穿过隧道
+func (c *VPNClient) handleTUNReadBatch(ctx context.Context, dev batchTUNDevice) {
+	bufs := make([][]byte, dev.BatchSize())
+	sizes := make([]int, len(bufs))
+	for i := range bufs {
+		bufs[i] = make([]byte, c.config.MTU)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -497,17 +590,57 @@ func (c *VPNClient) dataLoop(ctx context.Context) {
 		default:
 		}
 
-		c.connMutex.Lock()
-		conn := c.conn
-		c.connMutex.Unlock()
+		n, err := dev.ReadBatch(bufs, sizes)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("从TUN设备读取失败: %v", err)
+			}
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			if sizes[i] < 20 { // IP header minimum size
+				continue
+			}
+			if err := c.SendData(bufs[i][:sizes[i]]); err != nil {
+				log.Printf("发送数据包失败: %v", err)
+				return
+			}
+		}
+
+		if err := c.flushBatchers(); err != nil {
+			log.Printf("发送数据包失败: %v", err)
+			return
+		}
+	}
+}
+
+// dataLoop 一条连接的数据接收循环
+func (c *VPNClient) dataLoop(ctx context.Context, link *clientLink) {
+	conn := link.conn
 
-		if conn == nil {
+	// 复用读取和解压缓冲区，数据包在投递完成前不会被保留
+	reader := newFrameReader(conn)
+	defer reader.Release()
+	scratch := getPacketBuffer()
+	defer scratch.release()
+	// TUN设备支持批量写入时，批量消息中的包一次写入，由内核GRO重新合并为超级包
+	tunBatch := newTUNWriteBatch(c.tunDevice)
+
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		default:
+		}
+
+		if !c.hasLink(link) {
+			return // 连接已关闭或已移除
 		}
 
 		_ = conn.SetReadDeadline(time.Now().Add(c.config.KeepAliveTimeout))
 
-		msgType, data, err := c.ReceiveData()
+		msgType, data, err := link.receive(reader)
 		if err != nil {
 			if ctx.Err() != nil {
 				return // context 已取消
@@ -520,8 +653,24 @@ func (c *VPNClient) dataLoop(ctx context.Context) {
 			return
 		}
 
-		// 处理心跳响应 - 不打印日志
+		// 处理心跳：回显服务端的探测，记录本端探测的回显
 		if msgType == MessageTypeHeartbeat {
+			if err := c.handleHeartbeat(link, data); err != nil {
+				log.Printf("回应心跳失败: %v", err)
+				return
+			}
+			continue
+		}
+
+		// 服务端通知断开：关闭所有连接，按建议的延迟重连
+		if msgType == MessageTypeGoodbye {
+			c.handleGoodbye(data)
+			return
+		}
+
+		// 服务端通知：记录后继续接收
+		if msgType == MessageTypeNotice {
+			c.handleNotice(data)
 			continue
 		}
 
@@ -540,29 +689,173 @@ func (c *VPNClient) dataLoop(ctx context.Context) {
 			continue
 		}
 
-		// 处理数据包
-		if msgType == MessageTypeData && data != nil && len(data) > 0 {
-			if c.tunDevice != nil {
-				// 直接写入TUN设备（Windows Wintun和Unix/Linux TUN都是Layer 3）
-				_, err := c.tunDevice.Write(data)
+		// 处理批量数据包
+		if msgType == MessageTypeBatch {
+			err := splitBatch(data, func(packet []byte, compressed bool) error {
+				packet, err := decodePacket(scratch.b, packet, compressed)
 				if err != nil {
-					log.Printf("写入TUN设备失败: %v", err)
+					return err
 				}
-			} else if c.packetHandler != nil {
-				// 使用自定义处理器
-				err := c.packetHandler(data)
-				if err != nil {
-					log.Printf("处理数据包失败: %v", err)
+				c.deliverPacket(packet, tunBatch)
+				return nil
+			})
+			if tunBatch != nil {
+				if err := tunBatch.Flush(); err != nil {
+					log.Printf("写入TUN设备失败: %v", err)
 				}
-			} else {
-				// 默认处理：打印数据包信息
-				log.Printf("接收到数据包，长度: %d, 内容: %s", len(data), hex.EncodeToString(data[:min(len(data), 16)]))
 			}
+			if err != nil {
+				log.Printf("批量消息格式错误: %v", err)
+				return
+			}
+			continue
 		}
+
+		// 处理数据包
+		if msgType == MessageTypeData {
+			c.deliverPacket(data, nil)
+		} else if msgType == MessageTypeCompressedData {
+			packet, err := decompressPacket(scratch.b, data)
+			if err != nil {
+				log.Printf("%v", err)
+				return
+			}
+			c.deliverPacket(packet, nil)
+		}
+	}
+}
+
+// handleGoodbye 处理服务端的断开通知：记录原因和重连等待时间并关闭所有连接，
+// 所有连接的接收循环退出后 Run 按原因决定是否重连以及等待多久
+func (c *VPNClient) handleGoodbye(data []byte) {
+	if c.recordGoodbye(data) == nil {
+		return
+	}
+
+	c.connMutex.Lock()
+	c.bondToken = "" // 停止补齐成员连接
+	links := c.links
+	c.connMutex.Unlock()
+	for _, link := range links {
+		link.close()
+	}
+}
+
+// recordGoodbye 解析并记录服务端的断开通知，解析失败返回nil
+func (c *VPNClient) recordGoodbye(data []byte) *Goodbye {
+	var goodbye Goodbye
+	if err := json.Unmarshal(data, &goodbye); err != nil {
+		log.Printf("解析断开通知失败: %v", err)
+		return nil
 	}
+	log.Printf("服务端通知断开 (%s): %s", goodbyeReasonName(goodbye.Reason), goodbye.Message)
+
+	c.connMutex.Lock()
+	c.goodbye = &goodbye
+	c.disconnect = &goodbye
+	c.connMutex.Unlock()
+	return &goodbye
+}
+
+// pendingGoodbyeReason 返回尚未用于重连的断开通知的原因（没有时为空）
+func (c *VPNClient) pendingGoodbyeReason() string {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	if c.goodbye == nil {
+		return ""
+	}
+	return c.goodbye.Reason
 }
 
-// setupRoutes 设置路由（根据配置模式）
+// LastDisconnect 返回服务端最近一次断开通知的原因和说明（没有时为空）
+func (c *VPNClient) LastDisconnect() (reason, message string) {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	if c.disconnect == nil {
+		return "", ""
+	}
+	return c.disconnect.Reason, c.disconnect.Message
+}
+
+// handleNotice 处理服务端的通知
+func (c *VPNClient) handleNotice(data []byte) {
+	var notice Notice
+	if err := json.Unmarshal(data, &notice); err != nil {
+		log.Printf("解析服务端通知失败: %v", err)
+		return
+	}
+	log.Printf("服务端通知 (%s): %s", notice.Kind, notice.Message)
+
+	c.connMutex.Lock()
+	c.notice = &notice
+	c.connMutex.Unlock()
+}
+
+// LastNotice 返回服务端最近一次发来的通知内容（没有时为空）
+func (c *VPNClient) LastNotice() string {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	if c.notice == nil {
+		return ""
+	}
+	return c.notice.Message
+}
+
+// nextReconnectDelay 返回下一次重连前的等待时间：服务端通知断开时使用其建议值，
+// 地址池已满时在建议值的基础上按连续被拒绝的次数指数退避（最长 poolFullMaxBackoff）
+func (c *VPNClient) nextReconnectDelay() time.Duration {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	goodbye := c.goodbye
+	c.goodbye = nil
+	if goodbye == nil {
+		return c.config.ReconnectDelay
+	}
+
+	delay := time.Duration(goodbye.RetryAfterMs) * time.Millisecond
+	if goodbye.Reason == GoodbyeReasonPoolFull {
+		delay = max(delay, c.config.ReconnectDelay)
+		for i := 0; i < c.poolFullCount && delay < poolFullMaxBackoff; i++ {
+			delay *= 2
+		}
+		delay = min(delay, poolFullMaxBackoff)
+		c.poolFullCount++
+	}
+	return delay
+}
+
+// deliverPacket 处理从服务端收到的IP包，batch 不为nil时只加入TUN写批次，由调用方 Flush
+func (c *VPNClient) deliverPacket(data []byte, batch *tunWriteBatch) {
+	if len(data) == 0 {
+		return
+	}
+	if !c.config.DisableMSSClamp {
+		clampTCPMSS(data, c.tunnelMTU())
+	}
+	if c.tunDevice != nil {
+		// 直接写入TUN设备（Windows Wintun和Unix/Linux TUN都是Layer 3）
+		var err error
+		if batch != nil {
+			err = batch.Add(data)
+		} else {
+			_, err = c.tunDevice.Write(data)
+		}
+		if err != nil {
+			log.Printf("写入TUN设备失败: %v", err)
+		}
+	} else if c.packetHandler != nil {
+		// 使用自定义处理器
+		err := c.packetHandler(data)
+		if err != nil {
+			log.Printf("处理数据包失败: %v", err)
+		}
+	} else {
+		// 默认处理：打印数据包信息
+		log.Printf("接收到数据包，长度: %d, 内容: %s", len(data), hex.EncodeToString(data[:min(len(data), 16)]))
+	}
+}
+
+// setupRoutes 设置路由（根据配置模式，调用方持有 routeMutex）
 func (c *VPNClient) setupRoutes() error {
 	// 创建路由管理器
 	rm, err := NewRouteManager()
@@ -571,29 +864,33 @@ func (c *VPNClient) setupRoutes() error {
 	}
 	c.routeManager = rm
 
-	// 获取服务器IP（去掉CIDR后缀）
-	serverIP := c.config.ServerAddress
-
-	// 添加到VPN服务器的路由，确保不走VPN
-	serverRoute := serverIP + "/32"
-	if err := rm.AddRoute(serverRoute, rm.defaultGateway, rm.defaultIface); err != nil {
+	// 添加到VPN服务器的路由，确保不走VPN（使用连接时实际解析到的地址）
+	c.connMutex.Lock()
+	serverIP := c.serverAddrIP
+	c.connMutex.Unlock()
+	if serverIP == nil {
+		log.Printf("警告：未知服务器地址，跳过添加服务器路由")
+	} else if gateway, iface := rm.bypassGateway(serverIP); gateway == "" {
+		log.Printf("警告：未检测到可达服务器 %s 的默认网关，跳过添加服务器路由", serverIP)
+	} else if err := rm.AddRoute(hostRoute(serverIP), gateway, iface); err != nil {
 		log.Printf("警告：添加到VPN服务器的路由失败: %v", err)
 	}
 
 	// 根据路由模式设置路由
-	switch c.config.RouteMode {
+	routes := c.routes.Load()
+	switch routes.RouteMode {
 	case "full":
-		return c.setupFullTunnelRoutes(rm)
+		return c.setupFullTunnelRoutes(rm, routes)
 	case "split":
-		return c.setupSplitTunnelRoutes(rm)
+		return c.setupSplitTunnelRoutes(rm, routes)
 	default:
-		log.Printf("警告：未知的路由模式 %s，使用分流模式", c.config.RouteMode)
-		return c.setupSplitTunnelRoutes(rm)
+		log.Printf("警告：未知的路由模式 %s，使用分流模式", routes.RouteMode)
+		return c.setupSplitTunnelRoutes(rm, routes)
 	}
 }
 
 // setupFullTunnelRoutes 设置全流量模式路由
-func (c *VPNClient) setupFullTunnelRoutes(rm *RouteManager) error {
+func (c *VPNClient) setupFullTunnelRoutes(rm *RouteManager, routes *pushedConfig) error {
 	log.Println("配置全流量代理模式...")
 
 	// 获取VPN网关（服务器在VPN网络中的IP）
@@ -615,10 +912,9 @@ func (c *VPNClient) setupFullTunnelRoutes(rm *RouteManager) error {
 	tunDeviceName := c.tunDevice.Name()
 
 	// 添加 0.0.0.0/1 和 128.0.0.0/1 路由（覆盖所有IP）
-	routes := []string{"0.0.0.0/1", "128.0.0.0/1"}
-	for _, route := range routes {
+	for _, route := range []string{"0.0.0.0/1", "128.0.0.0/1"} {
 		// 检查是否被排除
-		if c.isExcluded(route) {
+		if slices.Contains(routes.ExcludeRoutes, route) {
 			log.Printf("跳过被排除的路由: %s", route)
 			continue
 		}
@@ -629,20 +925,21 @@ func (c *VPNClient) setupFullTunnelRoutes(rm *RouteManager) error {
 	}
 
 	// 处理排除路由 - 添加到原始网关
-	for _, excludeRoute := range c.config.ExcludeRoutes {
-		if err := rm.AddRoute(excludeRoute, rm.defaultGateway, rm.defaultIface); err != nil {
+	for _, excludeRoute := range routes.ExcludeRoutes {
+		gateway, iface := rm.bypassRouteGateway(excludeRoute)
+		if err := rm.AddRoute(excludeRoute, gateway, iface); err != nil {
 			log.Printf("警告：添加排除路由 %s 失败: %v", excludeRoute, err)
 		}
 	}
 
 	// 配置DNS（如果启用）
-	if c.config.RedirectDNS && len(c.config.DNSServers) > 0 {
+	if routes.RedirectDNS && len(routes.DNSServers) > 0 {
 		if err := rm.SaveDNS(); err != nil {
 			log.Printf("警告：保存DNS配置失败: %v", err)
 		} else {
 			// Windows上需要在VPN接口上设置DNS，而不是物理网卡
 			tunDeviceName := c.tunDevice.Name()
-			if err := rm.SetDNSForInterface(c.config.DNSServers, tunDeviceName); err != nil {
+			if err := rm.SetDNSForInterface(routes.DNSServers, tunDeviceName); err != nil {
 				log.Printf("警告：设置DNS失败: %v", err)
 			}
 		}
@@ -653,47 +950,24 @@ func (c *VPNClient) setupFullTunnelRoutes(rm *RouteManager) error {
 }
 
 // setupSplitTunnelRoutes 设置分流模式路由
-func (c *VPNClient) setupSplitTunnelRoutes(rm *RouteManager) error {
+func (c *VPNClient) setupSplitTunnelRoutes(rm *RouteManager, routes *pushedConfig) error {
 	log.Println("配置分流模式...")
 
-	// 获取VPN网关
-	vpnGateway := ""
-	if c.config.ServerIP != "" {
-		for i := 0; i < len(c.config.ServerIP); i++ {
-			if c.config.ServerIP[i] == '/' {
-				vpnGateway = c.config.ServerIP[:i]
-				break
-			}
+	// 只添加 push_routes 中的路由（IPv6路由使用服务器的IPv6隧道地址作为网关）
+	for _, route := range routes.PushRoutes {
+		gateway, tunDeviceName, ok := c.tunnelRouteGateway(route)
+		if !ok {
+			continue
 		}
-	}
-	if vpnGateway == "" {
-		vpnGateway = "10.8.0.1"
-	}
-
-	// 使用TUN设备名称
-	tunDeviceName := c.tunDevice.Name()
-
-	// 只添加 push_routes 中的路由
-	for _, route := range c.config.PushRoutes {
-		if err := rm.AddRoute(route, vpnGateway, tunDeviceName); err != nil {
+		if err := rm.AddRoute(route, gateway, tunDeviceName); err != nil {
 			log.Printf("警告：添加路由 %s 失败: %v", route, err)
 		}
 	}
 
-	log.Printf("分流模式配置完成，已添加 %d 条路由", len(c.config.PushRoutes))
+	log.Printf("分流模式配置完成，已添加 %d 条路由", len(routes.PushRoutes))
 	return nil
 }
 
-// isExcluded 检查路由是否被排除
-func (c *VPNClient) isExcluded(route string) bool {
-	for _, excludeRoute := range c.config.ExcludeRoutes {
-		if route == excludeRoute {
-			return true
-		}
-	}
-	return false
-}
-
 // closeConnection 关闭当前连接（不停止整个客户端，用于重连场景）
 func (c *VPNClient) closeConnection() {
 	c.connMutex.Lock()
@@ -701,7 +975,28 @@ func (c *VPNClient) closeConnection() {
 		_ = c.conn.Close()
 		c.conn = nil
 	}
+	for _, link := range c.links {
+		link.close()
+	}
+	c.links = nil
+	c.closeLinksDone()
+	c.bondToken = ""
+	if c.udpConn != nil {
+		_ = c.udpConn.Close()
+		c.udpConn = nil
+		c.udp = nil
+	}
 	c.connMutex.Unlock()
+	atomic.StoreInt64(&c.udpLastRecv, 0)
+	atomic.StoreInt32(&c.compression, 0)
+}
+
+// DataChannel 返回当前数据包使用的传输通道（"udp" 或 "tcp"）
+func (c *VPNClient) DataChannel() string {
+	if c.udpActive() {
+		return "udp"
+	}
+	return "tcp"
 }
 
 // Close 关闭客户端（完全停止）
@@ -716,10 +1011,12 @@ func (c *VPNClient) Close() {
 	c.cancelMutex.Unlock()
 
 	// 清理路由和DNS
+	c.routeMutex.Lock()
 	if c.routeManager != nil {
 		c.routeManager.CleanupRoutes()
 		_ = c.routeManager.RestoreDNS()
 	}
+	c.routeMutex.Unlock()
 
 	// 关闭连接
 	c.closeConnection()
@@ -738,35 +1035,3 @@ func (c *VPNClient) IsRunning() bool {
 	defer c.cancelMutex.Unlock()
 	return c.cancel != nil
 }
-
-// applyServerConfig 应用服务器推送的配置
-func (c *VPNClient) applyServerConfig(config *ClientConfig) error {
-	log.Printf("收到服务器配置: DNS=%v, Routes=%v, MTU=%d", config.DNS, config.Routes, config.MTU)
-
-	// 记录DNS服务器（可以修改/etc/resolv.conf，但需谨慎）
-	for _, dns := range config.DNS {
-		log.Printf("推荐DNS: %s", dns)
-	}
-
-	// 添加路由
-	tunDeviceName := c.tunDevice.Name()
-	for _, route := range config.Routes {
-		// 解析服务器IP以获取网关（去掉CIDR后缀）
-		serverIPStr := config.ServerIP
-		for i := 0; i < len(serverIPStr); i++ {
-			if serverIPStr[i] == '/' {
-				serverIPStr = serverIPStr[:i]
-				break
-			}
-		}
-
-		output, err := runCmdCombined("ip", "route", "add", route, "via", serverIPStr, "dev", tunDeviceName)
-		if err != nil {
-			log.Printf("警告：添加路由 %s 失败: %v, 输出: %s", route, err, string(output))
-		} else {
-			log.Printf("已添加路由: %s via %s dev %s", route, serverIPStr, tunDeviceName)
-		}
-	}
-
-	return nil
-}
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"hash/crc32"
@@ -11,6 +10,8 @@ import (
 	"log"
 	mathrand "math/rand"
 	"net"
+	"net/http"
+	"net/netip"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -20,20 +21,57 @@ import (
 // VPNSession VPN会话结构
 type VPNSession struct {
 	ID           string
-	RemoteAddr   net.Addr
-	TLSConn      *tls.Conn
+	RemoteAddr   net.Addr // 第一条连接的客户端地址
 	LastActivity time.Time
 	IP           net.IP
+	IP6          net.IP // IPv6隧道地址（未启用IPv6时为nil）
 	CertSubject  string // 证书主题，用于绑定IP
+	Group        string // 客户端分组（证书OU），用于客户端互访策略
 	closed       bool   // 标记会话是否已关闭
-	mutex        sync.RWMutex
-	sendSeq      uint32     // 新增：发送序列号
-	recvSeq      uint32     // 新增：接收序列号
-	seqMutex     sync.Mutex // 新增：序列号锁
+	// 协商结果（旧版客户端为版本1，无特性）
+	ProtocolVersion int
+	Features        []string
+	mutex           sync.RWMutex
 	// 流量统计
-	BytesSent     uint64    // 发送字节数
-	BytesReceived uint64    // 接收字节数
+	BytesSent     uint64    // 发送字节数（压缩前）
+	BytesReceived uint64    // 接收字节数（解压后）
 	ConnectedAt   time.Time // 连接时间
+	// 线路流量统计（数据消息负载压缩后的实际字节数）
+	WireBytesSent     uint64
+	WireBytesReceived uint64
+	compression       int32 // 客户端已接受压缩（使用 atomic，1=true）
+	// UDP数据通道（未启用时为nil）
+	udp         *udpCipher
+	udpAddr     *net.UDPAddr // 最近一次认证通过的UDP源地址
+	udpLastRecv time.Time    // 最近一次收到UDP数据报的时间
+	pathMTU     int32        // 客户端报告的UDP路径MTU（0=未报告，使用 atomic）
+	// 批量传输（客户端声明支持批量数据包时每条连接创建批量发送器）
+	batching bool
+	// 客户端支持 Goodbye 断开通知（见 server_drain.go），不支持时直接关闭会话
+	goodbye bool
+	// TLS成员连接（见 session_link.go），至少有一条
+	links      []*sessionLink
+	nextLinkID int
+	bondToken  string // 成员连接加入本会话的凭据（未启用聚合时为空）
+	// 已断开连接的发送队列丢弃统计
+	droppedMessages uint64
+	droppedBytes    uint64
+	// 持有的IP租约（同一证书的另一个会话已占用租约地址时为空）
+	leaseKey string
+	// 带宽限速（见 rate_limit.go），上行为客户端到服务端方向
+	rateUp   rateBucket
+	rateDown rateBucket
+	// 流量配额（见 quota.go）：已计入配额的流量统计，以及是否已按超出配额处理（使用 atomic，1=true）
+	quotaSent     uint64
+	quotaReceived uint64
+	quotaExceeded int32
+	// 链路质量（见 link_quality.go）：客户端支持带时间戳的心跳时服务端主动探测
+	measureRTT bool
+	quality    linkQuality
+	// 被ACL丢弃的包数（见 acl.go，使用 atomic）
+	aclDenied uint64
+	// 源地址校验（见 source_guard.go）
+	source sourceGuard
 }
 
 // UpdateActivity 更新活动时间
@@ -57,20 +95,44 @@ func (s *VPNSession) IsClosed() bool {
 	return s.closed
 }
 
-// Close 关闭会话连接
+// Close 关闭会话的所有连接
 func (s *VPNSession) Close() error {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
 	if s.closed {
+		s.mutex.Unlock()
 		return nil // 已经关闭
 	}
 	s.closed = true
-	if s.TLSConn != nil {
-		return s.TLSConn.Close()
+	links := s.links
+	s.mutex.Unlock()
+
+	for _, link := range links {
+		link.close()
 	}
 	return nil
 }
 
+// setUDPPeer 记录客户端的UDP地址（仅在数据报认证通过后调用）
+func (s *VPNSession) setUDPPeer(addr *net.UDPAddr) (changed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	changed = s.udpAddr == nil || !s.udpAddr.IP.Equal(addr.IP) || s.udpAddr.Port != addr.Port ||
+		time.Since(s.udpLastRecv) > udpActiveTimeout
+	s.udpAddr = addr
+	s.udpLastRecv = time.Now()
+	return changed
+}
+
+// udpPeer 获取可用的UDP对端地址，UDP通道不可用时返回nil
+func (s *VPNSession) udpPeer() *net.UDPAddr {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.udp == nil || s.udpAddr == nil || time.Since(s.udpLastRecv) > udpActiveTimeout {
+		return nil
+	}
+	return s.udpAddr
+}
+
 // AddBytesSent 增加发送字节数
 func (s *VPNSession) AddBytesSent(n uint64) {
 	atomic.AddUint64(&s.BytesSent, n)
@@ -81,6 +143,48 @@ func (s *VPNSession) AddBytesReceived(n uint64) {
 	atomic.AddUint64(&s.BytesReceived, n)
 }
 
+// AddWireBytesSent 增加线路发送字节数
+func (s *VPNSession) AddWireBytesSent(n uint64) {
+	atomic.AddUint64(&s.WireBytesSent, n)
+}
+
+// AddWireBytesReceived 增加线路接收字节数
+func (s *VPNSession) AddWireBytesReceived(n uint64) {
+	atomic.AddUint64(&s.WireBytesReceived, n)
+}
+
+// GetWireStats 获取线路流量统计
+func (s *VPNSession) GetWireStats() (sent, received uint64) {
+	return atomic.LoadUint64(&s.WireBytesSent), atomic.LoadUint64(&s.WireBytesReceived)
+}
+
+// GetDropStats 获取发送队列丢弃的消息数和字节数（所有连接合计）
+func (s *VPNSession) GetDropStats() (messages, bytes uint64) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	messages, bytes = s.droppedMessages, s.droppedBytes
+	for _, link := range s.links {
+		m, b := link.sendQueue.DropStats()
+		messages += m
+		bytes += b
+	}
+	return messages, bytes
+}
+
+// SendQueueLen 获取排队中的数据消息数（所有连接合计）
+func (s *VPNSession) SendQueueLen() int {
+	n := 0
+	for _, link := range s.getLinks() {
+		n += link.sendQueue.Len()
+	}
+	return n
+}
+
+// compressionEnabled 是否对发往客户端的数据包进行压缩
+func (s *VPNSession) compressionEnabled() bool {
+	return atomic.LoadInt32(&s.compression) == 1
+}
+
 // GetStats 获取会话统计信息
 func (s *VPNSession) GetStats() (sent, received uint64, connTime time.Duration) {
 	sent = atomic.LoadUint64(&s.BytesSent)
@@ -91,21 +195,38 @@ func (s *VPNSession) GetStats() (sent, received uint64, connTime time.Duration)
 
 // VPNServer VPN服务器结构
 type VPNServer struct {
+	tcpListener   net.Listener // TLS监听套接字下层的TCP监听套接字（交接给新进程时传递其描述符）
 	listener      net.Listener
+	udpConn       *net.UDPConn // UDP数据通道（未启用时为nil）
+	wsListener    net.Listener // WebSocket传输的TCP监听套接字（未启用时为nil，见 websocket.go）
+	wsServer      *http.Server // WebSocket传输的HTTPS服务（运行时设置，受 cancelMutex 保护）
 	tlsConfig     *tls.Config
-	sessions      map[string]*VPNSession
-	ipToSession   map[string]*VPNSession // IP到会话的快速映射
-	sessionMutex  sync.RWMutex
+	sessions      *sessionRegistry   // 会话注册表（写时复制，查找无锁）
 	cancel        context.CancelFunc // 用于停止服务器
 	cancelMutex   sync.Mutex
 	vpnNetwork    *net.IPNet
 	clientIPPool  *IPPool
+	vpnNetwork6   *net.IPNet // IPv6隧道网段（未启用时为nil）
+	clientIPPool6 *IPPool    // IPv6地址池（未启用时为nil）
+	serverIP6     net.IP
+	leases        *leaseDB // 客户端证书到隧道地址的租约（持久化）
+	quotas        *quotaDB // 各客户端在当前配额周期内的流量（持久化）
 	packetHandler func([]byte) error
-	sessionCount  int64
 	config        VPNConfig
 	tunDevice     TUNDevice // 统一的TUN设备接口
 	serverIP      net.IP
 	natRules      []NATRule // NAT规则跟踪
+	natMutex      sync.Mutex
+	icmpLimiter   *icmpRateLimiter                // 目标不可达报文的速率限制
+	clientSubnets map[string][]netip.Prefix       // 按证书CN授权的源网段（见 source_guard.go）
+	rateLimits    atomic.Pointer[rateLimitPolicy] // 会话带宽限速配置（运行时可替换）
+	pushed        atomic.Pointer[pushedConfig]    // 推送给客户端的路由和DNS配置（运行时可替换，见 config_push.go）
+	acl           atomic.Pointer[aclPolicy]       // 访问控制规则（运行时可替换，见 acl.go）
+	spoofLimit    int64                           // 伪造源地址的包数达到该值时断开会话（运行时可修改，使用 atomic，见 source_guard.go）
+	quotaPolicy   atomic.Pointer[quotaPolicy]     // 流量配额配置（运行时可替换，见 quota.go）
+	draining      int32                           // 排空中，不再接受新连接（使用 atomic，1=true）
+	handedOff     int32                           // 已交接给新进程，停止时保留TUN设备和NAT规则（使用 atomic，1=true）
+	stopOnce      sync.Once
 }
 
 // NewVPNServer 创建新的VPN服务器
@@ -115,28 +236,112 @@ func NewVPNServer(address string, certManager *CertificateManager, config VPNCon
 		return nil, fmt.Errorf("配置验证失败: %v", err)
 	}
 
-	serverConfig := certManager.ServerTLSConfig()
-	listener, err := tls.Listen("tcp", address, serverConfig)
+	tcpListener, err := net.Listen("tcp", address)
 	if err != nil {
 		return nil, fmt.Errorf("监听失败: %v", err)
 	}
 
+	// 启用UDP数据通道时，在同一主机地址上监听UDP端口
+	var udpConn *net.UDPConn
+	if config.EnableUDP {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			tcpListener.Close()
+			return nil, fmt.Errorf("解析监听地址失败: %v", err)
+		}
+		udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, fmt.Sprintf("%d", config.GetUDPPort())))
+		if err != nil {
+			tcpListener.Close()
+			return nil, fmt.Errorf("解析UDP监听地址失败: %v", err)
+		}
+		udpConn, err = net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			tcpListener.Close()
+			return nil, fmt.Errorf("UDP监听失败: %v", err)
+		}
+	}
+
+	// 启用WebSocket传输时，在同一主机地址上监听HTTPS端口
+	var wsListener net.Listener
+	if config.WebSocketPort > 0 {
+		host, _, _ := net.SplitHostPort(address)
+		wsListener, err = net.Listen("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", config.WebSocketPort)))
+		if err != nil {
+			tcpListener.Close()
+			if udpConn != nil {
+				udpConn.Close()
+			}
+			return nil, fmt.Errorf("WebSocket监听失败: %v", err)
+		}
+	}
+
+	server, err := newVPNServer(tcpListener, udpConn, wsListener, certManager, config)
+	if err != nil {
+		tcpListener.Close()
+		if udpConn != nil {
+			udpConn.Close()
+		}
+		if wsListener != nil {
+			wsListener.Close()
+		}
+		return nil, err
+	}
+	return server, nil
+}
+
+// newVPNServer 在已打开的监听套接字上创建服务器（无中断重启时套接字继承自旧进程）
+func newVPNServer(tcpListener net.Listener, udpConn *net.UDPConn, wsListener net.Listener, certManager *CertificateManager, config VPNConfig) (*VPNServer, error) {
+	leases, err := loadLeaseDB(config.GetLeaseFile(), config.GetLeaseDuration())
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := loadQuotaDB(config.GetQuotaFile())
+	if err != nil {
+		return nil, err
+	}
+
 	_, vpnNetwork, err := net.ParseCIDR(config.Network)
 	if err != nil {
 		return nil, fmt.Errorf("解析VPN网络失败: %v", err)
 	}
 
-	return &VPNServer{
-		listener:     listener,
-		tlsConfig:    serverConfig,
-		sessions:     make(map[string]*VPNSession),
-		ipToSession:  make(map[string]*VPNSession),
-		vpnNetwork:   vpnNetwork,
-		clientIPPool: NewIPPool(vpnNetwork, &config),
-		config:       config,
-		serverIP:     vpnNetwork.IP.To4(),
-		natRules:     make([]NATRule, 0),
-	}, nil
+	// IPv6隧道网段（可选）
+	serverIP6, vpnNetwork6, err := config.ParseServerIP6()
+	if err != nil {
+		return nil, err
+	}
+	var clientIPPool6 *IPPool
+	if vpnNetwork6 != nil {
+		clientIPPool6 = NewIPPool(vpnNetwork6, &config)
+	}
+
+	serverConfig := certManager.ServerTLSConfig()
+	server := &VPNServer{
+		tcpListener:   tcpListener,
+		listener:      tls.NewListener(tcpListener, serverConfig),
+		udpConn:       udpConn,
+		wsListener:    wsListener,
+		tlsConfig:     serverConfig,
+		sessions:      newSessionRegistry(),
+		vpnNetwork:    vpnNetwork,
+		clientIPPool:  NewIPPool(vpnNetwork, &config),
+		vpnNetwork6:   vpnNetwork6,
+		clientIPPool6: clientIPPool6,
+		serverIP6:     serverIP6,
+		leases:        leases,
+		quotas:        quotas,
+		config:        config,
+		serverIP:      vpnNetwork.IP.To4(),
+		natRules:      make([]NATRule, 0),
+		icmpLimiter:   newICMPRateLimiter(),
+		clientSubnets: newClientSubnets(&config),
+	}
+	server.rateLimits.Store(newRateLimitPolicy(&config))
+	server.pushed.Store(newPushedConfig(&config))
+	server.acl.Store(newACLPolicy(&config, nil))
+	server.quotaPolicy.Store(newQuotaPolicy(&config))
+	server.SetSpoofDisconnectThreshold(&config)
+	return server, nil
 }
 
 // InitializeTUN 初始化TUN设备
@@ -147,17 +352,18 @@ func (s *VPNServer) InitializeTUN() error {
 	}
 
 	// 创建TUN设备（自动选择可用名称，传入网络配置）
-	tun, err := createTUNDevice("tun", s.config.Network)
+	tun, err := createTUNDevice("tun", s.config.Network, s.config.GetTUNQueues(), s.config.TUNOffload)
 	if err != nil {
 		return err
 	}
 	s.tunDevice = tun
 	log.Printf("服务器使用TUN设备: %s", tun.Name())
 
-	// 配置TUN设备 - 服务器使用10.8.0.1/24
-	serverIP := net.IPv4(s.serverIP[0], s.serverIP[1], s.serverIP[2], 1)
+	// 配置TUN设备 - 服务器使用网段中的第一个地址（例如 10.8.0.1/24）
+	serverIP := ipAdd(s.vpnNetwork.IP, 1)
 	s.serverIP = serverIP
-	ipAddr := fmt.Sprintf("%s/24", serverIP.String())
+	ones, _ := s.vpnNetwork.Mask.Size()
+	ipAddr := fmt.Sprintf("%s/%d", serverIP.String(), ones)
 
 	if err := configureTUNDevice(tun.Name(), ipAddr, s.config.MTU); err != nil {
 		tun.Close()
@@ -165,6 +371,22 @@ func (s *VPNServer) InitializeTUN() error {
 		return err
 	}
 
+	// 配置IPv6隧道地址
+	if s.serverIP6 != nil {
+		ones6, _ := s.vpnNetwork6.Mask.Size()
+		ipAddr6 := fmt.Sprintf("%s/%d", s.serverIP6.String(), ones6)
+		if err := addTUNAddress(tun.Name(), ipAddr6); err != nil {
+			tun.Close()
+			cleanupTUNDevice(tun.Name())
+			return err
+		}
+		if err := enableIPv6Forwarding(tun.Name()); err != nil {
+			tun.Close()
+			cleanupTUNDevice(tun.Name())
+			return err
+		}
+	}
+
 	// 启用IP转发
 	if err := enableIPForwarding(); err != nil {
 		tun.Close()
@@ -191,6 +413,10 @@ func (s *VPNServer) Start(ctx context.Context) {
 	closeListener := func() {
 		closeOnce.Do(func() {
 			s.listener.Close()
+			if s.udpConn != nil {
+				s.udpConn.Close()
+			}
+			s.closeWebSocket()
 		})
 	}
 
@@ -201,14 +427,29 @@ func (s *VPNServer) Start(ctx context.Context) {
 		closeListener()
 	}()
 
-	// 如果有TUN设备，启动TUN数据转发
+	// 如果有TUN设备，启动TUN数据转发（多队列设备每个队列一个读取协程）
 	if s.tunDevice != nil {
-		go s.handleTUNRead(ctx)
+		for _, queue := range tunQueues(s.tunDevice) {
+			go s.handleTUNRead(ctx, queue)
+		}
+	}
+
+	// 如果启用了UDP数据通道，启动UDP接收协程
+	if s.udpConn != nil {
+		log.Printf("UDP数据通道已启用，监听地址: %s", s.udpConn.LocalAddr())
+		go s.handleUDPRead(ctx)
 	}
 
+	// 如果启用了WebSocket传输，启动HTTPS服务
+	s.startWebSocket(ctx)
+
 	// 启动会话清理协程
 	go s.cleanupSessions(ctx)
 
+	// 启动流量配额统计协程
+	go s.quotaLoop(ctx)
+	go s.heartbeatLoop(ctx)
+
 	// 监听 context 取消，关闭 listener 以中断 Accept
 	go func() {
 		<-ctx.Done()
@@ -221,9 +462,18 @@ func (s *VPNServer) Start(ctx context.Context) {
 			if ctx.Err() != nil {
 				break // context 已取消
 			}
+			if s.isDraining() {
+				// 排空时监听套接字已关闭，已有会话继续运行直到服务器停止
+				<-ctx.Done()
+				break
+			}
 			log.Printf("接受连接失败: %v", err)
 			continue
 		}
+		if s.isDraining() {
+			_ = conn.Close()
+			continue
+		}
 
 		go s.handleConnection(ctx, conn)
 	}
@@ -247,35 +497,71 @@ func (s *VPNServer) handleConnection(ctx context.Context, conn net.Conn) {
 		return
 	}
 
+	s.handleTunnel(ctx, tlsConn)
+}
+
+// handleTunnel 处理已完成TLS握手的连接（直接的TLS连接或WebSocket传输）
+func (s *VPNServer) handleTunnel(ctx context.Context, conn tunnelConn) {
 	// 验证客户端证书
-	state := tlsConn.ConnectionState()
+	state := conn.ConnectionState()
 	if len(state.PeerCertificates) == 0 {
 		log.Printf("客户端未提供证书: %s", conn.RemoteAddr())
 		conn.Close()
 		return
 	}
 
-	// 检查连接数限制
-	s.sessionMutex.RLock()
-	count := s.sessionCount
-	s.sessionMutex.RUnlock()
-	if count >= int64(s.config.MaxConnections) {
-		log.Printf("连接数已达到上限: %d", s.config.MaxConnections)
+	// 获取证书主题
+	clientCert := state.PeerCertificates[0]
+	certSubject := clientCert.Subject.CommonName
+	group := certGroup(clientCert)
+
+	// 协议版本和能力协商
+	hello, bondToken, err := s.negotiateHello(conn)
+	if err != nil {
+		log.Printf("协议协商失败 %s: %v", conn.RemoteAddr(), err)
 		conn.Close()
 		return
 	}
 
-	// 获取证书主题
-	clientCert := state.PeerCertificates[0]
-	certSubject := clientCert.Subject.CommonName
+	// 超出流量配额且处理方式为断开的客户端被拒绝，并通知其在新周期开始后重连
+	if goodbye := s.quotaReject(certSubject); goodbye != nil {
+		log.Printf("拒绝客户端连接 %s (Cert: %s): %s", conn.RemoteAddr(), certSubject, goodbye.Message)
+		s.rejectConnection(conn, hello, goodbye)
+		return
+	}
+
+	// 成员连接加入已有会话，不占用连接数和IP地址
+	if bondToken != "" {
+		s.joinSession(ctx, conn, hello, bondToken, certSubject)
+		return
+	}
+
+	// 检查连接数限制（注册会话时会再次检查）
+	if s.sessions.count() >= s.config.MaxConnections {
+		log.Printf("连接数已达到上限: %d", s.config.MaxConnections)
+		s.rejectConnection(conn, hello, &Goodbye{
+			Reason:       GoodbyeReasonPoolFull,
+			Message:      "服务端连接数已达到上限",
+			RetryAfterMs: int(poolFullRetryAfter / time.Millisecond),
+		})
+		return
+	}
 
-	// 分配IP地址
-	clientIP := s.clientIPPool.AllocateIP()
+	// 分配IP地址（优先使用该证书租约中的地址，IPv6在客户端支持且服务端启用时分配）
+	leaseKey := leaseKeyFor(clientCert, s.config.GetLeaseKey())
+	clientIP, clientIP6, leaseOwner := s.allocateClientIPs(leaseKey, hello.HasFeature(FeatureIPv6))
 	if clientIP == nil {
 		log.Printf("IP地址池已满: %s", conn.RemoteAddr())
-		conn.Close()
+		s.rejectConnection(conn, hello, &Goodbye{
+			Reason:       GoodbyeReasonPoolFull,
+			Message:      "服务端IP地址池已满",
+			RetryAfterMs: int(poolFullRetryAfter / time.Millisecond),
+		})
 		return
 	}
+	if !leaseOwner {
+		leaseKey = ""
+	}
 
 	// 生成唯一的SessionID (使用纳秒时间戳 + 随机数)
 	sessionID := fmt.Sprintf("%s-%d-%d",
@@ -283,64 +569,154 @@ func (s *VPNServer) handleConnection(ctx context.Context, conn net.Conn) {
 		time.Now().UnixNano(),
 		mathrand.Int31())
 	session := &VPNSession{
-		ID:            sessionID,
-		RemoteAddr:    conn.RemoteAddr(),
-		TLSConn:       tlsConn,
-		LastActivity:  time.Now(),
-		IP:            clientIP,
-		CertSubject:   certSubject,
-		sendSeq:       0,
-		recvSeq:       0,
-		BytesSent:     0,
-		BytesReceived: 0,
-		ConnectedAt:   time.Now(),
-	}
-
-	s.addSession(sessionID, session)
-	log.Printf("客户端连接建立: %s (IP: %s, Cert: %s, ID: %s)",
-		conn.RemoteAddr(), clientIP, certSubject, sessionID)
-
-	// 发送IP分配信息
-	ipMsg := &Message{
-		Type:     MessageTypeIPAssignment,
-		Length:   uint32(len(clientIP)),
-		Sequence: 0,
-		Checksum: 0,
-		Payload:  clientIP,
-	}
-	ipData, err := ipMsg.Serialize()
-	if err != nil {
-		log.Printf("序列化IP分配消息失败: %v", err)
-		s.removeSession(sessionID)
-		return
+		ID:              sessionID,
+		RemoteAddr:      conn.RemoteAddr(),
+		LastActivity:    time.Now(),
+		IP:              clientIP,
+		IP6:             clientIP6,
+		CertSubject:     certSubject,
+		Group:           group,
+		ProtocolVersion: hello.Version,
+		Features:        hello.Features,
+		BytesSent:       0,
+		BytesReceived:   0,
+		ConnectedAt:     time.Now(),
+		batching:        hello.HasFeature(FeatureBatching),
+		goodbye:         hello.HasFeature(FeatureGoodbye),
+		measureRTT:      hello.HasFeature(FeatureHeartbeatRTT),
+		leaseKey:        leaseKey,
+		source:          sourceGuard{subnets: s.clientSubnets[certSubject]},
 	}
 
-	_, err = tlsConn.Write(ipData)
-	if err != nil {
+	// 启用协商成功的数据传输特性
+	if hello.HasFeature(FeatureCompression) {
+		atomic.StoreInt32(&session.compression, 1)
+	}
+	if hello.HasFeature(FeatureBonding) {
+		if session.bondToken, err = generateBondToken(); err != nil {
+			log.Printf("会话 %s 无法启用多连接聚合: %v", sessionID, err)
+		}
+	}
+
+	link := s.newSessionLink(session, conn)
+	session.addLink(link, 1)
+	go s.runSessionWriter(session, link)
+
+	// 为会话派生UDP数据通道密钥
+	if s.udpConn != nil && hello.HasFeature(FeatureUDP) {
+		if err := s.setupSessionUDP(session, state); err != nil {
+			log.Printf("会话 %s 初始化UDP数据通道失败，仅使用TCP: %v", sessionID, err)
+		}
+	}
+
+	// 先排队IP分配信息和配置（路由模式、DNS等），保证它们先于任何数据包发出
+	if err := s.sendSessionMessage(session, MessageTypeIPAssignment, clientIP, 0); err != nil {
 		log.Printf("发送IP分配信息失败: %v", err)
-		s.removeSession(sessionID)
+		_ = session.Close()
+		s.releaseSessionIPs(session)
 		return
 	}
-
-	// 推送配置给客户端（路由模式、DNS等）
 	if err := s.pushConfigToClient(session); err != nil {
 		log.Printf("推送配置给客户端失败: %v", err)
 	}
 
+	if !s.addSession(session) {
+		log.Printf("连接数已达到上限: %d", s.config.MaxConnections)
+		_ = session.Close()
+		s.releaseSessionIPs(session)
+		return
+	}
+	// 注册后再设置限速，保证不会错过同时发生的限速配置修改
+	s.applyRateLimit(session)
+	if clientIP6 != nil {
+		log.Printf("客户端连接建立: %s (IP: %s, IPv6: %s, Cert: %s, ID: %s)",
+			conn.RemoteAddr(), clientIP, clientIP6, certSubject, sessionID)
+	} else {
+		log.Printf("客户端连接建立: %s (IP: %s, Cert: %s, ID: %s)",
+			conn.RemoteAddr(), clientIP, certSubject, sessionID)
+	}
+	if session.batching {
+		log.Printf("会话 %s 已启用批量数据传输", session.ID)
+	}
+
 	// 启动数据处理协程
-	go s.handleSessionData(ctx, session)
+	go s.handleSessionData(ctx, session, link)
+}
+
+// newSessionLink 为会话创建一条成员连接（尚未加入会话）
+func (s *VPNServer) newSessionLink(session *VPNSession, conn tunnelConn) *sessionLink {
+	link := &sessionLink{
+		conn:      conn,
+		remote:    conn.RemoteAddr(),
+		sendQueue: newSendQueue(s.config.GetSendQueueSize(), s.config.GetSendQueuePolicy()),
+	}
+	link.touch()
+	if session.batching {
+		link.batcher = newPacketBatcher(s.config.GetBatchDelay(), s.config.GetBatchMaxBytes(),
+			func(msgType MessageType, payload []byte, packetBytes uint64, buf *packetBuffer) error {
+				return s.queueLinkMessage(session, link, outboundMessage{
+					msgType: msgType, payload: payload, packetBytes: packetBytes, buf: buf,
+				})
+			})
+	}
+	return link
+}
+
+// joinSession 将成员连接加入凭据对应的会话（多连接聚合）
+func (s *VPNServer) joinSession(ctx context.Context, conn tunnelConn, hello *Hello, bondToken, certSubject string) {
+	session := s.sessions.lookupBondToken(bondToken)
+	if session == nil || session.IsClosed() {
+		log.Printf("成员连接 %s 加入会话失败: 会话不存在或已结束", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	// 凭据只在会话的TLS连接上下发过，再校验一次证书，防止凭据泄露后被其他客户端使用
+	if session.CertSubject != certSubject || !hello.HasFeature(FeatureBonding) {
+		log.Printf("成员连接 %s 加入会话 %s 被拒绝: 证书或协商特性不匹配", conn.RemoteAddr(), session.ID)
+		conn.Close()
+		return
+	}
+
+	link := s.newSessionLink(session, conn)
+	go s.runSessionWriter(session, link)
+
+	// 回复会话的IP分配信息作为加入确认（先于任何数据包发出）
+	err := link.sendQueue.pushControl(outboundMessage{msgType: MessageTypeIPAssignment, payload: session.IP})
+	if err != nil || !session.addLink(link, s.config.GetBondLinks()) {
+		log.Printf("成员连接 %s 加入会话 %s 失败: 会话已结束或连接数已达上限 (%d)",
+			conn.RemoteAddr(), session.ID, s.config.GetBondLinks())
+		link.close()
+		return
+	}
+
+	log.Printf("会话 %s 新增成员连接 #%d: %s (共 %d 条)",
+		session.ID, link.id, conn.RemoteAddr(), len(session.getLinks()))
+	go s.handleSessionData(ctx, session, link)
 }
 
-// handleSessionData 处理会话数据
-func (s *VPNServer) handleSessionData(ctx context.Context, session *VPNSession) {
+// handleSessionData 处理会话一条成员连接上的数据，最后一条连接断开时清理会话
+func (s *VPNServer) handleSessionData(ctx context.Context, session *VPNSession, link *sessionLink) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("会话 %s 处理发生panic: %v", session.ID, r)
 		}
+		if remaining := session.removeLink(link); remaining > 0 {
+			log.Printf("会话 %s 成员连接 #%d 已断开，剩余 %d 条连接", session.ID, link.id, remaining)
+			return
+		}
+		log.Printf("会话断开: %s", session.ID)
 		s.removeSession(session.ID)
 		log.Printf("会话 %s 已清理，IP %s 已回收", session.ID, session.IP)
 	}()
 
+	// 复用读取和解压缓冲区，数据包在处理完成前不会被保留
+	reader := newFrameReader(link.conn)
+	defer reader.Release()
+	scratch := getPacketBuffer()
+	defer scratch.release()
+	// TUN设备支持批量写入时，批量消息中的包一次写入，由内核GRO重新合并为超级包
+	tunBatch := newTUNWriteBatch(s.tunDevice)
+
 sessionLoop:
 	for !session.IsClosed() {
 		// 检查 context 是否取消
@@ -350,15 +726,14 @@ sessionLoop:
 		default:
 		}
 
-		session.TLSConn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		link.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 
 		// 读取消息头（13字节：类型+长度+序列号+校验和）
-		header := make([]byte, 13)
-		_, err := io.ReadFull(session.TLSConn, header)
+		msgType, length, sequence, checksum, err := reader.ReadHeader()
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				// 检查是否超时
-				if time.Since(session.GetActivity()) > 90*time.Second {
+				if link.idle() > 90*time.Second {
 					log.Printf("会话超时: %s", session.ID)
 					break
 				}
@@ -370,45 +745,33 @@ sessionLoop:
 			break
 		}
 
-		// 解析消息头
-		msgType := MessageType(header[0])
-		length := binary.BigEndian.Uint32(header[1:5])
-		sequence := binary.BigEndian.Uint32(header[5:9])
-		checksum := binary.BigEndian.Uint32(header[9:13])
-
 		// 防止过大的消息
-		if length > 65535 {
+		if length > maxMessagePayload {
 			log.Printf("会话 %s 消息过大: %d 字节", session.ID, length)
 			break
 		}
 
 		// 读取消息体
-		payload := make([]byte, length)
-		if length > 0 {
-			_, err = io.ReadFull(session.TLSConn, payload)
-			if err != nil {
-				log.Printf("会话 %s 读取消息体失败: %v", session.ID, err)
-				break
-			}
+		payload, err := reader.ReadPayload(length)
+		if err != nil {
+			log.Printf("会话 %s 读取消息体失败: %v", session.ID, err)
+			break
 		}
 
-		// 验证序列号（心跳消息除外）
+		// 验证序列号（心跳消息除外，每条成员连接独立计数）
 		if msgType != MessageTypeHeartbeat && msgType != MessageTypeIPAssignment {
-			session.seqMutex.Lock()
 			// 检测重放攻击（序列号回退）
-			if sequence < session.recvSeq {
-				session.seqMutex.Unlock()
+			if sequence < link.recvSeq {
 				log.Printf("会话 %s 检测到重放攻击：期望序列号 >= %d，收到 %d",
-					session.ID, session.recvSeq, sequence)
+					session.ID, link.recvSeq, sequence)
 				break
 			}
 			// 检测消息丢失（序列号跳跃）
-			if sequence > session.recvSeq+1 && session.recvSeq > 0 {
+			if sequence > link.recvSeq+1 && link.recvSeq > 0 {
 				log.Printf("警告：会话 %s 检测到消息丢失，期望序列号 %d，收到 %d",
-					session.ID, session.recvSeq+1, sequence)
+					session.ID, link.recvSeq+1, sequence)
 			}
-			session.recvSeq = sequence
-			session.seqMutex.Unlock()
+			link.recvSeq = sequence
 		}
 
 		// 验证校验和（如果提供）
@@ -422,99 +785,397 @@ sessionLoop:
 		}
 
 		session.UpdateActivity()
+		link.touch()
 
 		// 处理不同类型的消息
 		switch msgType {
 		case MessageTypeHeartbeat:
-			// 响应心跳
-			if err := s.sendHeartbeatResponse(session); err != nil {
+			if err := s.handleHeartbeat(session, link, payload); err != nil {
 				log.Printf("会话 %s 发送心跳响应失败: %v", session.ID, err)
 				break sessionLoop
 			}
 		case MessageTypeData:
-			// 统计接收流量
-			session.AddBytesReceived(uint64(len(payload)))
-
-			// 处理数据包 - 直接写入TUN设备（Windows Wintun和Unix/Linux TUN都是Layer 3）
-			if s.tunDevice != nil && len(payload) > 0 {
-				_, err := s.tunDevice.Write(payload)
+			session.AddWireBytesReceived(uint64(len(payload)))
+			if !session.rateUp.wait(ctx, len(payload)) {
+				break sessionLoop
+			}
+			s.writeToTUN(session, payload, nil)
+		case MessageTypeCompressedData:
+			session.AddWireBytesReceived(uint64(len(payload)))
+			packet, err := decompressPacket(scratch.b, payload)
+			if err != nil {
+				log.Printf("会话 %s %v", session.ID, err)
+				break sessionLoop
+			}
+			if !session.rateUp.wait(ctx, len(packet)) {
+				break sessionLoop
+			}
+			s.writeToTUN(session, packet, nil)
+		case MessageTypeBatch:
+			session.AddWireBytesReceived(uint64(len(payload)))
+			err := splitBatch(payload, func(packet []byte, compressed bool) error {
+				packet, err := decodePacket(scratch.b, packet, compressed)
 				if err != nil {
+					return err
+				}
+				// 超出上行限速时推迟读取，借助TCP反压让客户端放慢
+				if !session.rateUp.wait(ctx, len(packet)) {
+					return ctx.Err()
+				}
+				s.writeToTUN(session, packet, tunBatch)
+				return nil
+			})
+			if tunBatch != nil {
+				if err := tunBatch.Flush(); err != nil {
 					log.Printf("会话 %s 写入TUN设备失败: %v", session.ID, err)
 				}
-			} else {
-				log.Printf("从会话 %s 接收到数据包，长度: %d", session.ID, len(payload))
+			}
+			if err != nil {
+				log.Printf("会话 %s 批量消息格式错误: %v", session.ID, err)
+				break sessionLoop
 			}
 		default:
 			log.Printf("会话 %s 收到未知消息类型: %d", session.ID, msgType)
 		}
 	}
+}
+
+// writeToTUN 将客户端发来的IP包写入TUN设备（目标为其他客户端时直接转发）。
+// batch 不为nil时只加入写批次，由调用方 Flush
+func (s *VPNServer) writeToTUN(session *VPNSession, packet []byte, batch *tunWriteBatch) {
+	// 统计接收流量
+	session.AddBytesReceived(uint64(len(packet)))
+
+	if !s.sourceAllowed(session, packet) || !s.aclAllowed(session, packet) {
+		return
+	}
+
+	if !s.config.DisableMSSClamp {
+		clampTCPMSS(packet, session.tunnelMTU(s.config.MTU))
+	}
+
+	if s.forwardToSession(session, packet) {
+		return
+	}
+
+	// 处理数据包 - 直接写入TUN设备（Windows Wintun和Unix/Linux TUN都是Layer 3）
+	if s.tunDevice != nil && len(packet) > 0 {
+		var err error
+		if batch != nil {
+			err = batch.Add(packet)
+		} else {
+			_, err = s.tunDevice.Write(packet)
+		}
+		if err != nil {
+			log.Printf("会话 %s 写入TUN设备失败: %v", session.ID, err)
+		}
+	} else {
+		log.Printf("从会话 %s 接收到数据包，长度: %d", session.ID, len(packet))
+	}
+}
 
-	log.Printf("会话断开: %s", session.ID)
+// forwardToSession 目标地址属于其他在线客户端时，按互访策略在会话之间直接转发，
+// 不经过TUN设备和内核路由。返回 true 表示数据包已处理（转发或丢弃）
+func (s *VPNServer) forwardToSession(src *VPNSession, packet []byte) bool {
+	destIP := packetDstIP(packet)
+	if destIP == nil {
+		return false
+	}
+
+	target := s.sessions.lookupIP(ipKey(destIP))
+	if target == nil || target == src {
+		return false
+	}
+
+	if !s.clientToClientAllowed(src, target) {
+		return true
+	}
+	if s.replyTooBig(target, packet, src) {
+		return true
+	}
+	if err := s.sendPacket(target, packet); err != nil {
+		log.Printf("转发数据包到客户端 %s 失败: %v", destIP, err)
+	}
+	return true
 }
 
-// sendHeartbeatResponse 发送心跳响应
-func (s *VPNServer) sendHeartbeatResponse(session *VPNSession) error {
-	response := &Message{
-		Type:     MessageTypeHeartbeat,
-		Length:   0,
-		Sequence: 0, // 心跳不使用序列号
-		Checksum: 0,
-		Payload:  []byte{},
+// clientToClientAllowed 按互访策略判断两个客户端之间是否允许通信
+func (s *VPNServer) clientToClientAllowed(src, dst *VPNSession) bool {
+	switch s.config.GetClientToClient() {
+	case ClientToClientDeny:
+		return false
+	case ClientToClientSameGroup:
+		return src.Group != "" && src.Group == dst.Group
+	default:
+		return true
+	}
+}
+
+// helloTimeout 等待客户端 Hello 的时间，超时按不支持 Hello 的旧版客户端处理
+const helloTimeout = 3 * time.Second
+
+// serverFeatures 返回服务端当前启用的特性
+func (s *VPNServer) serverFeatures() []string {
+	features := make([]string, 0, 7)
+	features = append(features, FeatureHeartbeatRTT, FeatureGoodbye)
+	if s.config.EnableCompression {
+		features = append(features, FeatureCompression)
 	}
-	responseData, err := response.Serialize()
+	if s.config.EnableBatching {
+		features = append(features, FeatureBatching)
+	}
+	if s.udpConn != nil {
+		features = append(features, FeatureUDP)
+	}
+	if s.clientIPPool6 != nil {
+		features = append(features, FeatureIPv6)
+	}
+	if s.config.GetBondLinks() > 1 {
+		features = append(features, FeatureBonding)
+	}
+	return features
+}
+
+// negotiateHello 与客户端协商协议版本和特性，不兼容时回复错误原因并返回error。
+// bondToken 为客户端请求加入的会话凭据（新会话为空）
+func (s *VPNServer) negotiateHello(conn tunnelConn) (*Hello, string, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	msgType, payload, err := readHandshakeMessage(conn)
+	_ = conn.SetReadDeadline(time.Time{})
 	if err != nil {
-		return fmt.Errorf("序列化心跳响应失败: %v", err)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			// 旧版客户端不发送Hello，直接等待IP分配
+			return &Hello{Version: 1, MinVersion: 1}, "", nil
+		}
+		return nil, "", err
+	}
+	if msgType != MessageTypeHello {
+		return nil, "", fmt.Errorf("期望Hello消息，收到消息类型 %d", msgType)
+	}
+
+	var clientHello Hello
+	if err := json.Unmarshal(payload, &clientHello); err != nil {
+		return nil, "", fmt.Errorf("解析Hello消息失败: %v", err)
+	}
+
+	reply := &Hello{
+		Version:     min(clientHello.Version, ProtocolVersion),
+		MinVersion:  MinProtocolVersion,
+		AuthMethods: []string{AuthMethodCert},
+	}
+	switch {
+	case clientHello.Version < MinProtocolVersion || clientHello.MinVersion > ProtocolVersion:
+		reply.Version = ProtocolVersion
+		reply.Error = fmt.Sprintf("协议版本不兼容: 客户端支持 %d-%d，服务端支持 %d-%d",
+			clientHello.MinVersion, clientHello.Version, MinProtocolVersion, ProtocolVersion)
+	case len(clientHello.AuthMethods) > 0 &&
+		len(intersectFeatures(reply.AuthMethods, clientHello.AuthMethods)) == 0:
+		reply.Error = fmt.Sprintf("认证方式不兼容: 服务端要求 %v", reply.AuthMethods)
+	default:
+		reply.Features = intersectFeatures(s.serverFeatures(), clientHello.Features)
+	}
+
+	if err := writeHello(conn, reply); err != nil {
+		return nil, "", fmt.Errorf("发送Hello消息失败: %v", err)
+	}
+	if reply.Error != "" {
+		return nil, "", fmt.Errorf("%s", reply.Error)
+	}
+	return reply, clientHello.BondToken, nil
+}
+
+// sendHeartbeat 在指定连接上发送心跳（payload 不会被复用）
+func (s *VPNServer) sendHeartbeat(session *VPNSession, link *sessionLink, payload []byte) error {
+	return s.queueLinkMessage(session, link, outboundMessage{msgType: MessageTypeHeartbeat, payload: payload})
+}
+
+// sendPooledMessage 将 payload 复制到池化缓冲区后排队发送
+// （payload 可能引用调用方复用的缓冲区，如TUN读取缓冲区）
+func (s *VPNServer) sendPooledMessage(session *VPNSession, link *sessionLink, msgType MessageType, payload []byte, packetBytes uint64) error {
+	buf := getPacketBuffer()
+	buf.b = append(buf.b, payload...)
+	return s.queueLinkMessage(session, link, outboundMessage{
+		msgType: msgType, payload: buf.b, packetBytes: packetBytes, buf: buf,
+	})
+}
+
+// sendSessionMessage 将控制消息放入会话主连接的发送队列
+func (s *VPNServer) sendSessionMessage(session *VPNSession, msgType MessageType, payload []byte, packetBytes uint64) error {
+	link := session.primaryLink()
+	if link == nil {
+		return errSendQueueClosed
+	}
+	return s.queueLinkMessage(session, link, outboundMessage{
+		msgType: msgType, payload: payload, packetBytes: packetBytes,
+	})
+}
+
+// queueLinkMessage 在成员连接上排队一条消息。数据消息队列满时按溢出策略处理，disconnect 策略下断开该会话
+func (s *VPNServer) queueLinkMessage(session *VPNSession, link *sessionLink, m outboundMessage) error {
+	if !isDataMessage(m.msgType) {
+		return link.sendQueue.pushControl(m)
+	}
+
+	err := link.sendQueue.pushData(m)
+	if err == errSendQueueFull {
+		log.Printf("会话 %s 发送队列已满，按策略断开连接", session.ID)
+		_ = session.Close()
 	}
-	_, err = session.TLSConn.Write(responseData)
 	return err
 }
 
-// sendDataResponse 发送数据响应
-func (s *VPNServer) sendDataResponse(session *VPNSession, payload []byte) error {
-	// 获取并递增发送序列号
-	session.seqMutex.Lock()
-	seq := session.sendSeq
-	session.sendSeq++
-	session.seqMutex.Unlock()
+// sessionWriteTimeout 单次写入的超时，超时视为客户端已失去响应
+const sessionWriteTimeout = 30 * time.Second
+
+// runSessionWriter 成员连接的写协程：取出发送队列中的消息，把已排队的多条消息编码进同一个
+// 池化缓冲区后一次写入TLS连接，写入失败时关闭该连接
+func (s *VPNServer) runSessionWriter(session *VPNSession, link *sessionLink) {
+	out := getPacketBuffer()
+	defer out.release()
+	pending := make([]outboundMessage, 0, 64)
 
-	// 计算校验和（可选）
-	checksum := uint32(0)
-	if len(payload) > 0 {
-		checksum = crc32.ChecksumIEEE(payload)
+	flush := func() error {
+		if len(out.b) == 0 {
+			return nil
+		}
+		_ = link.conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		_, err := link.conn.Write(out.b)
+		for i := range pending {
+			// 统计发送流量
+			if err == nil && isDataMessage(pending[i].msgType) {
+				session.AddBytesSent(pending[i].packetBytes)
+				session.AddWireBytesSent(uint64(len(pending[i].payload)))
+			}
+			pending[i].buf.release()
+			pending[i] = outboundMessage{}
+		}
+		out.b = out.b[:0]
+		pending = pending[:0]
+		return err
 	}
 
-	response := &Message{
-		Type:     MessageTypeData,
-		Length:   uint32(len(payload)),
-		Sequence: seq,
-		Checksum: checksum,
-		Payload:  payload,
+	for {
+		m, ok := link.sendQueue.next()
+		if !ok {
+			return // 连接已关闭
+		}
+		for ok {
+			// 缓冲区放不下时先写出已编码的消息（单条消息总能放进空缓冲区）
+			if len(out.b)+messageHeaderSize+len(m.payload) > cap(out.b) {
+				if err := flush(); err != nil {
+					s.closeOnWriteError(session, link, err)
+					m.buf.release()
+					return
+				}
+			}
+			out.b = appendLinkMessage(link, out.b, m)
+			pending = append(pending, m)
+			m, ok = link.sendQueue.poll()
+		}
+		if err := flush(); err != nil {
+			s.closeOnWriteError(session, link, err)
+			return
+		}
 	}
-	responseData, err := response.Serialize()
-	if err != nil {
-		return fmt.Errorf("序列化数据响应失败: %v", err)
+}
+
+// closeOnWriteError 写入失败时关闭该成员连接（读协程随后将其移出会话）
+func (s *VPNServer) closeOnWriteError(session *VPNSession, link *sessionLink, err error) {
+	if !session.IsClosed() {
+		log.Printf("会话 %s 连接 #%d 发送消息失败: %v", session.ID, link.id, err)
 	}
-	_, err = session.TLSConn.Write(responseData)
-	if err == nil {
-		// 统计发送流量
-		session.AddBytesSent(uint64(len(payload)))
+	link.close()
+}
+
+// appendLinkMessage 将消息编码追加到 dst（仅由写协程调用），在编码时分配序列号
+func appendLinkMessage(link *sessionLink, dst []byte, m outboundMessage) []byte {
+	// 心跳和IP分配消息不使用序列号和校验和
+	if m.msgType == MessageTypeHeartbeat || m.msgType == MessageTypeIPAssignment {
+		return appendMessage(dst, m.msgType, 0, m.payload, false)
 	}
-	return err
+
+	seq := link.sendSeq
+	link.sendSeq++
+	return appendMessage(dst, m.msgType, seq, m.payload, true)
+}
+
+// sendPacket 发送IP包到客户端：优先使用UDP数据通道，不可用或超过路径MTU时回退到TLS连接
+// （按流选择成员连接，客户端支持时在TLS连接上批量发送，并按协商结果压缩）
+func (s *VPNServer) sendPacket(session *VPNSession, packet []byte) error {
+	link := session.linkForPacket(packet)
+	if link == nil {
+		return errSendQueueClosed
+	}
+	// 超出下行限速的包直接丢弃（已计入限速丢弃统计）
+	if !session.rateDown.allow(len(packet)) {
+		return nil
+	}
+	if !s.config.DisableMSSClamp {
+		clampTCPMSS(packet, session.tunnelMTU(s.config.MTU))
+	}
+	if !session.compressionEnabled() {
+		return s.sendEncodedPacket(session, link, packet, false, len(packet))
+	}
+	buf := getPacketBuffer()
+	defer buf.release()
+	payload, compressed := compressPacket(buf.b, packet)
+	return s.sendEncodedPacket(session, link, payload, compressed, len(packet))
+}
+
+// sendEncodedPacket 发送已编码（可能已压缩）的IP包，packetLen 为压缩前的长度。
+// payload 只在调用期间有效，需要异步发送时会复制到池化缓冲区
+func (s *VPNServer) sendEncodedPacket(session *VPNSession, link *sessionLink, payload []byte, compressed bool, packetLen int) error {
+	msgType := MessageTypeData
+	if compressed {
+		msgType = MessageTypeCompressedData
+	}
+	if s.udpConn != nil && session.udpFits(packetLen) {
+		if addr := session.udpPeer(); addr != nil {
+			buf := getPacketBuffer()
+			datagram := session.udp.sealTo(buf.b, msgType, payload)
+			_, err := s.udpConn.WriteToUDP(datagram, addr)
+			buf.release()
+			if err == nil {
+				session.AddBytesSent(uint64(packetLen))
+				session.AddWireBytesSent(uint64(len(payload)))
+				return nil
+			}
+		}
+	}
+	if link.batcher != nil {
+		return link.batcher.Add(payload, compressed, packetLen)
+	}
+	return s.sendPooledMessage(session, link, msgType, payload, uint64(packetLen))
 }
 
 // pushConfigToClient 推送配置给客户端
 func (s *VPNServer) pushConfigToClient(session *VPNSession) error {
 	// 准备客户端配置
+	ones, _ := s.vpnNetwork.Mask.Size()
+	pushed := s.pushed.Load()
 	config := ClientConfig{
-		AssignedIP:      session.IP.String() + "/24",
+		AssignedIP:      fmt.Sprintf("%s/%d", session.IP, ones),
 		ServerIP:        s.config.ServerIP,
-		DNS:             s.config.DNSServers,
-		Routes:          s.config.PushRoutes,
+		DNS:             pushed.DNSServers,
+		Routes:          pushed.PushRoutes,
 		MTU:             s.config.MTU,
-		RouteMode:       s.config.RouteMode,
-		ExcludeRoutes:   s.config.ExcludeRoutes,
-		RedirectGateway: s.config.RedirectGateway,
-		RedirectDNS:     s.config.RedirectDNS,
+		RouteMode:       pushed.RouteMode,
+		ExcludeRoutes:   pushed.ExcludeRoutes,
+		RedirectGateway: pushed.RedirectGateway,
+		RedirectDNS:     pushed.RedirectDNS,
+	}
+	if session.IP6 != nil {
+		ones6, _ := s.vpnNetwork6.Mask.Size()
+		config.AssignedIP6 = fmt.Sprintf("%s/%d", session.IP6, ones6)
+		config.ServerIP6 = fmt.Sprintf("%s/%d", s.serverIP6, ones6)
+	}
+	if session.udp != nil {
+		config.UDPPort = s.config.GetUDPPort()
+		config.UDPSessionID = session.udp.sessionID
+	}
+	if session.bondToken != "" {
+		config.BondToken = session.bondToken
+		config.BondLinks = s.config.GetBondLinks()
 	}
 
 	// 序列化为JSON
@@ -523,28 +1184,8 @@ func (s *VPNServer) pushConfigToClient(session *VPNSession) error {
 		return fmt.Errorf("序列化客户端配置失败: %v", err)
 	}
 
-	// 获取并递增发送序列号
-	session.seqMutex.Lock()
-	seq := session.sendSeq
-	session.sendSeq++
-	session.seqMutex.Unlock()
-
 	// 发送控制消息
-	msg := &Message{
-		Type:     MessageTypeControl,
-		Length:   uint32(len(data)),
-		Sequence: seq,
-		Checksum: crc32.ChecksumIEEE(data),
-		Payload:  data,
-	}
-
-	msgData, err := msg.Serialize()
-	if err != nil {
-		return fmt.Errorf("序列化控制消息失败: %v", err)
-	}
-
-	_, err = session.TLSConn.Write(msgData)
-	if err != nil {
+	if err := s.sendSessionMessage(session, MessageTypeControl, data, 0); err != nil {
 		return fmt.Errorf("发送配置失败: %v", err)
 	}
 
@@ -553,8 +1194,13 @@ func (s *VPNServer) pushConfigToClient(session *VPNSession) error {
 	return nil
 }
 
-// handleTUNRead 处理从TUN设备读取的数据
-func (s *VPNServer) handleTUNRead(ctx context.Context) {
+// handleTUNRead 处理从TUN设备（或多队列设备的一个队列）读取的数据
+func (s *VPNServer) handleTUNRead(ctx context.Context, queue TUNDevice) {
+	if bd, ok := asBatchTUN(queue); ok {
+		s.handleTUNReadBatch(ctx, bd)
+		return
+	}
+
 	packet := make([]byte, s.config.MTU)
 
 	for {
@@ -564,7 +1210,7 @@ func (s *VPNServer) handleTUNRead(ctx context.Context) {
 		default:
 		}
 
-		n, err := s.tunDevice.Read(packet)
+		n, err := queue.Read(packet)
 		if err != nil {
 			if ctx.Err() == nil {
 				log.Printf("从TUN设备读取失败: %v", err)
@@ -572,52 +1218,108 @@ func (s *VPNServer) handleTUNRead(ctx context.Context) {
 			return
 		}
 
-		if n < 20 { // IP header minimum size
-			continue
-		}
+		s.routeTUNPacket(packet[:n])
+	}
+}
 
-		// 提取目标IP地址 (IP header offset 16-19)
-		destIP := net.IP(packet[16:20])
+// handleTUNReadBatch 批量读取offload TUN设备。一次读取得到的通常是同一个超级包拆分出的分段，
+// 全部加入目标会话的批量发送器后立即发送，使这些分段合并为一条批量消息穿过隧道
+func (s *VPNServer) handleTUNReadBatch(ctx context.Context, dev batchTUNDevice) {
+	bufs := make([][]byte, dev.BatchSize())
+	sizes := make([]int, len(bufs))
+	for i := range bufs {
+		bufs[i] = make([]byte, s.config.MTU)
+	}
+	var touched []*VPNSession
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
 
-		// 使用IP到会话的映射进行O(1)查找
-		s.sessionMutex.RLock()
-		targetSession := s.ipToSession[destIP.String()]
-		s.sessionMutex.RUnlock()
+		n, err := dev.ReadBatch(bufs, sizes)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("从TUN设备读取失败: %v", err)
+			}
+			return
+		}
 
-		if targetSession != nil {
-			// 发送到目标客户端
-			err := s.sendDataResponse(targetSession, packet[:n])
-			if err != nil {
-				log.Printf("转发数据包到客户端 %s 失败: %v", destIP, err)
+		touched = touched[:0]
+		for i := 0; i < n; i++ {
+			target := s.routeTUNPacket(bufs[i][:sizes[i]])
+			if target != nil && !containsSession(touched, target) {
+				touched = append(touched, target)
 			}
 		}
+		for _, session := range touched {
+			if err := session.flushBatchers(); err != nil {
+				log.Printf("发送批量数据到客户端 %s 失败: %v", session.IP, err)
+			}
+		}
+	}
+}
+
+// routeTUNPacket 将从TUN设备读取的IP包发送给目标客户端，返回目标会话。
+// 无法投递时（没有目标客户端、超过隧道MTU）向TUN设备写回ICMP差错报文并返回nil
+func (s *VPNServer) routeTUNPacket(packet []byte) *VPNSession {
+	// 提取目标IP地址（IPv4或IPv6）
+	destIP := packetDstIP(packet)
+	if destIP == nil {
+		return nil
+	}
+
+	// 使用IP到会话的映射进行O(1)无锁查找
+	targetSession := s.sessions.lookupIP(ipKey(destIP))
+	if targetSession == nil {
+		s.replyUnreachable(packet, destIP)
+		return nil
+	}
+	if s.replyTooBig(targetSession, packet, nil) {
+		return nil
+	}
+
+	// 发送到目标客户端
+	if err := s.sendPacket(targetSession, packet); err != nil {
+		log.Printf("转发数据包到客户端 %s 失败: %v", destIP, err)
 	}
+	return targetSession
 }
 
-// addSession 添加会话
-func (s *VPNServer) addSession(id string, session *VPNSession) {
-	s.sessionMutex.Lock()
-	defer s.sessionMutex.Unlock()
-	s.sessions[id] = session
-	s.ipToSession[session.IP.String()] = session // 维护IP到会话的映射
-	s.sessionCount++
+// containsSession 判断会话是否已在列表中（列表很短，线性查找即可）
+func containsSession(sessions []*VPNSession, session *VPNSession) bool {
+	for _, s := range sessions {
+		if s == session {
+			return true
+		}
+	}
+	return false
+}
+
+// addSession 注册会话，在线会话数已达到上限时返回 false
+func (s *VPNServer) addSession(session *VPNSession) bool {
+	return s.sessions.add(session, s.config.MaxConnections)
 }
 
 // removeSession 移除会话
 func (s *VPNServer) removeSession(id string) {
-	s.sessionMutex.Lock()
-	session, exists := s.sessions[id]
-	if exists {
-		s.clientIPPool.ReleaseIP(session.IP)
-		delete(s.sessions, id)
-		delete(s.ipToSession, session.IP.String()) // 删除IP映射
-		s.sessionCount--
-	}
-	s.sessionMutex.Unlock()
-
-	// 在锁外部关闭连接，避免死锁
-	if exists && session != nil {
-		_ = session.Close()
+	session := s.sessions.remove(id)
+	if session == nil {
+		return
+	}
+	s.releaseSessionIPs(session)
+	_ = session.Close()
+	s.accountQuota(session)
+}
+
+// releaseSessionIPs 回收会话分配的IPv4/IPv6地址，并从此刻起重新计算租约有效期
+func (s *VPNServer) releaseSessionIPs(session *VPNSession) {
+	s.renewSessionLease(session)
+	s.clientIPPool.ReleaseIP(session.IP)
+	if session.IP6 != nil {
+		s.clientIPPool6.ReleaseIP(session.IP6)
 	}
 }
 
@@ -633,18 +1335,22 @@ func (s *VPNServer) cleanupSessions(ctx context.Context) {
 		case <-ticker.C:
 			// 收集需要清理的会话ID
 			var toCleanup []string
-			s.sessionMutex.RLock()
-			for id, session := range s.sessions {
+			for id, session := range s.sessions.snapshot().byID {
 				if time.Since(session.GetActivity()) > s.config.SessionTimeout {
 					toCleanup = append(toCleanup, id)
 				}
 			}
-			s.sessionMutex.RUnlock()
 
-			// 释放锁后再清理会话
 			for _, id := range toCleanup {
+				session := s.sessions.get(id)
+				if session == nil {
+					continue
+				}
 				log.Printf("清理超时会话: %s", id)
-				s.removeSession(id)
+				s.disconnectSession(session, &Goodbye{
+					Reason:  GoodbyeReasonTimeout,
+					Message: fmt.Sprintf("超过 %v 没有收到客户端的消息", s.config.SessionTimeout),
+				})
 			}
 		}
 	}
@@ -652,12 +1358,11 @@ func (s *VPNServer) cleanupSessions(ctx context.Context) {
 
 // cleanupNATRules 清理NAT规则
 func (s *VPNServer) cleanupNATRules() {
-	// 加锁复制规则列表，避免并发访问
-	s.sessionMutex.Lock()
-	rules := make([]NATRule, len(s.natRules))
-	copy(rules, s.natRules)
+	// 加锁取出规则列表，避免并发访问
+	s.natMutex.Lock()
+	rules := s.natRules
 	s.natRules = nil
-	s.sessionMutex.Unlock()
+	s.natMutex.Unlock()
 
 	// 在锁外执行清理操作
 	for _, rule := range rules {
@@ -665,7 +1370,7 @@ func (s *VPNServer) cleanupNATRules() {
 		args := []string{"-t", rule.Table, "-D", rule.Chain}
 		args = append(args, rule.Args...)
 
-		output, err := runCmdCombined("iptables", args...)
+		output, err := runCmdCombined(iptablesCommand(rule.IPv6), args...)
 		if err != nil {
 			log.Printf("警告：删除NAT规则失败: %v (参数: %v), 输出: %s", err, args, string(output))
 		} else {
@@ -674,8 +1379,17 @@ func (s *VPNServer) cleanupNATRules() {
 	}
 }
 
-// Stop 停止服务器
+// Stop 停止服务器（已交接给新进程时保留TUN设备和NAT规则，由新进程继续使用）
 func (s *VPNServer) Stop() {
+	s.stopOnce.Do(s.stop)
+}
+
+func (s *VPNServer) stop() {
+	// 未经排空或交接直接停止时通知客户端（排空和交接已发送过 Goodbye）
+	if !s.isDraining() && atomic.LoadInt32(&s.handedOff) == 0 {
+		s.notifyShutdown()
+	}
+
 	// 取消 context，停止所有协程
 	s.cancelMutex.Lock()
 	if s.cancel != nil {
@@ -687,18 +1401,23 @@ func (s *VPNServer) Stop() {
 	if s.listener != nil {
 		_ = s.listener.Close()
 	}
+	if s.udpConn != nil {
+		_ = s.udpConn.Close()
+	}
+	s.closeWebSocket()
 
-	// 收集所有会话ID
-	s.sessionMutex.Lock()
-	sessionIDs := make([]string, 0, len(s.sessions))
-	for id := range s.sessions {
-		sessionIDs = append(sessionIDs, id)
+	// 关闭所有会话
+	for _, session := range s.sessions.all() {
+		s.removeSession(session.ID)
 	}
-	s.sessionMutex.Unlock()
+	s.flushQuotas()
 
-	// 在锁外部关闭所有会话
-	for _, id := range sessionIDs {
-		s.removeSession(id)
+	if atomic.LoadInt32(&s.handedOff) == 1 {
+		// 只关闭本进程持有的描述符，设备和规则属于新进程
+		if s.tunDevice != nil {
+			_ = s.tunDevice.Close()
+		}
+		return
 	}
 
 	// 清理NAT规则
@@ -721,40 +1440,98 @@ func (s *VPNServer) IsRunning() bool {
 
 // GetSessionCount 获取在线会话数
 func (s *VPNServer) GetSessionCount() int {
-	s.sessionMutex.RLock()
-	defer s.sessionMutex.RUnlock()
-	return len(s.sessions)
+	return s.sessions.count()
 }
 
 // SessionInfo 会话信息（用于显示）
 type SessionInfo struct {
 	ID            string
 	IP            string
+	IP6           string
 	RemoteAddr    string
 	CertSubject   string
+	Group         string
 	ConnectedAt   time.Time
 	LastActivity  time.Time
 	BytesSent     uint64
 	BytesReceived uint64
+	// 线路字节数（压缩后），未启用压缩时与 BytesSent/BytesReceived 相同
+	WireBytesSent     uint64
+	WireBytesReceived uint64
+	ProtocolVersion   int
+	Features          []string
+	Links             int // 成员连接数（多连接聚合）
+	PathMTU           int // 客户端报告的UDP路径MTU（0=未报告）
+	// 带宽限速（上行为客户端到服务端方向）：限速值、当前速率（字节/秒）和超出限速的包。
+	// 上行在TLS连接上推迟读取、在UDP通道上丢弃，下行丢弃
+	LimitUpKbps        int
+	LimitDownKbps      int
+	RateUp             uint64
+	RateDown           uint64
+	ThrottledUp        uint64 // 被推迟或丢弃的包数
+	ThrottledDown      uint64
+	ThrottledUpBytes   uint64
+	ThrottledDownBytes uint64
+	ThrottleDelayUp    time.Duration // 上行累计推迟的时间
+	// 链路质量（服务端探测的结果，HeartbeatProbes 为0表示客户端不支持或尚未测量）
+	RTT             time.Duration
+	Jitter          time.Duration
+	HeartbeatLoss   float64 // 最近的探测中未收到回显的比例（百分比）
+	HeartbeatProbes uint64
+	ACLDenied       uint64 // 被ACL丢弃的包数
+	Spoofed         uint64 // 源地址校验失败丢弃的包数
+	// 发送队列状态
+	SendQueueLen    int
+	DroppedMessages uint64
+	DroppedBytes    uint64
 }
 
 // GetAllSessions 获取所有会话信息
 func (s *VPNServer) GetAllSessions() []SessionInfo {
-	s.sessionMutex.RLock()
-	defer s.sessionMutex.RUnlock()
-
-	sessions := make([]SessionInfo, 0, len(s.sessions))
-	for _, session := range s.sessions {
+	snap := s.sessions.snapshot()
+	sessions := make([]SessionInfo, 0, len(snap.byID))
+	for _, session := range snap.byID {
 		sent, received, _ := session.GetStats()
+		wireSent, wireReceived := session.GetWireStats()
+		droppedMessages, droppedBytes := session.GetDropStats()
+		up := session.rateUp.stats()
+		down := session.rateDown.stats()
+		quality := session.quality.stats()
 		sessions = append(sessions, SessionInfo{
-			ID:            session.ID,
-			IP:            session.IP.String(),
-			RemoteAddr:    session.RemoteAddr.String(),
-			CertSubject:   session.CertSubject,
-			ConnectedAt:   session.ConnectedAt,
-			LastActivity:  session.GetActivity(),
-			BytesSent:     sent,
-			BytesReceived: received,
+			ID:                 session.ID,
+			IP:                 session.IP.String(),
+			IP6:                ipString(session.IP6),
+			RemoteAddr:         session.RemoteAddr.String(),
+			CertSubject:        session.CertSubject,
+			Group:              session.Group,
+			ConnectedAt:        session.ConnectedAt,
+			LastActivity:       session.GetActivity(),
+			BytesSent:          sent,
+			BytesReceived:      received,
+			WireBytesSent:      wireSent,
+			WireBytesReceived:  wireReceived,
+			ProtocolVersion:    session.ProtocolVersion,
+			Features:           session.Features,
+			Links:              len(session.getLinks()),
+			PathMTU:            int(atomic.LoadInt32(&session.pathMTU)),
+			LimitUpKbps:        up.limitKbps,
+			LimitDownKbps:      down.limitKbps,
+			RateUp:             up.rate,
+			RateDown:           down.rate,
+			ThrottledUp:        up.throttledPackets,
+			ThrottledDown:      down.throttledPackets,
+			ThrottledUpBytes:   up.throttledBytes,
+			ThrottledDownBytes: down.throttledBytes,
+			ThrottleDelayUp:    up.delay,
+			RTT:                quality.rtt,
+			Jitter:             quality.jitter,
+			HeartbeatLoss:      quality.loss,
+			HeartbeatProbes:    quality.sent,
+			ACLDenied:          atomic.LoadUint64(&session.aclDenied),
+			Spoofed:            atomic.LoadUint64(&session.source.violations),
+			SendQueueLen:       session.SendQueueLen(),
+			DroppedMessages:    droppedMessages,
+			DroppedBytes:       droppedBytes,
 		})
 	}
 
@@ -766,40 +1543,46 @@ func (s *VPNServer) GetAllSessions() []SessionInfo {
 	return sessions
 }
 
-// KickSession 踢出指定会话
+// KickSession 踢出指定会话（通知客户端不再自动重连）
 func (s *VPNServer) KickSession(sessionID string) bool {
-	s.sessionMutex.RLock()
-	_, exists := s.sessions[sessionID]
-	s.sessionMutex.RUnlock()
-
-	if !exists {
+	session := s.sessions.get(sessionID)
+	if session == nil {
 		return false
 	}
 
-	s.removeSession(sessionID)
+	s.kickSession(session)
 	return true
 }
 
 // KickByIP 根据IP踢出会话
 func (s *VPNServer) KickByIP(ip string) bool {
-	s.sessionMutex.RLock()
-	session := s.ipToSession[ip]
-	s.sessionMutex.RUnlock()
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	session := s.sessions.lookupIP(addr.Unmap())
 
 	if session == nil {
 		return false
 	}
 
-	s.removeSession(session.ID)
+	s.kickSession(session)
 	return true
 }
 
+// kickSession 通知客户端已被管理员踢出并断开会话
+func (s *VPNServer) kickSession(session *VPNSession) {
+	log.Printf("踢出会话 %s (IP: %s, Cert: %s)", session.ID, session.IP, session.CertSubject)
+	s.disconnectSession(session, &Goodbye{
+		Reason:  GoodbyeReasonKick,
+		Message: "已被管理员断开连接",
+	})
+}
+
 // GetTotalStats 获取总流量统计
 func (s *VPNServer) GetTotalStats() (totalSent, totalReceived uint64) {
-	s.sessionMutex.RLock()
-	defer s.sessionMutex.RUnlock()
-
-	for _, session := range s.sessions {
+	for _, session := range s.sessions.snapshot().byID {
 		sent, received, _ := session.GetStats()
 		totalSent += sent
 		totalReceived += received
@@ -10,8 +10,10 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
@@ -64,7 +66,7 @@ func (s *VPNService) StartServer() error {
 	}
 
 	// 创建服务器
-	serverAddr := fmt.Sprintf(":%d", s.config.ServerPort)
+	serverAddr := net.JoinHostPort("", fmt.Sprintf("%d", s.config.ServerPort))
 	server, err := NewVPNServer(serverAddr, certManager, s.config)
 	if err != nil {
 		return fmt.Errorf("创建服务器失败: %v", err)
@@ -112,6 +114,43 @@ func (s *VPNService) StopServer() error {
 	return nil
 }
 
+// DrainServer 排空会话后停止服务端：不再接受新连接，通知客户端重连，
+// 会话全部断开或超时后停止（timeout 为0时使用配置值）。立即返回实际使用的超时时间
+func (s *VPNService) DrainServer(timeout time.Duration) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.server == nil || !s.server.IsRunning() {
+		return 0, fmt.Errorf("服务端未运行")
+	}
+	if s.server.isDraining() {
+		return 0, fmt.Errorf("服务端正在排空会话")
+	}
+	if timeout <= 0 {
+		timeout = s.config.GetDrainTimeout()
+	}
+
+	if s.apiServer != nil {
+		s.apiServer.Stop()
+		s.apiServer = nil
+	}
+
+	server := s.server
+	go func() {
+		if err := server.Drain(timeout); err != nil {
+			log.Printf("排空会话失败: %v", err)
+			return
+		}
+		s.mu.Lock()
+		if s.server == server {
+			s.server = nil
+		}
+		s.mu.Unlock()
+		log.Println("服务端已停止")
+	}()
+	return timeout, nil
+}
+
 // GetServerStatus 获取服务端状态
 func (s *VPNService) GetServerStatus() ServerStatusResponse {
 	s.mu.RLock()
@@ -127,6 +166,7 @@ func (s *VPNService) GetServerStatus() ServerStatusResponse {
 		resp.Network = s.config.Network
 		resp.ClientCount = s.server.GetSessionCount()
 		resp.TotalSent, resp.TotalRecv = s.server.GetTotalStats()
+		resp.Draining = s.server.isDraining()
 	}
 
 	return resp
@@ -145,11 +185,35 @@ func (s *VPNService) GetOnlineClients() []ClientInfo {
 	clients := make([]ClientInfo, 0, len(sessions))
 	for _, sess := range sessions {
 		clients = append(clients, ClientInfo{
-			IP:            sess.IP,
-			BytesSent:     sess.BytesSent,
-			BytesReceived: sess.BytesReceived,
-			ConnectedAt:   sess.ConnectedAt,
-			Duration:      time.Since(sess.ConnectedAt).Truncate(time.Second).String(),
+			IP:                 sess.IP,
+			IP6:                sess.IP6,
+			CN:                 sess.CertSubject,
+			Group:              sess.Group,
+			BytesSent:          sess.BytesSent,
+			BytesReceived:      sess.BytesReceived,
+			WireSent:           sess.WireBytesSent,
+			WireReceived:       sess.WireBytesReceived,
+			Dropped:            sess.DroppedMessages,
+			DroppedBytes:       sess.DroppedBytes,
+			Links:              sess.Links,
+			PathMTU:            sess.PathMTU,
+			LimitUpKbps:        sess.LimitUpKbps,
+			LimitDownKbps:      sess.LimitDownKbps,
+			RateUp:             sess.RateUp,
+			RateDown:           sess.RateDown,
+			ThrottledUp:        sess.ThrottledUp,
+			ThrottledDown:      sess.ThrottledDown,
+			ThrottledUpBytes:   sess.ThrottledUpBytes,
+			ThrottledDownBytes: sess.ThrottledDownBytes,
+			ThrottleDelayUpMs:  durationMs(sess.ThrottleDelayUp),
+			ACLDenied:          sess.ACLDenied,
+			Spoofed:            sess.Spoofed,
+			RTTMs:              durationMs(sess.RTT),
+			JitterMs:           durationMs(sess.Jitter),
+			HeartbeatLoss:      sess.HeartbeatLoss,
+			Measured:           sess.HeartbeatProbes > 0,
+			ConnectedAt:        sess.ConnectedAt,
+			Duration:           time.Since(sess.ConnectedAt).Truncate(time.Second).String(),
 		})
 	}
 	return clients
@@ -227,9 +291,27 @@ func (s *VPNService) GetClientStatus() VPNClientStatusResponse {
 		if s.client.assignedIP != nil {
 			resp.AssignedIP = s.client.assignedIP.String()
 		}
+		resp.AssignedIP6 = s.client.assignedIP6
 		if s.client.tunDevice != nil {
 			resp.TUNDevice = s.client.tunDevice.Name()
 		}
+		resp.DataChannel = s.client.DataChannel()
+		resp.Links = s.client.LinkCount()
+		resp.MTU = s.client.tunnelMTU()
+		resp.Notice = s.client.LastNotice()
+		if quality := s.client.quality.stats(); quality.sent > 0 {
+			resp.RTTMs = durationMs(quality.rtt)
+			resp.JitterMs = durationMs(quality.jitter)
+			resp.HeartbeatLoss = quality.loss
+			resp.Measured = true
+		}
+		if s.client.hello != nil {
+			resp.ProtocolVersion = s.client.hello.Version
+			resp.Features = s.client.hello.Features
+		}
+	}
+	if s.client != nil {
+		resp.DisconnectReason, resp.DisconnectMessage = s.client.LastDisconnect()
 	}
 
 	return resp
@@ -300,7 +382,7 @@ func (s *VPNService) GetSignedClients() []SignedClient {
 }
 
 // GenerateCSR 生成 CSR
-func (s *VPNService) GenerateCSR(clientName string) (*GenerateCSRResponse, error) {
+func (s *VPNService) GenerateCSR(clientName, group string) (*GenerateCSRResponse, error) {
 	if clientName == "" {
 		return nil, fmt.Errorf("客户端名称不能为空")
 	}
@@ -317,6 +399,10 @@ func (s *VPNService) GenerateCSR(clientName string) (*GenerateCSRResponse, error
 			Country:      []string{"CN"},
 		},
 	}
+	if group != "" {
+		// 分组写入OU，服务端据此执行客户端互访策略
+		csrTemplate.Subject.OrganizationalUnit = []string{group}
+	}
 
 	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &csrTemplate, privateKey)
 	if err != nil {
@@ -390,7 +476,7 @@ func (s *VPNService) RequestCert(req RequestCertRequest) error {
 	}
 	reqData, _ := json.Marshal(certReq)
 
-	url := fmt.Sprintf("http://%s:%d/api/cert/request", req.ServerAddress, req.ServerPort)
+	url := fmt.Sprintf("http://%s/api/cert/request", net.JoinHostPort(req.ServerAddress, fmt.Sprintf("%d", req.ServerPort)))
 	resp, err := http.Post(url, "application/json", strings.NewReader(string(reqData)))
 	if err != nil {
 		return fmt.Errorf("发送请求失败: %v", err)
@@ -590,6 +676,293 @@ func (s *VPNService) CleanupExpiredTokens() int {
 	return cleaned
 }
 
+// ================ IP租约操作 ================
+
+// leaseDBNoLock 返回租约表：服务端运行时使用其租约表，否则直接读写租约文件（调用方持有锁）
+func (s *VPNService) leaseDBNoLock() (*leaseDB, error) {
+	if s.server != nil && s.server.IsRunning() {
+		return s.server.leases, nil
+	}
+	return loadLeaseDB(s.config.GetLeaseFile(), s.config.GetLeaseDuration())
+}
+
+// GetLeases 获取IP租约列表
+func (s *VPNService) GetLeases() ([]LeaseInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db, err := s.leaseDBNoLock()
+	if err != nil {
+		return nil, err
+	}
+	online := map[string]bool{}
+	if s.server != nil && s.server.IsRunning() {
+		online = s.server.onlineLeaseKeys()
+	}
+
+	leases := db.list()
+	infos := make([]LeaseInfo, 0, len(leases))
+	for _, lease := range leases {
+		infos = append(infos, LeaseInfo{
+			Key:       lease.Key,
+			IP:        lease.IP,
+			IP6:       lease.IP6,
+			Static:    lease.Static,
+			ExpiresAt: lease.ExpiresAt,
+			Online:    online[lease.Key],
+		})
+	}
+	return infos, nil
+}
+
+// SetLease 新增或修改IP租约（在线客户端重新连接后生效）
+func (s *VPNService) SetLease(req SetLeaseRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.Key == "" {
+		return fmt.Errorf("租约key不能为空")
+	}
+	ip := net.ParseIP(req.IP)
+	if ip == nil || ip.To4() == nil {
+		return fmt.Errorf("无效的IPv4地址: %s", req.IP)
+	}
+	_, network, err := net.ParseCIDR(s.config.Network)
+	if err != nil {
+		return fmt.Errorf("VPN网络格式无效: %v", err)
+	}
+	if !NewIPPool(network, &s.config).Contains(ip) {
+		return fmt.Errorf("地址 %s 不在客户端地址范围内", ip)
+	}
+	lease := IPLease{Key: req.Key, IP: ip.String(), Static: req.Static}
+
+	if req.IP6 != "" {
+		_, network6, err := s.config.ParseServerIP6()
+		if err != nil {
+			return err
+		}
+		if network6 == nil {
+			return fmt.Errorf("未启用IPv6，不能设置IPv6地址")
+		}
+		ip6 := net.ParseIP(req.IP6)
+		if ip6 == nil || ip6.To4() != nil {
+			return fmt.Errorf("无效的IPv6地址: %s", req.IP6)
+		}
+		if !NewIPPool(network6, &s.config).Contains(ip6) {
+			return fmt.Errorf("地址 %s 不在客户端地址范围内", ip6)
+		}
+		lease.IP6 = ip6.String()
+	}
+
+	db, err := s.leaseDBNoLock()
+	if err != nil {
+		return err
+	}
+	return db.set(lease)
+}
+
+// DeleteLease 删除IP租约
+func (s *VPNService) DeleteLease(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db, err := s.leaseDBNoLock()
+	if err != nil {
+		return err
+	}
+	return db.delete(key)
+}
+
+// ================ 流量配额操作 ================
+
+// GetQuotas 获取各客户端的流量配额状态：服务端运行时包含在线状态和最新流量，否则读取配额文件
+func (s *VPNService) GetQuotas() (*QuotaListResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resp := &QuotaListResponse{Action: s.config.GetQuotaAction()}
+	if s.server != nil && s.server.IsRunning() {
+		resp.Quotas = s.server.QuotaStatus()
+		return resp, nil
+	}
+	db, err := loadQuotaDB(s.config.GetQuotaFile())
+	if err != nil {
+		return nil, err
+	}
+	resp.Quotas = quotaStatus(db, newQuotaPolicy(&s.config), nil)
+	return resp, nil
+}
+
+// ================ 带宽限速操作 ================
+
+// GetRateLimits 获取带宽限速配置
+func (s *VPNService) GetRateLimits() RateLimitListResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return RateLimitListResponse{
+		UpKbps:   s.config.RateLimitUpKbps,
+		DownKbps: s.config.RateLimitDownKbps,
+		Rules:    append([]RateLimitRule{}, s.config.RateLimits...),
+	}
+}
+
+// SetRateLimit 新增或修改限速规则（CN和分组都为空时修改默认值），立即作用于在线客户端
+func (s *VPNService) SetRateLimit(rule RateLimitRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rule.CN == "" && rule.Group == "" {
+		if rule.UpKbps < 0 || rule.DownKbps < 0 {
+			return fmt.Errorf("限速值不能为负数（0表示不限速）")
+		}
+		s.config.RateLimitUpKbps = rule.UpKbps
+		s.config.RateLimitDownKbps = rule.DownKbps
+		return s.applyRateLimitsNoLock()
+	}
+	if err := rule.validate(); err != nil {
+		return err
+	}
+
+	// 复制规则列表，运行中的服务端持有的是旧列表
+	rules := make([]RateLimitRule, 0, len(s.config.RateLimits)+1)
+	replaced := false
+	for _, r := range s.config.RateLimits {
+		if r.sameTarget(rule) {
+			r = rule
+			replaced = true
+		}
+		rules = append(rules, r)
+	}
+	if !replaced {
+		rules = append(rules, rule)
+	}
+	s.config.RateLimits = rules
+	return s.applyRateLimitsNoLock()
+}
+
+// DeleteRateLimit 删除针对某个CN或分组的限速规则
+func (s *VPNService) DeleteRateLimit(rule RateLimitRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := make([]RateLimitRule, 0, len(s.config.RateLimits))
+	for _, r := range s.config.RateLimits {
+		if !r.sameTarget(rule) {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) == len(s.config.RateLimits) {
+		return fmt.Errorf("未找到限速规则")
+	}
+	s.config.RateLimits = rules
+	return s.applyRateLimitsNoLock()
+}
+
+// applyRateLimitsNoLock 保存限速配置并应用到运行中的服务端（调用方持有锁）
+func (s *VPNService) applyRateLimitsNoLock() error {
+	if s.server != nil && s.server.IsRunning() {
+		s.server.SetRateLimits(&s.config)
+	}
+	return s.saveConfigNoLock()
+}
+
+// ================ 访问控制操作 ================
+
+// GetACL 获取ACL规则及命中次数（服务端未运行时命中次数为0）
+func (s *VPNService) GetACL() ACLListResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hits []uint64
+	resp := ACLListResponse{Default: s.config.GetACLDefault(), Rules: []ACLRuleInfo{}}
+	if s.server != nil && s.server.IsRunning() {
+		hits, resp.DefaultHits = s.server.ACLHits()
+	}
+	for i, rule := range s.config.ACLRules {
+		info := ACLRuleInfo{Index: i + 1, ACLRule: rule}
+		if i < len(hits) {
+			info.Hits = hits[i]
+		}
+		resp.Rules = append(resp.Rules, info)
+	}
+	return resp
+}
+
+// AddACLRule 在指定位置（从1开始，0=末尾）插入ACL规则，立即作用于在线客户端
+func (s *VPNService) AddACLRule(rule ACLRule, position int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	if position < 0 || position > len(s.config.ACLRules)+1 {
+		return fmt.Errorf("无效的插入位置: %d（当前共 %d 条规则）", position, len(s.config.ACLRules))
+	}
+	if position == 0 {
+		position = len(s.config.ACLRules) + 1
+	}
+
+	// 复制规则列表，运行中的服务端持有的是旧列表
+	rules := make([]ACLRule, 0, len(s.config.ACLRules)+1)
+	rules = append(rules, s.config.ACLRules[:position-1]...)
+	rules = append(rules, rule)
+	rules = append(rules, s.config.ACLRules[position-1:]...)
+	s.config.ACLRules = rules
+	return s.applyACLNoLock()
+}
+
+// DeleteACLRule 删除指定序号（从1开始）的ACL规则
+func (s *VPNService) DeleteACLRule(index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index < 1 || index > len(s.config.ACLRules) {
+		return fmt.Errorf("未找到第%d条ACL规则", index)
+	}
+	rules := make([]ACLRule, 0, len(s.config.ACLRules)-1)
+	rules = append(rules, s.config.ACLRules[:index-1]...)
+	rules = append(rules, s.config.ACLRules[index:]...)
+	s.config.ACLRules = rules
+	return s.applyACLNoLock()
+}
+
+// ReloadACL 从配置文件重新加载ACL规则和默认动作（其他配置不变），返回规则数
+func (s *VPNService) ReloadACL() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg, err := LoadConfigFromFile(s.configFile)
+	if err != nil {
+		return 0, err
+	}
+	if err := validateACLDefault(cfg.ACLDefault); err != nil {
+		return 0, err
+	}
+	for i, rule := range cfg.ACLRules {
+		if err := rule.validate(); err != nil {
+			return 0, fmt.Errorf("第%d条ACL规则无效: %v", i+1, err)
+		}
+	}
+
+	s.config.ACLDefault = cfg.ACLDefault
+	s.config.ACLRules = cfg.ACLRules
+	if s.server != nil && s.server.IsRunning() {
+		s.server.SetACL(&s.config)
+	}
+	log.Printf("已从配置文件重新加载ACL: %d 条规则，默认动作 %s", len(cfg.ACLRules), s.config.GetACLDefault())
+	return len(cfg.ACLRules), nil
+}
+
+// applyACLNoLock 保存ACL配置并应用到运行中的服务端（调用方持有锁）
+func (s *VPNService) applyACLNoLock() error {
+	if s.server != nil && s.server.IsRunning() {
+		s.server.SetACL(&s.config)
+	}
+	return s.saveConfigNoLock()
+}
+
 // ================ 配置操作 ================
 
 // GetConfig 获取当前配置
@@ -636,6 +1009,9 @@ func (s *VPNService) UpdateConfig(field string, value interface{}) error {
 		}
 	case "route_mode":
 		if v, ok := value.(string); ok {
+			if v != "full" && v != "split" {
+				return fmt.Errorf("无效的路由模式: %s", v)
+			}
 			s.config.RouteMode = v
 		}
 	case "enable_nat":
@@ -675,10 +1051,254 @@ func (s *VPNService) UpdateConfig(field string, value interface{}) error {
 			}
 			s.config.PushRoutes = routes
 		}
+	case "exclude_routes":
+		if v, ok := value.([]interface{}); ok {
+			routes := make([]string, 0, len(v))
+			for _, r := range v {
+				if rs, ok := r.(string); ok && rs != "" {
+					if _, _, err := net.ParseCIDR(rs); err != nil {
+						return fmt.Errorf("无效的排除路由格式: %s", rs)
+					}
+					routes = append(routes, rs)
+				}
+			}
+			s.config.ExcludeRoutes = routes
+		}
 	case "redirect_dns":
 		if v, ok := value.(bool); ok {
 			s.config.RedirectDNS = v
 		}
+	case "enable_udp":
+		if v, ok := value.(bool); ok {
+			s.config.EnableUDP = v
+		}
+	case "udp_port":
+		if v, ok := value.(float64); ok {
+			port := int(v)
+			if port >= 0 && port < 65536 {
+				s.config.UDPPort = port
+			} else {
+				return fmt.Errorf("无效的UDP端口号")
+			}
+		}
+	case "enable_batching":
+		if v, ok := value.(bool); ok {
+			s.config.EnableBatching = v
+		}
+	case "enable_compression":
+		if v, ok := value.(bool); ok {
+			s.config.EnableCompression = v
+		}
+	case "network6":
+		if v, ok := value.(string); ok {
+			if v != "" && !isIPv6CIDR(v) {
+				return fmt.Errorf("无效的IPv6网段: %s", v)
+			}
+			s.config.Network6 = v
+		}
+	case "send_queue_size":
+		if v, ok := value.(float64); ok {
+			size := int(v)
+			if size < 0 || size > 65536 {
+				return fmt.Errorf("无效的发送队列长度")
+			}
+			s.config.SendQueueSize = size
+		}
+	case "tun_queues":
+		if v, ok := value.(float64); ok {
+			queues := int(v)
+			if queues < 0 || queues > maxTUNQueues {
+				return fmt.Errorf("无效的TUN队列数")
+			}
+			s.config.TUNQueues = queues
+		}
+	case "bond_links":
+		if v, ok := value.(float64); ok {
+			links := int(v)
+			if links < 0 || links > maxBondLinks {
+				return fmt.Errorf("无效的聚合连接数")
+			}
+			s.config.BondLinks = links
+		}
+	case "bond_local_addrs":
+		if v, ok := value.([]interface{}); ok {
+			addrs := make([]string, 0, len(v))
+			for _, a := range v {
+				if as, ok := a.(string); ok && as != "" {
+					if net.ParseIP(as) == nil {
+						return fmt.Errorf("无效的本地地址: %s", as)
+					}
+					addrs = append(addrs, as)
+				}
+			}
+			s.config.BondLocalAddrs = addrs
+		}
+	case "lease_file":
+		if v, ok := value.(string); ok {
+			s.config.LeaseFile = v
+		}
+	case "lease_duration_hours":
+		if v, ok := value.(float64); ok {
+			if v < 0 {
+				return fmt.Errorf("无效的IP租约有效期")
+			}
+			s.config.LeaseDuration = time.Duration(v) * time.Hour
+		}
+	case "lease_key":
+		if v, ok := value.(string); ok {
+			switch v {
+			case LeaseKeyCN, LeaseKeySerial:
+				s.config.LeaseKey = v
+			default:
+				return fmt.Errorf("无效的IP租约绑定字段: %s", v)
+			}
+		}
+	case "drain_timeout_sec":
+		if v, ok := value.(float64); ok {
+			if v < 0 {
+				return fmt.Errorf("无效的排空超时")
+			}
+			s.config.DrainTimeout = time.Duration(v) * time.Second
+		}
+	case "tun_offload":
+		if v, ok := value.(bool); ok {
+			s.config.TUNOffload = v
+		}
+	case "disable_mss_clamp":
+		if v, ok := value.(bool); ok {
+			s.config.DisableMSSClamp = v
+		}
+	case "quota_file":
+		if v, ok := value.(string); ok {
+			s.config.QuotaFile = v
+		}
+	case "quota_period":
+		if v, ok := value.(string); ok {
+			if err := validateQuotaPeriod(v); err != nil {
+				return err
+			}
+			s.config.QuotaPeriod = v
+			if s.server != nil && s.server.IsRunning() {
+				s.server.SetQuotas(&s.config)
+			}
+		}
+	case "quota_default_mb":
+		if v, ok := value.(float64); ok {
+			if v < 0 {
+				return fmt.Errorf("无效的流量配额")
+			}
+			s.config.QuotaDefaultMB = int(v)
+			if s.server != nil && s.server.IsRunning() {
+				s.server.SetQuotas(&s.config)
+			}
+		}
+	case "quota_action":
+		if v, ok := value.(string); ok {
+			switch v {
+			case QuotaActionWarn, QuotaActionThrottle, QuotaActionDisconnect:
+				s.config.QuotaAction = v
+			default:
+				return fmt.Errorf("无效的超出配额处理方式: %s", v)
+			}
+			if s.server != nil && s.server.IsRunning() {
+				s.server.SetQuotas(&s.config)
+			}
+		}
+	case "quota_throttle_kbps":
+		if v, ok := value.(float64); ok {
+			if v < 0 {
+				return fmt.Errorf("无效的配额限速")
+			}
+			s.config.QuotaThrottleKbps = int(v)
+			if s.server != nil && s.server.IsRunning() {
+				s.server.SetQuotas(&s.config)
+			}
+		}
+	case "rate_limit_up_kbps", "rate_limit_down_kbps":
+		if v, ok := value.(float64); ok {
+			if v < 0 {
+				return fmt.Errorf("无效的限速值")
+			}
+			if field == "rate_limit_up_kbps" {
+				s.config.RateLimitUpKbps = int(v)
+			} else {
+				s.config.RateLimitDownKbps = int(v)
+			}
+			if s.server != nil && s.server.IsRunning() {
+				s.server.SetRateLimits(&s.config)
+			}
+		}
+	case "spoof_disconnect_threshold":
+		if v, ok := value.(float64); ok {
+			if v < 0 {
+				return fmt.Errorf("无效的伪造源地址断开阈值")
+			}
+			s.config.SpoofDisconnectThreshold = int(v)
+			if s.server != nil && s.server.IsRunning() {
+				s.server.SetSpoofDisconnectThreshold(&s.config)
+			}
+		}
+	case "transport":
+		if v, ok := value.(string); ok {
+			switch v {
+			case TransportTLS, TransportWebSocket:
+				s.config.Transport = v
+			default:
+				return fmt.Errorf("无效的传输方式: %s", v)
+			}
+		}
+	case "proxy_url":
+		if v, ok := value.(string); ok {
+			if v != "" {
+				if proxy, err := url.Parse(v); err != nil || proxy.Scheme != "http" || proxy.Host == "" {
+					return fmt.Errorf("无效的代理地址: %s", v)
+				}
+			}
+			s.config.ProxyURL = v
+		}
+	case "websocket_port":
+		if v, ok := value.(float64); ok {
+			port := int(v)
+			if port < 0 || port > 65535 {
+				return fmt.Errorf("无效的WebSocket端口")
+			}
+			s.config.WebSocketPort = port
+		}
+	case "websocket_path":
+		if v, ok := value.(string); ok {
+			if v != "" && !strings.HasPrefix(v, "/") {
+				return fmt.Errorf("WebSocket路径必须以 / 开头")
+			}
+			s.config.WebSocketPath = v
+		}
+	case "acl_default":
+		if v, ok := value.(string); ok {
+			if err := validateACLDefault(v); err != nil {
+				return err
+			}
+			s.config.ACLDefault = v
+			if s.server != nil && s.server.IsRunning() {
+				s.server.SetACL(&s.config)
+			}
+		}
+	case "send_queue_policy":
+		if v, ok := value.(string); ok {
+			switch v {
+			case SendQueueDropNewest, SendQueueDropOldest, SendQueueDisconnect:
+				s.config.SendQueuePolicy = v
+			default:
+				return fmt.Errorf("无效的发送队列溢出策略: %s", v)
+			}
+		}
+	case "client_to_client":
+		if v, ok := value.(string); ok {
+			switch v {
+			case ClientToClientAllow, ClientToClientDeny, ClientToClientSameGroup:
+				s.config.ClientToClient = v
+			default:
+				return fmt.Errorf("无效的客户端互访策略: %s", v)
+			}
+		}
 	case "dns_servers":
 		if v, ok := value.([]interface{}); ok {
 			servers := make([]string, 0, len(v))
@@ -702,6 +1322,14 @@ func (s *VPNService) UpdateConfig(field string, value interface{}) error {
 		return fmt.Errorf("未知的配置字段: %s", field)
 	}
 
+	// 路由和DNS配置有变化时推送给在线客户端
+	switch field {
+	case "route_mode", "push_routes", "exclude_routes", "dns_servers", "redirect_gateway", "redirect_dns":
+		if s.server != nil && s.server.IsRunning() {
+			s.server.PushClientConfig(&s.config)
+		}
+	}
+
 	// 自动保存
 	s.saveConfigNoLock()
 	return nil
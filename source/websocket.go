@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================ WebSocket 传输 ================
+//
+// 只允许 HTTP(S) 出站（或只能经 HTTP 代理出站）的网络中，客户端可以改用 WebSocket 传输：
+// 在 HTTPS 端点（websocket_port）上完成 TLS 1.3 握手（与直接连接相同的双向证书认证），
+// 发起 WebSocket 升级（RFC 6455），随后的消息流与直接的TLS连接完全相同，
+// 只是被切分为二进制帧传输。需要经过代理时，客户端先通过 HTTP CONNECT 建立到服务端的隧道，
+// TLS 握手仍在客户端和服务端之间端到端完成，代理看不到证书和隧道内容。
+//
+// 经过代理时客户端不知道服务端的实际地址，不启用UDP数据通道，聚合成员连接按配置的服务器地址连接。
+
+// 传输方式
+const (
+	TransportTLS       = "tls"       // 直接的TLS连接
+	TransportWebSocket = "websocket" // TLS之上的WebSocket（可经HTTP代理）
+)
+
+const (
+	DefaultWebSocketPath = "/vpn"
+	websocketGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	websocketTimeout     = 30 * time.Second // 代理 CONNECT 和 WebSocket 升级的超时
+	wsWriteFrameSize     = 64 * 1024        // Write 切分数据帧的最大负载
+	wsMaxFrameSize       = 1024 * 1024      // 接受的数据帧的最大负载（数据帧按字节流读取，与隧道消息的大小无关）
+
+	// WebSocket 帧类型
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// tunnelConn 承载隧道消息流的连接：直接的TLS连接（*tls.Conn）或TLS之上的WebSocket（*wsConn）
+type tunnelConn interface {
+	net.Conn
+	ConnectionState() tls.ConnectionState
+}
+
+// wsConn 把WebSocket二进制帧还原为字节流的连接。Write 按 wsWriteFrameSize 切分为连续的帧；
+// 读取按帧头逐帧解析，自动回复 Ping 和 Close。只允许一个协程读取
+type wsConn struct {
+	net.Conn // 下层的TLS连接
+	state    tls.ConnectionState
+	br       *bufio.Reader // 下层连接的读缓冲（可能包含升级响应之后的数据）
+	client   bool          // 客户端发出的帧需要加掩码
+	writeMu  sync.Mutex
+	wbuf     []byte
+	// 当前数据帧的读取状态（仅由读取协程访问）
+	remaining uint64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+	closed    bool // 已收到 Close 帧
+}
+
+// ConnectionState 返回下层TLS连接的状态（证书、导出密钥等）
+func (c *wsConn) ConnectionState() tls.ConnectionState {
+	return c.state
+}
+
+// Read 读取数据帧的负载，跨帧时每次最多返回一个帧剩余的数据
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		if err := c.readFrameHeader(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// readFrameHeader 读取下一个帧头，处理控制帧，数据帧设置 remaining。
+// 帧头完整到达后才从缓冲区取出，读取超时不会破坏帧边界
+func (c *wsConn) readFrameHeader() error {
+	head, err := c.br.Peek(2)
+	if err != nil {
+		return err
+	}
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	// 客户端发出的帧必须加掩码，服务端发出的帧不能加掩码（RFC 6455 5.1）
+	if masked == c.client {
+		if c.client {
+			return fmt.Errorf("服务端发送了加掩码的WebSocket帧")
+		}
+		return fmt.Errorf("客户端发送了未加掩码的WebSocket帧")
+	}
+	size := 2
+	switch head[1] & 0x7F {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if masked {
+		size += 4
+	}
+	head, err = c.br.Peek(size)
+	if err != nil {
+		return err
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(head[2:4]))
+	case 127:
+		length = binary.BigEndian.Uint64(head[2:10])
+	}
+	var mask [4]byte
+	if masked {
+		copy(mask[:], head[size-4:size])
+	}
+
+	switch opcode {
+	case wsOpContinuation, wsOpText, wsOpBinary:
+		if length > wsMaxFrameSize {
+			return fmt.Errorf("WebSocket帧过大: %d 字节", length)
+		}
+		_, _ = c.br.Discard(size)
+		c.remaining, c.masked, c.mask, c.maskPos = length, masked, mask, 0
+		return nil
+	case wsOpClose, wsOpPing, wsOpPong:
+		if length > 125 {
+			return fmt.Errorf("WebSocket控制帧过大: %d 字节", length)
+		}
+		frame, err := c.br.Peek(size + int(length))
+		if err != nil {
+			return err
+		}
+		payload := append([]byte(nil), frame[size:]...)
+		_, _ = c.br.Discard(len(frame))
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i&3]
+			}
+		}
+		switch opcode {
+		case wsOpPing:
+			return c.writeFrame(wsOpPong, payload)
+		case wsOpClose:
+			c.closed = true
+			_ = c.writeFrame(wsOpClose, payload)
+		}
+		return nil
+	default:
+		return fmt.Errorf("未知的WebSocket帧类型: %d", opcode)
+	}
+}
+
+// Write 将 p 按 wsWriteFrameSize 切分为二进制帧连续发送（期间不会插入其他帧）
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for {
+		chunk := p[written:min(len(p), written+wsWriteFrameSize)]
+		if err := c.writeFrameLocked(wsOpBinary, chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		if written == len(p) {
+			return written, nil
+		}
+	}
+}
+
+// writeFrame 发送一个帧
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// writeFrameLocked 发送一个帧（帧头和负载编码进同一个缓冲区后一次写入，调用方持有 writeMu）
+func (c *wsConn) writeFrameLocked(opcode byte, payload []byte) error {
+	b := append(c.wbuf[:0], 0x80|opcode)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xFFFF:
+		b = append(b, maskBit|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		b = append(b, mask[:]...)
+		start := len(b)
+		b = append(b, payload...)
+		for i := range b[start:] {
+			b[start+i] ^= mask[i&3]
+		}
+	} else {
+		b = append(b, payload...)
+	}
+	c.wbuf = b
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+// Close 尽量发送 Close 帧后关闭下层连接（写入正在阻塞时直接关闭）
+func (c *wsConn) Close() error {
+	if c.writeMu.TryLock() {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_ = c.writeFrameLocked(wsOpClose, nil)
+		c.writeMu.Unlock()
+	}
+	return c.Conn.Close()
+}
+
+// websocketAccept 计算升级响应中的 Sec-WebSocket-Accept
+func websocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContainsToken 判断逗号分隔的头部值中是否包含 token（不区分大小写）
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ================ 服务端 ================
+
+// startWebSocket 在 WebSocket 监听套接字上启动 HTTPS 服务（使用与TLS监听相同的证书和客户端认证）
+func (s *VPNServer) startWebSocket(ctx context.Context) {
+	if s.wsListener == nil {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.config.GetWebSocketPath(), func(w http.ResponseWriter, r *http.Request) {
+		s.handleWebSocket(ctx, w, r)
+	})
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: websocketTimeout,
+	}
+	s.cancelMutex.Lock()
+	s.wsServer = server
+	s.cancelMutex.Unlock()
+
+	log.Printf("WebSocket传输已启用，监听地址: https://%s%s", s.wsListener.Addr(), s.config.GetWebSocketPath())
+	go func() {
+		if err := server.Serve(tls.NewListener(s.wsListener, s.tlsConfig)); err != nil && err != http.ErrServerClosed {
+			log.Printf("WebSocket服务异常退出: %v", err)
+		}
+	}()
+}
+
+// closeWebSocket 停止接受新的 WebSocket 连接（已升级的连接不受影响）
+func (s *VPNServer) closeWebSocket() {
+	s.cancelMutex.Lock()
+	server := s.wsServer
+	s.wsServer = nil
+	s.cancelMutex.Unlock()
+	if server != nil {
+		_ = server.Close()
+	} else if s.wsListener != nil {
+		_ = s.wsListener.Close()
+	}
+}
+
+// handleWebSocket 校验升级请求，接管连接后按直接的TLS连接处理
+func (s *VPNServer) handleWebSocket(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if s.isDraining() {
+		http.Error(w, "服务端正在停止", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet || !headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "需要WebSocket升级请求", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "不支持的WebSocket版本", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.TLS == nil {
+		http.Error(w, "无效的WebSocket升级请求", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "连接不支持升级", http.StatusInternalServerError)
+		return
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("WebSocket升级失败 %s: %v", r.RemoteAddr, err)
+		return
+	}
+	_ = netConn.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n"
+	if _, err := netConn.Write([]byte(response)); err != nil {
+		log.Printf("WebSocket升级失败 %s: %v", r.RemoteAddr, err)
+		_ = netConn.Close()
+		return
+	}
+
+	s.handleTunnel(ctx, &wsConn{Conn: netConn, state: *r.TLS, br: rw.Reader})
+}
+
+// ================ 客户端 ================
+
+// transportProxy 返回 WebSocket 传输使用的HTTP代理（proxy_url 为空时读取 HTTPS_PROXY 等环境变量），
+// 不使用代理或直接TLS传输时返回nil
+func (c *VPNClient) transportProxy() (*url.URL, error) {
+	if c.config.GetTransport() != TransportWebSocket {
+		return nil, nil
+	}
+	if c.config.ProxyURL != "" {
+		return url.Parse(c.config.ProxyURL)
+	}
+	address := net.JoinHostPort(c.config.ServerAddress, fmt.Sprintf("%d", c.config.ServerPort))
+	return http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: address}})
+}
+
+// dialProxy 通过HTTP代理的 CONNECT 方法建立到 address 的TCP隧道
+func dialProxy(ctx context.Context, dialer *net.Dialer, proxy *url.URL, address string) (net.Conn, error) {
+	if proxy.Scheme != "http" {
+		return nil, fmt.Errorf("不支持的代理协议: %s（只支持 http）", proxy.Scheme)
+	}
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), "80")
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("连接代理 %s 失败: %v", proxyAddr, err)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(websocketTimeout))
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("发送代理请求失败: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("读取代理响应失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("代理拒绝连接: %s", resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, br: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn 先读取缓冲区中已收到的数据的连接（代理响应之后紧跟的数据）
+type bufferedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
+
+// upgradeWebSocket 在已完成握手的TLS连接上发起 WebSocket 升级
+func (c *VPNClient) upgradeWebSocket(conn *tls.Conn) (*wsConn, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	host := net.JoinHostPort(c.config.ServerAddress, fmt.Sprintf("%d", c.config.ServerPort))
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Scheme: "https", Host: host, Path: c.config.GetWebSocketPath()},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Host:       host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(websocketTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("发送WebSocket升级请求失败: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("读取WebSocket升级响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, fmt.Errorf("服务端拒绝WebSocket升级: %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		return nil, fmt.Errorf("WebSocket升级响应校验失败")
+	}
+	return &wsConn{Conn: conn, state: conn.ConnectionState(), br: br, client: true}, nil
+}